@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// NamedQuery is a pre-registered query clients of the server run by ID
+type NamedQuery struct {
+	ID          string            `mapstructure:"id"`
+	SQL         string            `mapstructure:"sql"` // Parameters are referenced as :name
+	Description string            `mapstructure:"description"`
+	Parameters  map[string]string `mapstructure:"parameters"` // Type of each :name parameter such as int or datetime
+}
+
+// QueryCatalog holds the named queries of the server
+type QueryCatalog struct {
+	Queries []NamedQuery `mapstructure:"queries"`
+}
+
+// LoadQueryCatalog loads named queries from a YAML file
+func LoadQueryCatalog(file string) (*QueryCatalog, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType("yaml")
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read query catalog: %w", err)
+	}
+
+	var catalog QueryCatalog
+	if err := v.Unmarshal(&catalog); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal query catalog: %w", err)
+	}
+
+	ids := make(map[string]bool, len(catalog.Queries))
+	for i, query := range catalog.Queries {
+		if query.ID == "" {
+			return nil, fmt.Errorf("query catalog entry %d has no id", i)
+		}
+		if ids[query.ID] {
+			return nil, fmt.Errorf("query catalog has duplicate id %q", query.ID)
+		}
+		if query.SQL == "" {
+			return nil, fmt.Errorf("query %q has no sql", query.ID)
+		}
+		ids[query.ID] = true
+	}
+
+	return &catalog, nil
+}
@@ -1,22 +1,35 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
+// Executor types
+const (
+	ExecutorClosedLoop          = "closed_loop"
+	ExecutorConstantArrivalRate = "constant_arrival_rate"
+	ExecutorRampingArrivalRate  = "ramping_arrival_rate"
+	ExecutorConnectionStorm     = "connection_storm"
+)
+
 // Config represents the application configuration
 type Config struct {
 	Database DatabaseConfig `mapstructure:"database"`
 	Test     TestConfig     `mapstructure:"test"`
 	Metrics  MetricsConfig  `mapstructure:"metrics"`
+
+	// All loaded settings, kept for the report
+	settings map[string]interface{}
 }
 
 // DatabaseConfig holds database connection settings
 type DatabaseConfig struct {
-	Type     string `mapstructure:"type"` // mysql, postgres, sqlite
+	Type     string `mapstructure:"type"` // mysql, postgres, sqlite, sqlserver
 	Host     string `mapstructure:"host"`
 	Port     int    `mapstructure:"port"`
 	Username string `mapstructure:"username"`
@@ -30,8 +43,21 @@ type DatabaseConfig struct {
 	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
 	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
 	QueryTimeout    time.Duration `mapstructure:"query_timeout"`
+
+	// Load test connection pools: per_worker (default), shared or sharded.
+	// Shared and sharded pools are sized from the settings above, sharded
+	// pools split them across PoolShards pools that together stay within them.
+	PoolStrategy string `mapstructure:"pool_strategy"`
+	PoolShards   int    `mapstructure:"pool_shards"`
 }
 
+// Connection pool strategies
+const (
+	PoolStrategyPerWorker = "per_worker"
+	PoolStrategyShared    = "shared"
+	PoolStrategySharded   = "sharded"
+)
+
 // TestConfig holds load test parameters
 type TestConfig struct {
 	Duration        time.Duration `mapstructure:"duration"`
@@ -39,11 +65,79 @@ type TestConfig struct {
 	RampUpTime      time.Duration `mapstructure:"ramp_up_time"`
 	ThinkTime       time.Duration `mapstructure:"think_time"`
 
+	// Executor model: closed_loop (users wait for each query),
+	// constant_arrival_rate (queries are dispatched on a fixed schedule) or
+	// connection_storm (connections are opened and held without query load)
+	Executor        string                `mapstructure:"executor"`
+	ArrivalRate     ArrivalRateConfig     `mapstructure:"arrival_rate"`
+	ConnectionStorm ConnectionStormConfig `mapstructure:"connection_storm"`
+
 	// Dynamic user scaling
 	UserScaling UserScalingConfig `mapstructure:"user_scaling"`
 
 	// Query settings
 	Queries []QueryConfig `mapstructure:"queries"`
+
+	// Multi-statement transactions, selected by weight together with queries
+	Scenarios []ScenarioConfig `mapstructure:"scenarios"`
+
+	// Data files feeding query parameters
+	DataSources []DataSourceConfig `mapstructure:"data_sources"`
+
+	// Pass/fail conditions evaluated at the end of the run, such as
+	// "p95(select_users) < 50ms", "error_rate < 1%" or "qps > 500"
+	Thresholds []string `mapstructure:"thresholds"`
+
+	// Circuit breaker rules evaluated over rolling windows while running
+	AbortRules []AbortRuleConfig `mapstructure:"abort_rules"`
+
+	// Default retry policy of queries and scenarios
+	Retry RetryConfig `mapstructure:"retry"`
+}
+
+// RetryConfig defines how failed executions are retried. Each retry waits
+// Backoff doubled for every earlier retry, capped at MaxBackoff, with jitter.
+type RetryConfig struct {
+	MaxAttempts int           `mapstructure:"max_attempts"` // Attempts including the first, 0 or 1 disables retries
+	Backoff     time.Duration `mapstructure:"backoff"`      // Delay before the first retry
+	MaxBackoff  time.Duration `mapstructure:"max_backoff"`  // Upper limit of the delay
+	On          []string      `mapstructure:"on"`           // Error classes to retry, default the transient ones
+}
+
+// ErrorClasses are the error class names accepted by retry policies
+var ErrorClasses = map[string]bool{
+	"deadlock":      true,
+	"serialization": true,
+	"lock_timeout":  true,
+	"connection":    true,
+	"login":         true,
+	"timeout":       true,
+	"constraint":    true,
+	"syntax":        true,
+	"other":         true,
+}
+
+// Abort rule actions
+const (
+	AbortActionStop      = "stop"
+	AbortActionScaleDown = "scale_down"
+)
+
+// AbortRuleConfig stops or scales down a running test when a threshold is
+// breached over a rolling window
+type AbortRuleConfig struct {
+	Threshold   string        `mapstructure:"threshold"`    // Condition that must hold, e.g. "error_rate < 5%"
+	Window      time.Duration `mapstructure:"window"`       // Rolling window the condition is evaluated over
+	Action      string        `mapstructure:"action"`       // stop (default), scale_down
+	TargetUsers int           `mapstructure:"target_users"` // User count to roll back to with scale_down
+}
+
+// DataSourceConfig defines a file of rows that feeds query parameters
+type DataSourceConfig struct {
+	Name   string `mapstructure:"name"`
+	File   string `mapstructure:"file"`
+	Format string `mapstructure:"format"` // csv, jsonl (detected from the file extension if empty)
+	Mode   string `mapstructure:"mode"`   // sequential, random, unique
 }
 
 // UserScalingConfig holds dynamic user scaling parameters
@@ -52,10 +146,33 @@ type UserScalingConfig struct {
 	ScalingPlan []ScalingStep `mapstructure:"scaling_plan"`
 }
 
+// ConnectionStormConfig opens and holds raw connections instead of running
+// queries, to probe the server's connection and login limits
+type ConnectionStormConfig struct {
+	Connections       int           `mapstructure:"connections"`        // Physical connections to open and hold
+	Rate              float64       `mapstructure:"rate"`               // Connection attempts per second
+	ConnectTimeout    time.Duration `mapstructure:"connect_timeout"`    // Limit of a single connection attempt
+	Heartbeat         string        `mapstructure:"heartbeat"`          // Query run on every held connection, e.g. SELECT 1
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"` // Delay between heartbeats of a connection
+	ChurnRate         float64       `mapstructure:"churn_rate"`         // Held connections closed per second, reopened at the attempt rate
+	StopOnRefusal     bool          `mapstructure:"stop_on_refusal"`    // Stop opening after the first refused connection
+}
+
+// ArrivalRateConfig holds open-model executor parameters
+type ArrivalRateConfig struct {
+	Rate          float64       `mapstructure:"rate"`           // Target iterations per second (starting rate when ramping)
+	MaxWorkers    int           `mapstructure:"max_workers"`    // Goroutine pool size (defaults to concurrent_users)
+	LateThreshold time.Duration `mapstructure:"late_threshold"` // Start delay after which an iteration counts as late
+
+	// Rate stages for the ramping executor, offsets are relative to test start
+	ScalingPlan []ScalingStep `mapstructure:"scaling_plan"`
+}
+
 // ScalingStep defines a user scaling step
 type ScalingStep struct {
 	TimeOffset   time.Duration `mapstructure:"time_offset"`   // When to apply this step
 	TargetUsers  int           `mapstructure:"target_users"`  // Target user count
+	TargetRate   float64       `mapstructure:"target_rate"`   // Target iterations per second (ramping arrival rate)
 	RampDuration time.Duration `mapstructure:"ramp_duration"` // How long to ramp to target
 	Description  string        `mapstructure:"description"`   // Description of this step
 }
@@ -64,9 +181,60 @@ type ScalingStep struct {
 type QueryConfig struct {
 	Name       string            `mapstructure:"name"`
 	SQL        string            `mapstructure:"sql"`
-	Weight     int               `mapstructure:"weight"`     // Relative frequency (1-100)
-	Type       string            `mapstructure:"type"`       // select, insert, update, delete
-	Parameters map[string]string `mapstructure:"parameters"` // Parameter placeholders
+	Weight     int               `mapstructure:"weight"`      // Relative frequency (1-100)
+	Type       string            `mapstructure:"type"`        // select, insert, update, delete, procedure
+	Procedure  string            `mapstructure:"procedure"`   // Stored procedure called by procedure queries instead of sql
+	Outputs    map[string]string `mapstructure:"outputs"`     // Output parameter name -> type of procedure queries
+	Parameters map[string]string `mapstructure:"parameters"`  // Generator specs for :name parameters
+	DataSource string            `mapstructure:"data_source"` // Data source feeding this query
+	Bindings   map[string]string `mapstructure:"bindings"`    // Parameter name -> data source column
+	Capture    map[string]string `mapstructure:"capture"`     // Variable name -> result column
+	CaptureRow string            `mapstructure:"capture_row"` // first (default), random
+	Retry      *RetryConfig      `mapstructure:"retry"`       // Overrides the test's retry policy
+}
+
+// QueryTypeProcedure is the type of queries calling a stored procedure. Its
+// parameters and data source bindings are passed as named procedure inputs.
+const QueryTypeProcedure = "procedure"
+
+// Captured row selections
+const (
+	CaptureRowFirst  = "first"
+	CaptureRowRandom = "random"
+)
+
+// Scenario transaction endings
+const (
+	ScenarioEndCommit   = "commit"
+	ScenarioEndRollback = "rollback"
+)
+
+// ScenarioConfig defines an ordered list of statements run in one transaction
+type ScenarioConfig struct {
+	Name                string         `mapstructure:"name"`
+	Weight              int            `mapstructure:"weight"`               // Relative frequency against queries and other scenarios
+	Isolation           string         `mapstructure:"isolation"`            // read_uncommitted, read_committed, repeatable_read, snapshot, serializable
+	End                 string         `mapstructure:"end"`                  // commit (default), rollback
+	RollbackProbability float64        `mapstructure:"rollback_probability"` // Chance (0-1) to roll back instead of committing
+	Retry               *RetryConfig   `mapstructure:"retry"`                // Retries the whole transaction, overrides the test's policy
+	Steps               []ScenarioStep `mapstructure:"steps"`
+}
+
+// ScenarioStep is one statement of a scenario. Parameters, data sources and
+// bindings work as for queries; weight is ignored.
+type ScenarioStep struct {
+	QueryConfig `mapstructure:",squash"`
+
+	Isolation string `mapstructure:"isolation"` // Changes the isolation level before this step (SQL Server only)
+}
+
+// IsolationLevels are the accepted transaction isolation level names
+var IsolationLevels = map[string]bool{
+	"read_uncommitted": true,
+	"read_committed":   true,
+	"repeatable_read":  true,
+	"snapshot":         true,
+	"serializable":     true,
 }
 
 // MetricsConfig holds metrics collection settings
@@ -75,30 +243,60 @@ type MetricsConfig struct {
 	Interval   time.Duration    `mapstructure:"interval"`
 	OutputFile string           `mapstructure:"output_file"`
 	Prometheus PrometheusConfig `mapstructure:"prometheus"`
+
+	// Per-interval snapshots, appended every interval instead of overwritten
+	TimeSeriesFile   string `mapstructure:"timeseries_file"`
+	TimeSeriesFormat string `mapstructure:"timeseries_format"` // jsonl, csv (default: by extension)
+
+	// Self-contained HTML report written at the end of the run
+	ReportFile string `mapstructure:"report_file"`
 }
 
 // PrometheusConfig holds Prometheus metrics settings
 type PrometheusConfig struct {
-	Enabled bool   `mapstructure:"enabled"`
-	Port    int    `mapstructure:"port"`
-	Path    string `mapstructure:"path"`
+	Enabled bool      `mapstructure:"enabled"`
+	Port    int       `mapstructure:"port"`
+	Path    string    `mapstructure:"path"`
+	Buckets []float64 `mapstructure:"buckets"` // Query duration histogram buckets in seconds
 }
 
 // Load loads configuration from file
 func Load(configFile string) (*Config, error) {
-	viper.SetConfigFile(configFile)
-	viper.SetConfigType("yaml")
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.SetConfigType("yaml")
 
 	// Set default values
-	setDefaults()
+	setDefaults(v)
 
 	// Read config file
-	if err := viper.ReadInConfig(); err != nil {
+	if err := v.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	return decode(v)
+}
+
+// Parse loads configuration from a YAML or JSON document
+func Parse(data []byte) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+
+	// Set default values
+	setDefaults(v)
+
+	// JSON is valid YAML, one parser reads both
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	return decode(v)
+}
+
+// decode unmarshals and validates the settings read into v
+func decode(v *viper.Viper) (*Config, error) {
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
@@ -107,34 +305,86 @@ func Load(configFile string) (*Config, error) {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	config.settings = v.AllSettings()
 	return &config, nil
 }
 
+// redactedKeys are substrings of setting names whose values are secrets
+var redactedKeys = []string{"password", "passwd", "secret", "token", "api_key", "apikey", "credential", "dsn"}
+
+// RedactedSettings returns all loaded settings with secret values masked,
+// for inclusion in reports
+func (c *Config) RedactedSettings() map[string]interface{} {
+	return redactSettings(c.settings)
+}
+
+// redactSettings masks secret values of a settings map recursively
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			redacted[key] = redactSettings(v)
+			continue
+		case []interface{}:
+			items := make([]interface{}, len(v))
+			for i, item := range v {
+				if nested, ok := item.(map[string]interface{}); ok {
+					item = redactSettings(nested)
+				}
+				items[i] = item
+			}
+			redacted[key] = items
+			continue
+		}
+		if isSecretKey(key) && value != nil && value != "" {
+			redacted[key] = "******"
+			continue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// isSecretKey reports whether a setting name holds a secret
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range redactedKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
 // setDefaults sets default configuration values
-func setDefaults() {
+func setDefaults(v *viper.Viper) {
 	// Database defaults
-	viper.SetDefault("database.type", "mysql")
-	viper.SetDefault("database.host", "localhost")
-	viper.SetDefault("database.port", 3306)
-	viper.SetDefault("database.max_open_conns", 100)
-	viper.SetDefault("database.max_idle_conns", 10)
-	viper.SetDefault("database.conn_max_lifetime", "1h")
-	viper.SetDefault("database.conn_max_idle_time", "10m")
-	viper.SetDefault("database.query_timeout", "30s")
+	v.SetDefault("database.type", "mysql")
+	v.SetDefault("database.host", "localhost")
+	v.SetDefault("database.port", 3306)
+	v.SetDefault("database.max_open_conns", 100)
+	v.SetDefault("database.max_idle_conns", 10)
+	v.SetDefault("database.conn_max_lifetime", "1h")
+	v.SetDefault("database.conn_max_idle_time", "10m")
+	v.SetDefault("database.query_timeout", "30s")
+	v.SetDefault("database.pool_strategy", PoolStrategyPerWorker)
 
 	// Test defaults
-	viper.SetDefault("test.duration", "5m")
-	viper.SetDefault("test.concurrent_users", 10)
-	viper.SetDefault("test.ramp_up_time", "30s")
-	viper.SetDefault("test.think_time", "1s")
+	v.SetDefault("test.duration", "5m")
+	v.SetDefault("test.concurrent_users", 10)
+	v.SetDefault("test.ramp_up_time", "30s")
+	v.SetDefault("test.think_time", "1s")
+	v.SetDefault("test.executor", ExecutorClosedLoop)
+	v.SetDefault("test.arrival_rate.late_threshold", "10ms")
 
 	// Metrics defaults
-	viper.SetDefault("metrics.enabled", true)
-	viper.SetDefault("metrics.interval", "10s")
-	viper.SetDefault("metrics.output_file", "metrics.json")
-	viper.SetDefault("metrics.prometheus.enabled", false)
-	viper.SetDefault("metrics.prometheus.port", 8080)
-	viper.SetDefault("metrics.prometheus.path", "/metrics")
+	v.SetDefault("metrics.enabled", true)
+	v.SetDefault("metrics.interval", "10s")
+	v.SetDefault("metrics.output_file", "metrics.json")
+	v.SetDefault("metrics.prometheus.enabled", false)
+	v.SetDefault("metrics.prometheus.port", 8080)
+	v.SetDefault("metrics.prometheus.path", "/metrics")
 }
 
 // validateConfig validates the configuration
@@ -151,6 +401,25 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("invalid database type: %s", config.Database.Type)
 	}
 
+	// Validate connection pool strategy
+	switch config.Database.PoolStrategy {
+	case PoolStrategyPerWorker, PoolStrategyShared:
+	case PoolStrategySharded:
+		if config.Database.PoolShards <= 0 {
+			return fmt.Errorf("sharded pool strategy requires a positive pool_shards")
+		}
+		if config.Database.MaxOpenConns > 0 && config.Database.PoolShards > config.Database.MaxOpenConns {
+			return fmt.Errorf("pool_shards %d exceeds max_open_conns %d, every shard needs a connection",
+				config.Database.PoolShards, config.Database.MaxOpenConns)
+		}
+		if config.Database.MaxIdleConns > 0 && config.Database.PoolShards > config.Database.MaxIdleConns {
+			return fmt.Errorf("pool_shards %d exceeds max_idle_conns %d, every shard needs an idle connection",
+				config.Database.PoolShards, config.Database.MaxIdleConns)
+		}
+	default:
+		return fmt.Errorf("invalid pool strategy: %s", config.Database.PoolStrategy)
+	}
+
 	// Validate test parameters
 	if config.Test.Duration <= 0 {
 		return fmt.Errorf("test duration must be positive")
@@ -162,31 +431,317 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("ramp-up time cannot be negative")
 	}
 
+	// Validate executor
+	switch config.Test.Executor {
+	case ExecutorClosedLoop:
+	case ExecutorConstantArrivalRate:
+		if config.Test.ArrivalRate.Rate <= 0 {
+			return fmt.Errorf("arrival rate must be positive")
+		}
+		if config.Test.ArrivalRate.MaxWorkers < 0 {
+			return fmt.Errorf("arrival rate max workers cannot be negative")
+		}
+	case ExecutorRampingArrivalRate:
+		if config.Test.ArrivalRate.Rate < 0 {
+			return fmt.Errorf("arrival rate cannot be negative")
+		}
+		if config.Test.ArrivalRate.MaxWorkers < 0 {
+			return fmt.Errorf("arrival rate max workers cannot be negative")
+		}
+		if len(config.Test.ArrivalRate.ScalingPlan) == 0 {
+			return fmt.Errorf("ramping arrival rate requires a scaling plan")
+		}
+		var lastOffset time.Duration
+		for i, step := range config.Test.ArrivalRate.ScalingPlan {
+			if step.TargetRate < 0 {
+				return fmt.Errorf("arrival rate step %d: target rate cannot be negative", i)
+			}
+			if step.TimeOffset < lastOffset {
+				return fmt.Errorf("arrival rate step %d: time offsets must be increasing", i)
+			}
+			if step.RampDuration < 0 {
+				return fmt.Errorf("arrival rate step %d: ramp duration cannot be negative", i)
+			}
+			lastOffset = step.TimeOffset
+		}
+	case ExecutorConnectionStorm:
+		storm := config.Test.ConnectionStorm
+		if storm.Connections <= 0 {
+			return fmt.Errorf("connection storm connections must be positive")
+		}
+		if storm.Rate <= 0 {
+			return fmt.Errorf("connection storm rate must be positive")
+		}
+		if storm.ConnectTimeout < 0 || storm.HeartbeatInterval < 0 {
+			return fmt.Errorf("connection storm timeouts cannot be negative")
+		}
+		if storm.ChurnRate < 0 {
+			return fmt.Errorf("connection storm churn rate cannot be negative")
+		}
+	default:
+		return fmt.Errorf("invalid executor: %s", config.Test.Executor)
+	}
+
+	// Validate data sources
+	dataSources := make(map[string]bool)
+	for i, source := range config.Test.DataSources {
+		if source.Name == "" {
+			return fmt.Errorf("data source %d: name is required", i)
+		}
+		if dataSources[source.Name] {
+			return fmt.Errorf("data source %d: duplicate name %s", i, source.Name)
+		}
+		if source.File == "" {
+			return fmt.Errorf("data source %s: file is required", source.Name)
+		}
+		switch source.Format {
+		case "", "csv", "jsonl":
+		default:
+			return fmt.Errorf("data source %s: invalid format: %s", source.Name, source.Format)
+		}
+		switch source.Mode {
+		case "", "sequential", "random", "unique":
+		default:
+			return fmt.Errorf("data source %s: invalid mode: %s", source.Name, source.Mode)
+		}
+		dataSources[source.Name] = true
+	}
+
+	// Validate abort rules
+	for i, rule := range config.Test.AbortRules {
+		if rule.Threshold == "" {
+			return fmt.Errorf("abort rule %d: threshold is required", i)
+		}
+		if rule.Window < 0 {
+			return fmt.Errorf("abort rule %d: window cannot be negative", i)
+		}
+		switch rule.Action {
+		case "", AbortActionStop:
+		case AbortActionScaleDown:
+			if config.Test.Executor != ExecutorClosedLoop {
+				return fmt.Errorf("abort rule %d: scale_down requires the closed_loop executor", i)
+			}
+			if rule.TargetUsers <= 0 {
+				return fmt.Errorf("abort rule %d: scale_down requires positive target users", i)
+			}
+		default:
+			return fmt.Errorf("abort rule %d: invalid action: %s", i, rule.Action)
+		}
+	}
+
+	// Validate histogram buckets
+	buckets := config.Metrics.Prometheus.Buckets
+	for i := range buckets {
+		if buckets[i] <= 0 {
+			return fmt.Errorf("prometheus bucket %d must be positive", i)
+		}
+		if i > 0 && buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("prometheus buckets must be in increasing order")
+		}
+	}
+
+	if err := validateRetry(&config.Test.Retry); err != nil {
+		return err
+	}
+
 	// Validate queries
-	if len(config.Test.Queries) == 0 {
-		return fmt.Errorf("at least one query must be defined")
+	if len(config.Test.Queries) == 0 && len(config.Test.Scenarios) == 0 && config.Test.Executor != ExecutorConnectionStorm {
+		return fmt.Errorf("at least one query or scenario must be defined")
 	}
 
 	for i, query := range config.Test.Queries {
 		if query.Name == "" {
 			return fmt.Errorf("query %d: name is required", i)
 		}
-		if query.SQL == "" {
-			return fmt.Errorf("query %d: SQL is required", i)
+		if err := validateProcedure(&query, config.Database.Type); err != nil {
+			return fmt.Errorf("query %d: %w", i, err)
 		}
 		if query.Weight <= 0 {
 			return fmt.Errorf("query %d: weight must be positive", i)
 		}
+		if query.DataSource != "" {
+			if !dataSources[query.DataSource] {
+				return fmt.Errorf("query %d: unknown data source: %s", i, query.DataSource)
+			}
+			if len(query.Bindings) == 0 {
+				return fmt.Errorf("query %d: data source requires bindings", i)
+			}
+		}
+		if err := validateCapture(&query); err != nil {
+			return fmt.Errorf("query %d: %w", i, err)
+		}
+		if query.Retry != nil {
+			if err := validateRetry(query.Retry); err != nil {
+				return fmt.Errorf("query %d: %w", i, err)
+			}
+		}
+	}
+
+	// Validate scenarios
+	names := make(map[string]bool, len(config.Test.Queries))
+	for _, query := range config.Test.Queries {
+		names[query.Name] = true
+	}
+	for i, scenario := range config.Test.Scenarios {
+		if scenario.Name == "" {
+			return fmt.Errorf("scenario %d: name is required", i)
+		}
+		if names[scenario.Name] {
+			return fmt.Errorf("scenario %d: name %s is already used by a query or scenario", i, scenario.Name)
+		}
+		names[scenario.Name] = true
+		if scenario.Weight <= 0 {
+			return fmt.Errorf("scenario %s: weight must be positive", scenario.Name)
+		}
+		if scenario.Isolation != "" && !IsolationLevels[scenario.Isolation] {
+			return fmt.Errorf("scenario %s: invalid isolation level: %s", scenario.Name, scenario.Isolation)
+		}
+		switch scenario.End {
+		case "", ScenarioEndCommit, ScenarioEndRollback:
+		default:
+			return fmt.Errorf("scenario %s: invalid end: %s", scenario.Name, scenario.End)
+		}
+		if scenario.RollbackProbability < 0 || scenario.RollbackProbability > 1 {
+			return fmt.Errorf("scenario %s: rollback probability must be between 0 and 1", scenario.Name)
+		}
+		if scenario.Retry != nil {
+			if err := validateRetry(scenario.Retry); err != nil {
+				return fmt.Errorf("scenario %s: %w", scenario.Name, err)
+			}
+		}
+		if len(scenario.Steps) == 0 {
+			return fmt.Errorf("scenario %s: at least one step is required", scenario.Name)
+		}
+
+		steps := make(map[string]bool, len(scenario.Steps))
+		for j, step := range scenario.Steps {
+			if step.Name == "" {
+				return fmt.Errorf("scenario %s: step %d: name is required", scenario.Name, j)
+			}
+			if steps[step.Name] {
+				return fmt.Errorf("scenario %s: step %d: duplicate name %s", scenario.Name, j, step.Name)
+			}
+			steps[step.Name] = true
+			if step.Type == QueryTypeProcedure || step.Procedure != "" {
+				return fmt.Errorf("scenario %s: step %s: procedure calls are only supported as queries", scenario.Name, step.Name)
+			}
+			if step.SQL == "" {
+				return fmt.Errorf("scenario %s: step %s: SQL is required", scenario.Name, step.Name)
+			}
+			if step.Isolation != "" {
+				if !IsolationLevels[step.Isolation] {
+					return fmt.Errorf("scenario %s: step %s: invalid isolation level: %s", scenario.Name, step.Name, step.Isolation)
+				}
+				// Other engines fix the isolation level when the transaction starts
+				if config.Database.Type != "mssql" && config.Database.Type != "sqlserver" {
+					return fmt.Errorf("scenario %s: step %s: step isolation requires sqlserver, set the scenario isolation instead",
+						scenario.Name, step.Name)
+				}
+			}
+			if step.DataSource != "" {
+				if !dataSources[step.DataSource] {
+					return fmt.Errorf("scenario %s: step %s: unknown data source: %s", scenario.Name, step.Name, step.DataSource)
+				}
+				if len(step.Bindings) == 0 {
+					return fmt.Errorf("scenario %s: step %s: data source requires bindings", scenario.Name, step.Name)
+				}
+			}
+			if err := validateCapture(&step.QueryConfig); err != nil {
+				return fmt.Errorf("scenario %s: step %s: %w", scenario.Name, step.Name, err)
+			}
+			if step.Retry != nil {
+				// A failed statement aborts the transaction, only the whole
+				// scenario can be retried
+				return fmt.Errorf("scenario %s: step %s: retry must be set on the scenario", scenario.Name, step.Name)
+			}
+		}
 	}
 
 	return nil
 }
 
+// validateRetry validates a retry policy
+func validateRetry(retry *RetryConfig) error {
+	if retry.MaxAttempts < 0 {
+		return fmt.Errorf("retry max_attempts must not be negative")
+	}
+	if retry.Backoff < 0 || retry.MaxBackoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+	if retry.MaxBackoff > 0 && retry.MaxBackoff < retry.Backoff {
+		return fmt.Errorf("retry max_backoff must not be less than backoff")
+	}
+	for _, class := range retry.On {
+		if !ErrorClasses[class] {
+			return fmt.Errorf("retry: unknown error class: %s", class)
+		}
+	}
+	return nil
+}
+
+// validateProcedure validates the SQL or stored procedure a query runs
+func validateProcedure(query *QueryConfig, dbType string) error {
+	if query.Type != QueryTypeProcedure {
+		if query.Procedure != "" || len(query.Outputs) > 0 {
+			return fmt.Errorf("procedure and outputs require type %s", QueryTypeProcedure)
+		}
+		if query.SQL == "" {
+			return fmt.Errorf("SQL is required")
+		}
+		return nil
+	}
+
+	if query.Procedure == "" {
+		return fmt.Errorf("procedure is required for %s queries", QueryTypeProcedure)
+	}
+	if query.SQL != "" {
+		return fmt.Errorf("%s queries take a procedure instead of SQL", QueryTypeProcedure)
+	}
+	if len(query.Capture) > 0 {
+		return fmt.Errorf("%s queries cannot capture variables", QueryTypeProcedure)
+	}
+	switch dbType {
+	case "mssql", "sqlserver", "postgres":
+	default:
+		return fmt.Errorf("%s queries require sqlserver or postgres", QueryTypeProcedure)
+	}
+	return nil
+}
+
+// validateCapture validates the result columns a query captures into variables
+func validateCapture(query *QueryConfig) error {
+	if len(query.Capture) == 0 {
+		if query.CaptureRow != "" {
+			return fmt.Errorf("capture_row requires capture")
+		}
+		return nil
+	}
+
+	switch query.Type {
+	case "", "select":
+	default:
+		return fmt.Errorf("capture requires a select query, got %s", query.Type)
+	}
+	switch query.CaptureRow {
+	case "", CaptureRowFirst, CaptureRowRandom:
+	default:
+		return fmt.Errorf("invalid capture_row: %s", query.CaptureRow)
+	}
+	for name, column := range query.Capture {
+		if column == "" {
+			return fmt.Errorf("capture %s: column is required", name)
+		}
+	}
+	return nil
+}
+
 // GetDSN returns the database connection string
 func (c *DatabaseConfig) GetDSN() string {
 	switch c.Type {
 	case "mysql":
-		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&timeout=30s",
+		// clientFoundRows makes UPDATE report matched rather than changed rows,
+		// consistent with the row counts of the other engines
+		return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true&timeout=30s&clientFoundRows=true",
 			c.Username, c.Password, c.Host, c.Port, c.Database)
 	case "postgres":
 		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
@@ -196,7 +751,12 @@ func (c *DatabaseConfig) GetDSN() string {
 		}
 		return dsn
 	case "sqlite":
-		return c.Database
+		// Concurrent workers contend for the single writer lock, so wait for it
+		// instead of failing immediately with SQLITE_BUSY
+		if strings.Contains(c.Database, "?") {
+			return c.Database
+		}
+		return fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", c.Database)
 	case "mssql", "sqlserver":
 		// SQL Server connection string
 		encrypt := "disable"
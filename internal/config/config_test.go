@@ -0,0 +1,109 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"database": map[string]interface{}{
+			"host":     "db.example.com",
+			"password": "hunter2",
+			"dsn":      "user:hunter2@tcp(db)/app",
+			"username": "app",
+			"options":  map[string]interface{}{"API_Key": "abc", "timeout": 5},
+		},
+		"remote": map[string]interface{}{"auth_token": ""},
+		"nodes": []interface{}{
+			map[string]interface{}{"host": "a", "client_secret": "s3cret"},
+			"plain",
+		},
+	}
+
+	want := map[string]interface{}{
+		"database": map[string]interface{}{
+			"host":     "db.example.com",
+			"password": "******",
+			"dsn":      "******",
+			"username": "app",
+			"options":  map[string]interface{}{"API_Key": "******", "timeout": 5},
+		},
+		// Unset secrets stay visible as unset
+		"remote": map[string]interface{}{"auth_token": ""},
+		"nodes": []interface{}{
+			map[string]interface{}{"host": "a", "client_secret": "******"},
+			"plain",
+		},
+	}
+
+	got := redactSettings(settings)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactSettings =\n%v\nwant\n%v", got, want)
+	}
+	if settings["database"].(map[string]interface{})["password"] != "hunter2" {
+		t.Error("redactSettings modified the loaded settings")
+	}
+}
+
+func TestRedactedSettings(t *testing.T) {
+	cfg, err := Parse([]byte(`
+database:
+  type: sqlite
+  database: ":memory:"
+  password: hunter2
+test:
+  queries:
+    - name: ping
+      type: select
+      weight: 1
+      sql: SELECT 1
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	database := cfg.RedactedSettings()["database"].(map[string]interface{})
+	if database["password"] != "******" || database["type"] != "sqlite" {
+		t.Errorf("RedactedSettings database = %v", database)
+	}
+}
+
+func TestValidatePoolShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		pool    string
+		wantErr string
+	}{
+		{name: "within limits", pool: "pool_shards: 4\n  max_open_conns: 8\n  max_idle_conns: 4"},
+		{name: "idle connections disabled", pool: "pool_shards: 4\n  max_open_conns: 8\n  max_idle_conns: 0"},
+		{name: "more shards than open", pool: "pool_shards: 4\n  max_open_conns: 3\n  max_idle_conns: 3", wantErr: "exceeds max_open_conns"},
+		{name: "more shards than idle", pool: "pool_shards: 4\n  max_open_conns: 8\n  max_idle_conns: 2", wantErr: "exceeds max_idle_conns"},
+		{name: "no shards", pool: "pool_shards: 0", wantErr: "positive pool_shards"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(`
+database:
+  type: sqlite
+  database: ":memory:"
+  pool_strategy: sharded
+  ` + tt.pool + `
+test:
+  queries:
+    - name: ping
+      type: select
+      weight: 1
+      sql: SELECT 1
+`))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Parse: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Parse = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -33,6 +33,21 @@ type EnvConfig struct {
 
 	// Query configuration
 	DefaultQuery string
+	QueryMaxRows int // Rows a buffered JSON response holds at most, 0 for no limit
+
+	// Query timeouts. Requests may set their own timeout up to the maximum.
+	QueryTimeout    time.Duration
+	QueryMaxTimeout time.Duration // 0 for no limit
+
+	// Timeout of NDJSON streamed queries without timeout_ms
+	QueryStreamTimeout time.Duration
+
+	// Statements a batch request holds at most, 0 for no limit
+	BatchMaxStatements int
+
+	// Directory holding the files of load tests started through the API.
+	// Unset disables their output files and rejects configs that read files.
+	LoadTestFilesDir string
 
 	// Logging
 	LogLevel string
@@ -41,8 +56,45 @@ type EnvConfig struct {
 	PrometheusEnabled bool
 	PrometheusPort    int
 	PrometheusPath    string
+
+	// Authentication, one method is required unless it is disabled
+	AuthAPIKeys    string // name:role:key entries, comma separated
+	AuthHMACSecret string // Secret signing bearer tokens
+	AuthMTLSRoles  string // Client certificate common name:role entries, comma separated
+	AuthDisabled   bool   // Serve every endpoint without authentication
+
+	// TLS, client certificates are verified if a client CA is set
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	// Origins allowed to call the API from a browser, comma separated. Unset
+	// allows no cross-origin access, * allows every origin.
+	CORSAllowedOrigins string
+
+	// SQL policy of the query endpoint
+	SQLPolicyMode     string // open, read_only, named
+	SQLMaxQueryBytes  int    // 0 for no limit
+	SQLMaxStatements  int    // 0 for no limit
+	SQLDenyStatements string // Denied keywords such as "DROP,TRUNCATE,ALTER TABLE", comma separated
+	SQLQueryCatalog   string // YAML file of named queries
+
+	// The database user can only read. SQL Server has no read-only
+	// transactions, so read_only mode requires it there.
+	SQLReadOnlyLogin bool
+
+	// Stored procedures the procedure endpoint may call, comma separated. Unset
+	// allows every procedure in open mode and none in the other modes.
+	SQLAllowedProcedures string
 }
 
+// SQL policy modes
+const (
+	SQLPolicyOpen     = "open"      // Any SQL is accepted
+	SQLPolicyReadOnly = "read_only" // Only statements that read data are accepted
+	SQLPolicyNamed    = "named"     // Only named queries from the catalog are accepted
+)
+
 // LoadFromEnv loads configuration from environment variables
 func LoadFromEnv() (*EnvConfig, error) {
 	config := &EnvConfig{
@@ -70,6 +122,16 @@ func LoadFromEnv() (*EnvConfig, error) {
 
 		// Query defaults
 		DefaultQuery: getEnv("DEFAULT_QUERY", "SELECT 1 as test"),
+		QueryMaxRows: getEnvAsInt("QUERY_MAX_ROWS", 10000),
+
+		QueryTimeout:    getEnvAsDuration("QUERY_TIMEOUT", "30s"),
+		QueryMaxTimeout: getEnvAsDuration("QUERY_MAX_TIMEOUT", "5m"),
+
+		QueryStreamTimeout: getEnvAsDuration("QUERY_STREAM_TIMEOUT", "10m"),
+
+		BatchMaxStatements: getEnvAsInt("BATCH_MAX_STATEMENTS", 100),
+
+		LoadTestFilesDir: getEnv("LOADTEST_FILES_DIR", ""),
 
 		// Logging
 		LogLevel: getEnv("LOG_LEVEL", "info"),
@@ -78,12 +140,76 @@ func LoadFromEnv() (*EnvConfig, error) {
 		PrometheusEnabled: getEnv("PROMETHEUS_ENABLED", "false") == "true",
 		PrometheusPort:    getEnvAsInt("PROMETHEUS_PORT", 8080),
 		PrometheusPath:    getEnv("PROMETHEUS_PATH", "/metrics"),
+
+		// Authentication
+		AuthAPIKeys:    getEnv("AUTH_API_KEYS", ""),
+		AuthHMACSecret: getEnv("AUTH_HMAC_SECRET", ""),
+		AuthMTLSRoles:  getEnv("AUTH_MTLS_ROLES", ""),
+		AuthDisabled:   getEnv("AUTH_DISABLED", "false") == "true",
+
+		// TLS
+		TLSCertFile:     getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile: getEnv("TLS_CLIENT_CA_FILE", ""),
+
+		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", ""),
+
+		// SQL policy
+		SQLPolicyMode:     getEnv("SQL_POLICY_MODE", SQLPolicyOpen),
+		SQLMaxQueryBytes:  getEnvAsInt("SQL_MAX_QUERY_BYTES", 0),
+		SQLMaxStatements:  getEnvAsInt("SQL_MAX_STATEMENTS", 0),
+		SQLDenyStatements: getEnv("SQL_DENY_STATEMENTS", ""),
+		SQLQueryCatalog:   getEnv("SQL_QUERY_CATALOG", ""),
+
+		SQLAllowedProcedures: getEnv("SQL_ALLOWED_PROCEDURES", ""),
+		SQLReadOnlyLogin:     getEnv("SQL_READ_ONLY_LOGIN", "false") == "true",
 	}
 
 	// Validate required fields
 	if config.DBPassword == "" {
 		return nil, fmt.Errorf("DB_PASSWORD environment variable is required")
 	}
+	if config.QueryMaxRows < 0 {
+		return nil, fmt.Errorf("QUERY_MAX_ROWS must not be negative")
+	}
+	if config.QueryTimeout <= 0 {
+		return nil, fmt.Errorf("QUERY_TIMEOUT must be positive")
+	}
+	if config.QueryMaxTimeout < 0 {
+		return nil, fmt.Errorf("QUERY_MAX_TIMEOUT must not be negative")
+	}
+	if config.QueryStreamTimeout <= 0 {
+		return nil, fmt.Errorf("QUERY_STREAM_TIMEOUT must be positive")
+	}
+	if config.BatchMaxStatements < 0 {
+		return nil, fmt.Errorf("BATCH_MAX_STATEMENTS must not be negative")
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if config.TLSClientCAFile != "" && config.TLSCertFile == "" {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if config.AuthMTLSRoles != "" && config.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("AUTH_MTLS_ROLES requires TLS_CLIENT_CA_FILE")
+	}
+	authConfigured := config.AuthAPIKeys != "" || config.AuthHMACSecret != "" || config.AuthMTLSRoles != ""
+	if !authConfigured && !config.AuthDisabled {
+		return nil, fmt.Errorf("authentication is required: set AUTH_API_KEYS, AUTH_HMAC_SECRET or AUTH_MTLS_ROLES, or AUTH_DISABLED=true to make every endpoint public")
+	}
+	if authConfigured && config.AuthDisabled {
+		return nil, fmt.Errorf("AUTH_DISABLED=true cannot be combined with AUTH_API_KEYS, AUTH_HMAC_SECRET or AUTH_MTLS_ROLES")
+	}
+	switch config.SQLPolicyMode {
+	case SQLPolicyOpen, SQLPolicyReadOnly:
+	case SQLPolicyNamed:
+		if config.SQLQueryCatalog == "" {
+			return nil, fmt.Errorf("SQL_POLICY_MODE=%s requires SQL_QUERY_CATALOG", SQLPolicyNamed)
+		}
+	default:
+		return nil, fmt.Errorf("invalid SQL_POLICY_MODE %q: must be %s, %s or %s",
+			config.SQLPolicyMode, SQLPolicyOpen, SQLPolicyReadOnly, SQLPolicyNamed)
+	}
 
 	return config, nil
 }
@@ -95,6 +221,11 @@ func (c *EnvConfig) GetDSN() string {
 		c.DBHost, c.DBPort, c.DBUsername, c.DBPassword, c.DBName, c.DBSSLMode)
 }
 
+// TLSEnabled reports whether the server serves HTTPS
+func (c *EnvConfig) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
 // GetServerAddress returns the server address
 func (c *EnvConfig) GetServerAddress() string {
 	return fmt.Sprintf("%s:%s", c.ServerHost, c.ServerPort)
@@ -3,30 +3,73 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"fiyuu-ktdb-loadtest/internal/config"
 
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/microsoft/go-mssqldb"
 	"github.com/sirupsen/logrus"
 )
 
 // Manager handles database connections
 type Manager struct {
-	db   *sql.DB
-	cfg  *config.DatabaseConfig
-	done chan struct{}
+	db      *sql.DB
+	cfg     *config.DatabaseConfig
+	dialect *Dialect
+	done    chan struct{}
 }
 
 // NewManager creates a new database manager
 func NewManager(cfg *config.DatabaseConfig) (*Manager, error) {
+	dialect, err := DialectFor(cfg.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	if dialect.Name == "sqlite" {
+		logrus.Infof("Connected to %s database at %s", cfg.Type, cfg.Database)
+	} else {
+		logrus.Infof("Connected to %s database at %s:%d", cfg.Type, cfg.Host, cfg.Port)
+	}
+
+	return &Manager{
+		db:      db,
+		cfg:     cfg,
+		dialect: dialect,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// OpenDB opens a connection pool configured from cfg without connecting.
+// Connections are established when they are first used.
+func OpenDB(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	dialect, err := DialectFor(cfg.Type)
+	if err != nil {
+		return nil, err
+	}
+
 	dsn := cfg.GetDSN()
 	if dsn == "" {
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Type)
 	}
 
-	db, err := sql.Open("sqlserver", dsn)
+	db, err := sql.Open(dialect.DriverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -37,22 +80,7 @@ func NewManager(cfg *config.DatabaseConfig) (*Manager, error) {
 	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	// Set connection wait timeout to prevent blocking
-	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
-
-	// Test connection
-	if err := db.Ping(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to ping database: %w", err)
-	}
-
-	logrus.Infof("Connected to %s database at %s:%d", cfg.Type, cfg.Host, cfg.Port)
-
-	return &Manager{
-		db:   db,
-		cfg:  cfg,
-		done: make(chan struct{}),
-	}, nil
+	return db, nil
 }
 
 // GetDB returns the database connection
@@ -60,6 +88,11 @@ func (m *Manager) GetDB() *sql.DB {
 	return m.db
 }
 
+// Dialect returns the SQL dialect of the connected database
+func (m *Manager) Dialect() *Dialect {
+	return m.dialect
+}
+
 // Close closes the database connection
 func (m *Manager) Close() error {
 	close(m.done)
@@ -83,21 +116,17 @@ func (m *Manager) Close() error {
 }
 
 // HealthCheck performs a health check on the database
-func (m *Manager) HealthCheck() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (m *Manager) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	done := make(chan error, 1)
-	go func() {
-		done <- m.db.Ping()
-	}()
-
-	select {
-	case err := <-done:
+	if err := m.db.PingContext(ctx); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("health check timeout")
+		}
 		return err
-	case <-ctx.Done():
-		return fmt.Errorf("health check timeout")
 	}
+	return nil
 }
 
 // GetStats returns database connection statistics
@@ -105,52 +134,79 @@ func (m *Manager) GetStats() sql.DBStats {
 	return m.db.Stats()
 }
 
-// ExecuteQuery executes a query and returns the result
-func (m *Manager) ExecuteQuery(query string, args ...interface{}) (*sql.Rows, error) {
-	// Use config timeout or default 30 seconds
-	timeout := m.cfg.QueryTimeout
-	if timeout <= 0 {
-		timeout = 30 * time.Second
+// withQueryTimeout applies the query timeout to a context without a
+// deadline. A caller's deadline overrides the query timeout.
+func (m *Manager) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, m.QueryTimeout())
+}
 
-	// Use context with timeout to ensure connection is returned to pool
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+// Rows are the result of ExecuteQuery. Close also releases the query
+// timeout, so it must be called even after Next returned false.
+type Rows struct {
+	*sql.Rows
+	release func()
+}
+
+// Close closes the rows and releases the query timeout
+func (r *Rows) Close() error {
+	defer r.release()
+	return r.Rows.Close()
+}
+
+// ExecuteQuery executes a query and returns the result. The query is
+// canceled with the context or when the query timeout expires.
+func (m *Manager) ExecuteQuery(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	// The caller still reads the rows, so the timeout is released by Close
+	ctx, cancel := m.withQueryTimeout(ctx)
 
 	rows, err := m.db.QueryContext(ctx, query, args...)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-
-	return rows, nil
+	return &Rows{Rows: rows, release: cancel}, nil
 }
 
-// ExecuteQueryRow executes a query that returns a single row
-func (m *Manager) ExecuteQueryRow(query string, args ...interface{}) *sql.Row {
-	return m.db.QueryRow(query, args...)
+// ExecuteQueryRow executes a query that returns a single row and scans it
+// into dest. It returns sql.ErrNoRows if the query returned no rows.
+func (m *Manager) ExecuteQueryRow(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	ctx, cancel := m.withQueryTimeout(ctx)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, query, args...).Scan(dest...)
 }
 
 // ExecuteExec executes a query that doesn't return rows
-func (m *Manager) ExecuteExec(query string, args ...interface{}) (sql.Result, error) {
-	// Use config timeout or default 30 seconds
-	timeout := m.cfg.QueryTimeout
-	if timeout <= 0 {
-		timeout = 30 * time.Second
-	}
-
-	// Use context with timeout to ensure connection is returned to pool
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func (m *Manager) ExecuteExec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := m.withQueryTimeout(ctx)
 	defer cancel()
 
 	return m.db.ExecContext(ctx, query, args...)
 }
 
-// BeginTransaction starts a new transaction
-func (m *Manager) BeginTransaction() (*sql.Tx, error) {
-	return m.db.Begin()
+// BeginTransaction starts a new transaction bound to the context
+func (m *Manager) BeginTransaction(ctx context.Context, isolation sql.IsolationLevel) (*sql.Tx, error) {
+	return m.db.BeginTx(ctx, &sql.TxOptions{Isolation: isolation})
+}
+
+// Conn reserves a single connection of the pool, so statements run on it
+// share the session. The caller must close it to return it to the pool.
+func (m *Manager) Conn(ctx context.Context) (*sql.Conn, error) {
+	return m.db.Conn(ctx)
+}
+
+// QueryTimeout returns the configured statement timeout, or 30 seconds
+func (m *Manager) QueryTimeout() time.Duration {
+	if m.cfg.QueryTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return m.cfg.QueryTimeout
 }
 
 // PrepareStatement prepares a statement for execution
-func (m *Manager) PrepareStatement(query string) (*sql.Stmt, error) {
-	return m.db.Prepare(query)
+func (m *Manager) PrepareStatement(ctx context.Context, query string) (*sql.Stmt, error) {
+	return m.db.PrepareContext(ctx, query)
 }
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the SQL quirks of a supported database engine
+type Dialect struct {
+	Name       string // Normalized dialect name: mysql, postgres, sqlite, sqlserver
+	DriverName string // database/sql driver name
+}
+
+var (
+	mysqlDialect     = &Dialect{Name: "mysql", DriverName: "mysql"}
+	postgresDialect  = &Dialect{Name: "postgres", DriverName: "postgres"}
+	sqliteDialect    = &Dialect{Name: "sqlite", DriverName: "sqlite3"}
+	sqlserverDialect = &Dialect{Name: "sqlserver", DriverName: "sqlserver"}
+
+	// nowPattern matches the current timestamp functions of every dialect
+	nowPattern = regexp.MustCompile(`(?i)\b(NOW|GETDATE|SYSDATETIME)\s*\(\s*\)`)
+)
+
+// DialectFor returns the dialect for a configured database type
+func DialectFor(dbType string) (*Dialect, error) {
+	switch strings.ToLower(dbType) {
+	case "mysql":
+		return mysqlDialect, nil
+	case "postgres":
+		return postgresDialect, nil
+	case "sqlite":
+		return sqliteDialect, nil
+	case "mssql", "sqlserver":
+		return sqlserverDialect, nil
+	default:
+		return nil, fmt.Errorf("unsupported database type: %s", dbType)
+	}
+}
+
+// Placeholder returns the bind parameter marker for the n-th (1-based) argument
+func (d *Dialect) Placeholder(n int) string {
+	switch d.Name {
+	case "postgres":
+		return "$" + strconv.Itoa(n)
+	case "sqlserver":
+		return "@p" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+// NowFunc returns the expression yielding the current timestamp
+func (d *Dialect) NowFunc() string {
+	switch d.Name {
+	case "sqlserver":
+		return "GETDATE()"
+	case "sqlite":
+		return "CURRENT_TIMESTAMP"
+	default:
+		return "NOW()"
+	}
+}
+
+// IsolationLevel returns the database/sql isolation level for a configured
+// level name such as read_committed; an empty name is the driver default
+func IsolationLevel(name string) (sql.IsolationLevel, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return sql.LevelDefault, nil
+	case "read_uncommitted":
+		return sql.LevelReadUncommitted, nil
+	case "read_committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable_read":
+		return sql.LevelRepeatableRead, nil
+	case "snapshot":
+		return sql.LevelSnapshot, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	default:
+		return sql.LevelDefault, fmt.Errorf("unsupported isolation level: %s", name)
+	}
+}
+
+// SetIsolationStatement returns the statement changing the isolation level
+// inside a running transaction. Only SQL Server supports this; the other
+// engines fix the level when the transaction begins.
+func (d *Dialect) SetIsolationStatement(name string) (string, error) {
+	if d.Name != "sqlserver" {
+		return "", fmt.Errorf("%s cannot change the isolation level inside a transaction", d.Name)
+	}
+	if _, err := IsolationLevel(name); err != nil {
+		return "", err
+	}
+	return "SET TRANSACTION ISOLATION LEVEL " + strings.ToUpper(strings.ReplaceAll(name, "_", " ")), nil
+}
+
+// Rewrite adapts a portable query to the dialect. Timestamp functions such as
+// NOW() and GETDATE() are mapped to the dialect's equivalent. A '?' is left
+// alone, it may be an operator such as PostgreSQL's jsonb ?|.
+func (d *Dialect) Rewrite(query string) string {
+	return d.rewriteFuncs(query)
+}
+
+// RewriteNamed adapts a portable query that references parameters as :name.
+// Every :name accepted by isParam is replaced with a positional marker; the
+// returned names list the parameter bound to each marker in order.
+func (d *Dialect) RewriteNamed(query string, isParam func(name string) bool) (string, []string) {
+	return d.BindNamed(d.rewriteFuncs(query), isParam)
+}
+
+// BindNamed replaces :name references with the dialect's positional markers
+func (d *Dialect) BindNamed(query string, isParam func(name string) bool) (string, []string) {
+	var names []string
+
+	query = d.rewriteOutsideLiterals(query, func(segment string) string {
+		var b strings.Builder
+		for i := 0; i < len(segment); i++ {
+			c := segment[i]
+			if c != ':' {
+				b.WriteByte(c)
+				continue
+			}
+
+			// Keep casts such as ::int intact
+			if i+1 < len(segment) && segment[i+1] == ':' {
+				b.WriteString("::")
+				i++
+				continue
+			}
+
+			end := i + 1
+			for end < len(segment) && isIdentByte(segment[end], end == i+1) {
+				end++
+			}
+			name := segment[i+1 : end]
+			if name == "" || !isParam(name) {
+				b.WriteByte(c)
+				continue
+			}
+
+			names = append(names, name)
+			b.WriteString(d.Placeholder(len(names)))
+			i = end - 1
+		}
+		return b.String()
+	})
+
+	return query, names
+}
+
+// rewriteFuncs maps timestamp functions to the dialect's equivalent
+func (d *Dialect) rewriteFuncs(query string) string {
+	return d.rewriteOutsideLiterals(query, func(segment string) string {
+		return nowPattern.ReplaceAllString(segment, d.NowFunc())
+	})
+}
+
+// isIdentByte reports whether c may appear in a parameter name
+func isIdentByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	default:
+		return false
+	}
+}
+
+// Rebind replaces positional '?' placeholders with the dialect's markers
+func (d *Dialect) Rebind(query string) string {
+	if d.Placeholder(1) == "?" || !strings.Contains(query, "?") {
+		return query
+	}
+
+	n := 0
+	return d.rewriteOutsideLiterals(query, func(segment string) string {
+		var b strings.Builder
+		for _, r := range segment {
+			if r == '?' {
+				n++
+				b.WriteString(d.Placeholder(n))
+				continue
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	})
+}
+
+// rewriteOutsideLiterals applies fn to every part of query that is not a
+// literal, quoted identifier or comment. An unterminated literal or comment
+// and the rest of the query after it are kept as they are.
+func (d *Dialect) rewriteOutsideLiterals(query string, fn func(string) string) string {
+	var b strings.Builder
+	start, end := 0, 0 // Code not yet passed to fn, end of the scanned pieces
+
+	flush := func() {
+		if start < end {
+			b.WriteString(fn(query[start:end]))
+		}
+	}
+	err := d.Scan(query, func(kind TokenKind, text string) {
+		if kind != TokenCode && kind != TokenWord {
+			flush()
+			b.WriteString(text)
+			start = end + len(text)
+		}
+		end += len(text)
+	})
+	flush()
+	if err != nil {
+		b.WriteString(query[end:])
+	}
+
+	return b.String()
+}
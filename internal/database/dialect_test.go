@@ -0,0 +1,326 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mustDialect returns the dialect of a database type
+func mustDialect(t *testing.T, dbType string) *Dialect {
+	t.Helper()
+	d, err := DialectFor(dbType)
+	if err != nil {
+		t.Fatalf("DialectFor(%s): %v", dbType, err)
+	}
+	return d
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		query   string
+		want    string
+	}{
+		{
+			name:    "postgres now",
+			dialect: "postgres",
+			query:   "SELECT GETDATE(), sysdatetime( )",
+			want:    "SELECT NOW(), NOW()",
+		},
+		{
+			name:    "sqlserver now",
+			dialect: "sqlserver",
+			query:   "INSERT INTO t (at) VALUES (NOW())",
+			want:    "INSERT INTO t (at) VALUES (GETDATE())",
+		},
+		{
+			name:    "sqlite now",
+			dialect: "sqlite",
+			query:   "SELECT now()",
+			want:    "SELECT CURRENT_TIMESTAMP",
+		},
+		{
+			name:    "mysql now",
+			dialect: "mysql",
+			query:   "SELECT KNOW(), GETDATE()",
+			want:    "SELECT KNOW(), NOW()",
+		},
+		{
+			name:    "now in literal",
+			dialect: "sqlite",
+			query:   "SELECT 'NOW()', NOW()",
+			want:    "SELECT 'NOW()', CURRENT_TIMESTAMP",
+		},
+		{
+			name:    "postgres cast",
+			dialect: "postgres",
+			query:   "SELECT NOW()::date",
+			want:    "SELECT NOW()::date",
+		},
+		{
+			name:    "postgres jsonb operators",
+			dialect: "postgres",
+			query:   "SELECT doc ? 'a', doc ?| array['b'] FROM t WHERE at < GETDATE()",
+			want:    "SELECT doc ? 'a', doc ?| array['b'] FROM t WHERE at < NOW()",
+		},
+		{
+			name:    "apostrophe in line comment",
+			dialect: "postgres",
+			query:   "SELECT 1 -- don't\nFROM t WHERE at < GETDATE()",
+			want:    "SELECT 1 -- don't\nFROM t WHERE at < NOW()",
+		},
+		{
+			name:    "now in block comment",
+			dialect: "sqlserver",
+			query:   "SELECT /* NOW() isn't used */ NOW()",
+			want:    "SELECT /* NOW() isn't used */ GETDATE()",
+		},
+		{
+			name:    "mysql hash comment",
+			dialect: "mysql",
+			query:   "SELECT GETDATE() # it's NOW()\n, GETDATE()",
+			want:    "SELECT NOW() # it's NOW()\n, NOW()",
+		},
+		{
+			name:    "sqlserver brackets",
+			dialect: "sqlserver",
+			query:   "SELECT [it's now()], NOW() FROM t",
+			want:    "SELECT [it's now()], GETDATE() FROM t",
+		},
+		{
+			name:    "postgres dollar quotes",
+			dialect: "postgres",
+			query:   "SELECT $$it's NOW()$$, GETDATE()",
+			want:    "SELECT $$it's NOW()$$, NOW()",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustDialect(t, tt.dialect).Rewrite(tt.query); got != tt.want {
+				t.Errorf("Rewrite(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect string
+		query   string
+		want    string
+	}{
+		{dialect: "postgres", query: "SELECT * FROM t WHERE a = ? AND b = ?", want: "SELECT * FROM t WHERE a = $1 AND b = $2"},
+		{dialect: "mssql", query: "UPDATE t SET a = ? WHERE id = ?", want: "UPDATE t SET a = @p1 WHERE id = @p2"},
+		{dialect: "mysql", query: "SELECT * FROM t WHERE a = ?", want: "SELECT * FROM t WHERE a = ?"},
+		{dialect: "sqlite", query: "SELECT * FROM t WHERE a = ?", want: "SELECT * FROM t WHERE a = ?"},
+		{dialect: "postgres", query: "SELECT '?', \"a?\" FROM t WHERE a = ?::int", want: "SELECT '?', \"a?\" FROM t WHERE a = $1::int"},
+		{dialect: "postgres", query: "SELECT ? -- why?\n, ?", want: "SELECT $1 -- why?\n, $2"},
+		{dialect: "mssql", query: "SELECT [a?], ? /* ? */", want: "SELECT [a?], @p1 /* ? */"},
+	}
+
+	for _, tt := range tests {
+		if got := mustDialect(t, tt.dialect).Rebind(tt.query); got != tt.want {
+			t.Errorf("%s: Rebind(%q) = %q, want %q", tt.dialect, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBindNamed(t *testing.T) {
+	params := map[string]bool{"id": true, "name": true, "p2": true}
+	isParam := func(name string) bool { return params[strings.ToLower(name)] }
+
+	tests := []struct {
+		name      string
+		dialect   string
+		query     string
+		want      string
+		wantNames []string
+	}{
+		{
+			name:      "postgres",
+			dialect:   "postgres",
+			query:     "SELECT * FROM t WHERE id = :id AND name = :name",
+			want:      "SELECT * FROM t WHERE id = $1 AND name = $2",
+			wantNames: []string{"id", "name"},
+		},
+		{
+			name:      "sqlserver",
+			dialect:   "mssql",
+			query:     "SELECT * FROM t WHERE id = :id AND name = :name",
+			want:      "SELECT * FROM t WHERE id = @p1 AND name = @p2",
+			wantNames: []string{"id", "name"},
+		},
+		{
+			name:      "mysql",
+			dialect:   "mysql",
+			query:     "SELECT * FROM t WHERE id = :id AND name = :name",
+			want:      "SELECT * FROM t WHERE id = ? AND name = ?",
+			wantNames: []string{"id", "name"},
+		},
+		{
+			name:      "sqlite",
+			dialect:   "sqlite",
+			query:     "SELECT * FROM t WHERE id = :id",
+			want:      "SELECT * FROM t WHERE id = ?",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "repeated parameter",
+			dialect:   "postgres",
+			query:     "SELECT :id, :name, :id",
+			want:      "SELECT $1, $2, $3",
+			wantNames: []string{"id", "name", "id"},
+		},
+		{
+			name:      "postgres casts",
+			dialect:   "postgres",
+			query:     "SELECT :id::bigint, created::date, :name::text",
+			want:      "SELECT $1::bigint, created::date, $2::text",
+			wantNames: []string{"id", "name"},
+		},
+		{
+			name:      "cast to a parameter name",
+			dialect:   "postgres",
+			query:     "SELECT x::id FROM t",
+			want:      "SELECT x::id FROM t",
+			wantNames: nil,
+		},
+		{
+			name:      "single quoted literal",
+			dialect:   "postgres",
+			query:     "SELECT ':id', 'a '':name'' b' WHERE id = :id",
+			want:      "SELECT ':id', 'a '':name'' b' WHERE id = $1",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "quoted identifiers",
+			dialect:   "mysql",
+			query:     "SELECT `:id`, \":name\" FROM t WHERE id = :id",
+			want:      "SELECT `:id`, \":name\" FROM t WHERE id = ?",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "mysql backslash escape",
+			dialect:   "mysql",
+			query:     "SELECT 'it\\'s :id' WHERE id = :id",
+			want:      "SELECT 'it\\'s :id' WHERE id = ?",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "apostrophe in comment",
+			dialect:   "postgres",
+			query:     "SELECT :id -- don't bind :name\n, :name /* it's :id */",
+			want:      "SELECT $1 -- don't bind :name\n, $2 /* it's :id */",
+			wantNames: []string{"id", "name"},
+		},
+		{
+			name:      "sqlserver brackets",
+			dialect:   "sqlserver",
+			query:     "SELECT [it's :id] FROM t WHERE id = :id",
+			want:      "SELECT [it's :id] FROM t WHERE id = @p1",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "postgres dollar quotes",
+			dialect:   "postgres",
+			query:     "SELECT $$:id$$, :id",
+			want:      "SELECT $$:id$$, $1",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "unknown names",
+			dialect:   "sqlserver",
+			query:     "SELECT :missing, :id, :",
+			want:      "SELECT :missing, @p1, :",
+			wantNames: []string{"id"},
+		},
+		{
+			name:      "name with digits",
+			dialect:   "postgres",
+			query:     "SELECT :p2, :2",
+			want:      "SELECT $1, :2",
+			wantNames: []string{"p2"},
+		},
+		{
+			name:      "name followed by punctuation",
+			dialect:   "sqlserver",
+			query:     "INSERT INTO t VALUES (:id,:name)",
+			want:      "INSERT INTO t VALUES (@p1,@p2)",
+			wantNames: []string{"id", "name"},
+		},
+		{
+			name:      "unterminated literal",
+			dialect:   "postgres",
+			query:     "SELECT :id, ':name",
+			want:      "SELECT $1, ':name",
+			wantNames: []string{"id"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, names := mustDialect(t, tt.dialect).BindNamed(tt.query, isParam)
+			if got != tt.want {
+				t.Errorf("BindNamed(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("BindNamed(%q) names = %v, want %v", tt.query, names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestRewriteNamed(t *testing.T) {
+	isParam := func(name string) bool { return name == "id" }
+
+	got, names := mustDialect(t, "sqlserver").RewriteNamed("UPDATE t SET at = NOW(), note = 'NOW() :id' WHERE id = :id", isParam)
+	if want := "UPDATE t SET at = GETDATE(), note = 'NOW() :id' WHERE id = @p1"; got != want {
+		t.Errorf("RewriteNamed = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(names, []string{"id"}) {
+		t.Errorf("RewriteNamed names = %v, want [id]", names)
+	}
+}
+
+func TestRewriteOutsideLiterals(t *testing.T) {
+	tests := []struct {
+		dialect string
+		query   string
+		want    []string // Segments passed to fn
+	}{
+		{dialect: "postgres", query: "SELECT 1", want: []string{"SELECT 1"}},
+		{dialect: "postgres", query: "a 'b' c", want: []string{"a ", " c"}},
+		{dialect: "postgres", query: `a "b" c`, want: []string{"a ", " c"}},
+		{dialect: "mysql", query: "a `b` c", want: []string{"a ", " c"}},
+		{dialect: "postgres", query: "a 'it''s' c", want: []string{"a ", " c"}},
+		{dialect: "postgres", query: `a "it's" c`, want: []string{"a ", " c"}},
+		{dialect: "postgres", query: `a E'it\'s' c`, want: []string{"a E", " c"}},
+		{dialect: "mysql", query: `a 'it\'s' c`, want: []string{"a ", " c"}},
+		{dialect: "mysql", query: "a `x\\` c", want: []string{"a ", " c"}},
+		{dialect: "postgres", query: "a -- it's\nc", want: []string{"a ", "c"}},
+		{dialect: "mysql", query: "a --it's", want: []string{"a --it"}},
+		{dialect: "mysql", query: "a # it's\nc", want: []string{"a ", "c"}},
+		{dialect: "postgres", query: "a /* /* it's */ */ c", want: []string{"a ", " c"}},
+		{dialect: "mysql", query: "a /*!50000 b */ c", want: []string{"a /*!50000 b */ c"}},
+		{dialect: "mssql", query: "a [it's] c", want: []string{"a ", " c"}},
+		{dialect: "postgres", query: "a $tag$it's$tag$ c", want: []string{"a ", " c"}},
+		{dialect: "postgres", query: "a 'b", want: []string{"a "}},
+	}
+
+	for _, tt := range tests {
+		var segments []string
+		got := mustDialect(t, tt.dialect).rewriteOutsideLiterals(tt.query, func(segment string) string {
+			segments = append(segments, segment)
+			return segment
+		})
+		if !reflect.DeepEqual(segments, tt.want) {
+			t.Errorf("%s: segments of %q = %q, want %q", tt.dialect, tt.query, segments, tt.want)
+		}
+		if got != tt.query {
+			t.Errorf("%s: unchanged segments of %q were reassembled as %q", tt.dialect, tt.query, got)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Error classes reported in metrics
+const (
+	ErrorClassDeadlock      = "deadlock"
+	ErrorClassSerialization = "serialization"
+	ErrorClassLockTimeout   = "lock_timeout"
+	ErrorClassConnection    = "connection"
+	ErrorClassLogin         = "login"
+	ErrorClassTimeout       = "timeout"
+	ErrorClassConstraint    = "constraint"
+	ErrorClassSyntax        = "syntax"
+	ErrorClassCanceled      = "canceled"
+	ErrorClassOther         = "other"
+)
+
+// TransientErrorClasses are the classes that may succeed when retried and are
+// retried by default
+var TransientErrorClasses = []string{
+	ErrorClassDeadlock,
+	ErrorClassSerialization,
+	ErrorClassLockTimeout,
+	ErrorClassConnection,
+}
+
+// ClassifyError returns the error class of a query error. Server errors are
+// classified by their driver error code, network failures by their type.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var msErr mssql.Error
+	if errors.As(err, &msErr) {
+		return classifyMSSQL(msErr.Number)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(string(pqErr.Code))
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return classifyMySQL(myErr.Number)
+	}
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		return classifySQLite(liteErr.Code)
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case isConnectionError(err):
+		return ErrorClassConnection
+	default:
+		return ErrorClassOther
+	}
+}
+
+// classifyMSSQL classifies a SQL Server error number
+func classifyMSSQL(number int32) string {
+	switch number {
+	case 1205: // Transaction was deadlocked and chosen as the victim
+		return ErrorClassDeadlock
+	case 3960, 3961: // Snapshot isolation update conflict
+		return ErrorClassSerialization
+	case 1222: // Lock request time out period exceeded
+		return ErrorClassLockTimeout
+	case 18456, 18452, 4060: // Login failed, cannot open database
+		return ErrorClassLogin
+	case 233, 10053, 10054, 10060: // Transport level errors
+		return ErrorClassConnection
+	case 2627, 2601, 547, 515: // Unique key, foreign key/check, NULL violations
+		return ErrorClassConstraint
+	case 102, 105, 156, 170, 207, 208, 2812: // Syntax, unknown column/object/procedure
+		return ErrorClassSyntax
+	default:
+		return ErrorClassOther
+	}
+}
+
+// classifyPostgres classifies a PostgreSQL SQLSTATE code
+func classifyPostgres(code string) string {
+	switch code {
+	case "40P01": // deadlock_detected
+		return ErrorClassDeadlock
+	case "40001": // serialization_failure
+		return ErrorClassSerialization
+	case "55P03": // lock_not_available
+		return ErrorClassLockTimeout
+	case "57014": // query_canceled, raised by statement_timeout
+		return ErrorClassTimeout
+	case "57P01", "57P02", "57P03", "53300": // Server shutdown, too many connections
+		return ErrorClassConnection
+	}
+
+	switch {
+	case strings.HasPrefix(code, "28"): // Invalid authorization specification
+		return ErrorClassLogin
+	case strings.HasPrefix(code, "08"): // Connection exception
+		return ErrorClassConnection
+	case strings.HasPrefix(code, "23"): // Integrity constraint violation
+		return ErrorClassConstraint
+	case strings.HasPrefix(code, "42"): // Syntax error or access rule violation
+		return ErrorClassSyntax
+	default:
+		return ErrorClassOther
+	}
+}
+
+// classifyMySQL classifies a MySQL server error number
+func classifyMySQL(number uint16) string {
+	switch number {
+	case 1213: // ER_LOCK_DEADLOCK
+		return ErrorClassDeadlock
+	case 1205: // ER_LOCK_WAIT_TIMEOUT
+		return ErrorClassLockTimeout
+	case 1045, 1044, 1049: // Access denied, unknown database
+		return ErrorClassLogin
+	case 1040, 1053, 1152, 1153, 1159, 1161: // Too many connections, shutdown, aborted connection, network errors
+		return ErrorClassConnection
+	case 3024, 1317: // Max execution time exceeded, query interrupted
+		return ErrorClassTimeout
+	case 1062, 1048, 1216, 1217, 1451, 1452, 3819: // Duplicate key, NULL, foreign key, check violations
+		return ErrorClassConstraint
+	case 1064, 1054, 1146, 1149: // Syntax error, unknown column/table
+		return ErrorClassSyntax
+	default:
+		return ErrorClassOther
+	}
+}
+
+// classifySQLite classifies an SQLite result code
+func classifySQLite(code sqlite3.ErrNo) string {
+	switch code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return ErrorClassLockTimeout
+	case sqlite3.ErrConstraint:
+		return ErrorClassConstraint
+	case sqlite3.ErrAuth, sqlite3.ErrPerm:
+		return ErrorClassLogin
+	case sqlite3.ErrError: // SQL error or missing table
+		return ErrorClassSyntax
+	default:
+		return ErrorClassOther
+	}
+}
+
+// isConnectionError reports whether an error is a broken or refused connection
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, mysql.ErrInvalidConn),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "nil", err: nil, want: ""},
+
+		{name: "mssql deadlock", err: mssql.Error{Number: 1205}, want: ErrorClassDeadlock},
+		{name: "mssql snapshot conflict", err: mssql.Error{Number: 3960}, want: ErrorClassSerialization},
+		{name: "mssql lock timeout", err: mssql.Error{Number: 1222}, want: ErrorClassLockTimeout},
+		{name: "mssql login failed", err: mssql.Error{Number: 18456}, want: ErrorClassLogin},
+		{name: "mssql transport", err: mssql.Error{Number: 10054}, want: ErrorClassConnection},
+		{name: "mssql unique key", err: mssql.Error{Number: 2627}, want: ErrorClassConstraint},
+		{name: "mssql invalid object", err: mssql.Error{Number: 208}, want: ErrorClassSyntax},
+		{name: "mssql other", err: mssql.Error{Number: 8134}, want: ErrorClassOther},
+
+		{name: "postgres deadlock", err: &pq.Error{Code: "40P01"}, want: ErrorClassDeadlock},
+		{name: "postgres serialization", err: &pq.Error{Code: "40001"}, want: ErrorClassSerialization},
+		{name: "postgres lock not available", err: &pq.Error{Code: "55P03"}, want: ErrorClassLockTimeout},
+		{name: "postgres statement timeout", err: &pq.Error{Code: "57014"}, want: ErrorClassTimeout},
+		{name: "postgres too many connections", err: &pq.Error{Code: "53300"}, want: ErrorClassConnection},
+		{name: "postgres connection exception", err: &pq.Error{Code: "08006"}, want: ErrorClassConnection},
+		{name: "postgres authentication", err: &pq.Error{Code: "28P01"}, want: ErrorClassLogin},
+		{name: "postgres unique violation", err: &pq.Error{Code: "23505"}, want: ErrorClassConstraint},
+		{name: "postgres undefined table", err: &pq.Error{Code: "42P01"}, want: ErrorClassSyntax},
+		{name: "postgres other", err: &pq.Error{Code: "22012"}, want: ErrorClassOther},
+
+		{name: "mysql deadlock", err: &mysql.MySQLError{Number: 1213}, want: ErrorClassDeadlock},
+		{name: "mysql lock wait timeout", err: &mysql.MySQLError{Number: 1205}, want: ErrorClassLockTimeout},
+		{name: "mysql access denied", err: &mysql.MySQLError{Number: 1045}, want: ErrorClassLogin},
+		{name: "mysql too many connections", err: &mysql.MySQLError{Number: 1040}, want: ErrorClassConnection},
+		{name: "mysql max execution time", err: &mysql.MySQLError{Number: 3024}, want: ErrorClassTimeout},
+		{name: "mysql duplicate key", err: &mysql.MySQLError{Number: 1062}, want: ErrorClassConstraint},
+		{name: "mysql syntax", err: &mysql.MySQLError{Number: 1064}, want: ErrorClassSyntax},
+		{name: "mysql other", err: &mysql.MySQLError{Number: 1365}, want: ErrorClassOther},
+
+		{name: "sqlite busy", err: sqlite3.Error{Code: sqlite3.ErrBusy}, want: ErrorClassLockTimeout},
+		{name: "sqlite locked", err: sqlite3.Error{Code: sqlite3.ErrLocked}, want: ErrorClassLockTimeout},
+		{name: "sqlite constraint", err: sqlite3.Error{Code: sqlite3.ErrConstraint}, want: ErrorClassConstraint},
+		{name: "sqlite permission", err: sqlite3.Error{Code: sqlite3.ErrPerm}, want: ErrorClassLogin},
+		{name: "sqlite error", err: sqlite3.Error{Code: sqlite3.ErrError}, want: ErrorClassSyntax},
+		{name: "sqlite other", err: sqlite3.Error{Code: sqlite3.ErrFull}, want: ErrorClassOther},
+
+		{name: "wrapped driver error", err: fmt.Errorf("query failed: %w", &pq.Error{Code: "40001"}), want: ErrorClassSerialization},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: ErrorClassTimeout},
+		{name: "canceled", err: fmt.Errorf("query failed: %w", context.Canceled), want: ErrorClassCanceled},
+		{name: "bad connection", err: driver.ErrBadConn, want: ErrorClassConnection},
+		{name: "mysql invalid connection", err: mysql.ErrInvalidConn, want: ErrorClassConnection},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: ErrorClassConnection},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, want: ErrorClassConnection},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: ErrorClassConnection},
+		{name: "plain error", err: errors.New("something failed"), want: ErrorClassOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TokenKind is the kind of a piece of a query reported by Scan
+type TokenKind int
+
+// Token kinds
+const (
+	TokenCode       TokenKind = iota // Operators, numbers, whitespace and executable comments
+	TokenWord                        // Keyword or unquoted identifier
+	TokenString                      // String literal, with its quotes
+	TokenIdentifier                  // Quoted identifier, with its quotes
+	TokenComment                     // Line or block comment
+)
+
+// quoting holds the rules by which a dialect's server finds the literals,
+// quoted identifiers and comments of a query
+type quoting struct {
+	backslashStrings   bool // '' and "" strings escape with backslashes (MySQL)
+	escapeStrings      bool // E'' strings escape with backslashes (PostgreSQL)
+	backticks          bool // `identifier`
+	brackets           bool // [identifier]
+	dollarQuotes       bool // $tag$string$tag$
+	nestedComments     bool // /* /* */ */
+	hashComments       bool // # comment
+	dashNeedsSpace     bool // -- starts a comment only before whitespace
+	executableComments bool // /*! code */ is executed
+}
+
+// quoting returns the quoting rules of the dialect
+func (d *Dialect) quoting() quoting {
+	switch d.Name {
+	case "mysql":
+		return quoting{backslashStrings: true, backticks: true, hashComments: true, dashNeedsSpace: true, executableComments: true}
+	case "postgres":
+		return quoting{escapeStrings: true, dollarQuotes: true, nestedComments: true}
+	case "sqlserver":
+		return quoting{brackets: true, nestedComments: true}
+	default:
+		return quoting{backticks: true, brackets: true}
+	}
+}
+
+// Scan splits a query into words, literals, quoted identifiers, comments and
+// the code between them the way the dialect's server reads them, and calls
+// fn with every piece in order. The contents of MySQL's executable comments
+// are code. An unterminated literal or comment is an error; fn has seen the
+// pieces before it.
+func (d *Dialect) Scan(query string, fn func(kind TokenKind, text string)) error {
+	rules := d.quoting()
+
+	code := 0 // Start of the code not yet passed to fn
+	emit := func(kind TokenKind, start, end int) {
+		if code < start {
+			fn(TokenCode, query[code:start])
+		}
+		fn(kind, query[start:end])
+		code = end
+	}
+
+	i := 0
+	fail := func(err error) error {
+		if code < i {
+			fn(TokenCode, query[code:i])
+		}
+		return err
+	}
+
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--") &&
+			(!rules.dashNeedsSpace || i+2 == len(query) || query[i+2] <= ' '),
+			c == '#' && rules.hashComments:
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 1
+			}
+			emit(TokenComment, i, end)
+			i = end
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*!") && rules.executableComments:
+			// The server runs the contents, scan them as code
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end, err := skipComment(query, i, rules.nestedComments)
+			if err != nil {
+				return fail(err)
+			}
+			emit(TokenComment, i, end)
+			i = end
+
+		case c == '\'':
+			escapes := rules.backslashStrings || (rules.escapeStrings && i > 0 &&
+				(query[i-1] == 'E' || query[i-1] == 'e') && (i == 1 || !isWordByte(query[i-2])))
+			end, err := skipQuoted(query, i, '\'', escapes)
+			if err != nil {
+				return fail(err)
+			}
+			emit(TokenString, i, end)
+			i = end
+
+		case c == '"':
+			end, err := skipQuoted(query, i, '"', rules.backslashStrings)
+			if err != nil {
+				return fail(err)
+			}
+			// MySQL reads "" as a string unless ANSI_QUOTES is set
+			if rules.backslashStrings {
+				emit(TokenString, i, end)
+			} else {
+				emit(TokenIdentifier, i, end)
+			}
+			i = end
+
+		case c == '`' && rules.backticks:
+			end, err := skipQuoted(query, i, '`', false)
+			if err != nil {
+				return fail(err)
+			}
+			emit(TokenIdentifier, i, end)
+			i = end
+
+		case c == '[' && rules.brackets:
+			end, err := skipQuoted(query, i, ']', false)
+			if err != nil {
+				return fail(err)
+			}
+			emit(TokenIdentifier, i, end)
+			i = end
+
+		case c == '$' && rules.dollarQuotes && dollarTag(query[i:]) != "":
+			tag := dollarTag(query[i:])
+			end := strings.Index(query[i+len(tag):], tag)
+			if end < 0 {
+				return fail(fmt.Errorf("unterminated dollar-quoted string"))
+			}
+			end = i + len(tag) + end + len(tag)
+			emit(TokenString, i, end)
+			i = end
+
+		case isWordByte(c) && !(c >= '0' && c <= '9'):
+			start := i
+			for i < len(query) && isWordByte(query[i]) {
+				i++
+			}
+			emit(TokenWord, start, i)
+
+		default:
+			i++
+		}
+	}
+	if code < len(query) {
+		fn(TokenCode, query[code:])
+	}
+
+	return nil
+}
+
+// skipComment returns the index after the block comment that starts at
+// query[start]
+func skipComment(query string, start int, nested bool) (int, error) {
+	depth := 0
+	for i := start; i+1 < len(query); i++ {
+		switch {
+		case query[i] == '/' && query[i+1] == '*' && (nested || depth == 0):
+			depth++
+			i++
+		case query[i] == '*' && query[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated comment")
+}
+
+// skipQuoted returns the index after the literal or quoted identifier that
+// starts at query[start] and ends with the closing byte. Doubled closing
+// bytes are escapes, as are backslashes if escapes is set.
+func skipQuoted(query string, start int, closing byte, escapes bool) (int, error) {
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case closing:
+			if i+1 < len(query) && query[i+1] == closing {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated quoted string or identifier")
+}
+
+// dollarTag returns the PostgreSQL dollar quote tag such as $$ or $body$ at
+// the start of s, or "" if s does not start with one
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// isWordByte reports whether c is part of a keyword or identifier. @ and #
+// belong to SQL Server variables and temporary tables.
+func isWordByte(c byte) bool {
+	switch {
+	case c == '_', c == '@', c == '#', c == '$':
+		return true
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// Parameter types arguments can be bound as
+const (
+	ParamInt              = "int"
+	ParamDecimal          = "decimal"
+	ParamDateTime         = "datetime"
+	ParamUniqueIdentifier = "uniqueidentifier"
+	ParamNVarChar         = "nvarchar"
+)
+
+// ParamTypes lists the supported parameter types
+var ParamTypes = []string{ParamInt, ParamDecimal, ParamDateTime, ParamUniqueIdentifier, ParamNVarChar}
+
+var (
+	// decimalPattern matches exact decimal numbers such as -12.50
+	decimalPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)$`)
+
+	// dateTimeLayouts are the accepted datetime formats, most specific first
+	dateTimeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02",
+	}
+)
+
+// ValidParamType reports whether a parameter type is supported; the empty
+// type binds values as they are
+func ValidParamType(paramType string) bool {
+	if paramType == "" {
+		return true
+	}
+	for _, t := range ParamTypes {
+		if t == paramType {
+			return true
+		}
+	}
+	return false
+}
+
+// BindValue converts a JSON decoded argument to the driver value of a
+// parameter type. On SQL Server the value is wrapped in the go-mssqldb type
+// that sends it as that SQL type. Without a type numbers are bound as
+// int64 or float64 and other values unchanged. nil is always bound as NULL.
+func (d *Dialect) BindValue(paramType string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch paramType {
+	case "":
+		if number, ok := value.(json.Number); ok {
+			if n, err := number.Int64(); err == nil {
+				return n, nil
+			}
+			return number.Float64()
+		}
+		return value, nil
+
+	case ParamInt:
+		n, err := strconv.ParseInt(argText(value), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not an int", value)
+		}
+		return n, nil
+
+	case ParamDecimal:
+		// Sent as text so no digit is lost to float64, the server converts it
+		text := argText(value)
+		if !decimalPattern.MatchString(text) {
+			return nil, fmt.Errorf("%v is not a decimal", value)
+		}
+		if d.Name == "sqlserver" {
+			return mssql.VarChar(text), nil
+		}
+		return text, nil
+
+	case ParamDateTime:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a datetime string", value)
+		}
+		for _, layout := range dateTimeLayouts {
+			if t, err := time.Parse(layout, text); err == nil {
+				if d.Name == "sqlserver" {
+					return mssql.DateTime1(t), nil
+				}
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a datetime, use RFC 3339 such as 2024-01-31T15:04:05Z", text)
+
+	case ParamUniqueIdentifier:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a uniqueidentifier string", value)
+		}
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "{"), "}")
+		var id mssql.UniqueIdentifier
+		if len(text) != 36 || strings.Count(text, "-") != 4 || id.Scan(text) != nil {
+			return nil, fmt.Errorf("%q is not a uniqueidentifier", text)
+		}
+		if d.Name == "sqlserver" {
+			return id, nil
+		}
+		return strings.ToLower(text), nil
+
+	case ParamNVarChar:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a string", value)
+		}
+		return text, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported parameter type %q", paramType)
+	}
+}
+
+// argText returns the text of a JSON number or string argument
+func argText(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case string:
+		return strings.TrimSpace(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
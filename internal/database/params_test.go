@@ -0,0 +1,85 @@
+package database
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestBindValue(t *testing.T) {
+	at := time.Date(2024, 1, 31, 15, 4, 5, 0, time.UTC)
+	id := mssql.UniqueIdentifier{}
+	if err := id.Scan("6F9619FF-8B86-D011-B42D-00C04FC964FF"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		dialect   string
+		paramType string
+		value     interface{}
+		want      interface{}
+		wantErr   bool
+	}{
+		{name: "null", dialect: "sqlserver", paramType: ParamInt, value: nil, want: nil},
+		{name: "untyped int", dialect: "postgres", value: json.Number("42"), want: int64(42)},
+		{name: "untyped float", dialect: "postgres", value: json.Number("4.5"), want: 4.5},
+		{name: "untyped string", dialect: "postgres", value: "abc", want: "abc"},
+
+		{name: "int", dialect: "postgres", paramType: ParamInt, value: json.Number("7"), want: int64(7)},
+		{name: "int from string", dialect: "postgres", paramType: ParamInt, value: " 7 ", want: int64(7)},
+		{name: "int fraction", dialect: "postgres", paramType: ParamInt, value: json.Number("7.5"), wantErr: true},
+
+		// Decimals keep every digit
+		{name: "decimal", dialect: "postgres", paramType: ParamDecimal, value: json.Number("12345678901234567.89"), want: "12345678901234567.89"},
+		{name: "decimal sqlserver", dialect: "sqlserver", paramType: ParamDecimal, value: "-12.50", want: mssql.VarChar("-12.50")},
+		{name: "decimal invalid", dialect: "postgres", paramType: ParamDecimal, value: "1e5", wantErr: true},
+
+		{name: "datetime", dialect: "postgres", paramType: ParamDateTime, value: "2024-01-31T15:04:05Z", want: at},
+		{name: "datetime without zone", dialect: "mysql", paramType: ParamDateTime, value: "2024-01-31 15:04:05", want: at},
+		{name: "datetime sqlserver", dialect: "sqlserver", paramType: ParamDateTime, value: "2024-01-31T15:04:05Z", want: mssql.DateTime1(at)},
+		{name: "datetime number", dialect: "postgres", paramType: ParamDateTime, value: json.Number("1706713445"), wantErr: true},
+		{name: "datetime invalid", dialect: "postgres", paramType: ParamDateTime, value: "31/01/2024", wantErr: true},
+
+		{name: "uniqueidentifier", dialect: "postgres", paramType: ParamUniqueIdentifier, value: "{6F9619FF-8B86-D011-B42D-00C04FC964FF}", want: "6f9619ff-8b86-d011-b42d-00c04fc964ff"},
+		{name: "uniqueidentifier sqlserver", dialect: "sqlserver", paramType: ParamUniqueIdentifier, value: "6F9619FF-8B86-D011-B42D-00C04FC964FF", want: id},
+		{name: "uniqueidentifier invalid", dialect: "sqlserver", paramType: ParamUniqueIdentifier, value: "6F9619FF8B86D011B42D00C04FC964FF", wantErr: true},
+
+		{name: "nvarchar", dialect: "sqlserver", paramType: ParamNVarChar, value: "Çağrı", want: "Çağrı"},
+		{name: "nvarchar number", dialect: "sqlserver", paramType: ParamNVarChar, value: json.Number("1"), wantErr: true},
+
+		{name: "unknown type", dialect: "postgres", paramType: "money", value: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mustDialect(t, tt.dialect).BindValue(tt.paramType, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("BindValue(%q, %v) = %v (%T), want an error", tt.paramType, tt.value, got, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BindValue(%q, %v): %v", tt.paramType, tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BindValue(%q, %v) = %v (%T), want %v (%T)", tt.paramType, tt.value, got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidParamType(t *testing.T) {
+	for _, paramType := range append([]string{""}, ParamTypes...) {
+		if !ValidParamType(paramType) {
+			t.Errorf("ValidParamType(%q) = false", paramType)
+		}
+	}
+	if ValidParamType("money") {
+		t.Error("ValidParamType(money) = true")
+	}
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+var (
+	// procedureNamePattern matches optionally schema qualified procedure names
+	// such as dbo.usp_orders or [sales].[Get Orders]
+	procedureNamePattern = regexp.MustCompile(`^(\[[^\[\]]+\]|[A-Za-z_][A-Za-z0-9_$#@]*)(\.(\[[^\[\]]+\]|[A-Za-z_][A-Za-z0-9_$#@]*)){0,2}$`)
+
+	// procedureParamPattern matches parameter names, an @ prefix is optional
+	procedureParamPattern = regexp.MustCompile(`^@?[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ProcedureParam is a named parameter of a stored procedure call
+type ProcedureParam struct {
+	Name   string
+	Type   string      // Parameter type, see ParamTypes; outputs without a value need one
+	Value  interface{} // JSON decoded input value, or the initial value of an output
+	Output bool
+}
+
+// ProcedureCall is a stored procedure call prepared for a dialect. Run SQL
+// with Args as a query; output values and the return status are set once the
+// rows of the call are closed.
+type ProcedureCall struct {
+	SQL  string
+	Args []interface{}
+
+	outputs      map[string]interface{} // Output parameter destinations by name (SQL Server)
+	outputRow    bool                   // Outputs are returned as the only result row (PostgreSQL)
+	returnStatus *mssql.ReturnStatus
+}
+
+// NewProcedureCall prepares the call of a stored procedure with named
+// parameters. SQL Server calls the procedure over RPC with OUTPUT parameters
+// and a return status. PostgreSQL runs CALL in named notation and returns
+// output parameters as a result row.
+func (d *Dialect) NewProcedureCall(name string, params []ProcedureParam) (*ProcedureCall, error) {
+	if !procedureNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid procedure name %q", name)
+	}
+	for _, param := range params {
+		if !procedureParamPattern.MatchString(param.Name) {
+			return nil, fmt.Errorf("invalid parameter name %q", param.Name)
+		}
+	}
+
+	switch d.Name {
+	case "sqlserver":
+		return d.newSQLServerCall(name, params)
+	case "postgres":
+		return d.newPostgresCall(name, params)
+	default:
+		return nil, fmt.Errorf("stored procedure calls are not supported on %s", d.Name)
+	}
+}
+
+// newSQLServerCall prepares an RPC call; go-mssqldb calls a bare procedure
+// name as a procedure instead of running it as a batch
+func (d *Dialect) newSQLServerCall(name string, params []ProcedureParam) (*ProcedureCall, error) {
+	call := &ProcedureCall{SQL: name, outputs: make(map[string]interface{}), returnStatus: new(mssql.ReturnStatus)}
+
+	for _, param := range params {
+		paramName := strings.TrimPrefix(param.Name, "@")
+		if !param.Output {
+			value, err := d.BindValue(param.Type, param.Value)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %w", param.Name, err)
+			}
+			call.Args = append(call.Args, sql.Named(paramName, value))
+			continue
+		}
+
+		value, err := d.outputValue(param)
+		if err != nil {
+			return nil, fmt.Errorf("output parameter %s: %w", param.Name, err)
+		}
+		// The driver writes the output back into a pointer of the value's type
+		dest := reflect.New(reflect.TypeOf(value))
+		dest.Elem().Set(reflect.ValueOf(value))
+		call.outputs[paramName] = dest.Interface()
+		call.Args = append(call.Args, sql.Named(paramName, sql.Out{Dest: dest.Interface(), In: param.Value != nil}))
+	}
+
+	call.Args = append(call.Args, call.returnStatus)
+	return call, nil
+}
+
+// outputValue returns the typed initial value of a SQL Server output
+// parameter. Strings are sent unsized so long outputs are not truncated.
+func (d *Dialect) outputValue(param ProcedureParam) (interface{}, error) {
+	if param.Value == nil {
+		switch param.Type {
+		case ParamInt:
+			return int64(0), nil
+		case ParamDecimal:
+			return mssql.VarCharMax(""), nil
+		case ParamDateTime:
+			return mssql.DateTime1(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)), nil
+		case ParamUniqueIdentifier:
+			return mssql.UniqueIdentifier{}, nil
+		case ParamNVarChar:
+			return mssql.NVarCharMax(""), nil
+		case "":
+			return nil, fmt.Errorf("a type or an initial value is required")
+		default:
+			return nil, fmt.Errorf("unsupported parameter type %q", param.Type)
+		}
+	}
+
+	value, err := d.BindValue(param.Type, param.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case string:
+		return mssql.NVarCharMax(v), nil
+	case mssql.VarChar:
+		return mssql.VarCharMax(v), nil
+	default:
+		return value, nil
+	}
+}
+
+// newPostgresCall prepares CALL name(param => $1, ...). Output parameters are
+// passed as NULL and returned by the server as a result row.
+func (d *Dialect) newPostgresCall(name string, params []ProcedureParam) (*ProcedureCall, error) {
+	call := &ProcedureCall{}
+	arguments := make([]string, 0, len(params))
+
+	for _, param := range params {
+		paramName := strings.TrimPrefix(param.Name, "@")
+		if param.Output {
+			call.outputRow = true
+			if param.Value == nil {
+				arguments = append(arguments, paramName+" => NULL")
+				continue
+			}
+		}
+
+		value, err := d.BindValue(param.Type, param.Value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", param.Name, err)
+		}
+		call.Args = append(call.Args, value)
+		arguments = append(arguments, paramName+" => "+d.Placeholder(len(call.Args)))
+	}
+
+	call.SQL = fmt.Sprintf("CALL %s(%s)", name, strings.Join(arguments, ", "))
+	return call, nil
+}
+
+// OutputRow reports whether the output values are returned as the only row
+// of the call's result instead of through Outputs
+func (c *ProcedureCall) OutputRow() bool {
+	return c.outputRow
+}
+
+// Outputs returns the output parameter values by name once the rows of the
+// call are closed
+func (c *ProcedureCall) Outputs() map[string]interface{} {
+	if len(c.outputs) == 0 {
+		return nil
+	}
+
+	outputs := make(map[string]interface{}, len(c.outputs))
+	for name, dest := range c.outputs {
+		switch v := reflect.ValueOf(dest).Elem().Interface().(type) {
+		case mssql.UniqueIdentifier:
+			outputs[name] = v.String()
+		case mssql.VarCharMax:
+			outputs[name] = string(v)
+		case mssql.NVarCharMax:
+			outputs[name] = string(v)
+		case mssql.VarChar:
+			outputs[name] = string(v)
+		case mssql.DateTime1:
+			outputs[name] = time.Time(v)
+		default:
+			outputs[name] = v
+		}
+	}
+	return outputs
+}
+
+// ReturnStatus returns the return status of the procedure once the rows of
+// the call are closed. Only SQL Server reports one.
+func (c *ProcedureCall) ReturnStatus() (int64, bool) {
+	if c.returnStatus == nil {
+		return 0, false
+	}
+	return int64(*c.returnStatus), true
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+func TestNewProcedureCallSQLServer(t *testing.T) {
+	call, err := mustDialect(t, "sqlserver").NewProcedureCall("sales.usp_orders", []ProcedureParam{
+		{Name: "@customer", Type: ParamInt, Value: json.Number("42")},
+		{Name: "note", Value: "rush"},
+		{Name: "total", Type: ParamDecimal, Output: true},
+		{Name: "label", Value: "draft", Output: true},
+	})
+	if err != nil {
+		t.Fatalf("NewProcedureCall: %v", err)
+	}
+
+	// The driver calls a bare procedure name over RPC
+	if call.SQL != "sales.usp_orders" {
+		t.Errorf("SQL = %q, want the procedure name", call.SQL)
+	}
+	if len(call.Args) != 5 {
+		t.Fatalf("got %d args, want 4 parameters and the return status", len(call.Args))
+	}
+	if want := sql.Named("customer", int64(42)); !reflect.DeepEqual(call.Args[0], want) {
+		t.Errorf("customer = %#v, want %#v", call.Args[0], want)
+	}
+	if want := sql.Named("note", "rush"); !reflect.DeepEqual(call.Args[1], want) {
+		t.Errorf("note = %#v, want %#v", call.Args[1], want)
+	}
+
+	// Outputs without a value are typed and sent as pure outputs, strings
+	// are unsized so long values are not truncated
+	total := call.Args[2].(sql.NamedArg)
+	if out, ok := total.Value.(sql.Out); !ok || out.In || !reflect.DeepEqual(out.Dest, ptr(mssql.VarCharMax(""))) {
+		t.Errorf("total = %#v, want an output of *mssql.VarCharMax", total.Value)
+	}
+	label := call.Args[3].(sql.NamedArg)
+	if out, ok := label.Value.(sql.Out); !ok || !out.In || !reflect.DeepEqual(out.Dest, ptr(mssql.NVarCharMax("draft"))) {
+		t.Errorf("label = %#v, want an input/output of *mssql.NVarCharMax", label.Value)
+	}
+	if _, ok := call.Args[4].(*mssql.ReturnStatus); !ok {
+		t.Errorf("last arg = %#v, want the return status", call.Args[4])
+	}
+	if call.OutputRow() {
+		t.Error("SQL Server outputs reported as a result row")
+	}
+}
+
+func TestNewProcedureCallPostgres(t *testing.T) {
+	call, err := mustDialect(t, "postgres").NewProcedureCall("public.place_order", []ProcedureParam{
+		{Name: "customer", Type: ParamInt, Value: json.Number("42")},
+		{Name: "@note", Value: "rush"},
+		{Name: "order_id", Output: true},
+		{Name: "total", Type: ParamDecimal, Value: "0.00", Output: true},
+	})
+	if err != nil {
+		t.Fatalf("NewProcedureCall: %v", err)
+	}
+
+	want := "CALL public.place_order(customer => $1, note => $2, order_id => NULL, total => $3)"
+	if call.SQL != want {
+		t.Errorf("SQL = %q, want %q", call.SQL, want)
+	}
+	if wantArgs := []interface{}{int64(42), "rush", "0.00"}; !reflect.DeepEqual(call.Args, wantArgs) {
+		t.Errorf("Args = %#v, want %#v", call.Args, wantArgs)
+	}
+	if !call.OutputRow() {
+		t.Error("PostgreSQL outputs not reported as a result row")
+	}
+	if outputs := call.Outputs(); outputs != nil {
+		t.Errorf("Outputs = %v, want none outside the result row", outputs)
+	}
+	if _, ok := call.ReturnStatus(); ok {
+		t.Error("PostgreSQL call reports a return status")
+	}
+
+	// Without outputs the call returns no output row
+	call, err = mustDialect(t, "postgres").NewProcedureCall("refresh", nil)
+	if err != nil {
+		t.Fatalf("NewProcedureCall: %v", err)
+	}
+	if call.SQL != "CALL refresh()" || call.OutputRow() {
+		t.Errorf("SQL = %q, output row %v; want CALL refresh() without one", call.SQL, call.OutputRow())
+	}
+}
+
+func TestNewProcedureCallInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		proc    string
+		params  []ProcedureParam
+		wantErr string
+	}{
+		{name: "injected name", dialect: "sqlserver", proc: "usp_orders; DROP TABLE orders", wantErr: "invalid procedure name"},
+		{name: "injected parameter", dialect: "postgres", proc: "place_order", params: []ProcedureParam{{Name: "id => 1); --"}}, wantErr: "invalid parameter name"},
+		{name: "untyped output", dialect: "sqlserver", proc: "usp_orders", params: []ProcedureParam{{Name: "total", Output: true}}, wantErr: "type or an initial value"},
+		{name: "invalid value", dialect: "postgres", proc: "place_order", params: []ProcedureParam{{Name: "id", Type: ParamInt, Value: "x"}}, wantErr: "parameter id"},
+		{name: "unsupported dialect", dialect: "mysql", proc: "place_order", wantErr: "not supported on mysql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mustDialect(t, tt.dialect).NewProcedureCall(tt.proc, tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcedureCallOutputs(t *testing.T) {
+	call, err := mustDialect(t, "sqlserver").NewProcedureCall("usp_orders", []ProcedureParam{
+		{Name: "count", Type: ParamInt, Output: true},
+		{Name: "total", Type: ParamDecimal, Output: true},
+		{Name: "placed", Type: ParamDateTime, Output: true},
+		{Name: "id", Type: ParamUniqueIdentifier, Output: true},
+		{Name: "note", Type: ParamNVarChar, Output: true},
+	})
+	if err != nil {
+		t.Fatalf("NewProcedureCall: %v", err)
+	}
+
+	// Write the outputs back the way the driver does once the rows are closed
+	at := time.Date(2024, 1, 31, 15, 4, 5, 0, time.UTC)
+	var id mssql.UniqueIdentifier
+	if err := id.Scan("6F9619FF-8B86-D011-B42D-00C04FC964FF"); err != nil {
+		t.Fatal(err)
+	}
+	written := map[string]interface{}{
+		"count":  int64(3),
+		"total":  mssql.VarCharMax("1250.50"),
+		"placed": mssql.DateTime1(at),
+		"id":     id,
+		"note":   mssql.NVarCharMax("Çağrı"),
+	}
+	for _, arg := range call.Args {
+		switch arg := arg.(type) {
+		case sql.NamedArg:
+			dest := reflect.ValueOf(arg.Value.(sql.Out).Dest).Elem()
+			dest.Set(reflect.ValueOf(written[arg.Name]))
+		case *mssql.ReturnStatus:
+			*arg = 2
+		}
+	}
+
+	want := map[string]interface{}{
+		"count":  int64(3),
+		"total":  "1250.50",
+		"placed": at,
+		"id":     "6F9619FF-8B86-D011-B42D-00C04FC964FF",
+		"note":   "Çağrı",
+	}
+	if outputs := call.Outputs(); !reflect.DeepEqual(outputs, want) {
+		t.Errorf("Outputs = %#v, want %#v", outputs, want)
+	}
+	if status, ok := call.ReturnStatus(); !ok || status != 2 {
+		t.Errorf("ReturnStatus = %d, %v; want 2", status, ok)
+	}
+}
+
+// ptr returns a pointer to a copy of v
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrReadOnlyUnsupported is returned for databases that cannot enforce
+// read-only access themselves
+var ErrReadOnlyUnsupported = errors.New("the database has no read-only transactions")
+
+// readOnlyResetTimeout bounds turning SQLite's query_only pragma off again
+const readOnlyResetTimeout = 5 * time.Second
+
+// SupportsReadOnly reports whether the database rejects writes in read-only
+// transactions. SQL Server has no read-only transactions.
+func (d *Dialect) SupportsReadOnly() bool {
+	return d.Name != "sqlserver"
+}
+
+// ReadOnlyTx is a transaction in which the database rejects writes: a READ
+// ONLY transaction on PostgreSQL and MySQL, and the query_only pragma on
+// SQLite. Commit or Rollback must be called to reset the connection.
+type ReadOnlyTx struct {
+	*sql.Tx
+	conn      *sql.Conn
+	queryOnly bool // The query_only pragma is set on conn
+}
+
+// BeginReadOnly starts a read-only transaction on conn. The caller keeps
+// ownership of conn.
+func (m *Manager) BeginReadOnly(ctx context.Context, conn *sql.Conn, isolation sql.IsolationLevel) (*ReadOnlyTx, error) {
+	if !m.dialect.SupportsReadOnly() {
+		return nil, ErrReadOnlyUnsupported
+	}
+
+	rotx := &ReadOnlyTx{conn: conn}
+	if m.dialect.Name == "sqlite" {
+		// go-sqlite3 ignores the ReadOnly transaction option
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return nil, fmt.Errorf("set query_only: %w", err)
+		}
+		rotx.queryOnly = true
+	}
+
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: isolation, ReadOnly: true})
+	if err != nil {
+		rotx.reset()
+		return nil, err
+	}
+	rotx.Tx = tx
+	return rotx, nil
+}
+
+// Commit commits the transaction and resets the connection
+func (tx *ReadOnlyTx) Commit() error {
+	defer tx.reset()
+	return tx.Tx.Commit()
+}
+
+// Rollback rolls the transaction back and resets the connection
+func (tx *ReadOnlyTx) Rollback() error {
+	defer tx.reset()
+	return tx.Tx.Rollback()
+}
+
+// reset turns the query_only pragma off before the connection returns to
+// the pool. A connection that cannot be reset is discarded instead.
+func (tx *ReadOnlyTx) reset() {
+	if !tx.queryOnly {
+		return
+	}
+	tx.queryOnly = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), readOnlyResetTimeout)
+	defer cancel()
+
+	if _, err := tx.conn.ExecContext(ctx, "PRAGMA query_only = OFF"); err != nil {
+		logrus.Warnf("Failed to reset query_only, discarding the connection: %v", err)
+		tx.conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+	}
+}
+
+// ExecuteReadOnlyQuery executes a query in a read-only transaction on a
+// connection of its own. Closing the rows rolls the transaction back.
+func (m *Manager) ExecuteReadOnlyQuery(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := m.withQueryTimeout(ctx)
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	tx, err := m.BeginReadOnly(ctx, conn, sql.LevelDefault)
+	if err != nil {
+		conn.Close()
+		cancel()
+		return nil, err
+	}
+	release := func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logrus.Debugf("Failed to roll back read-only transaction: %v", err)
+		}
+		conn.Close()
+		cancel()
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &Rows{Rows: rows, release: release}, nil
+}
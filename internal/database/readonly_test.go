@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+)
+
+// newSQLiteManager returns a manager of a new SQLite database with one
+// connection, so every statement reuses the connection of the last one
+func newSQLiteManager(t *testing.T) *Manager {
+	t.Helper()
+	m, err := NewManager(&config.DatabaseConfig{
+		Type:         "sqlite",
+		Database:     filepath.Join(t.TempDir(), "test.db"),
+		MaxOpenConns: 1,
+		MaxIdleConns: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	if _, err := m.ExecuteExec(context.Background(), "CREATE TABLE t (id INTEGER)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	return m
+}
+
+func TestBeginReadOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{name: "select", query: "SELECT COUNT(*) FROM t"},
+		{name: "insert", query: "INSERT INTO t VALUES (1)", wantErr: true},
+		{name: "create", query: "CREATE TABLE u (id INTEGER)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newSQLiteManager(t)
+			ctx := context.Background()
+
+			conn, err := m.Conn(ctx)
+			if err != nil {
+				t.Fatalf("Conn: %v", err)
+			}
+			tx, err := m.BeginReadOnly(ctx, conn, sql.LevelDefault)
+			if err != nil {
+				t.Fatalf("BeginReadOnly: %v", err)
+			}
+			_, err = tx.ExecContext(ctx, tt.query)
+			if err := tx.Rollback(); err != nil {
+				t.Fatalf("Rollback: %v", err)
+			}
+			conn.Close()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("%s: error %v, want error %v", tt.query, err, tt.wantErr)
+			}
+
+			// The connection is writable again once the transaction ended
+			if _, err := m.ExecuteExec(ctx, "INSERT INTO t VALUES (2)"); err != nil {
+				t.Errorf("write after the read-only transaction: %v", err)
+			}
+		})
+	}
+}
+
+func TestExecuteReadOnlyQuery(t *testing.T) {
+	m := newSQLiteManager(t)
+	ctx := context.Background()
+
+	rows, err := m.ExecuteReadOnlyQuery(ctx, "SELECT COUNT(*) FROM t")
+	if err != nil {
+		t.Fatalf("ExecuteReadOnlyQuery: %v", err)
+	}
+	var count int
+	for rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Writes fail when the rows are read, SQLite runs the statement lazily
+	rows, err = m.ExecuteReadOnlyQuery(ctx, "INSERT INTO t VALUES (1) RETURNING id")
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	if err == nil {
+		t.Fatal("INSERT in a read-only query succeeded")
+	}
+
+	if _, err := m.ExecuteExec(ctx, "INSERT INTO t VALUES (2)"); err != nil {
+		t.Errorf("write after the read-only query: %v", err)
+	}
+}
+
+func TestBeginReadOnlyUnsupported(t *testing.T) {
+	m := &Manager{dialect: sqlserverDialect}
+	if _, err := m.BeginReadOnly(context.Background(), nil, sql.LevelDefault); !errors.Is(err, ErrReadOnlyUnsupported) {
+		t.Errorf("BeginReadOnly on SQL Server: error %v, want %v", err, ErrReadOnlyUnsupported)
+	}
+}
@@ -0,0 +1,158 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// abortCheckInterval is how often abort rules are evaluated
+var abortCheckInterval = time.Second
+
+// defaultAbortWindow is the rolling window of rules that set none
+const defaultAbortWindow = 30 * time.Second
+
+// ErrAborted is returned by Run when an abort rule tripped
+var ErrAborted = errors.New("load test aborted")
+
+// abortRule is a parsed circuit breaker rule
+type abortRule struct {
+	config.AbortRuleConfig
+	threshold *metrics.Threshold
+
+	// Elapsed run time of the last scale down, re-evaluation waits for a
+	// window of results at the reduced load
+	scaledDownAt time.Duration
+	scaledDown   bool
+}
+
+// parseAbortRules parses the circuit breaker rules of the test
+func parseAbortRules(test *config.TestConfig) ([]*abortRule, error) {
+	queries := iterationNames(test)
+
+	rules := make([]*abortRule, 0, len(test.AbortRules))
+	for _, cfg := range test.AbortRules {
+		threshold, err := metrics.ParseThreshold(cfg.Threshold)
+		if err != nil {
+			return nil, err
+		}
+		if threshold.Query != "" && !queries[threshold.Query] {
+			return nil, fmt.Errorf("threshold %q: unknown query %s", cfg.Threshold, threshold.Query)
+		}
+
+		rule := &abortRule{AbortRuleConfig: cfg, threshold: threshold}
+		if rule.Window <= 0 {
+			rule.Window = defaultAbortWindow
+		}
+		if rule.Action == "" {
+			rule.Action = config.AbortActionStop
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// maxAbortWindow returns the longest rolling window of the rules
+func maxAbortWindow(rules []*abortRule) time.Duration {
+	var window time.Duration
+	for _, rule := range rules {
+		if rule.Window > window {
+			window = rule.Window
+		}
+	}
+	return window
+}
+
+// watchAbortRules evaluates the abort rules until the context is cancelled or
+// the test is stopped. A rule is only evaluated once a full window of results
+// exists, so warm-up noise cannot trip it.
+func (lt *LoadTester) watchAbortRules(ctx context.Context) {
+	ticker := time.NewTicker(abortCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-lt.abortCh:
+			return
+		case <-ticker.C:
+			lt.workersMu.RLock()
+			elapsed := time.Since(lt.startTime)
+			lt.workersMu.RUnlock()
+			for _, rule := range lt.abortRules {
+				if elapsed < rule.Window || (rule.scaledDown && elapsed < rule.scaledDownAt+rule.Window) {
+					continue
+				}
+
+				result := lt.metrics.EvaluateRollingThreshold(rule.threshold, rule.Window)
+				if result.Passed || result.NoData {
+					continue
+				}
+
+				reason := fmt.Sprintf("%s breached over the last %v (actual: %s)", rule.Threshold, rule.Window, result.Actual)
+				if rule.Action == config.AbortActionScaleDown && !rule.scaledDown {
+					lt.rollBack(rule, reason, elapsed)
+					continue
+				}
+
+				lt.abort(reason)
+				return
+			}
+		}
+	}
+}
+
+// rollBack reduces the user count after an abort rule tripped. The scaling
+// plan and the ramp in progress are halted so they cannot raise the load
+// again; if the rule still fails a window later the test is stopped. A test
+// already running at most the target users keeps its users.
+func (lt *LoadTester) rollBack(rule *abortRule, reason string, elapsed time.Duration) {
+	logrus.Errorf("Circuit breaker tripped: %s, rolling back to %d users", reason, rule.TargetUsers)
+
+	rule.scaledDown = true
+	rule.scaledDownAt = elapsed
+	lt.markAborted(reason)
+	lt.haltScaling()
+
+	reduced, err := lt.reduceUsers(rule.TargetUsers, "circuit breaker roll back")
+	switch {
+	case err != nil:
+		logrus.Errorf("Failed to roll back users: %v", err)
+	case !reduced:
+		logrus.Warnf("Skipping roll back, the test runs at most %d users", rule.TargetUsers)
+	}
+}
+
+// abort stops the running test
+func (lt *LoadTester) abort(reason string) {
+	logrus.Errorf("Circuit breaker tripped: %s, stopping test", reason)
+
+	lt.markAborted(reason)
+	lt.abortOnce.Do(func() {
+		close(lt.abortCh)
+	})
+}
+
+// markAborted records the reason the run was aborted, keeping the first one
+func (lt *LoadTester) markAborted(reason string) {
+	lt.abortMu.Lock()
+	defer lt.abortMu.Unlock()
+
+	if lt.abortReason == "" {
+		lt.abortReason = reason
+	}
+}
+
+// AbortReason returns why the run was aborted, or an empty string
+func (lt *LoadTester) AbortReason() string {
+	lt.abortMu.RLock()
+	defer lt.abortMu.RUnlock()
+	return lt.abortReason
+}
@@ -0,0 +1,265 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// fastAbortChecks evaluates abort rules every 20ms for the rest of the test
+func fastAbortChecks(t *testing.T) {
+	t.Helper()
+	interval := abortCheckInterval
+	abortCheckInterval = 20 * time.Millisecond
+	t.Cleanup(func() { abortCheckInterval = interval })
+}
+
+// newWatchedTester creates a load tester without workers whose run started
+// elapsed ago, watching the given rules over a collector
+func newWatchedTester(t *testing.T, elapsed time.Duration, rules ...config.AbortRuleConfig) *LoadTester {
+	t.Helper()
+	test := &config.TestConfig{Queries: []config.QueryConfig{{Name: "orders"}}, AbortRules: rules}
+	abortRules, err := parseAbortRules(test)
+	if err != nil {
+		t.Fatalf("parseAbortRules: %v", err)
+	}
+
+	collector := metrics.NewCollector(nil)
+	collector.EnableRollingWindow(maxAbortWindow(abortRules))
+	return &LoadTester{
+		config:     &config.Config{Test: *test},
+		metrics:    collector,
+		abortRules: abortRules,
+		abortCh:    make(chan struct{}),
+		rampStop:   make(chan struct{}),
+		startTime:  time.Now().Add(-elapsed),
+	}
+}
+
+// recordFailures records a failed query every 10ms until the test ends
+func recordFailures(t *testing.T, collector *metrics.Collector) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	record := func() {
+		collector.RecordQuery(metrics.QueryResult{QueryName: "orders", QueryType: "select", Duration: time.Millisecond,
+			Error: "deadlock detected", ErrorClass: "deadlock", Timestamp: time.Now()})
+	}
+	record()
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				record()
+			}
+		}
+	}()
+}
+
+// waitAborted reports whether the load tester was stopped within the timeout
+func waitAborted(lt *LoadTester, timeout time.Duration) bool {
+	select {
+	case <-lt.abortCh:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestParseAbortRules(t *testing.T) {
+	test := &config.TestConfig{
+		Queries: []config.QueryConfig{{Name: "orders"}},
+		AbortRules: []config.AbortRuleConfig{
+			{Threshold: "error_rate < 5%"},
+			{Threshold: "p95(orders) < 50ms", Window: time.Minute, Action: config.AbortActionScaleDown, TargetUsers: 10},
+		},
+	}
+	rules, err := parseAbortRules(test)
+	if err != nil {
+		t.Fatalf("parseAbortRules: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("parsed %d rules, want 2", len(rules))
+	}
+	if rules[0].Window != defaultAbortWindow || rules[0].Action != config.AbortActionStop {
+		t.Errorf("defaults = %v, %s, want %v, %s", rules[0].Window, rules[0].Action, defaultAbortWindow, config.AbortActionStop)
+	}
+	if rules[1].threshold.Query != "orders" || rules[1].TargetUsers != 10 {
+		t.Errorf("rule = %+v", rules[1])
+	}
+	if window := maxAbortWindow(rules); window != time.Minute {
+		t.Errorf("maxAbortWindow = %v, want 1m", window)
+	}
+
+	for _, threshold := range []string{"p95(payments) < 50ms", "p95 50ms"} {
+		test.AbortRules = []config.AbortRuleConfig{{Threshold: threshold}}
+		if _, err := parseAbortRules(test); err == nil {
+			t.Errorf("parseAbortRules(%q) succeeded", threshold)
+		}
+	}
+}
+
+func TestAbortRuleWaitsForWindow(t *testing.T) {
+	fastAbortChecks(t)
+	lt := newWatchedTester(t, 1500*time.Millisecond, config.AbortRuleConfig{Threshold: "error_rate < 5%", Window: 2 * time.Second})
+	recordFailures(t, lt.metrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lt.watchAbortRules(ctx)
+
+	// Warm-up results cannot trip the rule before a full window has passed
+	if waitAborted(lt, 300*time.Millisecond) {
+		t.Fatal("rule tripped before its window had passed")
+	}
+	if reason := lt.AbortReason(); reason != "" {
+		t.Fatalf("AbortReason = %q before the rule tripped", reason)
+	}
+
+	if !waitAborted(lt, 2*time.Second) {
+		t.Fatal("rule did not trip once its window had passed")
+	}
+	if reason := lt.AbortReason(); !strings.Contains(reason, "error_rate < 5%") || !strings.Contains(reason, "100.00%") {
+		t.Errorf("AbortReason = %q, want the breached threshold and its actual value", reason)
+	}
+}
+
+func TestAbortRuleRollBackThenStop(t *testing.T) {
+	fastAbortChecks(t)
+	window := time.Second
+	lt := newWatchedTester(t, window, config.AbortRuleConfig{
+		Threshold:   "error_rate < 5%",
+		Window:      window,
+		Action:      config.AbortActionScaleDown,
+		TargetUsers: 5,
+	})
+	lt.scalable = true
+	lt.scalingCtx = context.Background()
+	recordFailures(t, lt.metrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go lt.watchAbortRules(ctx)
+
+	// The roll back halts scaling but keeps the test running
+	deadline := time.Now().Add(2 * time.Second)
+	for lt.AbortReason() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("rule did not trip")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	rolledBack := time.Now()
+	if !lt.isScalingHalted() {
+		t.Error("roll back did not halt scaling")
+	}
+	// The test runs no users, rolling back to 5 must not add any
+	if workers := len(lt.snapshotWorkers()); workers != 0 {
+		t.Errorf("roll back started %d users", workers)
+	}
+
+	// Still failing a window after the roll back stops the test
+	if !waitAborted(lt, 3*time.Second) {
+		t.Fatal("rule did not stop the test after the roll back")
+	}
+	if waited := time.Since(rolledBack); waited < window-abortCheckInterval {
+		t.Errorf("test stopped %v after the roll back, want a window of %v", waited, window)
+	}
+}
+
+// abortConfig returns a closed-loop test of failing queries with an abort rule
+func abortConfig(t *testing.T, users int, rampUp, action string, targetUsers int) *config.Config {
+	t.Helper()
+	doc := `
+database:
+  type: sqlite
+  database: ` + filepath.Join(t.TempDir(), "test.db") + `
+metrics:
+  enabled: false
+test:
+  duration: 1m
+  concurrent_users: ` + strconv.Itoa(users) + `
+  ramp_up_time: ` + rampUp + `
+  think_time: 10ms
+  abort_rules:
+    - threshold: error_rate < 5%
+      window: 1s
+      action: ` + action + `
+      target_users: ` + strconv.Itoa(targetUsers) + `
+  queries:
+    - name: missing
+      type: select
+      weight: 1
+      sql: SELECT * FROM missing_table
+`
+	cfg, err := config.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("config.Parse: %v", err)
+	}
+	return cfg
+}
+
+func TestRunAbortsDuringRampUp(t *testing.T) {
+	inTempDir(t)
+	fastAbortChecks(t)
+	lt := NewLoadTester(abortConfig(t, 50, "1m", config.AbortActionStop, 0), metrics.NewCollector(nil))
+	defer lt.Close()
+
+	// The circuit breaker must stop the run while users are still ramping
+	// up, over a minute before the ramp would end
+	start := time.Now()
+	err := lt.Run(context.Background())
+	if !errors.Is(err, ErrAborted) {
+		t.Fatalf("Run = %v, want ErrAborted", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Run took %v, the ramp was not interrupted", elapsed)
+	}
+}
+
+func TestRunRollsBackThenAborts(t *testing.T) {
+	inTempDir(t)
+	fastAbortChecks(t)
+	lt := NewLoadTester(abortConfig(t, 4, "0s", config.AbortActionScaleDown, 1), metrics.NewCollector(nil))
+	defer lt.Close()
+
+	// Watch the user count go from 4 down to 1
+	rolledBack := make(chan struct{})
+	go func() {
+		want := 4
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if len(lt.snapshotWorkers()) != want {
+				continue
+			}
+			if want == 1 {
+				close(rolledBack)
+				return
+			}
+			want = 1
+		}
+	}()
+
+	err := lt.Run(context.Background())
+	if !errors.Is(err, ErrAborted) {
+		t.Fatalf("Run = %v, want ErrAborted", err)
+	}
+	select {
+	case <-rolledBack:
+	default:
+		t.Error("Run did not roll back to 1 user before stopping")
+	}
+	if !strings.Contains(err.Error(), "error_rate < 5%") {
+		t.Errorf("Run = %v, want the breached threshold", err)
+	}
+}
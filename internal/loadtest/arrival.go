@@ -0,0 +1,322 @@
+package loadtest
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ArrivalRateExecutor dispatches iterations on a schedule (open model).
+// Unlike the closed-loop worker model, the dispatch rate does not fall when
+// the database slows down; iterations that find no idle worker are dropped.
+// With a scaling plan the target rate ramps linearly between stages.
+type ArrivalRateExecutor struct {
+	rate          float64
+	stages        []config.ScalingStep
+	lateThreshold time.Duration
+	metrics       *metrics.Collector
+	jobs          chan time.Time
+
+	busy       int64
+	dispatched int64
+	dropped    int64
+	late       int64
+
+	// Per-stage counters, index 0 is the initial rate before the first step
+	stageDispatched []int64
+	stageDropped    []int64
+}
+
+// StageReport compares the requested and achieved rate of one stage
+type StageReport struct {
+	Description   string        `json:"description"`
+	Start         time.Duration `json:"start"`
+	Duration      time.Duration `json:"duration"`
+	TargetRate    float64       `json:"target_rate"`
+	RequestedRate float64       `json:"requested_rate"`
+	AchievedRate  float64       `json:"achieved_rate"`
+	Dispatched    int64         `json:"dispatched"`
+	Dropped       int64         `json:"dropped"`
+}
+
+// NewArrivalRateExecutor creates a new arrival rate executor
+func NewArrivalRateExecutor(cfg *config.ArrivalRateConfig, metrics *metrics.Collector) *ArrivalRateExecutor {
+	return &ArrivalRateExecutor{
+		rate:          cfg.Rate,
+		stages:        cfg.ScalingPlan,
+		lateThreshold: cfg.LateThreshold,
+		metrics:       metrics,
+		// Unbuffered so a dispatch only succeeds when a pool worker is idle
+		jobs:            make(chan time.Time),
+		stageDispatched: make([]int64, len(cfg.ScalingPlan)+1),
+		stageDropped:    make([]int64, len(cfg.ScalingPlan)+1),
+	}
+}
+
+// Run dispatches iterations until the context is cancelled
+func (e *ArrivalRateExecutor) Run(ctx context.Context) {
+	if len(e.stages) > 0 {
+		logrus.Infof("Dispatching iterations starting at %.2f/s over %d stages", e.rate, len(e.stages))
+	} else {
+		logrus.Infof("Dispatching iterations at %.2f/s", e.rate)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	schedule := e.schedule()
+	start := time.Now()
+	for n := 0; ; n++ {
+		// Dispatch times come from the schedule rather than the clock so
+		// delays do not accumulate into a lower rate
+		elapsed, ok := schedule.dispatchTime(n)
+		if !ok {
+			// The rate stays at zero for the rest of the run
+			<-ctx.Done()
+			return
+		}
+		next := start.Add(elapsed)
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+
+		stage := e.stageAt(elapsed)
+		select {
+		case <-ctx.Done():
+			return
+		case e.jobs <- next:
+			atomic.AddInt64(&e.dispatched, 1)
+			atomic.AddInt64(&e.stageDispatched[stage], 1)
+		default:
+			// Every worker in the pool is busy
+			atomic.AddInt64(&e.dropped, 1)
+			atomic.AddInt64(&e.stageDropped[stage], 1)
+			e.metrics.RecordDroppedIteration()
+		}
+	}
+}
+
+// rateAt returns the requested rate at the given time since start, ramping
+// linearly from the previous level during each step's ramp duration
+func (e *ArrivalRateExecutor) rateAt(elapsed time.Duration) float64 {
+	level := e.rate
+	for _, step := range e.stages {
+		if elapsed < step.TimeOffset {
+			break
+		}
+		rampEnd := step.TimeOffset + step.RampDuration
+		if elapsed < rampEnd {
+			progress := float64(elapsed-step.TimeOffset) / float64(step.RampDuration)
+			return level + (step.TargetRate-level)*progress
+		}
+		level = step.TargetRate
+	}
+	return level
+}
+
+// arrivalSchedule is the requested rate as pieces in which it is constant
+// or ramps linearly. The iterations due by a time are the integral of the
+// rate up to it, so a ramp from a low rate speeds up as the rate rises.
+type arrivalSchedule struct {
+	pieces []ratePiece
+}
+
+// ratePiece is a span of the schedule with a linear rate
+type ratePiece struct {
+	start      time.Duration
+	end        time.Duration // math.MaxInt64 for the last piece
+	rate       float64       // Iterations per second at start
+	slope      float64       // Change of the rate per second
+	iterations float64       // Iterations due before start
+}
+
+// schedule returns the arrival schedule of the executor
+func (e *ArrivalRateExecutor) schedule() *arrivalSchedule {
+	// The rate is linear between the starts and ends of the steps
+	bounds := []time.Duration{0}
+	for _, step := range e.stages {
+		bounds = append(bounds, step.TimeOffset, step.TimeOffset+step.RampDuration)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+
+	starts := bounds[:1]
+	for _, bound := range bounds[1:] {
+		if bound > starts[len(starts)-1] {
+			starts = append(starts, bound)
+		}
+	}
+
+	schedule := &arrivalSchedule{pieces: make([]ratePiece, len(starts))}
+	var iterations float64
+	for i, start := range starts {
+		piece := ratePiece{start: start, end: math.MaxInt64, rate: e.rateAt(start), iterations: iterations}
+		if i < len(starts)-1 {
+			piece.end = starts[i+1]
+			// The midpoint avoids the jump of a step starting at the end
+			if mid := start + (piece.end-start)/2; mid > start {
+				piece.slope = (e.rateAt(mid) - piece.rate) / (mid - start).Seconds()
+			}
+			length := (piece.end - start).Seconds()
+			iterations += piece.rate*length + piece.slope*length*length/2
+		}
+		schedule.pieces[i] = piece
+	}
+	return schedule
+}
+
+// dispatchTime returns when iteration n (from 0) is due, the first time the
+// integral of the rate reaches n. The first iteration waits for a rate above
+// zero. It reports false if the rate stays at zero.
+func (s *arrivalSchedule) dispatchTime(n int) (time.Duration, bool) {
+	due := float64(n)
+	if n == 0 {
+		due = math.SmallestNonzeroFloat64
+	}
+
+	for _, piece := range s.pieces {
+		remaining := due - piece.iterations
+		if remaining <= 0 {
+			return piece.start, true
+		}
+
+		// Solve rate*t + slope*t²/2 = remaining for t in seconds
+		var seconds float64
+		switch {
+		case piece.slope == 0:
+			if piece.rate <= 0 {
+				continue
+			}
+			seconds = remaining / piece.rate
+		default:
+			discriminant := piece.rate*piece.rate + 2*piece.slope*remaining
+			if discriminant < 0 {
+				// A falling rate that stops before the iteration is due
+				continue
+			}
+			seconds = (math.Sqrt(discriminant) - piece.rate) / piece.slope
+		}
+
+		if seconds >= 0 && seconds < (piece.end-piece.start).Seconds() {
+			return piece.start + time.Duration(seconds*float64(time.Second)), true
+		}
+	}
+	return 0, false
+}
+
+// stageAt returns the index of the stage active at the given time since start
+func (e *ArrivalRateExecutor) stageAt(elapsed time.Duration) int {
+	stage := 0
+	for i, step := range e.stages {
+		if elapsed < step.TimeOffset {
+			break
+		}
+		stage = i + 1
+	}
+	return stage
+}
+
+// iterationStarted is called by a worker when it picks up a scheduled iteration
+func (e *ArrivalRateExecutor) iterationStarted(scheduled time.Time) {
+	atomic.AddInt64(&e.busy, 1)
+
+	if time.Since(scheduled) > e.lateThreshold {
+		atomic.AddInt64(&e.late, 1)
+		e.metrics.RecordLateIteration()
+	}
+}
+
+// iterationFinished is called by a worker when a scheduled iteration completes
+func (e *ArrivalRateExecutor) iterationFinished() {
+	atomic.AddInt64(&e.busy, -1)
+}
+
+// BusyWorkers returns the number of pool workers currently executing an iteration
+func (e *ArrivalRateExecutor) BusyWorkers() int {
+	return int(atomic.LoadInt64(&e.busy))
+}
+
+// StageReports returns requested versus achieved rates for every stage that
+// started within the elapsed run time
+func (e *ArrivalRateExecutor) StageReports(elapsed time.Duration) []StageReport {
+	reports := make([]StageReport, 0, len(e.stages)+1)
+
+	for i := 0; i <= len(e.stages); i++ {
+		report := StageReport{
+			Description: "Initial rate",
+			TargetRate:  e.rate,
+		}
+		if i > 0 {
+			step := e.stages[i-1]
+			report.Description = step.Description
+			report.Start = step.TimeOffset
+			report.TargetRate = step.TargetRate
+		}
+		if i > 0 && report.Start >= elapsed {
+			break
+		}
+
+		end := elapsed
+		if i < len(e.stages) && e.stages[i].TimeOffset < elapsed {
+			end = e.stages[i].TimeOffset
+		}
+		report.Duration = end - report.Start
+		if report.Duration <= 0 {
+			// The first step starts immediately, so there is no initial stage
+			continue
+		}
+
+		report.Dispatched = atomic.LoadInt64(&e.stageDispatched[i])
+		report.Dropped = atomic.LoadInt64(&e.stageDropped[i])
+		if seconds := report.Duration.Seconds(); seconds > 0 {
+			report.RequestedRate = float64(report.Dispatched+report.Dropped) / seconds
+			report.AchievedRate = float64(report.Dispatched) / seconds
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+// LogSummary logs the dispatch statistics of the run
+func (e *ArrivalRateExecutor) LogSummary(elapsed time.Duration) {
+	dispatched := atomic.LoadInt64(&e.dispatched)
+	achieved := 0.0
+	if elapsed > 0 {
+		achieved = float64(dispatched) / elapsed.Seconds()
+	}
+
+	logrus.Info("=== Arrival Rate Executor ===")
+	if len(e.stages) == 0 {
+		logrus.Infof("Target Rate: %.2f/s", e.rate)
+	}
+	logrus.Infof("Achieved Rate: %.2f/s", achieved)
+	logrus.Infof("Dispatched: %d", dispatched)
+	logrus.Infof("Dropped: %d", atomic.LoadInt64(&e.dropped))
+	logrus.Infof("Late: %d", atomic.LoadInt64(&e.late))
+
+	if len(e.stages) == 0 {
+		return
+	}
+
+	for _, stage := range e.StageReports(elapsed) {
+		logrus.Infof("Stage: %s (at %v for %v)", stage.Description, stage.Start, stage.Duration.Round(time.Second))
+		logrus.Infof("  Target Rate: %.2f/s", stage.TargetRate)
+		logrus.Infof("  Requested Rate: %.2f/s", stage.RequestedRate)
+		logrus.Infof("  Achieved Rate: %.2f/s", stage.AchievedRate)
+		logrus.Infof("  Dropped: %d", stage.Dropped)
+	}
+}
@@ -0,0 +1,301 @@
+package loadtest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// runArrivals runs an executor for the given time with workers that pick up
+// every dispatched iteration and finish it immediately
+func runArrivals(executor *ArrivalRateExecutor, workers int, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case scheduled := <-executor.jobs:
+					executor.iterationStarted(scheduled)
+					executor.iterationFinished()
+				}
+			}
+		}()
+	}
+
+	executor.Run(ctx)
+	wg.Wait()
+}
+
+func TestArrivalRateDispatch(t *testing.T) {
+	collector := metrics.NewCollector(nil)
+	executor := NewArrivalRateExecutor(&config.ArrivalRateConfig{Rate: 100, LateThreshold: time.Second}, collector)
+
+	runArrivals(executor, 4, 500*time.Millisecond)
+
+	// 50 iterations are due, timer jitter may move one across the end
+	dispatched := atomic.LoadInt64(&executor.dispatched)
+	dropped := atomic.LoadInt64(&executor.dropped)
+	if total := dispatched + dropped; total < 45 || total > 51 {
+		t.Errorf("scheduled %d iterations in 500ms at 100/s, want about 50", total)
+	}
+	if dispatched < 40 {
+		t.Errorf("dispatched %d iterations to idle workers (%d dropped), want nearly all", dispatched, dropped)
+	}
+	if busy := executor.BusyWorkers(); busy != 0 {
+		t.Errorf("BusyWorkers = %d after the run, want 0", busy)
+	}
+}
+
+func TestArrivalRateDropsWithoutIdleWorkers(t *testing.T) {
+	collector := metrics.NewCollector(nil)
+	executor := NewArrivalRateExecutor(&config.ArrivalRateConfig{Rate: 100, LateThreshold: time.Second}, collector)
+
+	runArrivals(executor, 0, 300*time.Millisecond)
+
+	dropped := atomic.LoadInt64(&executor.dropped)
+	if dispatched := atomic.LoadInt64(&executor.dispatched); dispatched != 0 {
+		t.Errorf("dispatched %d iterations without workers", dispatched)
+	}
+	if dropped < 25 || dropped > 31 {
+		t.Errorf("dropped %d iterations in 300ms at 100/s, want about 30", dropped)
+	}
+	if got := collector.GetStats()["dropped_iterations"]; got != dropped {
+		t.Errorf("collector counted %v dropped iterations, want %d", got, dropped)
+	}
+}
+
+func TestArrivalRateLateIterations(t *testing.T) {
+	collector := metrics.NewCollector(nil)
+	executor := NewArrivalRateExecutor(&config.ArrivalRateConfig{Rate: 1, LateThreshold: 100 * time.Millisecond}, collector)
+
+	executor.iterationStarted(time.Now())
+	executor.iterationStarted(time.Now().Add(-time.Second))
+	if busy := executor.BusyWorkers(); busy != 2 {
+		t.Errorf("BusyWorkers = %d, want 2", busy)
+	}
+	executor.iterationFinished()
+	executor.iterationFinished()
+	if busy := executor.BusyWorkers(); busy != 0 {
+		t.Errorf("BusyWorkers = %d, want 0", busy)
+	}
+
+	if late := atomic.LoadInt64(&executor.late); late != 1 {
+		t.Errorf("late = %d, want the iteration started a second after its schedule", late)
+	}
+	if got := collector.GetStats()["late_iterations"]; got != int64(1) {
+		t.Errorf("collector counted %v late iterations, want 1", got)
+	}
+}
+
+// dispatchesBefore counts the iterations the schedule dispatches before end
+func dispatchesBefore(schedule *arrivalSchedule, end time.Duration) int {
+	n := 0
+	for {
+		at, ok := schedule.dispatchTime(n)
+		if !ok || at >= end {
+			return n
+		}
+		n++
+	}
+}
+
+func TestArrivalSchedule(t *testing.T) {
+	type window struct {
+		end  time.Duration
+		want int
+	}
+
+	tests := []struct {
+		name    string
+		rate    float64
+		stages  []config.ScalingStep
+		windows []window
+	}{
+		{
+			name: "constant",
+			rate: 10,
+			windows: []window{
+				{end: time.Second, want: 10},
+				{end: 10 * time.Second, want: 100},
+			},
+		},
+		{
+			name: "ramp up from zero",
+			stages: []config.ScalingStep{
+				{TimeOffset: 0, TargetRate: 10, RampDuration: 10 * time.Second},
+			},
+			windows: []window{
+				// The integral of a rate rising linearly to 10/s over 10s
+				{end: 5 * time.Second, want: 13},
+				{end: 10 * time.Second, want: 50},
+				{end: 20 * time.Second, want: 150},
+			},
+		},
+		{
+			name: "ramp up after constant",
+			rate: 10,
+			stages: []config.ScalingStep{
+				{TimeOffset: 10 * time.Second, TargetRate: 30, RampDuration: 10 * time.Second},
+			},
+			windows: []window{
+				{end: 10 * time.Second, want: 100},
+				{end: 20 * time.Second, want: 300},
+				{end: 30 * time.Second, want: 600},
+			},
+		},
+		{
+			name: "ramp down to zero",
+			rate: 10,
+			stages: []config.ScalingStep{
+				{TimeOffset: 0, TargetRate: 0, RampDuration: 10 * time.Second},
+			},
+			windows: []window{
+				{end: 5 * time.Second, want: 38},
+				{end: 10 * time.Second, want: 50},
+				{end: time.Hour, want: 50},
+			},
+		},
+		{
+			name: "ramp down then constant",
+			rate: 20,
+			stages: []config.ScalingStep{
+				{TimeOffset: 10 * time.Second, TargetRate: 10, RampDuration: 10 * time.Second},
+			},
+			windows: []window{
+				{end: 10 * time.Second, want: 200},
+				{end: 20 * time.Second, want: 350},
+				{end: 30 * time.Second, want: 450},
+			},
+		},
+		{
+			name: "zero rate",
+			windows: []window{
+				{end: time.Hour, want: 0},
+			},
+		},
+		{
+			name: "zero rate stage between steps",
+			rate: 10,
+			stages: []config.ScalingStep{
+				{TimeOffset: 10 * time.Second, TargetRate: 0},
+				{TimeOffset: 20 * time.Second, TargetRate: 10},
+			},
+			windows: []window{
+				{end: 10 * time.Second, want: 100},
+				{end: 20 * time.Second, want: 100},
+				{end: 30 * time.Second, want: 200},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewArrivalRateExecutor(&config.ArrivalRateConfig{Rate: tt.rate, ScalingPlan: tt.stages}, nil)
+			schedule := executor.schedule()
+
+			for _, w := range tt.windows {
+				// Rounding of the dispatch times may move one iteration across the end
+				got := dispatchesBefore(schedule, w.end)
+				if got < w.want-1 || got > w.want+1 {
+					t.Errorf("dispatches before %v = %d, want %d", w.end, got, w.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatchTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		stages []config.ScalingStep
+		n      int
+		want   time.Duration
+		ok     bool
+	}{
+		{name: "first iteration immediately", rate: 10, n: 0, want: 0, ok: true},
+		{name: "constant spacing", rate: 10, n: 25, want: 2500 * time.Millisecond, ok: true},
+		{
+			name:   "first iteration waits for a rate",
+			stages: []config.ScalingStep{{TimeOffset: 5 * time.Second, TargetRate: 10}},
+			n:      0,
+			want:   5 * time.Second,
+			ok:     true,
+		},
+		{
+			name:   "ramp from zero",
+			stages: []config.ScalingStep{{TimeOffset: 0, TargetRate: 10, RampDuration: 10 * time.Second}},
+			// Half of the 50 iterations of the ramp are due after √50 seconds
+			n:    25,
+			want: 7071 * time.Millisecond,
+			ok:   true,
+		},
+		{
+			name:   "resumes after a zero rate stage",
+			rate:   10,
+			stages: []config.ScalingStep{{TimeOffset: 10 * time.Second, TargetRate: 0}, {TimeOffset: 20 * time.Second, TargetRate: 10}},
+			// Iteration 100 is due as the rate drops at 10s
+			n:    101,
+			want: 20100 * time.Millisecond,
+			ok:   true,
+		},
+		{name: "zero rate", n: 0, ok: false},
+		{
+			name:   "stopped by a ramp down",
+			rate:   10,
+			stages: []config.ScalingStep{{TimeOffset: 0, TargetRate: 0, RampDuration: 10 * time.Second}},
+			n:      51,
+			ok:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := NewArrivalRateExecutor(&config.ArrivalRateConfig{Rate: tt.rate, ScalingPlan: tt.stages}, nil)
+			got, ok := executor.schedule().dispatchTime(tt.n)
+			if ok != tt.ok {
+				t.Fatalf("dispatchTime(%d) ok = %v, want %v", tt.n, ok, tt.ok)
+			}
+			if diff := got - tt.want; ok && (diff < -time.Millisecond || diff > time.Millisecond) {
+				t.Errorf("dispatchTime(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageAt(t *testing.T) {
+	executor := NewArrivalRateExecutor(&config.ArrivalRateConfig{
+		Rate: 10,
+		ScalingPlan: []config.ScalingStep{
+			{TimeOffset: 10 * time.Second, TargetRate: 20},
+			{TimeOffset: 20 * time.Second, TargetRate: 0},
+		},
+	}, nil)
+
+	tests := []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{elapsed: 0, want: 0},
+		{elapsed: 9 * time.Second, want: 0},
+		{elapsed: 10 * time.Second, want: 1},
+		{elapsed: 25 * time.Second, want: 2},
+	}
+	for _, tt := range tests {
+		if got := executor.stageAt(tt.elapsed); got != tt.want {
+			t.Errorf("stageAt(%v) = %d, want %d", tt.elapsed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package loadtest
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Feeder serves rows loaded from a data file. Rows are immutable after
+// loading and the sequential cursor is atomic, so a feeder can be shared by
+// all workers without locking.
+type Feeder struct {
+	name    string
+	mode    string
+	columns map[string]bool
+	rows    []map[string]interface{}
+	cursor  uint64
+}
+
+// LoadFeeder reads all rows of a CSV or JSONL data source into memory
+func LoadFeeder(cfg *config.DataSourceConfig) (*Feeder, error) {
+	format := cfg.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(cfg.File)) {
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		default:
+			format = "csv"
+		}
+	}
+
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data file: %w", err)
+	}
+	defer file.Close()
+
+	feeder := &Feeder{
+		name:    cfg.Name,
+		mode:    cfg.Mode,
+		columns: make(map[string]bool),
+	}
+	if feeder.mode == "" {
+		feeder.mode = "sequential"
+	}
+
+	if format == "jsonl" {
+		err = feeder.loadJSONL(file)
+	} else {
+		err = feeder.loadCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s data file %s: %w", format, cfg.File, err)
+	}
+
+	if len(feeder.rows) == 0 {
+		return nil, fmt.Errorf("data file %s has no rows", cfg.File)
+	}
+
+	logrus.Infof("Loaded %d rows from data source %s (%s)", len(feeder.rows), cfg.Name, feeder.mode)
+	return feeder, nil
+}
+
+// loadCSV reads rows from a CSV file whose first record is the header
+func (f *Feeder) loadCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	for _, column := range header {
+		f.columns[column] = true
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		row := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		f.rows = append(f.rows, row)
+	}
+}
+
+// loadJSONL reads rows from a file with one JSON object per line
+func (f *Feeder) loadJSONL(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+
+		var row map[string]interface{}
+		if err := decoder.Decode(&row); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		for column, value := range row {
+			f.columns[column] = true
+			row[column] = normalizeJSONValue(value)
+		}
+		f.rows = append(f.rows, row)
+	}
+
+	return scanner.Err()
+}
+
+// normalizeJSONValue converts decoded JSON values into driver-friendly types
+func normalizeJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	default:
+		return v
+	}
+}
+
+// HasColumn reports whether any row of the feeder has the column
+func (f *Feeder) HasColumn(column string) bool {
+	return f.columns[column]
+}
+
+// Next returns the next row for a worker according to the feeder mode
+func (f *Feeder) Next(ctx *ParamContext) map[string]interface{} {
+	switch f.mode {
+	case "random":
+		return f.rows[rand.Intn(len(f.rows))]
+	case "unique":
+		// Every worker is pinned to its own row. The load tester keeps
+		// worker IDs below the rows and reuses the ID of a removed worker
+		// only once its last iteration has returned.
+		return f.rows[ctx.WorkerID%len(f.rows)]
+	default:
+		index := atomic.AddUint64(&f.cursor, 1) - 1
+		return f.rows[index%uint64(len(f.rows))]
+	}
+}
+
+// Len returns the number of rows in the feeder
+func (f *Feeder) Len() int {
+	return len(f.rows)
+}
@@ -0,0 +1,286 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// inTempDir runs the test in a temporary working directory, where failed
+// queries are logged
+func inTempDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// writeDataFile writes a CSV data file with an id column of rows rows and
+// returns its data source settings
+func writeDataFile(t *testing.T, mode string, rows int) config.DataSourceConfig {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("id,name\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, "%d,user%d\n", i, i)
+	}
+
+	file := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(file, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return config.DataSourceConfig{Name: "users", File: file, Mode: mode}
+}
+
+// loadTestFeeder loads a feeder of rows rows in the mode
+func loadTestFeeder(t *testing.T, mode string, rows int) *Feeder {
+	t.Helper()
+	source := writeDataFile(t, mode, rows)
+	feeder, err := LoadFeeder(&source)
+	if err != nil {
+		t.Fatalf("LoadFeeder: %v", err)
+	}
+	return feeder
+}
+
+// drawConcurrently has every worker draw rows from the feeder at the same
+// time and returns the ids each worker got
+func drawConcurrently(feeder *Feeder, workers, draws int) [][]string {
+	ids := make([][]string, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ctx := &ParamContext{WorkerID: w}
+			for i := 0; i < draws; i++ {
+				ids[w] = append(ids[w], feeder.Next(ctx)["id"].(string))
+			}
+		}(w)
+	}
+	wg.Wait()
+	return ids
+}
+
+func TestFeederSequential(t *testing.T) {
+	const rows, workers, draws = 10, 8, 50
+	feeder := loadTestFeeder(t, "", rows)
+
+	// Every row is handed out equally often, whichever worker draws it
+	counts := make(map[string]int)
+	for _, workerIDs := range drawConcurrently(feeder, workers, draws) {
+		for _, id := range workerIDs {
+			counts[id]++
+		}
+	}
+	if len(counts) != rows {
+		t.Fatalf("sequential feeder handed out %d distinct rows, want %d", len(counts), rows)
+	}
+	for id, count := range counts {
+		if count != workers*draws/rows {
+			t.Errorf("row %s handed out %d times, want %d", id, count, workers*draws/rows)
+		}
+	}
+}
+
+func TestFeederRandom(t *testing.T) {
+	const rows = 5
+	feeder := loadTestFeeder(t, "random", rows)
+
+	seen := make(map[string]bool)
+	for _, workerIDs := range drawConcurrently(feeder, 8, 200) {
+		for _, id := range workerIDs {
+			seen[id] = true
+		}
+	}
+	if len(seen) != rows {
+		t.Errorf("random feeder handed out %d distinct rows of %d", len(seen), rows)
+	}
+}
+
+func TestFeederUnique(t *testing.T) {
+	const workers = 6
+	feeder := loadTestFeeder(t, "unique", workers)
+
+	owners := make(map[string]int)
+	for w, workerIDs := range drawConcurrently(feeder, workers, 20) {
+		for _, id := range workerIDs {
+			if id != workerIDs[0] {
+				t.Fatalf("worker %d got rows %s and %s, want the same row every time", w, workerIDs[0], id)
+			}
+		}
+		if owner, ok := owners[workerIDs[0]]; ok {
+			t.Errorf("workers %d and %d share row %s", owner, w, workerIDs[0])
+		}
+		owners[workerIDs[0]] = w
+	}
+}
+
+func TestParamSetUniqueRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		test    config.TestConfig
+		wantErr bool
+	}{
+		{name: "enough rows", test: config.TestConfig{Executor: config.ExecutorClosedLoop, ConcurrentUsers: 5}},
+		{name: "more users", test: config.TestConfig{Executor: config.ExecutorClosedLoop, ConcurrentUsers: 6}, wantErr: true},
+		{name: "scaling plan", wantErr: true, test: config.TestConfig{
+			Executor: config.ExecutorClosedLoop, ConcurrentUsers: 2,
+			UserScaling: config.UserScalingConfig{Enabled: true, ScalingPlan: []config.ScalingStep{{TargetUsers: 10}}},
+		}},
+		{name: "arrival rate workers", wantErr: true, test: config.TestConfig{
+			Executor: config.ExecutorConstantArrivalRate, ConcurrentUsers: 2,
+			ArrivalRate: config.ArrivalRateConfig{Rate: 10, MaxWorkers: 20},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test.DataSources = []config.DataSourceConfig{writeDataFile(t, "unique", 5)}
+			set, err := NewParamSet(&tt.test)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("NewParamSet succeeded, want an error for too few unique rows")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewParamSet: %v", err)
+			}
+
+			// Scaling up beyond the rows is refused
+			if err := set.CheckWorkers(5); err != nil {
+				t.Errorf("CheckWorkers(5): %v", err)
+			}
+			if err := set.CheckWorkers(6); err == nil {
+				t.Error("CheckWorkers(6) with 5 unique rows succeeded")
+			}
+		})
+	}
+}
+
+func TestWorkerIDs(t *testing.T) {
+	ids := newWorkerIDs(2)
+	for want := 0; want < 2; want++ {
+		if id, _ := ids.acquire(); id != want {
+			t.Fatalf("acquire = %d, want %d", id, want)
+		}
+	}
+
+	// Every ID below the limit is held by a running or stopping worker
+	id, released := ids.acquire()
+	if id != -1 || released == nil {
+		t.Fatalf("acquire beyond the limit = %d, want -1 and a release channel", id)
+	}
+	ids.release(1)
+	select {
+	case <-released:
+	default:
+		t.Fatal("release did not signal waiting workers")
+	}
+	if id, _ := ids.acquire(); id != 1 {
+		t.Errorf("acquire after release = %d, want the released 1", id)
+	}
+
+	// Without a limit new IDs are handed out, released ones first
+	ids = newWorkerIDs(0)
+	for want := 0; want < 5; want++ {
+		if id, _ := ids.acquire(); id != want {
+			t.Fatalf("acquire = %d, want %d", id, want)
+		}
+	}
+	ids.release(3)
+	ids.release(1)
+	for _, want := range []int{1, 3, 5} {
+		if id, _ := ids.acquire(); id != want {
+			t.Errorf("acquire = %d, want %d", id, want)
+		}
+	}
+}
+
+func TestUniqueRowsAcrossScaling(t *testing.T) {
+	inTempDir(t)
+	source := writeDataFile(t, "unique", 2)
+
+	// Every query outlives the scale down, so the removed worker is still
+	// running with its row when the load tester scales up again
+	doc := `
+database:
+  type: sqlite
+  database: ` + filepath.Join(t.TempDir(), "test.db") + `
+metrics:
+  enabled: false
+test:
+  duration: 1m
+  concurrent_users: 2
+  ramp_up_time: 0s
+  think_time: 0s
+  data_sources:
+    - name: users
+      file: ` + source.File + `
+      mode: unique
+  queries:
+    - name: slow
+      type: select
+      weight: 1
+      data_source: users
+      bindings:
+        id: id
+      sql: >-
+        WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 500000)
+        SELECT COUNT(*), :id FROM c
+`
+	cfg, err := config.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("config.Parse: %v", err)
+	}
+
+	lt := NewLoadTester(cfg, metrics.NewCollector(nil))
+	defer lt.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lt.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for lt.CanScaleUsers(2) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("test did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	removed := lt.snapshotWorkers()[1]
+
+	if err := lt.ScaleUsers(1, 0, "down"); err != nil {
+		t.Fatalf("ScaleUsers(1): %v", err)
+	}
+	if err := lt.ScaleUsers(2, 0, "up"); err != nil {
+		t.Fatalf("ScaleUsers(2): %v", err)
+	}
+
+	select {
+	case <-removed.done:
+	default:
+		t.Error("a new worker started while the removed worker still held its row")
+	}
+	workers := lt.snapshotWorkers()
+	if len(workers) != 2 || workers[0].id == workers[1].id || workers[1].id >= 2 {
+		t.Errorf("worker IDs after scaling = %d and %d, want 0 and 1", workers[0].id, workers[1].id)
+	}
+}
@@ -2,45 +2,102 @@ package loadtest
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"fiyuu-ktdb-loadtest/internal/config"
 	"fiyuu-ktdb-loadtest/internal/metrics"
+	"fiyuu-ktdb-loadtest/internal/report"
 
 	"github.com/sirupsen/logrus"
 )
 
+// liveSnapshotSpan is the rolling window kept for live snapshots, which
+// report the last completed second
+const liveSnapshotSpan = 2 * time.Second
+
+// ErrThresholdsFailed is returned by Run when a threshold is breached
+var ErrThresholdsFailed = errors.New("thresholds failed")
+
+// ErrNotScalable is returned by ScaleUsers outside the running phase of a
+// closed-loop test
+var ErrNotScalable = errors.New("users can only be scaled while a closed_loop test runs after its ramp-up")
+
 // LoadTester manages the load test execution
 type LoadTester struct {
 	config    *config.Config
 	metrics   *metrics.Collector
 	workers   []*Worker
+	workersMu sync.RWMutex // Guards workers and pools against concurrent stats readers
 	wg        sync.WaitGroup
 	closeOnce sync.Once // Prevent double close
 
 	// Dynamic scaling
-	currentUsers int
-	scalingMutex sync.RWMutex
+	currentUsers  int
+	targetUsers   atomic.Int64 // Users the test is scaling to, read by stats readers
+	scalingMutex  sync.RWMutex
+	scalingHalted atomic.Bool     // Set by the circuit breaker to skip further scaling steps
+	scalable      bool            // Set while the workers of a closed-loop test run
+	scalingCtx    context.Context // Context of the run, set with scalable
+
+	// Interrupts the user ramp in progress without waiting for scalingMutex
+	rampMu   sync.Mutex
+	rampStop chan struct{}
+
+	// Query parameter generators shared by all workers
+	params *ParamSet
+
+	// Database connection pools of the workers, nil until the run starts
+	pools *poolSet
+
+	// IDs of the running and stopping workers, nil until the run starts
+	workerIDs *workerIDs
+
+	// Connection storm, nil unless the connection_storm executor runs
+	storm *connectionStorm
+
+	// Pass/fail conditions checked at the end of the run
+	thresholds       []*metrics.Threshold
+	thresholdResults []metrics.ThresholdResult
+
+	// Circuit breaker
+	abortRules  []*abortRule
+	abortCh     chan struct{}
+	abortOnce   sync.Once
+	abortMu     sync.RWMutex
+	abortReason string
+
+	// Open-model executor, nil in closed-loop mode. Both are guarded by
+	// workersMu against stats readers.
+	arrival   *ArrivalRateExecutor
+	startTime time.Time
 }
 
 // NewLoadTester creates a new load tester
 func NewLoadTester(cfg *config.Config, metrics *metrics.Collector) *LoadTester {
-	return &LoadTester{
+	lt := &LoadTester{
 		config:       cfg,
 		metrics:      metrics,
 		workers:      make([]*Worker, 0),
 		currentUsers: cfg.Test.ConcurrentUsers,
+		abortCh:      make(chan struct{}),
+		rampStop:     make(chan struct{}),
 	}
+	lt.targetUsers.Store(int64(cfg.Test.ConcurrentUsers))
+	return lt
 }
 
 // Run executes the load test
 func (lt *LoadTester) Run(ctx context.Context) error {
 	// Configure metrics collector
+	lt.metrics.SetPoolStatsFunc(lt.poolStats)
 	if lt.config.Metrics.Enabled {
 		lt.metrics.SetOutputFile(lt.config.Metrics.OutputFile)
 		lt.metrics.SetInterval(lt.config.Metrics.Interval)
+		lt.metrics.SetTimeSeriesFile(lt.config.Metrics.TimeSeriesFile, lt.config.Metrics.TimeSeriesFormat)
 
 		if lt.config.Metrics.Prometheus.Enabled {
 			lt.metrics.EnablePrometheus("fiyuu_ktdb_loadtest")
@@ -50,101 +107,385 @@ func (lt *LoadTester) Run(ctx context.Context) error {
 		go lt.metrics.Start()
 	}
 
-	// Create and start workers with ramp-up
-	if err := lt.startWorkers(ctx); err != nil {
-		return fmt.Errorf("failed to start workers: %w", err)
+	// Load data sources and parse query parameter generators
+	params, err := NewParamSet(&lt.config.Test)
+	if err != nil {
+		return fmt.Errorf("invalid query parameters: %w", err)
 	}
+	lt.params = params
+	lt.workerIDs = newWorkerIDs(params.UniqueRows())
 
-	// Start dynamic scaling if enabled
-	lt.StartDynamicScaling(ctx)
+	thresholds, err := parseThresholds(&lt.config.Test)
+	if err != nil {
+		return fmt.Errorf("invalid thresholds: %w", err)
+	}
+	lt.thresholds = thresholds
 
-	// Wait for test duration or context cancellation
+	abortRules, err := parseAbortRules(&lt.config.Test)
+	if err != nil {
+		return fmt.Errorf("invalid abort rules: %w", err)
+	}
+	lt.abortRules = abortRules
+
+	// Rolling windows back the abort rules and live snapshots
+	rollingSpan := liveSnapshotSpan
+	if window := maxAbortWindow(abortRules); window > rollingSpan {
+		rollingSpan = window
+	}
+	lt.metrics.EnableRollingWindow(rollingSpan)
+
+	if lt.isConnectionStorm() {
+		storm, err := newConnectionStorm(&lt.config.Database, lt.config.Test.ConnectionStorm, lt.metrics)
+		if err != nil {
+			return err
+		}
+		lt.workersMu.Lock()
+		lt.storm = storm
+		lt.workersMu.Unlock()
+	} else {
+		pools, err := newPoolSet(&lt.config.Database)
+		if err != nil {
+			return err
+		}
+		lt.workersMu.Lock()
+		lt.pools = pools
+		lt.workersMu.Unlock()
+	}
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+
+	lt.workersMu.Lock()
+	lt.startTime = time.Now()
+	lt.workersMu.Unlock()
+
+	// The circuit breaker also watches the initial ramp
+	if len(lt.abortRules) > 0 {
+		go lt.watchAbortRules(runCtx)
+	}
+
+	if lt.storm != nil {
+		// Open and hold connections without query load
+		lt.storm.start(runCtx)
+	} else if lt.isArrivalRate() {
+		// Start the worker pool and dispatch iterations on a fixed schedule
+		if err := lt.startArrivalRate(runCtx); err != nil {
+			return fmt.Errorf("failed to start arrival rate executor: %w", err)
+		}
+	} else {
+		// Create and start workers with ramp-up. Scaling requests, such as
+		// the circuit breaker's roll back, wait for the ramp.
+		lt.scalingMutex.Lock()
+		lt.scalingCtx = runCtx
+		err := lt.startWorkers(runCtx)
+		lt.scalable = err == nil
+		lt.scalingMutex.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to start workers: %w", err)
+		}
+
+		// Start dynamic scaling if enabled
+		lt.StartDynamicScaling(runCtx)
+	}
+
+	// Wait for test duration, an abort or context cancellation
 	select {
 	case <-ctx.Done():
 		logrus.Info("Test cancelled by user")
+	case <-lt.abortCh:
+		logrus.Warn("Test stopped by circuit breaker")
 	case <-time.After(lt.config.Test.Duration):
 		logrus.Info("Test duration completed")
 	}
 
-	// Stop all workers
+	// Stop dispatching and all workers. A scaling step in progress is
+	// interrupted and finishes before the workers are stopped.
+	cancelRun()
+	lt.interruptRamp()
+	lt.scalingMutex.Lock()
+	lt.scalable = false
+	lt.scalingMutex.Unlock()
 	lt.stopWorkers()
 
 	// Wait for all workers to finish
 	lt.wg.Wait()
+	if lt.storm != nil {
+		lt.storm.wait()
+	}
+
+	endTime := time.Now()
 
 	// Print final statistics
 	lt.metrics.PrintStats()
+	lt.logPoolStats()
+	if lt.storm != nil {
+		lt.storm.logSummary()
+	}
+	if lt.arrival != nil {
+		lt.arrival.LogSummary(endTime.Sub(lt.startTime))
+	}
 
+	passed := lt.checkThresholds(endTime.Sub(lt.startTime))
+
+	if lt.config.Metrics.ReportFile != "" {
+		// Close the collector first so the final interval is part of the report
+		lt.metrics.Close()
+		if err := lt.writeReport(endTime); err != nil {
+			logrus.Errorf("Failed to write report: %v", err)
+		} else {
+			logrus.Infof("Report written to %s", lt.config.Metrics.ReportFile)
+		}
+	}
+
+	if reason := lt.AbortReason(); reason != "" {
+		return fmt.Errorf("%w: %s", ErrAborted, reason)
+	}
+	if !passed {
+		return ErrThresholdsFailed
+	}
 	return nil
 }
 
-// startWorkers starts workers with ramp-up
-func (lt *LoadTester) startWorkers(ctx context.Context) error {
-	concurrentUsers := lt.config.Test.ConcurrentUsers
-	rampUpTime := lt.config.Test.RampUpTime
+// parseThresholds parses the threshold expressions of the test
+func parseThresholds(test *config.TestConfig) ([]*metrics.Threshold, error) {
+	queries := iterationNames(test)
 
-	if rampUpTime <= 0 {
-		// Start all workers immediately
-		return lt.startWorkerBatch(0, concurrentUsers, ctx)
+	thresholds := make([]*metrics.Threshold, 0, len(test.Thresholds))
+	for _, expression := range test.Thresholds {
+		threshold, err := metrics.ParseThreshold(expression)
+		if err != nil {
+			return nil, err
+		}
+		if threshold.Query != "" && !queries[threshold.Query] {
+			return nil, fmt.Errorf("threshold %q: unknown query %s", expression, threshold.Query)
+		}
+		thresholds = append(thresholds, threshold)
 	}
+	return thresholds, nil
+}
 
-	// Calculate ramp-up parameters
-	rampUpInterval := rampUpTime / time.Duration(concurrentUsers)
-	if rampUpInterval < time.Millisecond {
-		rampUpInterval = time.Millisecond
+// iterationNames returns the names of all queries and scenarios of the test
+func iterationNames(test *config.TestConfig) map[string]bool {
+	names := make(map[string]bool, len(test.Queries)+len(test.Scenarios))
+	for _, query := range test.Queries {
+		names[query.Name] = true
+	}
+	for _, scenario := range test.Scenarios {
+		names[scenario.Name] = true
+	}
+	if test.Executor == config.ExecutorConnectionStorm {
+		names[stormConnectName] = true
+		names[stormHeartbeatName] = true
 	}
+	return names
+}
 
-	logrus.Infof("Starting %d workers with %v ramp-up interval", concurrentUsers, rampUpInterval)
+// checkThresholds evaluates the thresholds against the results of the run,
+// logs a pass/fail table and reports whether all of them passed
+func (lt *LoadTester) checkThresholds(elapsed time.Duration) bool {
+	if len(lt.thresholds) == 0 {
+		return true
+	}
 
-	// Start workers gradually
-	for i := 0; i < concurrentUsers; i++ {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			if err := lt.startWorkerBatch(i, 1, ctx); err != nil {
-				return err
-			}
+	lt.thresholdResults = lt.metrics.EvaluateThresholds(lt.thresholds, elapsed)
 
-			// Update active users count
-			lt.metrics.SetActiveUsers(i + 1)
+	passed := true
+	logrus.Info("=== Thresholds ===")
+	for _, result := range lt.thresholdResults {
+		if result.Passed {
+			logrus.Infof("PASS  %s  (actual: %s)", result.Expression, result.Actual)
+		} else {
+			passed = false
+			logrus.Errorf("FAIL  %s  (actual: %s)", result.Expression, result.Actual)
+		}
+	}
+	return passed
+}
+
+// writeReport writes the HTML report of the finished run
+func (lt *LoadTester) writeReport(endTime time.Time) error {
+	data := report.FromCollector(lt.metrics)
+	data.Title = "Fiyuu KTDB Load Test Report"
+	data.StartTime = lt.startTime
+	data.EndTime = endTime
+	data.Executor = lt.config.Test.Executor
+	data.Settings = lt.config.RedactedSettings()
+	data.Timeline = lt.timeline(endTime.Sub(lt.startTime))
+	data.Thresholds = lt.thresholdResults
+	data.Aborted = lt.AbortReason()
+
+	return report.Write(lt.config.Metrics.ReportFile, data)
+}
 
-			if i < concurrentUsers-1 {
-				time.Sleep(rampUpInterval)
+// timeline returns the configured scaling steps of the run, with the
+// achieved rate of every arrival rate stage that started
+func (lt *LoadTester) timeline(elapsed time.Duration) []report.TimelineStep {
+	var steps []report.TimelineStep
+
+	if lt.arrival != nil {
+		stages := make(map[time.Duration]StageReport)
+		for _, stage := range lt.arrival.StageReports(elapsed) {
+			stages[stage.Start] = stage
+		}
+		describe := func(stage StageReport) string {
+			return fmt.Sprintf("requested %.2f/s, achieved %.2f/s, dropped %d",
+				stage.RequestedRate, stage.AchievedRate, stage.Dropped)
+		}
+
+		if initial, ok := stages[0]; ok && initial.Description == "Initial rate" {
+			steps = append(steps, report.TimelineStep{
+				Target:      fmt.Sprintf("%.2f/s", initial.TargetRate),
+				Description: initial.Description,
+				Result:      describe(initial),
+			})
+		}
+		for _, step := range lt.arrival.stages {
+			row := report.TimelineStep{
+				Offset:      step.TimeOffset,
+				Ramp:        step.RampDuration,
+				Target:      fmt.Sprintf("%.2f/s", step.TargetRate),
+				Description: step.Description,
+				Result:      "not reached",
+			}
+			if stage, ok := stages[step.TimeOffset]; ok && stage.Description == step.Description {
+				row.Result = describe(stage)
 			}
+			steps = append(steps, row)
 		}
+		return steps
 	}
 
+	if !lt.config.Test.UserScaling.Enabled {
+		return nil
+	}
+	for _, step := range lt.config.Test.UserScaling.ScalingPlan {
+		steps = append(steps, report.TimelineStep{
+			Offset:      step.TimeOffset,
+			Ramp:        step.RampDuration,
+			Target:      fmt.Sprintf("%d users", step.TargetUsers),
+			Description: step.Description,
+		})
+	}
+	return steps
+}
+
+// isConnectionStorm reports whether the executor only opens connections
+func (lt *LoadTester) isConnectionStorm() bool {
+	return lt.config.Test.Executor == config.ExecutorConnectionStorm
+}
+
+// isArrivalRate reports whether the test uses an open-model executor
+func (lt *LoadTester) isArrivalRate() bool {
+	switch lt.config.Test.Executor {
+	case config.ExecutorConstantArrivalRate, config.ExecutorRampingArrivalRate:
+		return true
+	default:
+		return false
+	}
+}
+
+// startArrivalRate starts the worker pool and the arrival rate dispatcher
+func (lt *LoadTester) startArrivalRate(ctx context.Context) error {
+	arrivalCfg := lt.config.Test.ArrivalRate
+	poolSize := arrivalCfg.MaxWorkers
+	if poolSize <= 0 {
+		poolSize = lt.config.Test.ConcurrentUsers
+	}
+
+	arrival := NewArrivalRateExecutor(&arrivalCfg, lt.metrics)
+	lt.workersMu.Lock()
+	lt.arrival = arrival
+	lt.workersMu.Unlock()
+
+	logrus.Infof("Starting arrival rate pool with %d workers", poolSize)
+	if err := lt.startWorkerBatch(poolSize, ctx); err != nil {
+		return err
+	}
+	lt.metrics.SetActiveUsers(poolSize)
+
+	// Stage offsets are measured from the first dispatch
+	lt.workersMu.Lock()
+	lt.startTime = time.Now()
+	lt.workersMu.Unlock()
+	lt.wg.Add(1)
+	go func() {
+		defer lt.wg.Done()
+		lt.arrival.Run(ctx)
+	}()
+
 	return nil
 }
 
+// startWorkers starts workers with ramp-up. The ramp ends early if it is
+// interrupted, the run ends or the circuit breaker trips. The caller holds
+// scalingMutex.
+func (lt *LoadTester) startWorkers(ctx context.Context) error {
+	concurrentUsers := lt.config.Test.ConcurrentUsers
+	rampUpTime := lt.config.Test.RampUpTime
+
+	if rampUpTime <= 0 {
+		// Start all workers immediately
+		if err := lt.startWorkerBatch(concurrentUsers, ctx); err != nil {
+			return err
+		}
+		lt.metrics.SetActiveUsers(concurrentUsers)
+		return nil
+	}
+
+	// Start workers gradually
+	return lt.addUsers(concurrentUsers, rampUpTime, lt.currentRampStop())
+}
+
 // startWorkerBatch starts a batch of workers
-func (lt *LoadTester) startWorkerBatch(startID, count int, ctx context.Context) error {
+func (lt *LoadTester) startWorkerBatch(count int, ctx context.Context) error {
 	for i := 0; i < count; i++ {
-		workerID := startID + i
-
-		worker, err := NewWorker(workerID, lt.config, lt.metrics)
-		if err != nil {
-			return fmt.Errorf("failed to create worker %d: %w", workerID, err)
+		// Stopped workers only exist after scaling, the first IDs are free
+		workerID, _ := lt.workerIDs.acquire()
+		if workerID < 0 {
+			return fmt.Errorf("no free worker ID for worker %d of %d", i+1, count)
+		}
+		if err := lt.startWorker(workerID); err != nil {
+			return err
 		}
+	}
 
-		lt.workers = append(lt.workers, worker)
-		lt.wg.Add(1)
+	return nil
+}
 
-		go func(w *Worker) {
-			defer lt.wg.Done()
-			w.Start()
-		}(worker)
+// startWorker creates and starts the worker with the given ID
+func (lt *LoadTester) startWorker(workerID int) error {
+	worker, err := NewWorker(workerID, lt.config, lt.metrics, lt.params, lt.pools)
+	if err != nil {
+		lt.workerIDs.release(workerID)
+		return fmt.Errorf("failed to create worker %d: %w", workerID, err)
 	}
 
+	lt.workersMu.Lock()
+	lt.workers = append(lt.workers, worker)
+	lt.workersMu.Unlock()
+	lt.runWorker(worker)
 	return nil
 }
 
+// runWorker starts the worker loop matching the configured executor
+func (lt *LoadTester) runWorker(w *Worker) {
+	lt.wg.Add(1)
+
+	go func() {
+		defer lt.wg.Done()
+		if lt.arrival != nil {
+			w.StartArrivals(lt.arrival)
+		} else {
+			w.Start()
+		}
+	}()
+}
+
 // stopWorkers stops all workers
 func (lt *LoadTester) stopWorkers() {
 	logrus.Info("Stopping all workers...")
 
-	for _, worker := range lt.workers {
+	for _, worker := range lt.snapshotWorkers() {
 		worker.Stop()
 	}
 }
@@ -156,7 +497,10 @@ func (lt *LoadTester) Close() error {
 	lt.closeOnce.Do(func() {
 		logrus.Info("Closing all workers and cleaning up connections...")
 
-		for i, worker := range lt.workers {
+		// Close the metrics first so the final snapshot still sees the connections
+		lt.metrics.Close()
+
+		for i, worker := range lt.snapshotWorkers() {
 			if worker != nil {
 				if err := worker.Close(); err != nil {
 					lastErr = err
@@ -165,10 +509,17 @@ func (lt *LoadTester) Close() error {
 			}
 		}
 
-		// Clear workers slice
+		// Clear workers slice and close the shared pools
+		lt.workersMu.Lock()
 		lt.workers = nil
-
-		lt.metrics.Close()
+		if lt.pools != nil {
+			lt.pools.close()
+		}
+		if lt.storm != nil {
+			lt.storm.close()
+			lt.storm = nil
+		}
+		lt.workersMu.Unlock()
 
 		logrus.Info("All connections cleaned up")
 	})
@@ -178,54 +529,153 @@ func (lt *LoadTester) Close() error {
 
 // GetStats returns current load test statistics
 func (lt *LoadTester) GetStats() map[string]interface{} {
+	workers := lt.snapshotWorkers()
+
 	stats := lt.metrics.GetStats()
-	stats["active_workers"] = len(lt.workers)
-	stats["target_workers"] = lt.config.Test.ConcurrentUsers
+	stats["active_workers"] = len(workers)
+	stats["target_workers"] = lt.targetUsers.Load()
+	if reason := lt.AbortReason(); reason != "" {
+		stats["aborted"] = reason
+	}
+
+	lt.workersMu.RLock()
+	arrival := lt.arrival
+	startTime := lt.startTime
+	lt.workersMu.RUnlock()
+	if arrival != nil {
+		stats["busy_workers"] = arrival.BusyWorkers()
+		stats["arrival_stages"] = arrival.StageReports(time.Since(startTime))
+	}
 
-	// Update active connections metric from all workers' database stats
+	// Update active connections metric from the connection pools
 	totalOpenConnections := 0
 	totalInUseConnections := 0
 	totalIdleConnections := 0
 
-	for _, worker := range lt.workers {
-		if worker != nil {
-			dbStats := worker.GetDBStats()
-			totalOpenConnections += dbStats.OpenConnections
-			totalInUseConnections += dbStats.InUse
-			totalIdleConnections += dbStats.Idle
-		}
+	pools := lt.poolStats()
+	for _, pool := range pools {
+		totalOpenConnections += pool.OpenConnections
+		totalInUseConnections += pool.InUse
+		totalIdleConnections += pool.Idle
 	}
 
 	lt.metrics.SetActiveConnections(totalOpenConnections)
 	stats["active_connections"] = totalOpenConnections
 	stats["connections_in_use"] = totalInUseConnections
 	stats["connections_idle"] = totalIdleConnections
+	stats["pools"] = pools
+
+	lt.workersMu.RLock()
+	storm := lt.storm
+	lt.workersMu.RUnlock()
+	if storm != nil {
+		stats["connection_storm"] = storm.summary()
+	}
 
 	return stats
 }
 
+// snapshotWorkers returns a copy of the current worker list
+func (lt *LoadTester) snapshotWorkers() []*Worker {
+	lt.workersMu.RLock()
+	defer lt.workersMu.RUnlock()
+
+	workers := make([]*Worker, len(lt.workers))
+	copy(workers, lt.workers)
+	return workers
+}
+
+// poolStats returns the statistics of the connection pools
+func (lt *LoadTester) poolStats() []metrics.PoolStats {
+	lt.workersMu.RLock()
+	pools := lt.pools
+	storm := lt.storm
+	lt.workersMu.RUnlock()
+
+	switch {
+	case storm != nil:
+		return storm.poolStats()
+	case pools != nil:
+		return pools.stats()
+	default:
+		return nil
+	}
+}
+
+// logPoolStats logs the final statistics of the connection pools
+func (lt *LoadTester) logPoolStats() {
+	for _, pool := range lt.poolStats() {
+		logrus.Infof("Pool %s: %d open (max %d), %d in use, %d idle, waited %d times for %v",
+			pool.Name, pool.OpenConnections, pool.MaxOpenConnections, pool.InUse, pool.Idle,
+			pool.WaitCount, pool.WaitDuration)
+	}
+}
+
+// CanScaleUsers reports an error if ScaleUsers to the target would fail
+// right now: ErrNotScalable, or too few rows in a unique data source
+func (lt *LoadTester) CanScaleUsers(targetUsers int) error {
+	lt.scalingMutex.RLock()
+	defer lt.scalingMutex.RUnlock()
+
+	if !lt.scalable {
+		return ErrNotScalable
+	}
+	return lt.params.CheckWorkers(targetUsers)
+}
+
 // ScaleUsers dynamically scales the number of users
 func (lt *LoadTester) ScaleUsers(targetUsers int, rampDuration time.Duration, description string) error {
 	lt.scalingMutex.Lock()
 	defer lt.scalingMutex.Unlock()
 
+	return lt.scaleUsersLocked(targetUsers, rampDuration, description)
+}
+
+// reduceUsers immediately scales down to the target users and reports
+// whether users were removed. It never adds users.
+func (lt *LoadTester) reduceUsers(targetUsers int, description string) (bool, error) {
+	lt.scalingMutex.Lock()
+	defer lt.scalingMutex.Unlock()
+
+	if !lt.scalable {
+		return false, ErrNotScalable
+	}
+	if targetUsers >= len(lt.workers) {
+		return false, nil
+	}
+	return true, lt.scaleUsersLocked(targetUsers, 0, description)
+}
+
+// scaleUsersLocked scales the number of users while holding scalingMutex
+func (lt *LoadTester) scaleUsersLocked(targetUsers int, rampDuration time.Duration, description string) error {
+	if !lt.scalable {
+		return ErrNotScalable
+	}
+	if err := lt.params.CheckWorkers(targetUsers); err != nil {
+		return err
+	}
+	lt.targetUsers.Store(int64(targetUsers))
+
 	currentCount := len(lt.workers)
 
 	logrus.Infof("Scaling users: %d -> %d (%s)", currentCount, targetUsers, description)
 
+	// Only the ramps running when the signal arrives are interrupted
+	stop := lt.currentRampStop()
+
 	if targetUsers > currentCount {
 		// Add users
-		return lt.addUsers(targetUsers-currentCount, rampDuration)
+		return lt.addUsers(targetUsers-currentCount, rampDuration, stop)
 	} else if targetUsers < currentCount {
 		// Remove users
-		return lt.removeUsers(currentCount-targetUsers, rampDuration)
+		return lt.removeUsers(currentCount-targetUsers, rampDuration, stop)
 	}
 
 	return nil
 }
 
-// addUsers adds new users gradually
-func (lt *LoadTester) addUsers(count int, rampDuration time.Duration) error {
+// addUsers adds new users gradually until the ramp is interrupted
+func (lt *LoadTester) addUsers(count int, rampDuration time.Duration, stop <-chan struct{}) error {
 	if count <= 0 {
 		return nil
 	}
@@ -238,27 +688,22 @@ func (lt *LoadTester) addUsers(count int, rampDuration time.Duration) error {
 	logrus.Infof("Adding %d users with %v interval", count, rampInterval)
 
 	for i := 0; i < count; i++ {
-		workerID := len(lt.workers)
-
-		worker, err := NewWorker(workerID, lt.config, lt.metrics)
-		if err != nil {
-			return fmt.Errorf("failed to create worker %d: %w", workerID, err)
+		workerID, ok := lt.waitWorkerID(stop)
+		if !ok {
+			logrus.Warnf("Ramp interrupted after adding %d of %d users. Total users: %d", i, count, len(lt.workers))
+			return nil
+		}
+		if err := lt.startWorker(workerID); err != nil {
+			return err
 		}
-
-		lt.workers = append(lt.workers, worker)
-		lt.wg.Add(1)
-
-		go func(w *Worker) {
-			defer lt.wg.Done()
-			w.Start()
-		}(worker)
 
 		// Update metrics
 		lt.metrics.SetActiveUsers(len(lt.workers))
 		lt.currentUsers = len(lt.workers)
 
-		if i < count-1 {
-			time.Sleep(rampInterval)
+		if i < count-1 && !lt.waitRamp(rampInterval, stop) {
+			logrus.Warnf("Ramp interrupted after adding %d of %d users. Total users: %d", i+1, count, len(lt.workers))
+			return nil
 		}
 	}
 
@@ -266,8 +711,8 @@ func (lt *LoadTester) addUsers(count int, rampDuration time.Duration) error {
 	return nil
 }
 
-// removeUsers removes users gradually
-func (lt *LoadTester) removeUsers(count int, rampDuration time.Duration) error {
+// removeUsers removes users gradually until the ramp is interrupted
+func (lt *LoadTester) removeUsers(count int, rampDuration time.Duration, stop <-chan struct{}) error {
 	if count <= 0 || count >= len(lt.workers) {
 		return nil
 	}
@@ -293,14 +738,21 @@ func (lt *LoadTester) removeUsers(count int, rampDuration time.Duration) error {
 		worker.Stop()
 
 		// Remove from slice
+		lt.workersMu.Lock()
 		lt.workers = lt.workers[:lastIndex]
+		lt.workersMu.Unlock()
+
+		// Close may only release the connections once the query in flight
+		// has returned them
+		lt.closeStopped(worker)
 
 		// Update metrics
 		lt.metrics.SetActiveUsers(len(lt.workers))
 		lt.currentUsers = len(lt.workers)
 
-		if i < count-1 {
-			time.Sleep(rampInterval)
+		if i < count-1 && !lt.waitRamp(rampInterval, stop) {
+			logrus.Warnf("Ramp interrupted after removing %d of %d users. Total users: %d", i+1, count, len(lt.workers))
+			return nil
 		}
 	}
 
@@ -308,6 +760,98 @@ func (lt *LoadTester) removeUsers(count int, rampDuration time.Duration) error {
 	return nil
 }
 
+// closeStopped closes a stopped worker in the background once its loop has
+// returned, releasing its per-worker pool. Run waits for it like for the
+// worker itself.
+func (lt *LoadTester) closeStopped(w *Worker) {
+	lt.wg.Add(1)
+
+	go func() {
+		defer lt.wg.Done()
+		<-w.done
+		if err := w.Close(); err != nil {
+			logrus.Errorf("Failed to close worker %d: %v", w.id, err)
+		}
+		lt.workerIDs.release(w.id)
+	}()
+}
+
+// waitWorkerID returns a free worker ID, waiting for a stopped worker to
+// release its ID if every ID is taken. It reports false if the ramp was
+// interrupted or the run ended first.
+func (lt *LoadTester) waitWorkerID(stop <-chan struct{}) (int, bool) {
+	for {
+		workerID, released := lt.workerIDs.acquire()
+		if workerID >= 0 {
+			return workerID, true
+		}
+
+		logrus.Debug("Waiting for a stopped worker to release its ID")
+		select {
+		case <-released:
+		case <-stop:
+			return 0, false
+		case <-lt.scalingCtx.Done():
+			return 0, false
+		case <-lt.abortCh:
+			return 0, false
+		}
+	}
+}
+
+// workerIDs hands out worker IDs. The ID of a removed worker is reused only
+// once its loop has returned, so no two running workers share an ID or the
+// row a data source in unique mode pins to it. The lowest free ID is handed
+// out first, which keeps IDs below the peak worker count.
+type workerIDs struct {
+	mu       sync.Mutex
+	limit    int           // IDs stay below the limit, 0 for no limit
+	next     int           // Lowest ID not handed out yet
+	free     []int         // Released IDs
+	released chan struct{} // Closed and replaced when an ID is released
+}
+
+// newWorkerIDs creates worker IDs below the limit, 0 for no limit
+func newWorkerIDs(limit int) *workerIDs {
+	return &workerIDs{limit: limit, released: make(chan struct{})}
+}
+
+// acquire returns the lowest free ID. If every ID below the limit is taken,
+// it returns -1 and a channel that is closed when an ID is released.
+func (w *workerIDs) acquire() (int, <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.free) > 0 {
+		lowest := 0
+		for i, id := range w.free {
+			if id < w.free[lowest] {
+				lowest = i
+			}
+		}
+		id := w.free[lowest]
+		w.free = append(w.free[:lowest], w.free[lowest+1:]...)
+		return id, nil
+	}
+	if w.limit > 0 && w.next >= w.limit {
+		return -1, w.released
+	}
+
+	id := w.next
+	w.next++
+	return id, nil
+}
+
+// release makes the ID of a worker whose loop has returned available again
+func (w *workerIDs) release(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.free = append(w.free, id)
+	close(w.released)
+	w.released = make(chan struct{})
+}
+
 // StartDynamicScaling starts the dynamic scaling process
 func (lt *LoadTester) StartDynamicScaling(ctx context.Context) {
 	if !lt.config.Test.UserScaling.Enabled {
@@ -322,6 +866,10 @@ func (lt *LoadTester) StartDynamicScaling(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-time.After(step.TimeOffset):
+				if lt.isScalingHalted() {
+					logrus.Warnf("Skipping scaling step %q, scaling was halted by the circuit breaker", step.Description)
+					return
+				}
 				if err := lt.ScaleUsers(step.TargetUsers, step.RampDuration, step.Description); err != nil {
 					logrus.Errorf("Failed to scale users: %v", err)
 				}
@@ -329,3 +877,50 @@ func (lt *LoadTester) StartDynamicScaling(ctx context.Context) {
 		}
 	}()
 }
+
+// haltScaling stops the scaling plan from applying further steps and
+// interrupts the ramp in progress
+func (lt *LoadTester) haltScaling() {
+	lt.scalingHalted.Store(true)
+	lt.interruptRamp()
+}
+
+// isScalingHalted reports whether the scaling plan was halted
+func (lt *LoadTester) isScalingHalted() bool {
+	return lt.scalingHalted.Load()
+}
+
+// interruptRamp interrupts the ramp in progress. Ramps started afterwards,
+// such as the circuit breaker's roll back, run to completion.
+func (lt *LoadTester) interruptRamp() {
+	lt.rampMu.Lock()
+	defer lt.rampMu.Unlock()
+
+	close(lt.rampStop)
+	lt.rampStop = make(chan struct{})
+}
+
+// currentRampStop returns the channel closed by the next interruptRamp
+func (lt *LoadTester) currentRampStop() <-chan struct{} {
+	lt.rampMu.Lock()
+	defer lt.rampMu.Unlock()
+	return lt.rampStop
+}
+
+// waitRamp waits for the next ramp step and reports false if the ramp was
+// interrupted or the run ended first
+func (lt *LoadTester) waitRamp(interval time.Duration, stop <-chan struct{}) bool {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	case <-lt.scalingCtx.Done():
+		return false
+	case <-lt.abortCh:
+		return false
+	}
+}
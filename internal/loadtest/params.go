@@ -0,0 +1,447 @@
+package loadtest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+
+	"github.com/google/uuid"
+)
+
+const randomStringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// ParamContext carries the per-worker state available to parameter generators
+type ParamContext struct {
+	WorkerID int
+
+	// Values captured from result rows by earlier statements of the worker
+	Vars map[string]interface{}
+}
+
+// ParamGenerator produces a value for a query parameter on every execution.
+// Generators are shared by all workers and must be safe for concurrent use.
+type ParamGenerator interface {
+	Next(ctx *ParamContext) interface{}
+}
+
+// ParamSet holds the parameter generators and data feeds of every query
+type ParamSet struct {
+	queries map[string]map[string]ParamGenerator
+	feeds   map[string]*QueryFeed
+	unique  []*Feeder // Feeders that pin a row to every worker
+}
+
+// QueryFeed binds columns of a data source row to query parameters
+type QueryFeed struct {
+	Feeder   *Feeder
+	Bindings map[string]string // Parameter name -> column
+}
+
+// NewParamSet loads the data sources and parses the parameter specs of all
+// queries. A spec is a generator name with optional arguments, for example:
+//
+//	int(1, 1000)              random integer in the inclusive range
+//	string(12)                random alphanumeric string of the given length
+//	uuid                      random UUID
+//	sequence(1, 1)            shared increasing sequence with start and step
+//	timestamp                 current time
+//	timestamp(-24h, 0s)       random time within the offset window from now
+//	pick(active, closed)      random element of the list
+func NewParamSet(test *config.TestConfig) (*ParamSet, error) {
+	set := &ParamSet{
+		queries: make(map[string]map[string]ParamGenerator),
+		feeds:   make(map[string]*QueryFeed),
+	}
+
+	feeders := make(map[string]*Feeder, len(test.DataSources))
+	for i := range test.DataSources {
+		source := &test.DataSources[i]
+		feeder, err := LoadFeeder(source)
+		if err != nil {
+			return nil, fmt.Errorf("data source %s: %w", source.Name, err)
+		}
+		if source.Mode == "unique" {
+			set.unique = append(set.unique, feeder)
+		}
+		feeders[source.Name] = feeder
+	}
+	if err := set.CheckWorkers(peakWorkers(test)); err != nil {
+		return nil, err
+	}
+
+	for i := range test.Queries {
+		if err := set.add(test.Queries[i].Name, &test.Queries[i], feeders); err != nil {
+			return nil, err
+		}
+	}
+	for _, scenario := range test.Scenarios {
+		for i := range scenario.Steps {
+			step := &scenario.Steps[i].QueryConfig
+			if err := set.add(StepKey(scenario.Name, step.Name), step, feeders); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return set, nil
+}
+
+// peakWorkers returns the most workers a test runs at once without remote
+// scaling
+func peakWorkers(test *config.TestConfig) int {
+	switch test.Executor {
+	case config.ExecutorConstantArrivalRate, config.ExecutorRampingArrivalRate:
+		if test.ArrivalRate.MaxWorkers > 0 {
+			return test.ArrivalRate.MaxWorkers
+		}
+		return test.ConcurrentUsers
+	case config.ExecutorConnectionStorm:
+		return 0
+	}
+
+	peak := test.ConcurrentUsers
+	if test.UserScaling.Enabled {
+		for _, step := range test.UserScaling.ScalingPlan {
+			if step.TargetUsers > peak {
+				peak = step.TargetUsers
+			}
+		}
+	}
+	return peak
+}
+
+// CheckWorkers reports an error if a data source in unique mode has fewer
+// rows than workers, since workers would then share rows
+func (s *ParamSet) CheckWorkers(workers int) error {
+	if s == nil {
+		return nil
+	}
+	for _, feeder := range s.unique {
+		if feeder.Len() < workers {
+			return fmt.Errorf("data source %s has %d rows, unique mode needs one for each of %d workers",
+				feeder.name, feeder.Len(), workers)
+		}
+	}
+	return nil
+}
+
+// UniqueRows returns the rows of the smallest data source in unique mode, or
+// 0 if no data source is in unique mode
+func (s *ParamSet) UniqueRows() int {
+	rows := 0
+	for _, feeder := range s.unique {
+		if rows == 0 || feeder.Len() < rows {
+			rows = feeder.Len()
+		}
+	}
+	return rows
+}
+
+// StepKey returns the parameter set key of a scenario step
+func StepKey(scenario, step string) string {
+	return scenario + "/" + step
+}
+
+// add parses the parameter specs and data source bindings of a query
+func (s *ParamSet) add(key string, query *config.QueryConfig, feeders map[string]*Feeder) error {
+	if query.DataSource != "" {
+		feeder := feeders[query.DataSource]
+		bindings := make(map[string]string, len(query.Bindings))
+		for param, column := range query.Bindings {
+			if !feeder.HasColumn(column) {
+				return fmt.Errorf("query %s: parameter %s: data source %s has no column %s",
+					key, param, query.DataSource, column)
+			}
+			bindings[strings.ToLower(param)] = column
+		}
+		s.feeds[key] = &QueryFeed{Feeder: feeder, Bindings: bindings}
+	}
+
+	if len(query.Parameters) == 0 {
+		return nil
+	}
+
+	generators := make(map[string]ParamGenerator, len(query.Parameters))
+	for name, spec := range query.Parameters {
+		generator, err := NewParamGenerator(spec)
+		if err != nil {
+			return fmt.Errorf("query %s: parameter %s: %w", key, name, err)
+		}
+		generators[strings.ToLower(name)] = generator
+	}
+	s.queries[key] = generators
+	return nil
+}
+
+// Generators returns the parameter generators of a query by parameter name
+func (s *ParamSet) Generators(queryName string) map[string]ParamGenerator {
+	if s == nil {
+		return nil
+	}
+	return s.queries[queryName]
+}
+
+// Feed returns the data feed of a query, or nil if it has none
+func (s *ParamSet) Feed(queryName string) *QueryFeed {
+	if s == nil {
+		return nil
+	}
+	return s.feeds[queryName]
+}
+
+// NewParamGenerator creates a generator from a spec such as "int(1, 100)"
+func NewParamGenerator(spec string) (ParamGenerator, error) {
+	name, args, err := parseParamSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "int":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("int requires min and max")
+		}
+		min, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int min: %w", err)
+		}
+		max, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int max: %w", err)
+		}
+		if max < min {
+			return nil, fmt.Errorf("int max must not be less than min")
+		}
+		return &intRangeGenerator{min: min, max: max}, nil
+
+	case "string":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("string requires a length")
+		}
+		length, err := strconv.Atoi(args[0])
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid string length: %s", args[0])
+		}
+		return &randomStringGenerator{length: length}, nil
+
+	case "uuid":
+		return uuidGenerator{}, nil
+
+	case "sequence":
+		start, step := int64(1), int64(1)
+		if len(args) > 2 {
+			return nil, fmt.Errorf("sequence takes at most start and step")
+		}
+		if len(args) > 0 {
+			if start, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid sequence start: %w", err)
+			}
+		}
+		if len(args) > 1 {
+			if step, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid sequence step: %w", err)
+			}
+		}
+		return &sequenceGenerator{next: start - step, step: step}, nil
+
+	case "timestamp":
+		if len(args) == 0 {
+			return &timestampGenerator{}, nil
+		}
+		if len(args) != 2 {
+			return nil, fmt.Errorf("timestamp takes no arguments or a min and max offset")
+		}
+		min, err := time.ParseDuration(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp min offset: %w", err)
+		}
+		max, err := time.ParseDuration(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp max offset: %w", err)
+		}
+		if max < min {
+			return nil, fmt.Errorf("timestamp max offset must not be less than min")
+		}
+		return &timestampGenerator{min: min, max: max}, nil
+
+	case "pick":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("pick requires at least one value")
+		}
+		return &pickGenerator{values: args}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown generator: %s", name)
+	}
+}
+
+// parseParamSpec splits "name(arg1, arg2)" into its name and arguments
+func parseParamSpec(spec string) (string, []string, error) {
+	spec = strings.TrimSpace(spec)
+
+	open := strings.IndexByte(spec, '(')
+	if open < 0 {
+		if spec == "" {
+			return "", nil, fmt.Errorf("empty generator spec")
+		}
+		return strings.ToLower(spec), nil, nil
+	}
+	if !strings.HasSuffix(spec, ")") {
+		return "", nil, fmt.Errorf("invalid generator spec: %s", spec)
+	}
+
+	name := strings.ToLower(strings.TrimSpace(spec[:open]))
+	body := strings.TrimSpace(spec[open+1 : len(spec)-1])
+	if body == "" {
+		return name, nil, nil
+	}
+
+	args, err := splitParamArgs(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid generator spec %s: %w", spec, err)
+	}
+	return name, args, nil
+}
+
+// splitParamArgs splits generator arguments at commas outside quotes, so
+// pick('a,b', c) has the arguments "a,b" and "c". Arguments may be quoted
+// with ' or "; a quote character written twice inside is a literal quote.
+func splitParamArgs(body string) ([]string, error) {
+	var args []string
+	for i := 0; ; {
+		for i < len(body) && body[i] == ' ' {
+			i++
+		}
+
+		var arg string
+		if i < len(body) && (body[i] == '\'' || body[i] == '"') {
+			quote := body[i]
+			var b strings.Builder
+			closed := false
+			for i++; i < len(body); i++ {
+				if body[i] != quote {
+					b.WriteByte(body[i])
+					continue
+				}
+				if i+1 < len(body) && body[i+1] == quote {
+					b.WriteByte(quote)
+					i++
+					continue
+				}
+				closed = true
+				i++
+				break
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated quoted argument")
+			}
+			arg = b.String()
+
+			for i < len(body) && body[i] == ' ' {
+				i++
+			}
+			if i < len(body) && body[i] != ',' {
+				return nil, fmt.Errorf("unexpected %q after quoted argument", body[i:])
+			}
+		} else {
+			end := strings.IndexByte(body[i:], ',')
+			if end < 0 {
+				end = len(body) - i
+			}
+			arg = strings.TrimSpace(body[i : i+end])
+			i += end
+		}
+		args = append(args, arg)
+
+		if i >= len(body) {
+			return args, nil
+		}
+		i++ // Skip the comma
+	}
+}
+
+// intRangeGenerator returns random integers in an inclusive range
+type intRangeGenerator struct {
+	min, max int64
+}
+
+func (g *intRangeGenerator) Next(ctx *ParamContext) interface{} {
+	return randomInRange(g.min, g.max)
+}
+
+// randomStringGenerator returns random alphanumeric strings
+type randomStringGenerator struct {
+	length int
+}
+
+func (g *randomStringGenerator) Next(ctx *ParamContext) interface{} {
+	b := make([]byte, g.length)
+	for i := range b {
+		b[i] = randomStringChars[rand.Intn(len(randomStringChars))]
+	}
+	return string(b)
+}
+
+// uuidGenerator returns random version 4 UUIDs
+type uuidGenerator struct{}
+
+func (uuidGenerator) Next(ctx *ParamContext) interface{} {
+	return uuid.NewString()
+}
+
+// sequenceGenerator returns an increasing sequence shared by all workers
+type sequenceGenerator struct {
+	next int64
+	step int64
+}
+
+func (g *sequenceGenerator) Next(ctx *ParamContext) interface{} {
+	return atomic.AddInt64(&g.next, g.step)
+}
+
+// timestampGenerator returns the current time, optionally shifted by a random
+// offset within a window
+type timestampGenerator struct {
+	min, max time.Duration
+}
+
+func (g *timestampGenerator) Next(ctx *ParamContext) interface{} {
+	now := time.Now()
+	if g.max == g.min {
+		return now.Add(g.min)
+	}
+	return now.Add(time.Duration(randomInRange(int64(g.min), int64(g.max))))
+}
+
+// randomInRange returns a random integer in the inclusive range. The width
+// of the range is computed in uint64, so it may span all of int64.
+func randomInRange(min, max int64) int64 {
+	span := uint64(max) - uint64(min)
+	if span == math.MaxUint64 {
+		return int64(rand.Uint64())
+	}
+	if span < math.MaxInt64 {
+		return min + rand.Int63n(int64(span+1))
+	}
+	// Wider than int64, draw from uint64 and reject values outside the range
+	for {
+		if v := rand.Uint64(); v <= span {
+			return int64(uint64(min) + v)
+		}
+	}
+}
+
+// pickGenerator returns a random element of a fixed list
+type pickGenerator struct {
+	values []string
+}
+
+func (g *pickGenerator) Next(ctx *ParamContext) interface{} {
+	return g.values[rand.Intn(len(g.values))]
+}
@@ -0,0 +1,146 @@
+package loadtest
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitParamArgs(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{body: "1, 100", want: []string{"1", "100"}},
+		{body: " a ,b,  c ", want: []string{"a", "b", "c"}},
+		{body: "a,,b", want: []string{"a", "", "b"}},
+		{body: "'a,b', c", want: []string{"a,b", "c"}},
+		{body: `"x, y" , 'z'`, want: []string{"x, y", "z"}},
+		{body: "'it''s', b", want: []string{"it's", "b"}},
+		{body: `'say "hi"'`, want: []string{`say "hi"`}},
+		{body: "' padded '", want: []string{" padded "}},
+		{body: "''", want: []string{""}},
+
+		{body: "'a, b", wantErr: true},
+		{body: "'a' b, c", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			got, err := splitParamArgs(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("splitParamArgs(%q) = %q, want an error", tt.body, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitParamArgs(%q): %v", tt.body, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitParamArgs(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewParamGenerator(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "int(1, 100)"},
+		{spec: "int(0, 9223372036854775807)"},
+		{spec: "int(-9223372036854775808, 0)"},
+		{spec: "int(-9223372036854775808, 9223372036854775807)"},
+		{spec: "string(12)"},
+		{spec: "UUID"},
+		{spec: "sequence(10, 5)"},
+		{spec: "timestamp"},
+		{spec: "timestamp(-24h, 0s)"},
+		{spec: "pick('a,b', c)"},
+
+		{spec: "", wantErr: true},
+		{spec: "int(1)", wantErr: true},
+		{spec: "int(10, 1)", wantErr: true},
+		{spec: "int(1, 9223372036854775808)", wantErr: true},
+		{spec: "string(0)", wantErr: true},
+		{spec: "sequence(1, 2, 3)", wantErr: true},
+		{spec: "timestamp(1h)", wantErr: true},
+		{spec: "timestamp(1h, 0s)", wantErr: true},
+		{spec: "pick()", wantErr: true},
+		{spec: "pick('a)", wantErr: true},
+		{spec: "int(1, 2", wantErr: true},
+		{spec: "random", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := NewParamGenerator(tt.spec)
+			if tt.wantErr && err == nil {
+				t.Errorf("NewParamGenerator(%q) succeeded, want an error", tt.spec)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("NewParamGenerator(%q): %v", tt.spec, err)
+			}
+		})
+	}
+}
+
+func TestIntRangeGenerator(t *testing.T) {
+	tests := []struct {
+		min, max int64
+	}{
+		{min: 1, max: 1},
+		{min: -5, max: 5},
+		{min: 0, max: math.MaxInt64},
+		{min: math.MinInt64, max: 0},
+		{min: math.MinInt64, max: math.MaxInt64},
+		{min: -1, max: math.MaxInt64},
+		{min: math.MinInt64 + 1, max: math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		g := &intRangeGenerator{min: tt.min, max: tt.max}
+		for i := 0; i < 1000; i++ {
+			v := g.Next(&ParamContext{}).(int64)
+			if v < tt.min || v > tt.max {
+				t.Fatalf("int(%d, %d) returned %d", tt.min, tt.max, v)
+			}
+		}
+	}
+}
+
+func TestTimestampGenerator(t *testing.T) {
+	g, err := NewParamGenerator("timestamp(-1h, 1h)")
+	if err != nil {
+		t.Fatalf("NewParamGenerator: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		before := time.Now()
+		v := g.Next(&ParamContext{}).(time.Time)
+		if v.Before(before.Add(-time.Hour)) || v.After(time.Now().Add(time.Hour)) {
+			t.Fatalf("timestamp(-1h, 1h) returned %v, %v from now", v, v.Sub(before))
+		}
+	}
+
+	// The window is wider than int64 nanoseconds
+	wide := &timestampGenerator{min: math.MinInt64, max: math.MaxInt64}
+	for i := 0; i < 100; i++ {
+		wide.Next(&ParamContext{})
+	}
+}
+
+func TestSequenceGenerator(t *testing.T) {
+	g, err := NewParamGenerator("sequence(10, 5)")
+	if err != nil {
+		t.Fatalf("NewParamGenerator: %v", err)
+	}
+	for _, want := range []int64{10, 15, 20} {
+		if got := g.Next(&ParamContext{}); got != want {
+			t.Errorf("sequence returned %v, want %d", got, want)
+		}
+	}
+}
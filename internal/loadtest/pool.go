@@ -0,0 +1,202 @@
+package loadtest
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Per-worker pool settings, small so hundreds of workers do not exhaust the
+// server's connection limit
+const (
+	workerMaxOpenConns    = 3
+	workerMaxIdleConns    = 1
+	workerConnMaxLifetime = 5 * time.Minute
+	workerConnMaxIdleTime = 1 * time.Minute
+)
+
+// perWorkerPoolName is the name the per-worker pools are reported under
+const perWorkerPoolName = "per_worker"
+
+// poolSet hands out database managers to workers according to the pool
+// strategy: a new sql.DB per worker, one shared sql.DB, or a fixed number of
+// sql.DB that workers are assigned to by ID
+type poolSet struct {
+	strategy string
+	cfg      config.DatabaseConfig
+
+	mu sync.Mutex
+
+	// Shared and sharded pools, opened up front
+	shards []*database.Manager
+
+	// Per-worker pools currently in use, and the cumulative counters of the
+	// ones already released
+	workers map[*database.Manager]bool
+	retired sql.DBStats
+}
+
+// newPoolSet opens the shared pools of the configured strategy. Per-worker
+// pools are opened when workers acquire them.
+func newPoolSet(cfg *config.DatabaseConfig) (*poolSet, error) {
+	set := &poolSet{
+		strategy: cfg.PoolStrategy,
+		cfg:      *cfg,
+		workers:  make(map[*database.Manager]bool),
+	}
+	if set.strategy == "" {
+		set.strategy = config.PoolStrategyPerWorker
+	}
+
+	count := 0
+	switch set.strategy {
+	case config.PoolStrategyShared:
+		count = 1
+	case config.PoolStrategySharded:
+		count = cfg.PoolShards
+	default:
+		return set, nil
+	}
+
+	// Split the configured limits so the pools together stay within them
+	for i := 0; i < count; i++ {
+		shardConfig := *cfg
+		shardConfig.MaxOpenConns = shareOf(cfg.MaxOpenConns, count, i)
+		shardConfig.MaxIdleConns = shareOf(cfg.MaxIdleConns, count, i)
+
+		manager, err := database.NewManager(&shardConfig)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("failed to open connection pool %d: %w", i, err)
+		}
+		set.shards = append(set.shards, manager)
+	}
+
+	logrus.Infof("Opened %d %s connection pool(s) sharing max %d open, %d idle connections",
+		count, set.strategy, cfg.MaxOpenConns, cfg.MaxIdleConns)
+	return set, nil
+}
+
+// acquire returns the database manager of a worker
+func (p *poolSet) acquire(workerID int) (*database.Manager, error) {
+	p.mu.Lock()
+	shards := p.shards
+	p.mu.Unlock()
+	if len(shards) > 0 {
+		return shards[workerID%len(shards)], nil
+	}
+
+	dbConfig := p.cfg
+	dbConfig.MaxOpenConns = workerMaxOpenConns
+	dbConfig.MaxIdleConns = workerMaxIdleConns
+	dbConfig.ConnMaxLifetime = workerConnMaxLifetime
+	dbConfig.ConnMaxIdleTime = workerConnMaxIdleTime
+
+	manager, err := database.NewManager(&dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.workers[manager] = true
+	p.mu.Unlock()
+	return manager, nil
+}
+
+// release returns a worker's database manager, closing per-worker pools
+func (p *poolSet) release(manager *database.Manager) {
+	p.mu.Lock()
+	if !p.workers[manager] {
+		// Shared pools stay open until the set is closed
+		p.mu.Unlock()
+		return
+	}
+	delete(p.workers, manager)
+	addCounters(&p.retired, manager.GetStats())
+	p.mu.Unlock()
+
+	manager.Close()
+}
+
+// stats returns the aggregated statistics of every pool
+func (p *poolSet) stats() []metrics.PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.shards) > 0 {
+		pools := make([]metrics.PoolStats, len(p.shards))
+		for i, shard := range p.shards {
+			name := p.strategy
+			if p.strategy == config.PoolStrategySharded {
+				name = fmt.Sprintf("shard-%d", i)
+			}
+			pools[i] = newPoolStats(name, 1, shard.GetStats())
+		}
+		return pools
+	}
+
+	total := p.retired
+	for manager := range p.workers {
+		stats := manager.GetStats()
+		total.MaxOpenConnections += stats.MaxOpenConnections
+		total.OpenConnections += stats.OpenConnections
+		total.InUse += stats.InUse
+		total.Idle += stats.Idle
+		addCounters(&total, stats)
+	}
+	return []metrics.PoolStats{newPoolStats(perWorkerPoolName, len(p.workers), total)}
+}
+
+// close closes the shared pools
+func (p *poolSet) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, shard := range p.shards {
+		shard.Close()
+	}
+	p.shards = nil
+}
+
+// shareOf returns the part of total that pool i of count gets: an even
+// split, with the remainder going to the first pools
+func shareOf(total, count, i int) int {
+	share := total / count
+	if i < total%count {
+		share++
+	}
+	return share
+}
+
+// addCounters adds the cumulative counters of stats to total
+func addCounters(total *sql.DBStats, stats sql.DBStats) {
+	total.WaitCount += stats.WaitCount
+	total.WaitDuration += stats.WaitDuration
+	total.MaxIdleClosed += stats.MaxIdleClosed
+	total.MaxIdleTimeClosed += stats.MaxIdleTimeClosed
+	total.MaxLifetimeClosed += stats.MaxLifetimeClosed
+}
+
+// newPoolStats converts sql.DBStats to reported pool statistics
+func newPoolStats(name string, databases int, stats sql.DBStats) metrics.PoolStats {
+	return metrics.PoolStats{
+		Name:               name,
+		Databases:          databases,
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration,
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,173 @@
+package loadtest
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+)
+
+// testDatabaseConfig returns the settings of a new SQLite database
+func testDatabaseConfig(t *testing.T) config.DatabaseConfig {
+	t.Helper()
+	return config.DatabaseConfig{
+		Type:     "sqlite",
+		Database: filepath.Join(t.TempDir(), "test.db"),
+	}
+}
+
+func TestShareOf(t *testing.T) {
+	tests := []struct {
+		total, count int
+		want         []int
+	}{
+		{total: 10, count: 3, want: []int{4, 3, 3}},
+		{total: 12, count: 4, want: []int{3, 3, 3, 3}},
+		{total: 5, count: 5, want: []int{1, 1, 1, 1, 1}},
+		{total: 3, count: 5, want: []int{1, 1, 1, 0, 0}},
+		{total: 0, count: 2, want: []int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		sum := 0
+		for i, want := range tt.want {
+			got := shareOf(tt.total, tt.count, i)
+			if got != want {
+				t.Errorf("shareOf(%d, %d, %d) = %d, want %d", tt.total, tt.count, i, got, want)
+			}
+			sum += got
+		}
+		if sum != tt.total {
+			t.Errorf("shares of %d over %d pools add up to %d", tt.total, tt.count, sum)
+		}
+	}
+}
+
+func TestPoolSetSharded(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	cfg.PoolStrategy = config.PoolStrategySharded
+	cfg.PoolShards = 3
+	cfg.MaxOpenConns = 10
+	cfg.MaxIdleConns = 5
+
+	set, err := newPoolSet(&cfg)
+	if err != nil {
+		t.Fatalf("newPoolSet: %v", err)
+	}
+	defer set.close()
+
+	stats := set.stats()
+	if len(stats) != 3 {
+		t.Fatalf("sharded pool set has %d pools, want 3", len(stats))
+	}
+	total := 0
+	for i, pool := range stats {
+		total += pool.MaxOpenConnections
+		if want := []int{4, 3, 3}[i]; pool.MaxOpenConnections != want {
+			t.Errorf("pool %s max open = %d, want %d", pool.Name, pool.MaxOpenConnections, want)
+		}
+	}
+	if total != cfg.MaxOpenConns {
+		t.Errorf("shards hold %d connections together, want max_open_conns %d", total, cfg.MaxOpenConns)
+	}
+
+	// Workers are assigned by ID and keep their shard open on release
+	first, err := set.acquire(1)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	again, err := set.acquire(4)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if first != again || first != set.shards[1] {
+		t.Error("workers 1 and 4 did not share shard 1")
+	}
+	set.release(first)
+	if err := first.HealthCheck(context.Background()); err != nil {
+		t.Errorf("released shard was closed: %v", err)
+	}
+}
+
+func TestPoolSetShared(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	cfg.PoolStrategy = config.PoolStrategyShared
+	cfg.MaxOpenConns = 7
+
+	set, err := newPoolSet(&cfg)
+	if err != nil {
+		t.Fatalf("newPoolSet: %v", err)
+	}
+	defer set.close()
+
+	a, _ := set.acquire(0)
+	b, _ := set.acquire(1)
+	if a != b {
+		t.Error("shared pool set handed out different pools")
+	}
+	if stats := set.stats(); len(stats) != 1 || stats[0].MaxOpenConnections != 7 {
+		t.Errorf("shared pool stats = %+v, want one pool of 7 connections", stats)
+	}
+}
+
+func TestPoolSetPerWorker(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+
+	set, err := newPoolSet(&cfg)
+	if err != nil {
+		t.Fatalf("newPoolSet: %v", err)
+	}
+	defer set.close()
+
+	a, err := set.acquire(0)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	b, err := set.acquire(1)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if a == b {
+		t.Fatal("per-worker pool set handed out one pool twice")
+	}
+
+	stats := set.stats()
+	if len(stats) != 1 || stats[0].Name != perWorkerPoolName || stats[0].Databases != 2 ||
+		stats[0].MaxOpenConnections != 2*workerMaxOpenConns {
+		t.Errorf("per-worker stats = %+v, want 2 databases of %d connections", stats, workerMaxOpenConns)
+	}
+
+	// Released pools are closed and no longer counted
+	set.release(a)
+	if err := a.HealthCheck(context.Background()); err == nil {
+		t.Error("released per-worker pool is still open")
+	}
+	if stats := set.stats(); stats[0].Databases != 1 {
+		t.Errorf("per-worker pool set counts %d databases after a release, want 1", stats[0].Databases)
+	}
+	set.release(b)
+}
+
+func TestPoolShardsValidation(t *testing.T) {
+	doc := `
+database:
+  type: sqlite
+  database: test.db
+  max_open_conns: 5
+  pool_strategy: sharded
+  pool_shards: SHARDS
+test:
+  queries:
+    - name: one
+      sql: SELECT 1
+      weight: 1
+`
+	if _, err := config.Parse([]byte(strings.Replace(doc, "SHARDS", "5", 1))); err != nil {
+		t.Errorf("5 shards of 5 connections: %v", err)
+	}
+	if _, err := config.Parse([]byte(strings.Replace(doc, "SHARDS", "8", 1))); err == nil {
+		t.Error("8 shards of 5 connections passed validation")
+	}
+}
@@ -0,0 +1,127 @@
+package loadtest
+
+import (
+	"math/rand"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultRetryBackoff is the delay before the first retry if none is set
+	defaultRetryBackoff = 100 * time.Millisecond
+	// defaultRetryMaxBackoff caps the delay if no limit is set
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// retryPolicy decides whether and when a failed execution is retried
+type retryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	classes     map[string]bool
+}
+
+// newRetryPolicy returns the retry policy of a query or scenario, which
+// replaces the test's default policy if set. It returns nil if failed
+// executions are not retried.
+func newRetryPolicy(test config.RetryConfig, override *config.RetryConfig) *retryPolicy {
+	cfg := test
+	if override != nil {
+		cfg = *override
+	}
+	if cfg.MaxAttempts <= 1 {
+		return nil
+	}
+
+	policy := &retryPolicy{
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.Backoff,
+		maxBackoff:  cfg.MaxBackoff,
+		classes:     make(map[string]bool),
+	}
+	if policy.backoff <= 0 {
+		policy.backoff = defaultRetryBackoff
+	}
+	if policy.maxBackoff <= 0 {
+		policy.maxBackoff = defaultRetryMaxBackoff
+	}
+	if policy.maxBackoff < policy.backoff {
+		policy.maxBackoff = policy.backoff
+	}
+
+	classes := cfg.On
+	if len(classes) == 0 {
+		classes = database.TransientErrorClasses
+	}
+	for _, class := range classes {
+		policy.classes[class] = true
+	}
+	return policy
+}
+
+// retries reports whether a failure of the given class is retried after the
+// given number of attempts
+func (p *retryPolicy) retries(class string, attempts int) bool {
+	return p != nil && attempts < p.maxAttempts && p.classes[class]
+}
+
+// delay returns the wait before the given retry: the backoff doubled for
+// every earlier retry, capped at the maximum, with ±20% jitter so workers
+// that failed together do not retry in lockstep
+func (p *retryPolicy) delay(retry int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < retry && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+
+	randomFactor := 0.8 + rand.Float64()*0.4
+	return time.Duration(float64(delay) * randomFactor)
+}
+
+// withRetry runs attempt until it succeeds or the policy gives up, waiting
+// between attempts. The class of every retried failure is recorded in the
+// result; only the outcome of the last attempt counts as success or error.
+func (w *Worker) withRetry(policy *retryPolicy, result *metrics.QueryResult, attempt func()) {
+	for attempts := 1; ; attempts++ {
+		attempt()
+		if result.Success || !policy.retries(result.ErrorClass, attempts) {
+			return
+		}
+
+		delay := policy.delay(attempts)
+		logrus.Debugf("Worker %d: Retrying %s in %v after %s error (attempt %d of %d)",
+			w.id, result.QueryName, delay, result.ErrorClass, attempts+1, policy.maxAttempts)
+
+		if !w.sleep(delay) {
+			return
+		}
+		result.Retries = append(result.Retries, result.ErrorClass)
+		result.Error = ""
+		result.ErrorClass = ""
+		result.Canceled = false
+	}
+}
+
+// sleep waits for the given duration and reports false if the worker was
+// stopped in the meantime
+func (w *Worker) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.ctx.Done():
+		return false
+	case <-w.stopChan:
+		return false
+	}
+}
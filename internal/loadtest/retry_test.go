@@ -0,0 +1,226 @@
+package loadtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	if policy := newRetryPolicy(config.RetryConfig{MaxAttempts: 1}, nil); policy != nil {
+		t.Errorf("policy with one attempt = %+v, want nil", policy)
+	}
+	if policy := newRetryPolicy(config.RetryConfig{MaxAttempts: 3}, &config.RetryConfig{}); policy != nil {
+		t.Errorf("policy overridden without attempts = %+v, want nil", policy)
+	}
+
+	policy := newRetryPolicy(config.RetryConfig{MaxAttempts: 3}, nil)
+	if policy.backoff != defaultRetryBackoff || policy.maxBackoff != defaultRetryMaxBackoff {
+		t.Errorf("backoff = %v up to %v, want the defaults", policy.backoff, policy.maxBackoff)
+	}
+	for _, class := range database.TransientErrorClasses {
+		if !policy.classes[class] {
+			t.Errorf("transient class %s is not retried by default", class)
+		}
+	}
+
+	policy = newRetryPolicy(config.RetryConfig{MaxAttempts: 3}, &config.RetryConfig{
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Millisecond,
+		On:          []string{database.ErrorClassTimeout},
+	})
+	if policy.maxAttempts != 5 {
+		t.Errorf("maxAttempts = %d, want the override's 5", policy.maxAttempts)
+	}
+	if policy.maxBackoff != time.Second {
+		t.Errorf("maxBackoff = %v, want it raised to the backoff", policy.maxBackoff)
+	}
+	if want := map[string]bool{database.ErrorClassTimeout: true}; !reflect.DeepEqual(policy.classes, want) {
+		t.Errorf("classes = %v, want %v", policy.classes, want)
+	}
+}
+
+func TestRetryPolicyRetries(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{MaxAttempts: 3}, nil)
+
+	tests := []struct {
+		class    string
+		attempts int
+		want     bool
+	}{
+		{class: database.ErrorClassDeadlock, attempts: 1, want: true},
+		{class: database.ErrorClassSerialization, attempts: 2, want: true},
+		{class: database.ErrorClassDeadlock, attempts: 3, want: false},
+		{class: database.ErrorClassConstraint, attempts: 1, want: false},
+		{class: database.ErrorClassSyntax, attempts: 1, want: false},
+		{class: database.ErrorClassCanceled, attempts: 1, want: false},
+	}
+	for _, tt := range tests {
+		if got := policy.retries(tt.class, tt.attempts); got != tt.want {
+			t.Errorf("retries(%s, %d) = %v, want %v", tt.class, tt.attempts, got, tt.want)
+		}
+	}
+
+	var disabled *retryPolicy
+	if disabled.retries(database.ErrorClassDeadlock, 1) {
+		t.Error("nil policy retries")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{
+		MaxAttempts: 10,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  time.Second,
+	}, nil)
+
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: 100 * time.Millisecond},
+		{retry: 2, want: 200 * time.Millisecond},
+		{retry: 3, want: 400 * time.Millisecond},
+		{retry: 4, want: 800 * time.Millisecond},
+		{retry: 5, want: time.Second},
+		{retry: 9, want: time.Second},
+	}
+	for _, tt := range tests {
+		// The jitter spreads delays over ±20% of the backoff
+		low, high := tt.want*8/10, tt.want*12/10
+		distinct := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			delay := policy.delay(tt.retry)
+			if delay < low || delay > high {
+				t.Fatalf("delay(%d) = %v, want between %v and %v", tt.retry, delay, low, high)
+			}
+			distinct[delay] = true
+		}
+		if len(distinct) < 2 {
+			t.Errorf("delay(%d) is always %v, want jitter", tt.retry, tt.want)
+		}
+	}
+}
+
+// newRetryWorker returns a worker that is only able to wait between retries
+func newRetryWorker(ctx context.Context) *Worker {
+	return &Worker{ctx: ctx, stopChan: make(chan struct{})}
+}
+
+func TestWithRetry(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+	}, nil)
+
+	tests := []struct {
+		name        string
+		policy      *retryPolicy
+		classes     []string // Error class of each attempt, empty for success
+		wantCalls   int
+		wantRetries []string
+		wantSuccess bool
+		wantClass   string
+	}{
+		{
+			name:        "success",
+			policy:      policy,
+			classes:     []string{""},
+			wantCalls:   1,
+			wantSuccess: true,
+		},
+		{
+			name:        "success after retries",
+			policy:      policy,
+			classes:     []string{database.ErrorClassDeadlock, database.ErrorClassConnection, ""},
+			wantCalls:   3,
+			wantRetries: []string{database.ErrorClassDeadlock, database.ErrorClassConnection},
+			wantSuccess: true,
+		},
+		{
+			name:        "attempts exhausted",
+			policy:      policy,
+			classes:     []string{database.ErrorClassDeadlock, database.ErrorClassDeadlock, database.ErrorClassDeadlock, ""},
+			wantCalls:   3,
+			wantRetries: []string{database.ErrorClassDeadlock, database.ErrorClassDeadlock},
+			wantClass:   database.ErrorClassDeadlock,
+		},
+		{
+			name:      "non-retryable class",
+			policy:    policy,
+			classes:   []string{database.ErrorClassConstraint, ""},
+			wantCalls: 1,
+			wantClass: database.ErrorClassConstraint,
+		},
+		{
+			name:        "non-retryable after retry",
+			policy:      policy,
+			classes:     []string{database.ErrorClassSerialization, database.ErrorClassSyntax, ""},
+			wantCalls:   2,
+			wantRetries: []string{database.ErrorClassSerialization},
+			wantClass:   database.ErrorClassSyntax,
+		},
+		{
+			name:      "no policy",
+			classes:   []string{database.ErrorClassDeadlock, ""},
+			wantCalls: 1,
+			wantClass: database.ErrorClassDeadlock,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRetryWorker(context.Background())
+			result := &metrics.QueryResult{QueryName: "q"}
+			calls := 0
+			w.withRetry(tt.policy, result, func() {
+				class := tt.classes[calls]
+				calls++
+				result.Success = class == ""
+				result.ErrorClass = class
+				if class != "" {
+					result.Error = class + " error"
+				}
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("attempts = %d, want %d", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(result.Retries, tt.wantRetries) {
+				t.Errorf("Retries = %v, want %v", result.Retries, tt.wantRetries)
+			}
+			if result.Success != tt.wantSuccess || result.ErrorClass != tt.wantClass {
+				t.Errorf("outcome = success %v class %q, want success %v class %q",
+					result.Success, result.ErrorClass, tt.wantSuccess, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopped(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{MaxAttempts: 3, Backoff: time.Hour}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := newRetryWorker(ctx)
+
+	result := &metrics.QueryResult{QueryName: "q"}
+	calls := 0
+	w.withRetry(policy, result, func() {
+		calls++
+		result.ErrorClass = database.ErrorClassDeadlock
+	})
+
+	// The stopped worker does not wait out the backoff or retry
+	if calls != 1 || len(result.Retries) != 0 || result.ErrorClass != database.ErrorClassDeadlock {
+		t.Errorf("attempts = %d, retries = %v, class = %q; want one deadlock attempt",
+			calls, result.Retries, result.ErrorClass)
+	}
+}
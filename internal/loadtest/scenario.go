@@ -0,0 +1,270 @@
+package loadtest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scenarioQueryType is the query type label of transactional scenarios
+const scenarioQueryType = "transaction"
+
+// preparedScenario is a transactional scenario adapted to the worker's dialect
+type preparedScenario struct {
+	config.ScenarioConfig
+	isolation sql.IsolationLevel
+	steps     []preparedStep
+	retry     *retryPolicy // Nil if failed transactions are not retried
+}
+
+// preparedStep is one statement of a prepared scenario
+type preparedStep struct {
+	preparedQuery
+	isolationSQL string // Changes the isolation level before the step
+}
+
+// prepareScenario adapts the steps of a scenario to the dialect. Variables
+// captured by a step can be referenced as :name by the steps after it, in
+// addition to the worker variables captured by queries.
+func prepareScenario(dialect *database.Dialect, scenario config.ScenarioConfig, params *ParamSet, vars map[string]bool) (preparedScenario, error) {
+	isolation, err := database.IsolationLevel(scenario.Isolation)
+	if err != nil {
+		return preparedScenario{}, fmt.Errorf("scenario %s: %w", scenario.Name, err)
+	}
+
+	prepared := preparedScenario{
+		ScenarioConfig: scenario,
+		isolation:      isolation,
+		steps:          make([]preparedStep, len(scenario.Steps)),
+	}
+
+	for i, step := range scenario.Steps {
+		query := prepareQuery(dialect, StepKey(scenario.Name, step.Name), step.QueryConfig, params, vars)
+		prepared.steps[i] = preparedStep{preparedQuery: query}
+
+		if step.Isolation != "" {
+			statement, err := dialect.SetIsolationStatement(step.Isolation)
+			if err != nil {
+				return preparedScenario{}, fmt.Errorf("scenario %s: step %s: %w", scenario.Name, step.Name, err)
+			}
+			prepared.steps[i].isolationSQL = statement
+		}
+
+		if len(query.capture) > 0 {
+			// Later steps see the variables captured so far
+			next := make(map[string]bool, len(vars)+len(query.capture))
+			for name := range vars {
+				next[name] = true
+			}
+			for name := range query.capture {
+				next[name] = true
+			}
+			vars = next
+		}
+	}
+
+	return prepared, nil
+}
+
+// rollsBack decides whether an iteration ends with a rollback
+func (s *preparedScenario) rollsBack() bool {
+	if s.End == config.ScenarioEndRollback {
+		return true
+	}
+	return s.RollbackProbability > 0 && rand.Float64() < s.RollbackProbability
+}
+
+// executeScenario runs all steps of a scenario in one transaction. The
+// transaction is timed as a whole, including retries, and every step is
+// timed separately.
+func (w *Worker) executeScenario(scenario *preparedScenario) {
+	start := time.Now()
+	result := metrics.QueryResult{
+		QueryName: scenario.Name,
+		QueryType: scenarioQueryType,
+		Timestamp: start,
+	}
+
+	logrus.Debugf("Worker %d: Executing scenario: %s", w.id, scenario.Name)
+
+	defer func() {
+		result.Duration = time.Since(start)
+		w.metrics.RecordQuery(result)
+		logrus.Debugf("Worker %d: Scenario %s completed in %v", w.id, scenario.Name, result.Duration)
+	}()
+
+	w.withRetry(scenario.retry, &result, func() {
+		w.attemptScenario(scenario, &result)
+	})
+}
+
+// attemptScenario runs one attempt of a scenario's transaction
+func (w *Worker) attemptScenario(scenario *preparedScenario, result *metrics.QueryResult) {
+	// Only the steps of the last attempt are reported
+	result.RowsAffected = 0
+	result.Steps = result.Steps[:0]
+
+	ctx, cancel := context.WithTimeout(w.ctx, w.dbManager.QueryTimeout()*time.Duration(len(scenario.steps)))
+	defer cancel()
+
+	tx, err := w.dbManager.BeginTransaction(ctx, scenario.isolation)
+	if err != nil {
+		result.Outcome = metrics.OutcomeError
+		w.failQuery(result, fmt.Errorf("begin transaction: %w", err))
+		return
+	}
+
+	for i := range scenario.steps {
+		step := &scenario.steps[i]
+
+		stepStart := time.Now()
+		rows, err := w.executeStep(ctx, tx, step)
+		result.Steps = append(result.Steps, metrics.StepResult{
+			Name:     step.Name,
+			Success:  err == nil,
+			Duration: time.Since(stepStart),
+		})
+
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+				logrus.Debugf("Worker %d: Rollback of scenario %s failed: %v", w.id, scenario.Name, rollbackErr)
+			}
+			result.Outcome = metrics.OutcomeError
+			w.failQuery(result, fmt.Errorf("step %s: %w", step.Name, err))
+			logrus.Debugf("Worker %d: Scenario %s failed at step %s: %v", w.id, scenario.Name, step.Name, err)
+			w.logError(scenario.Name, step.SQL, err.Error(), time.Now())
+			return
+		}
+		result.RowsAffected += rows
+	}
+
+	if scenario.rollsBack() {
+		result.Outcome = metrics.OutcomeRollback
+		err = tx.Rollback()
+	} else {
+		result.Outcome = metrics.OutcomeCommit
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.failQuery(result, fmt.Errorf("%s: %w", result.Outcome, err))
+		result.Outcome = metrics.OutcomeError
+		return
+	}
+
+	result.Success = true
+}
+
+// executeStep runs one scenario step inside the transaction and returns the
+// number of rows read or affected
+func (w *Worker) executeStep(ctx context.Context, tx *sql.Tx, step *preparedStep) (int64, error) {
+	if step.isolationSQL != "" {
+		if _, err := tx.ExecContext(ctx, step.isolationSQL); err != nil {
+			return 0, fmt.Errorf("set isolation level: %w", err)
+		}
+	}
+
+	args, err := step.args(w.paramCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	if !step.returnsRows() {
+		res, err := tx.ExecContext(ctx, step.SQL, args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+
+	rows, err := tx.QueryContext(ctx, step.SQL, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	return scanRows(rows, &step.preparedQuery, w.paramCtx.Vars)
+}
+
+// scanRows counts the rows of a result and stores the captured columns of the
+// first or a random row into vars. A random row is chosen by reservoir
+// sampling so only the rows that replace the current pick are scanned.
+func scanRows(rows *sql.Rows, query *preparedQuery, vars map[string]interface{}) (int64, error) {
+	var count int64
+
+	if len(query.capture) == 0 {
+		for rows.Next() {
+			count++
+			if count > 1000 { // Limit to prevent memory issues
+				break
+			}
+		}
+		return count, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	indexes := make(map[string]int, len(query.capture))
+	for name, column := range query.capture {
+		index := -1
+		for i, c := range columns {
+			if strings.EqualFold(c, column) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return 0, fmt.Errorf("capture: result has no column %s", column)
+		}
+		indexes[name] = index
+	}
+
+	random := query.randomRow()
+	var picked []interface{}
+	for rows.Next() {
+		count++
+		if count > 1000 { // Limit to prevent memory issues
+			break
+		}
+		if picked != nil && (!random || rand.Int63n(count) != 0) {
+			continue
+		}
+
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return 0, fmt.Errorf("capture: %w", err)
+		}
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				// Drivers reuse byte buffers between rows
+				values[i] = string(b)
+			}
+		}
+		picked = values
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if picked == nil {
+		return 0, fmt.Errorf("capture: query returned no rows")
+	}
+
+	for name, index := range indexes {
+		vars[name] = picked[index]
+	}
+	return count, nil
+}
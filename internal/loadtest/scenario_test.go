@@ -0,0 +1,280 @@
+package loadtest
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// newScenarioWorker returns a worker running the scenarios of a test on a new
+// SQLite database prepared by the setup statements
+func newScenarioWorker(t *testing.T, scenarios string, setup ...string) (*Worker, *metrics.Collector) {
+	t.Helper()
+	return newTestWorker(t, "  scenarios:\n"+scenarios, setup...)
+}
+
+// newTestWorker returns a worker running a test, given as the YAML of its
+// settings, on a new SQLite database prepared by the setup statements
+func newTestWorker(t *testing.T, test string, setup ...string) (*Worker, *metrics.Collector) {
+	t.Helper()
+
+	inTempDir(t)
+	dir := t.TempDir()
+
+	doc := "database:\n  type: sqlite\n  database: " + filepath.Join(dir, "test.db") +
+		"\n  max_open_conns: 1\ntest:\n" + test
+	cfg, err := config.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("config.Parse: %v", err)
+	}
+
+	params, err := NewParamSet(&cfg.Test)
+	if err != nil {
+		t.Fatalf("NewParamSet: %v", err)
+	}
+	pools, err := newPoolSet(&cfg.Database)
+	if err != nil {
+		t.Fatalf("newPoolSet: %v", err)
+	}
+	t.Cleanup(pools.close)
+
+	collector := metrics.NewCollector(nil)
+	w, err := NewWorker(0, cfg, collector, params, pools)
+	if err != nil {
+		t.Fatalf("NewWorker: %v", err)
+	}
+	t.Cleanup(w.Stop)
+
+	for _, statement := range setup {
+		if _, err := w.dbManager.ExecuteExec(context.Background(), statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	return w, collector
+}
+
+// countRows returns the number of rows in a table of the worker's database
+func countRows(t *testing.T, w *Worker, table string) int {
+	t.Helper()
+	var count int
+	if err := w.dbManager.ExecuteQueryRow(context.Background(), "SELECT COUNT(*) FROM "+table, nil, &count); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return count
+}
+
+func TestScenarioOutcomes(t *testing.T) {
+	tests := []struct {
+		name          string
+		end           string
+		wantRows      int
+		wantCommits   int64
+		wantRollbacks int64
+	}{
+		{name: "commit", end: "commit", wantRows: 2, wantCommits: 1},
+		{name: "rollback", end: "rollback", wantRows: 0, wantRollbacks: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, collector := newScenarioWorker(t, `
+    - name: order
+      weight: 1
+      end: `+tt.end+`
+      steps:
+        - name: first
+          type: insert
+          sql: INSERT INTO orders (id) VALUES (1)
+        - name: second
+          type: insert
+          sql: INSERT INTO orders (id) VALUES (2)
+`, "CREATE TABLE orders (id INTEGER PRIMARY KEY)")
+
+			w.executeScenario(&w.scenarios[0])
+
+			if got := countRows(t, w, "orders"); got != tt.wantRows {
+				t.Errorf("orders has %d rows, want %d", got, tt.wantRows)
+			}
+			summary := collector.QuerySummaries()["order"]
+			if summary.SuccessfulQueries != 1 || summary.Commits != tt.wantCommits || summary.Rollbacks != tt.wantRollbacks {
+				t.Errorf("summary = %d successful, %d commits, %d rollbacks; want 1, %d, %d",
+					summary.SuccessfulQueries, summary.Commits, summary.Rollbacks, tt.wantCommits, tt.wantRollbacks)
+			}
+			if len(summary.Steps) != 2 {
+				t.Errorf("summary has %d steps, want 2", len(summary.Steps))
+			}
+		})
+	}
+}
+
+func TestScenarioFailedStepRollsBack(t *testing.T) {
+	w, collector := newScenarioWorker(t, `
+    - name: order
+      weight: 1
+      steps:
+        - name: insert
+          type: insert
+          sql: INSERT INTO orders (id) VALUES (2)
+        - name: duplicate
+          type: insert
+          sql: INSERT INTO orders (id) VALUES (1)
+        - name: never
+          type: insert
+          sql: INSERT INTO orders (id) VALUES (3)
+`, "CREATE TABLE orders (id INTEGER PRIMARY KEY)", "INSERT INTO orders (id) VALUES (1)")
+
+	w.executeScenario(&w.scenarios[0])
+
+	if got := countRows(t, w, "orders"); got != 1 {
+		t.Errorf("orders has %d rows, want the insert before the failed step rolled back", got)
+	}
+	summary := collector.QuerySummaries()["order"]
+	if summary.FailedQueries != 1 || summary.ErrorClasses[database.ErrorClassConstraint] != 1 {
+		t.Errorf("summary = %d failed with classes %v, want one constraint error", summary.FailedQueries, summary.ErrorClasses)
+	}
+	if _, ok := summary.Steps["never"]; ok {
+		t.Error("step after the failed step was executed")
+	}
+}
+
+func TestScenarioRetryRollsBack(t *testing.T) {
+	// The sequence gives every attempt a new ID, so the second attempt no
+	// longer collides with the existing row
+	w, collector := newScenarioWorker(t, `
+    - name: order
+      weight: 1
+      retry:
+        max_attempts: 3
+        backoff: 1ms
+        on: [constraint]
+      steps:
+        - name: audit
+          type: insert
+          sql: INSERT INTO audit (note) VALUES ('attempt')
+        - name: insert
+          type: insert
+          sql: INSERT INTO orders (id) VALUES (:id)
+          parameters:
+            id: sequence(1, 1)
+`, "CREATE TABLE orders (id INTEGER PRIMARY KEY)", "CREATE TABLE audit (note TEXT)", "INSERT INTO orders (id) VALUES (1)")
+
+	w.executeScenario(&w.scenarios[0])
+
+	if got := countRows(t, w, "audit"); got != 1 {
+		t.Errorf("audit has %d rows, want the first attempt's row rolled back", got)
+	}
+	if got := countRows(t, w, "orders"); got != 2 {
+		t.Errorf("orders has %d rows, want 2", got)
+	}
+
+	summary := collector.QuerySummaries()["order"]
+	if summary.SuccessfulQueries != 1 || summary.Commits != 1 || summary.Retries[database.ErrorClassConstraint] != 1 {
+		t.Errorf("summary = %d successful, %d commits, retries %v; want one commit after one constraint retry",
+			summary.SuccessfulQueries, summary.Commits, summary.Retries)
+	}
+	// Only the steps of the last attempt are reported
+	for name, step := range summary.Steps {
+		if step.TotalQueries != 1 || step.FailedQueries != 0 {
+			t.Errorf("step %s = %d executions, %d failed; want one successful execution",
+				name, step.TotalQueries, step.FailedQueries)
+		}
+	}
+}
+
+func TestScenarioCapture(t *testing.T) {
+	w, collector := newScenarioWorker(t, `
+    - name: transfer
+      weight: 1
+      steps:
+        - name: pick
+          type: select
+          sql: SELECT id, balance FROM accounts WHERE id = 2
+          capture:
+            account: id
+            balance: balance
+        - name: debit
+          type: update
+          sql: UPDATE accounts SET balance = :balance - 10 WHERE id = :account
+`, "CREATE TABLE accounts (id INTEGER PRIMARY KEY, balance INTEGER)",
+		"INSERT INTO accounts (id, balance) VALUES (1, 100), (2, 50)")
+
+	w.executeScenario(&w.scenarios[0])
+
+	if summary := collector.QuerySummaries()["transfer"]; summary.SuccessfulQueries != 1 {
+		t.Fatalf("scenario failed: %v", summary.ErrorClasses)
+	}
+	var balance int
+	if err := w.dbManager.ExecuteQueryRow(context.Background(), "SELECT balance FROM accounts WHERE id = 2", nil, &balance); err != nil {
+		t.Fatal(err)
+	}
+	if balance != 40 {
+		t.Errorf("balance = %d, want 40 from the captured values", balance)
+	}
+	if got := w.paramCtx.Vars["account"]; got != int64(2) {
+		t.Errorf("captured account = %v (%T), want 2", got, got)
+	}
+}
+
+func TestScenarioIsolationStatement(t *testing.T) {
+	w, collector := newScenarioWorker(t, `
+    - name: read
+      weight: 1
+      steps:
+        - name: count
+          type: select
+          sql: SELECT COUNT(*) FROM orders
+`, "CREATE TABLE orders (id INTEGER PRIMARY KEY)")
+
+	// SQLite has no SET TRANSACTION, a pragma takes the statement's place
+	scenario := &w.scenarios[0]
+	scenario.steps[0].isolationSQL = "PRAGMA read_uncommitted = 1"
+	w.executeScenario(scenario)
+	if summary := collector.QuerySummaries()["read"]; summary.SuccessfulQueries != 1 {
+		t.Fatalf("scenario with isolation statement failed: %v", summary.ErrorClasses)
+	}
+
+	scenario.steps[0].isolationSQL = "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"
+	w.executeScenario(scenario)
+	if summary := collector.QuerySummaries()["read"]; summary.FailedQueries != 1 {
+		t.Errorf("scenario with invalid isolation statement did not fail: %+v", summary)
+	}
+}
+
+func TestPrepareScenarioIsolation(t *testing.T) {
+	scenario := config.ScenarioConfig{
+		Name:      "report",
+		Isolation: "snapshot",
+		Steps: []config.ScenarioStep{
+			{QueryConfig: config.QueryConfig{Name: "read", SQL: "SELECT 1"}},
+			{QueryConfig: config.QueryConfig{Name: "write", SQL: "UPDATE t SET x = 1"}, Isolation: "serializable"},
+		},
+	}
+	params := &ParamSet{}
+
+	sqlserver, _ := database.DialectFor("mssql")
+	prepared, err := prepareScenario(sqlserver, scenario, params, nil)
+	if err != nil {
+		t.Fatalf("prepareScenario: %v", err)
+	}
+	if prepared.steps[0].isolationSQL != "" {
+		t.Errorf("step without isolation sets %q", prepared.steps[0].isolationSQL)
+	}
+	if want := "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"; prepared.steps[1].isolationSQL != want {
+		t.Errorf("isolation statement = %q, want %q", prepared.steps[1].isolationSQL, want)
+	}
+
+	sqlite, _ := database.DialectFor("sqlite")
+	if _, err := prepareScenario(sqlite, scenario, params, nil); err == nil || !strings.Contains(err.Error(), "isolation") {
+		t.Errorf("step isolation on sqlite: err = %v, want an isolation error", err)
+	}
+
+	scenario.Isolation = "chaos"
+	if _, err := prepareScenario(sqlserver, scenario, params, nil); err == nil {
+		t.Error("unknown scenario isolation level was accepted")
+	}
+}
@@ -0,0 +1,427 @@
+package loadtest
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"sync"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Names the connection storm records its results and connections under
+const (
+	stormConnectName   = "connect"
+	stormHeartbeatName = "heartbeat"
+	stormPoolName      = "connection_storm"
+)
+
+const (
+	// defaultStormConnectTimeout limits connection attempts if none is set
+	defaultStormConnectTimeout = 30 * time.Second
+	// defaultStormHeartbeatInterval is the heartbeat delay if none is set
+	defaultStormHeartbeatInterval = 10 * time.Second
+)
+
+// connectionStorm opens physical connections at a fixed rate and holds them,
+// optionally running a heartbeat query on each and closing random ones at a
+// churn rate. It runs no query load, so connect latency and the point where
+// the server starts refusing connections are measured in isolation.
+type connectionStorm struct {
+	cfg          config.ConnectionStormConfig
+	db           *sql.DB
+	metrics      *metrics.Collector
+	queryTimeout time.Duration
+	wg           sync.WaitGroup
+
+	mu        sync.Mutex
+	held      []*heldConn
+	pending   int // Connection attempts in progress
+	attempts  int64
+	opened    int64
+	failed    int64
+	churned   int64
+	dropped   int64 // Held connections lost because a heartbeat failed
+	peak      int
+	refusedAt int    // Held connections at the first refused attempt, -1 if none
+	refusal   string // Error of the first refused attempt
+	stopped   bool   // No further connections are opened after a refusal
+}
+
+// heldConn is one physical connection held by the storm
+type heldConn struct {
+	conn   *sql.Conn
+	cancel context.CancelFunc // Stops the heartbeat
+}
+
+// StormSummary is the outcome of a connection storm
+type StormSummary struct {
+	Target    int    `json:"target"`
+	Open      int    `json:"open"`
+	Peak      int    `json:"peak"`
+	Pending   int    `json:"pending"`
+	Attempts  int64  `json:"attempts"`
+	Opened    int64  `json:"opened"`
+	Failed    int64  `json:"failed"`
+	Churned   int64  `json:"churned"`
+	Dropped   int64  `json:"dropped"`
+	RefusedAt *int   `json:"refused_at,omitempty"` // Open connections when the server first refused one
+	Refusal   string `json:"refusal,omitempty"`
+}
+
+// newConnectionStorm creates a connection storm against the database. The
+// pool keeps no idle connections, so every attempt dials and logs in anew.
+func newConnectionStorm(dbConfig *config.DatabaseConfig, cfg config.ConnectionStormConfig, collector *metrics.Collector) (*connectionStorm, error) {
+	stormConfig := *dbConfig
+	stormConfig.MaxOpenConns = 0
+	stormConfig.MaxIdleConns = 0
+	stormConfig.ConnMaxLifetime = 0
+	stormConfig.ConnMaxIdleTime = 0
+
+	db, err := database.OpenDB(&stormConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaultStormConnectTimeout
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultStormHeartbeatInterval
+	}
+	queryTimeout := dbConfig.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = 30 * time.Second
+	}
+
+	return &connectionStorm{
+		cfg:          cfg,
+		db:           db,
+		metrics:      collector,
+		queryTimeout: queryTimeout,
+		refusedAt:    -1,
+	}, nil
+}
+
+// start opens connections until the context is cancelled
+func (s *connectionStorm) start(ctx context.Context) {
+	logrus.Infof("Starting connection storm: %d connections at %.2f/s", s.cfg.Connections, s.cfg.Rate)
+
+	s.wg.Add(1)
+	go s.open(ctx)
+
+	if s.cfg.ChurnRate > 0 {
+		s.wg.Add(1)
+		go s.churn(ctx)
+	}
+}
+
+// open starts a connection attempt at every tick of the attempt rate while
+// fewer than the target connections are held or being opened
+func (s *connectionStorm) open(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(rateInterval(s.cfg.Rate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.stopped || len(s.held)+s.pending >= s.cfg.Connections {
+				s.mu.Unlock()
+				continue
+			}
+			s.pending++
+			s.mu.Unlock()
+
+			s.wg.Add(1)
+			go s.connect(ctx)
+		}
+	}
+}
+
+// connect opens and holds one physical connection
+func (s *connectionStorm) connect(ctx context.Context) {
+	defer s.wg.Done()
+
+	start := time.Now()
+	attemptCtx, cancel := context.WithTimeout(ctx, s.cfg.ConnectTimeout)
+	conn, err := s.db.Conn(attemptCtx)
+	cancel()
+
+	result := metrics.QueryResult{
+		QueryName: stormConnectName,
+		QueryType: stormConnectName,
+		Duration:  time.Since(start),
+		Timestamp: start,
+	}
+
+	if ctx.Err() != nil {
+		// The run ended while connecting, the attempt says nothing about the server
+		if conn != nil {
+			conn.Close()
+		}
+		s.mu.Lock()
+		s.pending--
+		s.mu.Unlock()
+		return
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorClass = database.ClassifyError(err)
+		s.refused(err)
+		s.metrics.RecordQuery(result)
+		return
+	}
+
+	held := &heldConn{conn: conn}
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	held.cancel = stopHeartbeat
+
+	s.mu.Lock()
+	s.pending--
+	s.attempts++
+	s.opened++
+	s.held = append(s.held, held)
+	if len(s.held) > s.peak {
+		s.peak = len(s.held)
+	}
+	s.mu.Unlock()
+
+	result.Success = true
+	s.metrics.RecordQuery(result)
+
+	if s.cfg.Heartbeat != "" {
+		s.wg.Add(1)
+		go s.heartbeat(heartbeatCtx, held)
+	}
+}
+
+// refused records a failed connection attempt and remembers how many
+// connections were held when the server refused the first one
+func (s *connectionStorm) refused(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending--
+	s.attempts++
+	s.failed++
+	if s.refusedAt >= 0 {
+		return
+	}
+
+	s.refusedAt = len(s.held)
+	s.refusal = err.Error()
+	logrus.Warnf("Server refused a connection with %d connections open: %v", s.refusedAt, err)
+	if s.cfg.StopOnRefusal {
+		s.stopped = true
+		logrus.Warnf("Connection storm stopped opening connections at %d", s.refusedAt)
+	}
+}
+
+// heartbeat runs the heartbeat query on a held connection until it is
+// closed. A connection whose heartbeat fails is dropped and reopened.
+func (s *connectionStorm) heartbeat(ctx context.Context, held *heldConn) {
+	defer s.wg.Done()
+
+	// Spread the heartbeats of connections opened in the same second
+	ticker := time.NewTicker(time.Duration(float64(s.cfg.HeartbeatInterval) * (0.8 + rand.Float64()*0.4)))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		queryCtx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+		rows, err := held.conn.QueryContext(queryCtx, s.cfg.Heartbeat)
+		if err == nil {
+			for rows.Next() {
+			}
+			err = rows.Err()
+			rows.Close()
+		}
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		result := metrics.QueryResult{
+			QueryName: stormHeartbeatName,
+			QueryType: stormHeartbeatName,
+			Success:   err == nil,
+			Duration:  time.Since(start),
+			Timestamp: start,
+		}
+		if err != nil {
+			result.Error = err.Error()
+			result.ErrorClass = database.ClassifyError(err)
+		}
+		s.metrics.RecordQuery(result)
+
+		if err != nil {
+			logrus.Debugf("Connection storm: heartbeat failed, dropping connection: %v", err)
+			if s.release(held) {
+				s.mu.Lock()
+				s.dropped++
+				s.mu.Unlock()
+			}
+			return
+		}
+	}
+}
+
+// churn closes a random held connection at every tick of the churn rate, the
+// open loop reopens it at the attempt rate
+func (s *connectionStorm) churn(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(rateInterval(s.cfg.ChurnRate))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.stopped || len(s.held) == 0 {
+				s.mu.Unlock()
+				continue
+			}
+			held := s.held[rand.Intn(len(s.held))]
+			s.mu.Unlock()
+
+			if s.release(held) {
+				s.mu.Lock()
+				s.churned++
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// release stops holding a connection and closes it. It reports false if the
+// connection was already released.
+func (s *connectionStorm) release(held *heldConn) bool {
+	s.mu.Lock()
+	found := false
+	for i, h := range s.held {
+		if h == held {
+			last := len(s.held) - 1
+			s.held[i] = s.held[last]
+			s.held = s.held[:last]
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		return false
+	}
+	held.cancel()
+	if err := held.conn.Close(); err != nil {
+		logrus.Debugf("Connection storm: failed to close connection: %v", err)
+	}
+	return true
+}
+
+// wait waits until the storm stopped after its context was cancelled
+func (s *connectionStorm) wait() {
+	s.wg.Wait()
+}
+
+// close closes all held connections
+func (s *connectionStorm) close() {
+	s.mu.Lock()
+	held := s.held
+	s.held = nil
+	s.mu.Unlock()
+
+	for _, h := range held {
+		h.cancel()
+		h.conn.Close()
+	}
+	if err := s.db.Close(); err != nil {
+		logrus.Errorf("Error closing connection storm database: %v", err)
+	}
+}
+
+// summary returns the current outcome of the storm
+func (s *connectionStorm) summary() StormSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary := StormSummary{
+		Target:   s.cfg.Connections,
+		Open:     len(s.held),
+		Peak:     s.peak,
+		Pending:  s.pending,
+		Attempts: s.attempts,
+		Opened:   s.opened,
+		Failed:   s.failed,
+		Churned:  s.churned,
+		Dropped:  s.dropped,
+		Refusal:  s.refusal,
+	}
+	if s.refusedAt >= 0 {
+		refusedAt := s.refusedAt
+		summary.RefusedAt = &refusedAt
+	}
+	return summary
+}
+
+// poolStats reports the held connections as a connection pool
+func (s *connectionStorm) poolStats() []metrics.PoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return []metrics.PoolStats{{
+		Name:               stormPoolName,
+		Databases:          1,
+		MaxOpenConnections: s.cfg.Connections,
+		OpenConnections:    len(s.held),
+		InUse:              len(s.held),
+	}}
+}
+
+// logSummary logs the outcome of the storm
+func (s *connectionStorm) logSummary() {
+	summary := s.summary()
+
+	logrus.Info("=== Connection Storm ===")
+	logrus.Infof("Target Connections: %d", summary.Target)
+	logrus.Infof("Open Connections: %d (peak %d)", summary.Open, summary.Peak)
+	logrus.Infof("Attempts: %d, Opened: %d, Failed: %d", summary.Attempts, summary.Opened, summary.Failed)
+	if summary.Churned > 0 || summary.Dropped > 0 {
+		logrus.Infof("Churned: %d, Dropped by heartbeat: %d", summary.Churned, summary.Dropped)
+	}
+	if summary.RefusedAt != nil {
+		logrus.Warnf("First refusal at %d open connections: %s", *summary.RefusedAt, summary.Refusal)
+	} else {
+		logrus.Info("No connection was refused")
+	}
+}
+
+// rateInterval returns the delay between events at the given rate per second
+func rateInterval(rate float64) time.Duration {
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval < time.Microsecond {
+		interval = time.Microsecond
+	}
+	return interval
+}
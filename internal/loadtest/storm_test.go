@@ -0,0 +1,170 @@
+package loadtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// limitedServer is a database driver that accepts at most max connections at
+// a time, like a server at its connection limit
+type limitedServer struct {
+	mu   sync.Mutex
+	max  int // 0 for no limit
+	open int
+}
+
+func (s *limitedServer) Connect(ctx context.Context) (driver.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && s.open >= s.max {
+		return nil, errors.New("too many connections")
+	}
+	s.open++
+	return &limitedConn{server: s}, nil
+}
+
+func (s *limitedServer) Driver() driver.Driver {
+	return limitedDriver{s}
+}
+
+// openConnections returns the connections the server holds open
+func (s *limitedServer) openConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open
+}
+
+type limitedDriver struct {
+	server *limitedServer
+}
+
+func (d limitedDriver) Open(string) (driver.Conn, error) {
+	return d.server.Connect(context.Background())
+}
+
+type limitedConn struct {
+	server *limitedServer
+}
+
+func (c *limitedConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func (c *limitedConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *limitedConn) Close() error {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	c.server.open--
+	return nil
+}
+
+// newTestStorm creates a connection storm against a limited server
+func newTestStorm(t *testing.T, cfg config.ConnectionStormConfig, server *limitedServer) (*connectionStorm, *metrics.Collector) {
+	t.Helper()
+	dbConfig := testDatabaseConfig(t)
+	collector := metrics.NewCollector(nil)
+	storm, err := newConnectionStorm(&dbConfig, cfg, collector)
+	if err != nil {
+		t.Fatalf("newConnectionStorm: %v", err)
+	}
+	storm.db.Close()
+	storm.db = sql.OpenDB(server)
+	storm.db.SetMaxIdleConns(0)
+	t.Cleanup(storm.close)
+	return storm, collector
+}
+
+// runStorm runs a storm until done reports true or the timeout passes
+func runStorm(t *testing.T, storm *connectionStorm, timeout time.Duration, done func(StormSummary) bool) StormSummary {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	storm.start(ctx)
+
+	deadline := time.Now().Add(timeout)
+	for !done(storm.summary()) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	storm.wait()
+	return storm.summary()
+}
+
+func TestConnectionStormRefusal(t *testing.T) {
+	server := &limitedServer{max: 3}
+	storm, collector := newTestStorm(t, config.ConnectionStormConfig{Connections: 5, Rate: 200, StopOnRefusal: true}, server)
+
+	summary := runStorm(t, storm, 2*time.Second, func(s StormSummary) bool { return s.RefusedAt != nil })
+
+	if summary.RefusedAt == nil || *summary.RefusedAt != 3 {
+		t.Fatalf("RefusedAt = %v, want the server limit of 3", summary.RefusedAt)
+	}
+	if summary.Refusal != "too many connections" {
+		t.Errorf("Refusal = %q", summary.Refusal)
+	}
+	if summary.Opened != 3 || summary.Open != 3 || summary.Peak != 3 {
+		t.Errorf("opened %d, open %d, peak %d, want 3 each", summary.Opened, summary.Open, summary.Peak)
+	}
+	if summary.Failed < 1 || summary.Attempts != summary.Opened+summary.Failed {
+		t.Errorf("attempts %d, failed %d, want every attempt opened or failed", summary.Attempts, summary.Failed)
+	}
+
+	// Stopping on refusal keeps the storm from retrying
+	time.Sleep(50 * time.Millisecond)
+	if attempts := storm.summary().Attempts; attempts != summary.Attempts {
+		t.Errorf("storm made %d attempts after stopping on refusal", attempts-summary.Attempts)
+	}
+
+	connects := collector.QuerySummaries()[stormConnectName]
+	if connects.SuccessfulQueries != 3 || connects.FailedQueries != summary.Failed {
+		t.Errorf("recorded %d successful and %d failed connects, want 3 and %d",
+			connects.SuccessfulQueries, connects.FailedQueries, summary.Failed)
+	}
+}
+
+func TestConnectionStormKeepsRetryingWithoutStop(t *testing.T) {
+	server := &limitedServer{max: 2}
+	storm, _ := newTestStorm(t, config.ConnectionStormConfig{Connections: 4, Rate: 200}, server)
+
+	summary := runStorm(t, storm, 2*time.Second, func(s StormSummary) bool { return s.Failed >= 3 })
+
+	if summary.RefusedAt == nil || *summary.RefusedAt != 2 {
+		t.Fatalf("RefusedAt = %v, want the server limit of 2", summary.RefusedAt)
+	}
+	if summary.Failed < 3 || summary.Open != 2 {
+		t.Errorf("failed %d with %d open, want repeated attempts at the limit", summary.Failed, summary.Open)
+	}
+}
+
+func TestConnectionStormChurn(t *testing.T) {
+	server := &limitedServer{}
+	storm, _ := newTestStorm(t, config.ConnectionStormConfig{Connections: 4, Rate: 500, ChurnRate: 100}, server)
+
+	summary := runStorm(t, storm, 2*time.Second, func(s StormSummary) bool { return s.Churned >= 5 })
+
+	if summary.Churned < 5 {
+		t.Fatalf("churned %d connections, want at least 5", summary.Churned)
+	}
+	// Every opened connection is still held, churned or dropped
+	if summary.Opened != int64(summary.Open)+summary.Churned+summary.Dropped {
+		t.Errorf("opened %d, want open %d + churned %d + dropped %d",
+			summary.Opened, summary.Open, summary.Churned, summary.Dropped)
+	}
+	if summary.Peak > 4 {
+		t.Errorf("peak %d exceeds the target of 4 connections", summary.Peak)
+	}
+	// Churned connections are closed on the server, not just forgotten
+	if open := server.openConnections(); open != summary.Open {
+		t.Errorf("server holds %d connections, storm holds %d", open, summary.Open)
+	}
+}
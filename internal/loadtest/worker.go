@@ -2,10 +2,11 @@ package loadtest
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,37 +22,228 @@ type Worker struct {
 	id        int
 	config    *config.Config
 	dbManager *database.Manager
+	pools     *poolSet
 	metrics   *metrics.Collector
-	queries   []config.QueryConfig
+	queries   []preparedQuery
+	scenarios []preparedScenario
 	weightSum int
+	paramCtx  *ParamContext
 	stopChan  chan struct{}
+	done      chan struct{} // Closed when the worker loop has returned
 	ctx       context.Context
 	cancel    context.CancelFunc
 	stopOnce  sync.Once // Prevent double stop
 }
 
-// NewWorker creates a new load test worker
-func NewWorker(id int, cfg *config.Config, metrics *metrics.Collector) (*Worker, error) {
-	// Create a copy of database config for this worker
-	dbConfig := cfg.Database
+// preparedQuery is a query definition adapted to the worker's database dialect
+type preparedQuery struct {
+	config.QueryConfig
+	paramNames []string                  // Parameter bound to each marker, in order
+	generators map[string]ParamGenerator // Parameter generators by name
+	feed       *QueryFeed                // Data source row bound to parameters
+	vars       map[string]bool           // Variables captured by earlier statements
+	capture    map[string]string         // Variable name -> result column captured by this query
+	outputs    []string                  // Output parameters of a procedure query, sorted
+	retry      *retryPolicy              // Nil if failed executions are not retried
+}
+
+// isParam reports whether name is a generated or fed parameter of the query.
+// Parameter names are matched case-insensitively because the YAML loader
+// lowercases map keys.
+func (q *preparedQuery) isParam(name string) bool {
+	name = strings.ToLower(name)
+	if _, ok := q.generators[name]; ok {
+		return true
+	}
+	if q.feed != nil {
+		if _, ok := q.feed.Bindings[name]; ok {
+			return true
+		}
+	}
+	return q.vars[name]
+}
+
+// args generates the bind arguments for one execution. A parameter referenced
+// more than once gets the same value at every marker, and all fed parameters
+// come from the same data source row.
+func (q *preparedQuery) args(ctx *ParamContext) ([]interface{}, error) {
+	if len(q.paramNames) == 0 {
+		return nil, nil
+	}
+
+	var row map[string]interface{}
+	if q.feed != nil {
+		row = q.feed.Feeder.Next(ctx)
+	}
+
+	values := make(map[string]interface{}, len(q.paramNames))
+	args := make([]interface{}, len(q.paramNames))
+	for i, name := range q.paramNames {
+		value, ok := values[name]
+		if !ok {
+			if generator, found := q.generators[name]; found {
+				value = generator.Next(ctx)
+			} else if column, found := q.bindingFor(name); found {
+				value = row[column]
+			} else if value, found = ctx.Vars[name]; !found {
+				return nil, fmt.Errorf("variable %s has not been captured yet", name)
+			}
+			values[name] = value
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+// returnsRows reports whether the query is run as a query rather than an exec
+func (q *preparedQuery) returnsRows() bool {
+	return q.Type == "select" || len(q.capture) > 0
+}
+
+// randomRow reports whether captured values come from a random result row
+func (q *preparedQuery) randomRow() bool {
+	return q.CaptureRow == config.CaptureRowRandom
+}
+
+// procedureCall prepares a call of a procedure query with the given input
+// values, or with NULL inputs if args is nil
+func (q *preparedQuery) procedureCall(dialect *database.Dialect, args []interface{}) (*database.ProcedureCall, error) {
+	params := make([]database.ProcedureParam, 0, len(q.paramNames)+len(q.outputs))
+	for i, name := range q.paramNames {
+		param := database.ProcedureParam{Name: name}
+		if args != nil {
+			param.Value = args[i]
+		}
+		params = append(params, param)
+	}
+	for _, name := range q.outputs {
+		params = append(params, database.ProcedureParam{Name: name, Type: q.Outputs[name], Output: true})
+	}
+	return dialect.NewProcedureCall(q.Procedure, params)
+}
+
+// bindingFor returns the data source column bound to a parameter
+func (q *preparedQuery) bindingFor(name string) (string, bool) {
+	if q.feed == nil {
+		return "", false
+	}
+	column, ok := q.feed.Bindings[name]
+	return column, ok
+}
+
+// prepareQuery adapts a query definition to the dialect and binds its :name
+// references to parameter generators, data source columns and variables
+func prepareQuery(dialect *database.Dialect, key string, query config.QueryConfig, params *ParamSet, vars map[string]bool) preparedQuery {
+	prepared := preparedQuery{
+		QueryConfig: query,
+		generators:  params.Generators(key),
+		feed:        params.Feed(key),
+		vars:        referencedVars(dialect, query.SQL, vars),
+	}
+	if len(query.Capture) > 0 {
+		// Variable references are matched in lower case like parameters
+		prepared.capture = make(map[string]string, len(query.Capture))
+		for name, column := range query.Capture {
+			prepared.capture[strings.ToLower(name)] = column
+		}
+	}
+	if query.Type == config.QueryTypeProcedure {
+		// Parameters are passed to the procedure by name, in a stable order
+		for name := range prepared.generators {
+			prepared.paramNames = append(prepared.paramNames, name)
+		}
+		if prepared.feed != nil {
+			for name := range prepared.feed.Bindings {
+				if _, ok := prepared.generators[name]; !ok {
+					prepared.paramNames = append(prepared.paramNames, name)
+				}
+			}
+		}
+		sort.Strings(prepared.paramNames)
+		for name := range query.Outputs {
+			prepared.outputs = append(prepared.outputs, strings.ToLower(name))
+		}
+		sort.Strings(prepared.outputs)
+		return prepared
+	}
+	if len(prepared.generators) == 0 && prepared.feed == nil && len(prepared.vars) == 0 {
+		prepared.SQL = dialect.Rewrite(query.SQL)
+		return prepared
+	}
+
+	sql, names := dialect.RewriteNamed(query.SQL, prepared.isParam)
+	prepared.SQL = sql
+	for _, name := range names {
+		prepared.paramNames = append(prepared.paramNames, strings.ToLower(name))
+	}
+	return prepared
+}
 
-	// Each worker should have its own connection pool
-	// Set smaller pool size per worker to avoid connection exhaustion
-	dbConfig.MaxOpenConns = 3 // Each worker gets 3 connections max
-	dbConfig.MaxIdleConns = 1 // Each worker keeps 1 idle connection
-	dbConfig.ConnMaxLifetime = 5 * time.Minute
-	dbConfig.ConnMaxIdleTime = 1 * time.Minute
+// referencedVars returns the variables a query references as :name
+func referencedVars(dialect *database.Dialect, sql string, vars map[string]bool) map[string]bool {
+	referenced := make(map[string]bool)
+	if len(vars) == 0 {
+		return referenced
+	}
+	dialect.BindNamed(sql, func(name string) bool {
+		if name = strings.ToLower(name); vars[name] {
+			referenced[name] = true
+		}
+		return false
+	})
+	return referenced
+}
 
-	dbManager, err := database.NewManager(&dbConfig)
+// NewWorker creates a new load test worker using a connection pool of the
+// given pool set
+func NewWorker(id int, cfg *config.Config, metrics *metrics.Collector, params *ParamSet, pools *poolSet) (*Worker, error) {
+	dbManager, err := pools.acquire(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database manager: %w", err)
 	}
 
-	// Calculate total weight for query selection
-	weightSum := 0
+	// Variables captured by queries stay set for the worker's later iterations
+	vars := make(map[string]bool)
 	for _, query := range cfg.Test.Queries {
+		for name := range query.Capture {
+			vars[strings.ToLower(name)] = true
+		}
+	}
+
+	// Adapt query definitions to the target database dialect and bind
+	// :name references to their parameter generators
+	queries := make([]preparedQuery, len(cfg.Test.Queries))
+	dialect := dbManager.Dialect()
+	for i, query := range cfg.Test.Queries {
+		queries[i] = prepareQuery(dialect, query.Name, query, params, vars)
+		queries[i].retry = newRetryPolicy(cfg.Test.Retry, query.Retry)
+
+		if query.Type == config.QueryTypeProcedure {
+			// Catch invalid names and output types before the test starts
+			if _, err := queries[i].procedureCall(dialect, nil); err != nil {
+				pools.release(dbManager)
+				return nil, fmt.Errorf("query %s: %w", query.Name, err)
+			}
+		}
+	}
+
+	scenarios := make([]preparedScenario, len(cfg.Test.Scenarios))
+	for i, scenario := range cfg.Test.Scenarios {
+		if scenarios[i], err = prepareScenario(dialect, scenario, params, vars); err != nil {
+			pools.release(dbManager)
+			return nil, err
+		}
+		scenarios[i].retry = newRetryPolicy(cfg.Test.Retry, scenario.Retry)
+	}
+
+	// Calculate total weight for query and scenario selection
+	weightSum := 0
+	for _, query := range queries {
 		weightSum += query.Weight
 	}
+	for _, scenario := range scenarios {
+		weightSum += scenario.Weight
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -59,10 +251,14 @@ func NewWorker(id int, cfg *config.Config, metrics *metrics.Collector) (*Worker,
 		id:        id,
 		config:    cfg,
 		dbManager: dbManager,
+		pools:     pools,
 		metrics:   metrics,
-		queries:   cfg.Test.Queries,
+		queries:   queries,
+		scenarios: scenarios,
 		weightSum: weightSum,
+		paramCtx:  &ParamContext{WorkerID: id, Vars: make(map[string]interface{})},
 		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
 		ctx:       ctx,
 		cancel:    cancel,
 	}, nil
@@ -72,6 +268,7 @@ func NewWorker(id int, cfg *config.Config, metrics *metrics.Collector) (*Worker,
 func (w *Worker) Start() {
 	logrus.Debugf("Worker %d started", w.id)
 	defer logrus.Debugf("Worker %d stopped", w.id)
+	defer close(w.done)
 
 	for {
 		select {
@@ -80,12 +277,33 @@ func (w *Worker) Start() {
 		case <-w.stopChan:
 			return
 		default:
-			w.executeQuery()
+			w.executeIteration()
 			w.thinkTime()
 		}
 	}
 }
 
+// StartArrivals runs one iteration per scheduled arrival received from the
+// executor instead of looping on think time
+func (w *Worker) StartArrivals(executor *ArrivalRateExecutor) {
+	logrus.Debugf("Worker %d started (arrival rate)", w.id)
+	defer logrus.Debugf("Worker %d stopped", w.id)
+	defer close(w.done)
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.stopChan:
+			return
+		case scheduled := <-executor.jobs:
+			executor.iterationStarted(scheduled)
+			w.executeIteration()
+			executor.iterationFinished()
+		}
+	}
+}
+
 // Stop stops the worker
 func (w *Worker) Stop() {
 	w.stopOnce.Do(func() {
@@ -94,16 +312,31 @@ func (w *Worker) Stop() {
 	})
 }
 
-// executeQuery executes a randomly selected query
-func (w *Worker) executeQuery() {
-	query := w.selectQuery()
-	if query == nil {
-		return
+// executeIteration executes a query or scenario selected by weight
+func (w *Worker) executeIteration() {
+	query, scenario := w.selectIteration()
+	switch {
+	case scenario != nil:
+		w.executeScenario(scenario)
+	case query != nil:
+		w.executeQuery(query)
 	}
+}
 
+// executeQuery executes a single query
+func (w *Worker) executeQuery(query *preparedQuery) {
 	start := time.Now()
+	queryType := query.Type
+	if queryType == "" {
+		queryType = "generic"
+		if query.returnsRows() {
+			queryType = "select"
+		}
+	}
+
 	result := metrics.QueryResult{
 		QueryName: query.Name,
+		QueryType: queryType,
 		Timestamp: start,
 	}
 
@@ -116,44 +349,82 @@ func (w *Worker) executeQuery() {
 		logrus.Debugf("Worker %d: Query %s completed in %v", w.id, query.Name, result.Duration)
 	}()
 
+	w.withRetry(query.retry, &result, func() {
+		w.attemptQuery(query, queryType, &result)
+	})
+}
+
+// attemptQuery executes one attempt of a query
+func (w *Worker) attemptQuery(query *preparedQuery, queryType string, result *metrics.QueryResult) {
+	args, err := query.args(w.paramCtx)
+	if err != nil {
+		w.failQuery(result, err)
+		logrus.Debugf("Worker %d: Query %s skipped: %v", w.id, query.Name, err)
+		return
+	}
+
 	// Execute the query based on its type
-	switch query.Type {
+	switch queryType {
 	case "select":
-		w.executeSelectQuery(query, &result)
+		w.executeSelectQuery(query, args, result)
 	case "insert":
-		w.executeInsertQuery(query, &result)
+		w.executeInsertQuery(query, args, result)
 	case "update":
-		w.executeUpdateQuery(query, &result)
+		w.executeUpdateQuery(query, args, result)
 	case "delete":
-		w.executeDeleteQuery(query, &result)
+		w.executeDeleteQuery(query, args, result)
+	case config.QueryTypeProcedure:
+		w.executeProcedureQuery(query, args, result)
 	default:
-		w.executeGenericQuery(query, &result)
+		w.executeGenericQuery(query, args, result)
 	}
 }
 
-// selectQuery selects a query based on weights
-func (w *Worker) selectQuery() *config.QueryConfig {
-	if len(w.queries) == 0 {
-		return nil
+// selectIteration selects a query or a scenario based on weights
+func (w *Worker) selectIteration() (*preparedQuery, *preparedScenario) {
+	if len(w.queries)+len(w.scenarios) == 0 {
+		return nil, nil
 	}
 
-	if len(w.queries) == 1 {
-		return &w.queries[0]
+	if len(w.queries) == 1 && len(w.scenarios) == 0 {
+		return &w.queries[0], nil
 	}
 
 	// Weighted random selection
 	random := rand.Intn(w.weightSum)
 	current := 0
 
-	for _, query := range w.queries {
-		current += query.Weight
+	for i := range w.queries {
+		current += w.queries[i].Weight
+		if random < current {
+			return &w.queries[i], nil
+		}
+	}
+	for i := range w.scenarios {
+		current += w.scenarios[i].Weight
 		if random < current {
-			return &query
+			return nil, &w.scenarios[i]
 		}
 	}
 
-	// Fallback to first query
-	return &w.queries[0]
+	// Fallback to the first query or scenario
+	if len(w.queries) > 0 {
+		return &w.queries[0], nil
+	}
+	return nil, &w.scenarios[0]
+}
+
+// failQuery marks a query result as failed with the classified error. A
+// query cut off by stopping the worker is canceled, whatever error the
+// driver reported for it.
+func (w *Worker) failQuery(result *metrics.QueryResult, err error) {
+	result.Success = false
+	result.Error = err.Error()
+	result.ErrorClass = database.ClassifyError(err)
+	if w.ctx.Err() != nil {
+		result.ErrorClass = database.ErrorClassCanceled
+	}
+	result.Canceled = result.ErrorClass == database.ErrorClassCanceled
 }
 
 // logError logs detailed error information to a separate file
@@ -188,7 +459,7 @@ func (w *Worker) logError(queryName, sql, errorMsg string, timestamp time.Time)
 }
 
 // executeSelectQuery executes a SELECT query
-func (w *Worker) executeSelectQuery(query *config.QueryConfig, result *metrics.QueryResult) {
+func (w *Worker) executeSelectQuery(query *preparedQuery, args []interface{}, result *metrics.QueryResult) {
 	// Update connection stats on every query (not just every 100)
 	stats := w.dbManager.GetStats()
 
@@ -201,10 +472,9 @@ func (w *Worker) executeSelectQuery(query *config.QueryConfig, result *metrics.Q
 	// Always update active connections metric
 	w.metrics.SetActiveConnections(stats.OpenConnections)
 
-	rows, err := w.dbManager.ExecuteQuery(query.SQL)
+	rows, err := w.dbManager.ExecuteQuery(w.ctx, query.SQL, args...)
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		logrus.Debugf("Worker %d: SELECT query failed: %v", w.id, err)
 
 		// Log detailed error
@@ -218,37 +488,27 @@ func (w *Worker) executeSelectQuery(query *config.QueryConfig, result *metrics.Q
 		}
 	}()
 
-	// Count rows
-	count := 0
-	for rows.Next() {
-		count++
-		// Optionally scan the row to simulate real usage
-		if count > 1000 { // Limit to prevent memory issues
-			break
-		}
-	}
-
-	if err := rows.Err(); err != nil {
-		result.Success = false
-		result.Error = err.Error()
+	// Count rows and capture the configured columns
+	count, err := scanRows(rows.Rows, query, w.paramCtx.Vars)
+	if err != nil {
+		w.failQuery(result, err)
 		w.logError(query.Name, query.SQL, err.Error(), time.Now())
 		return
 	}
 
 	result.Success = true
-	result.RowsAffected = int64(count)
+	result.RowsAffected = count
 }
 
 // executeInsertQuery executes an INSERT query
-func (w *Worker) executeInsertQuery(query *config.QueryConfig, result *metrics.QueryResult) {
+func (w *Worker) executeInsertQuery(query *preparedQuery, args []interface{}, result *metrics.QueryResult) {
 	// Update connection stats
 	stats := w.dbManager.GetStats()
 	w.metrics.SetActiveConnections(stats.OpenConnections)
 
-	res, err := w.dbManager.ExecuteExec(query.SQL)
+	res, err := w.dbManager.ExecuteExec(w.ctx, query.SQL, args...)
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		logrus.Debugf("Worker %d: INSERT query failed: %v", w.id, err)
 		w.logError(query.Name, query.SQL, err.Error(), time.Now())
 		return
@@ -256,8 +516,7 @@ func (w *Worker) executeInsertQuery(query *config.QueryConfig, result *metrics.Q
 
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		w.logError(query.Name, query.SQL, err.Error(), time.Now())
 		return
 	}
@@ -267,23 +526,21 @@ func (w *Worker) executeInsertQuery(query *config.QueryConfig, result *metrics.Q
 }
 
 // executeUpdateQuery executes an UPDATE query
-func (w *Worker) executeUpdateQuery(query *config.QueryConfig, result *metrics.QueryResult) {
+func (w *Worker) executeUpdateQuery(query *preparedQuery, args []interface{}, result *metrics.QueryResult) {
 	// Update connection stats
 	stats := w.dbManager.GetStats()
 	w.metrics.SetActiveConnections(stats.OpenConnections)
 
-	res, err := w.dbManager.ExecuteExec(query.SQL)
+	res, err := w.dbManager.ExecuteExec(w.ctx, query.SQL, args...)
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		logrus.Debugf("Worker %d: UPDATE query failed: %v", w.id, err)
 		return
 	}
 
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		return
 	}
 
@@ -292,23 +549,21 @@ func (w *Worker) executeUpdateQuery(query *config.QueryConfig, result *metrics.Q
 }
 
 // executeDeleteQuery executes a DELETE query
-func (w *Worker) executeDeleteQuery(query *config.QueryConfig, result *metrics.QueryResult) {
+func (w *Worker) executeDeleteQuery(query *preparedQuery, args []interface{}, result *metrics.QueryResult) {
 	// Update connection stats
 	stats := w.dbManager.GetStats()
 	w.metrics.SetActiveConnections(stats.OpenConnections)
 
-	res, err := w.dbManager.ExecuteExec(query.SQL)
+	res, err := w.dbManager.ExecuteExec(w.ctx, query.SQL, args...)
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		logrus.Debugf("Worker %d: DELETE query failed: %v", w.id, err)
 		return
 	}
 
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		return
 	}
 
@@ -316,17 +571,64 @@ func (w *Worker) executeDeleteQuery(query *config.QueryConfig, result *metrics.Q
 	result.RowsAffected = rowsAffected
 }
 
+// executeProcedureQuery calls a stored procedure and reads all of its result
+// sets, so the timing includes the whole response
+func (w *Worker) executeProcedureQuery(query *preparedQuery, args []interface{}, result *metrics.QueryResult) {
+	// Update connection stats
+	stats := w.dbManager.GetStats()
+	w.metrics.SetActiveConnections(stats.OpenConnections)
+
+	call, err := query.procedureCall(w.dbManager.Dialect(), args)
+	if err != nil {
+		w.failQuery(result, err)
+		return
+	}
+
+	rows, err := w.dbManager.ExecuteQuery(w.ctx, call.SQL, call.Args...)
+	if err != nil {
+		w.failQuery(result, err)
+		logrus.Debugf("Worker %d: Procedure %s failed: %v", w.id, query.Procedure, err)
+		w.logError(query.Name, query.Procedure, err.Error(), time.Now())
+		return
+	}
+
+	var count int64
+	for {
+		for rows.Next() {
+			count++
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Close(); err != nil {
+		w.failQuery(result, err)
+		return
+	}
+	if err := rows.Err(); err != nil {
+		w.failQuery(result, err)
+		w.logError(query.Name, query.Procedure, err.Error(), time.Now())
+		return
+	}
+
+	if status, ok := call.ReturnStatus(); ok && status != 0 {
+		logrus.Debugf("Worker %d: Procedure %s returned status %d", w.id, query.Procedure, status)
+	}
+
+	result.Success = true
+	result.RowsAffected = count
+}
+
 // executeGenericQuery executes a generic query
-func (w *Worker) executeGenericQuery(query *config.QueryConfig, result *metrics.QueryResult) {
+func (w *Worker) executeGenericQuery(query *preparedQuery, args []interface{}, result *metrics.QueryResult) {
 	// Update connection stats
 	stats := w.dbManager.GetStats()
 	w.metrics.SetActiveConnections(stats.OpenConnections)
 
 	// Try to determine if it's a SELECT query by checking if it returns rows
-	rows, err := w.dbManager.ExecuteQuery(query.SQL)
+	rows, err := w.dbManager.ExecuteQuery(w.ctx, query.SQL, args...)
 	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		logrus.Debugf("Worker %d: Generic query failed: %v", w.id, err)
 		return
 	}
@@ -344,7 +646,7 @@ func (w *Worker) executeGenericQuery(query *config.QueryConfig, result *metrics.
 		result.RowsAffected = int64(count)
 	} else {
 		// It's not a SELECT query, try to get affected rows
-		if res, err := w.dbManager.ExecuteExec(query.SQL); err == nil {
+		if res, err := w.dbManager.ExecuteExec(w.ctx, query.SQL, args...); err == nil {
 			if rowsAffected, err := res.RowsAffected(); err == nil {
 				result.RowsAffected = rowsAffected
 			}
@@ -352,8 +654,7 @@ func (w *Worker) executeGenericQuery(query *config.QueryConfig, result *metrics.
 	}
 
 	if err := rows.Err(); err != nil {
-		result.Success = false
-		result.Error = err.Error()
+		w.failQuery(result, err)
 		return
 	}
 
@@ -370,21 +671,13 @@ func (w *Worker) thinkTime() {
 	}
 }
 
-// GetDBStats returns database connection statistics
-func (w *Worker) GetDBStats() sql.DBStats {
-	if w.dbManager != nil {
-		return w.dbManager.GetStats()
-	}
-	return sql.DBStats{}
-}
-
 // Close closes the worker and its database connection
 func (w *Worker) Close() error {
 	w.Stop()
 
-	// Force close all connections
+	// Close per-worker connections, shared pools stay open
 	if w.dbManager != nil {
-		w.dbManager.Close()
+		w.pools.release(w.dbManager)
 	}
 
 	logrus.Debugf("Worker %d: Closed and cleaned up connections", w.id)
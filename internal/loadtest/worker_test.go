@@ -0,0 +1,176 @@
+package loadtest
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+)
+
+// captureSetup creates five open orders
+var captureSetup = []string{
+	"CREATE TABLE orders (id INTEGER PRIMARY KEY, status TEXT, touched INTEGER DEFAULT 0)",
+	"INSERT INTO orders (id, status) VALUES (1, 'open'), (2, 'open'), (3, 'open'), (4, 'open'), (5, 'open')",
+}
+
+func TestCaptureFirstRow(t *testing.T) {
+	w, collector := newTestWorker(t, `
+  queries:
+    - name: find_order
+      type: select
+      weight: 1
+      sql: SELECT id, status FROM orders ORDER BY id
+      capture:
+        order_id: id
+        order_status: status
+`, captureSetup...)
+
+	w.executeQuery(&w.queries[0])
+
+	if summary := collector.QuerySummaries()["find_order"]; summary.SuccessfulQueries != 1 {
+		t.Fatalf("query failed: %v", summary.ErrorClasses)
+	}
+	if got := w.paramCtx.Vars["order_id"]; got != int64(1) {
+		t.Errorf("captured order_id = %v (%T), want 1 from the first row", got, got)
+	}
+	if got := w.paramCtx.Vars["order_status"]; got != "open" {
+		t.Errorf("captured order_status = %v (%T), want open", got, got)
+	}
+}
+
+func TestCaptureRandomRow(t *testing.T) {
+	w, _ := newTestWorker(t, `
+  queries:
+    - name: find_order
+      type: select
+      weight: 1
+      sql: SELECT id FROM orders ORDER BY id
+      capture:
+        order_id: id
+      capture_row: random
+`, captureSetup...)
+
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 100; i++ {
+		w.executeQuery(&w.queries[0])
+		id := w.paramCtx.Vars["order_id"]
+		if n, ok := id.(int64); !ok || n < 1 || n > 5 {
+			t.Fatalf("captured order_id = %v (%T), want a row of the result", id, id)
+		}
+		seen[id] = true
+	}
+	// 100 picks of 5 rows miss one with a probability of about 1e-9
+	if len(seen) != 5 {
+		t.Errorf("captured %d distinct rows in 100 random picks, want all 5", len(seen))
+	}
+}
+
+func TestCaptureBeforeCaptured(t *testing.T) {
+	w, collector := newTestWorker(t, `
+  queries:
+    - name: touch_order
+      type: update
+      weight: 1
+      sql: UPDATE orders SET touched = touched + 1 WHERE id = :order_id
+    - name: find_order
+      type: select
+      weight: 1
+      sql: SELECT id FROM orders
+      capture:
+        order_id: id
+`, captureSetup...)
+
+	w.executeQuery(&w.queries[0])
+
+	summary := collector.QuerySummaries()["touch_order"]
+	if summary.FailedQueries != 1 {
+		t.Fatalf("touch_order ran without its variable: %+v", summary)
+	}
+	errors, _ := collector.TopErrors(1)
+	if len(errors) != 1 || !strings.Contains(errors[0].Message, "variable order_id has not been captured yet") {
+		t.Errorf("errors = %+v, want the variable that was not captured", errors)
+	}
+}
+
+func TestCaptureReusedAcrossIterations(t *testing.T) {
+	w, collector := newTestWorker(t, `
+  queries:
+    - name: find_order
+      type: select
+      weight: 1
+      sql: SELECT id FROM orders WHERE id = 3
+      capture:
+        order_id: id
+    - name: touch_order
+      type: update
+      weight: 1
+      sql: UPDATE orders SET touched = touched + 1 WHERE id = :order_id
+`, captureSetup...)
+
+	// The variable stays set for the worker's later iterations
+	w.executeQuery(&w.queries[0])
+	for i := 0; i < 3; i++ {
+		w.executeQuery(&w.queries[1])
+	}
+
+	if summary := collector.QuerySummaries()["touch_order"]; summary.SuccessfulQueries != 3 {
+		t.Fatalf("touch_order failed: %v", summary.ErrorClasses)
+	}
+	var touched int
+	if err := w.dbManager.ExecuteQueryRow(context.Background(), "SELECT touched FROM orders WHERE id = 3", nil, &touched); err != nil {
+		t.Fatal(err)
+	}
+	if touched != 3 {
+		t.Errorf("order 3 touched %d times, want 3", touched)
+	}
+}
+
+func TestPrepareQueryVariables(t *testing.T) {
+	dialect, err := database.DialectFor("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := NewParamSet(&config.TestConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]bool{"order_id": true, "customer": true}
+
+	tests := []struct {
+		name       string
+		sql        string
+		wantSQL    string
+		wantParams []string
+	}{
+		{
+			name:       "referenced",
+			sql:        "UPDATE orders SET status = 'done' WHERE id = :ORDER_ID AND status <> ':customer'",
+			wantSQL:    "UPDATE orders SET status = 'done' WHERE id = $1 AND status <> ':customer'",
+			wantParams: []string{"order_id"},
+		},
+		{
+			// Other queries capturing variables leave this one alone
+			name:    "not referenced",
+			sql:     "SELECT payload::jsonb ? 'id' FROM orders WHERE created_at < NOW()",
+			wantSQL: "SELECT payload::jsonb ? 'id' FROM orders WHERE created_at < NOW()",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := config.QueryConfig{Name: tt.name, Type: "select", SQL: tt.sql}
+			prepared := prepareQuery(dialect, query.Name, query, params, vars)
+			if prepared.SQL != tt.wantSQL {
+				t.Errorf("SQL = %q, want %q", prepared.SQL, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(prepared.paramNames, tt.wantParams) {
+				t.Errorf("paramNames = %v, want %v", prepared.paramNames, tt.wantParams)
+			}
+			if len(prepared.vars) != len(tt.wantParams) {
+				t.Errorf("vars = %v, want only the referenced %v", prepared.vars, tt.wantParams)
+			}
+		})
+	}
+}
@@ -2,109 +2,314 @@ package metrics
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
+// Query result statuses
+const (
+	StatusSuccess  = "success"
+	StatusError    = "error"
+	StatusCanceled = "canceled" // Stopped before it finished, not counted as a failure
+)
+
 // QueryResult represents the result of a query execution
 type QueryResult struct {
 	QueryName    string        `json:"query_name"`
+	QueryType    string        `json:"query_type"`
 	Success      bool          `json:"success"`
 	Duration     time.Duration `json:"duration"`
 	RowsAffected int64         `json:"rows_affected"`
 	Error        string        `json:"error,omitempty"`
+	ErrorClass   string        `json:"error_class,omitempty"`
+	Retries      []string      `json:"retries,omitempty"`  // Error class of every retried attempt
+	Canceled     bool          `json:"canceled,omitempty"` // Cut off by stopping the test
 	Timestamp    time.Time     `json:"timestamp"`
+
+	// Transactional scenarios only
+	Outcome string       `json:"outcome,omitempty"` // commit, rollback
+	Steps   []StepResult `json:"steps,omitempty"`
+}
+
+// Transaction outcomes
+const (
+	OutcomeCommit   = "commit"
+	OutcomeRollback = "rollback"
+	OutcomeError    = "error"
+)
+
+// StepResult is the result of one statement of a transactional scenario
+type StepResult struct {
+	Name     string        `json:"name"`
+	Success  bool          `json:"success"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Status returns the status label of the result
+func (r *QueryResult) Status() string {
+	switch {
+	case r.Success:
+		return StatusSuccess
+	case r.Canceled:
+		return StatusCanceled
+	default:
+		return StatusError
+	}
+}
+
+// queryStats holds the aggregated results of one query
+type queryStats struct {
+	totalQueries      int64
+	successfulQueries int64
+	failedQueries     int64
+	canceledQueries   int64
+	totalDuration     time.Duration
+	latency           *Histogram
+	errorClasses      map[string]int64 // Final errors by class
+	retries           map[string]int64 // Retried attempts by error class
+
+	// Transactional scenarios only
+	commits   int64
+	rollbacks int64
+	steps     map[string]*queryStats
+}
+
+// QuerySummary is the reported view of one query's results
+type QuerySummary struct {
+	TotalQueries      int64              `json:"total_queries"`
+	SuccessfulQueries int64              `json:"successful_queries"`
+	FailedQueries     int64              `json:"failed_queries"`
+	CanceledQueries   int64              `json:"canceled_queries,omitempty"` // Not counted in total_queries
+	TotalDuration     time.Duration      `json:"total_duration"`
+	AvgDuration       time.Duration      `json:"avg_duration"`
+	Latency           LatencySummary     `json:"latency"`
+	Histogram         *HistogramSnapshot `json:"histogram,omitempty"`
+	ErrorClasses      map[string]int64   `json:"error_classes,omitempty"`
+	Retries           map[string]int64   `json:"retries,omitempty"`
+
+	// Transactional scenarios only
+	Commits   int64                   `json:"commits,omitempty"`
+	Rollbacks int64                   `json:"rollbacks,omitempty"`
+	Steps     map[string]QuerySummary `json:"steps,omitempty"`
 }
 
+// maxTrackedErrors caps the distinct error messages kept for reporting, so a
+// driver that embeds values in its messages cannot grow memory without bound
+const maxTrackedErrors = 1000
+
+// errorKey identifies an error message of a query
+type errorKey struct {
+	query   string
+	message string
+}
+
+// ErrorCount is the number of occurrences of one error message
+type ErrorCount struct {
+	Query      string `json:"query"`
+	ErrorClass string `json:"error_class"`
+	Message    string `json:"message"`
+	Count      int64  `json:"count"`
+}
+
+// ErrorClassCount is the number of errors and retries of one error class
+type ErrorClassCount struct {
+	Class   string `json:"class"`
+	Errors  int64  `json:"errors"`
+	Retries int64  `json:"retries"`
+}
+
+// DefaultLatencyBuckets are the query duration histogram buckets in seconds,
+// from 100µs to about 6.5s. prometheus.DefBuckets start at 5ms and cannot
+// resolve sub-millisecond queries.
+var DefaultLatencyBuckets = prometheus.ExponentialBuckets(0.0001, 2, 17)
+
+// Query metric label names
+var (
+	queryLabels       = []string{"query", "type", "status"}
+	errorLabels       = []string{"query", "type", "error_class"}
+	stepLabels        = []string{"scenario", "step", "status"}
+	transactionLabels = []string{"scenario", "outcome"}
+)
+
 // Collector handles metrics collection for load testing
 type Collector struct {
-	// Prometheus metrics
+	// Prometheus metrics, registered on the collector's own registry so
+	// several collectors can exist in one process
+	registry          *prometheus.Registry
 	requestsTotal     prometheus.Counter
 	requestDuration   prometheus.Histogram
 	activeConnections prometheus.Gauge
-	errorsTotal       prometheus.Counter
-	queriesExecuted   prometheus.Counter
-	queryDuration     prometheus.Histogram
+	errorsTotal       *prometheus.CounterVec
+	queriesExecuted   *prometheus.CounterVec
+	queryDuration     *prometheus.HistogramVec
 	activeUsers       prometheus.Gauge
-	successfulQueries prometheus.Counter
-	failedQueries     prometheus.Counter
+	successfulQueries *prometheus.CounterVec
+	failedQueries     *prometheus.CounterVec
+	droppedIterations prometheus.Counter
+	lateIterations    prometheus.Counter
+	stepDuration      *prometheus.HistogramVec
+	transactions      *prometheus.CounterVec
+	retries           *prometheus.CounterVec
+	pools             *poolGauges
 
 	// Internal state
 	outputFile       string
 	interval         time.Duration
 	prometheusName   string
 	activeUsersCount int
-	stats            map[string]interface{}
+	droppedCount     int64
+	lateCount        int64
+	stats            map[string]*queryStats
+	errorCounts      map[errorKey]*ErrorCount
+	untrackedErrors  int64
 	mu               sync.RWMutex
-	stopChan         chan struct{}
+
+	// Per-interval time series
+	timeSeriesFile   string
+	timeSeriesFormat string
+	timeSeries       *timeSeriesWriter
+	poolStatsFunc    func() []PoolStats
+	poolStats        []PoolStats // Connection pools at the last interval
+	startTime        time.Time
+	windowStart      time.Time
+	window           map[string]*windowStats
+	snapshots        []IntervalSnapshot
+
+	// Recent results for rolling-window thresholds and live snapshots, nil
+	// unless enabled
+	rolling *rollingWindow
+
+	// Lifecycle
+	stopChan  chan struct{}
+	closeOnce sync.Once // Prevent double close
 }
 
-// NewCollector creates a new metrics collector
-func NewCollector() *Collector {
+// NewCollector creates a new metrics collector. Query durations are observed
+// into the given histogram buckets (seconds), or DefaultLatencyBuckets if empty.
+func NewCollector(buckets []float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory := promauto.With(registry)
+
 	return &Collector{
-		requestsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		registry: registry,
+		requestsTotal: factory.NewCounter(prometheus.CounterOpts{
 			Name: "fiyuu_ktdb_requests_total",
 			Help: "Total number of requests processed",
 		}),
-		requestDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+		requestDuration: factory.NewHistogram(prometheus.HistogramOpts{
 			Name:    "fiyuu_ktdb_request_duration_seconds",
 			Help:    "Request duration in seconds",
-			Buckets: prometheus.DefBuckets,
+			Buckets: buckets,
 		}),
-		activeConnections: promauto.NewGauge(prometheus.GaugeOpts{
+		activeConnections: factory.NewGauge(prometheus.GaugeOpts{
 			Name: "fiyuu_ktdb_active_connections",
 			Help: "Number of active database connections",
 		}),
-		errorsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		errorsTotal: factory.NewCounterVec(prometheus.CounterOpts{
 			Name: "fiyuu_ktdb_errors_total",
 			Help: "Total number of errors",
-		}),
-		queriesExecuted: promauto.NewCounter(prometheus.CounterOpts{
+		}, errorLabels),
+		queriesExecuted: factory.NewCounterVec(prometheus.CounterOpts{
 			Name: "fiyuu_ktdb_queries_executed_total",
 			Help: "Total number of queries executed",
-		}),
-		queryDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+		}, queryLabels),
+		queryDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
 			Name:    "fiyuu_ktdb_query_duration_seconds",
 			Help:    "Query execution duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		}),
-		activeUsers: promauto.NewGauge(prometheus.GaugeOpts{
+			Buckets: buckets,
+		}, queryLabels),
+		activeUsers: factory.NewGauge(prometheus.GaugeOpts{
 			Name: "fiyuu_ktdb_active_users",
 			Help: "Number of active load test users",
 		}),
-		successfulQueries: promauto.NewCounter(prometheus.CounterOpts{
+		successfulQueries: factory.NewCounterVec(prometheus.CounterOpts{
 			Name: "fiyuu_ktdb_successful_queries_total",
 			Help: "Total number of successful queries",
-		}),
-		failedQueries: promauto.NewCounter(prometheus.CounterOpts{
+		}, []string{"query", "type"}),
+		failedQueries: factory.NewCounterVec(prometheus.CounterOpts{
 			Name: "fiyuu_ktdb_failed_queries_total",
 			Help: "Total number of failed queries",
+		}, []string{"query", "type"}),
+		droppedIterations: factory.NewCounter(prometheus.CounterOpts{
+			Name: "fiyuu_ktdb_dropped_iterations_total",
+			Help: "Total number of scheduled iterations dropped because the worker pool was exhausted",
+		}),
+		lateIterations: factory.NewCounter(prometheus.CounterOpts{
+			Name: "fiyuu_ktdb_late_iterations_total",
+			Help: "Total number of scheduled iterations that started later than the late threshold",
 		}),
-		stats:    make(map[string]interface{}),
-		stopChan: make(chan struct{}),
+		stepDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fiyuu_ktdb_scenario_step_duration_seconds",
+			Help:    "Duration of transactional scenario steps in seconds",
+			Buckets: buckets,
+		}, stepLabels),
+		transactions: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "fiyuu_ktdb_transactions_total",
+			Help: "Total number of transactional scenarios by outcome",
+		}, transactionLabels),
+		retries: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "fiyuu_ktdb_retries_total",
+			Help: "Total number of retried attempts by error class",
+		}, errorLabels),
+		pools:       newPoolGauges(factory),
+		stats:       make(map[string]*queryStats),
+		errorCounts: make(map[errorKey]*ErrorCount),
+		window:      make(map[string]*windowStats),
+		stopChan:    make(chan struct{}),
 	}
 }
 
+// Registry returns the Prometheus registry holding the collector's metrics
+func (c *Collector) Registry() *prometheus.Registry {
+	return c.registry
+}
+
+// Handler returns an HTTP handler exposing the collector's metrics
+func (c *Collector) Handler() http.Handler {
+	return promhttp.HandlerFor(c.registry, promhttp.HandlerOpts{Registry: c.registry})
+}
+
 // RecordRequest records a request metric
 func (c *Collector) RecordRequest(duration time.Duration) {
 	c.requestsTotal.Inc()
 	c.requestDuration.Observe(duration.Seconds())
 }
 
-// RecordError records an error metric
-func (c *Collector) RecordError() {
-	c.errorsTotal.Inc()
+// RecordDroppedIteration records a scheduled iteration that could not be dispatched
+func (c *Collector) RecordDroppedIteration() {
+	c.droppedIterations.Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.droppedCount++
 }
 
-// RecordQueryDuration records a query execution metric by duration
-func (c *Collector) RecordQueryDuration(duration time.Duration) {
-	c.queriesExecuted.Inc()
-	c.queryDuration.Observe(duration.Seconds())
+// RecordLateIteration records a scheduled iteration that started late
+func (c *Collector) RecordLateIteration() {
+	c.lateIterations.Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lateCount++
 }
 
 // SetActiveConnections sets the number of active connections
@@ -126,6 +331,33 @@ func (c *Collector) SetInterval(interval time.Duration) {
 	c.interval = interval
 }
 
+// SetTimeSeriesFile sets the file that receives one snapshot per interval.
+// The format is jsonl or csv, detected from the file extension if empty.
+func (c *Collector) SetTimeSeriesFile(filename, format string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeSeriesFile = filename
+	c.timeSeriesFormat = format
+}
+
+// SetPoolStatsFunc sets the function reporting the connection pools at each
+// interval
+func (c *Collector) SetPoolStatsFunc(fn func() []PoolStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.poolStatsFunc = fn
+}
+
+// PoolStats returns the connection pools observed at the last interval
+func (c *Collector) PoolStats() []PoolStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	pools := make([]PoolStats, len(c.poolStats))
+	copy(pools, c.poolStats)
+	return pools
+}
+
 // EnablePrometheus enables Prometheus metrics collection
 func (c *Collector) EnablePrometheus(name string) {
 	c.mu.Lock()
@@ -135,16 +367,31 @@ func (c *Collector) EnablePrometheus(name string) {
 
 // Start starts the metrics collection
 func (c *Collector) Start() {
+	c.mu.Lock()
 	if c.interval <= 0 {
 		c.interval = 10 * time.Second
 	}
+	interval := c.interval
 
-	ticker := time.NewTicker(c.interval)
+	c.startTime = time.Now()
+	c.windowStart = c.startTime
+	if c.timeSeriesFile != "" {
+		writer, err := newTimeSeriesWriter(c.timeSeriesFile, c.timeSeriesFormat)
+		if err != nil {
+			logrus.Errorf("Failed to open time series file: %v", err)
+		} else {
+			c.timeSeries = writer
+		}
+	}
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
+			c.snapshotInterval(false)
 			c.collectMetrics()
 		case <-c.stopChan:
 			return
@@ -162,47 +409,426 @@ func (c *Collector) SetActiveUsers(count int) {
 
 // RecordQuery records a query execution with QueryResult
 func (c *Collector) RecordQuery(result QueryResult) {
-	c.queriesExecuted.Inc()
-	c.queryDuration.Observe(result.Duration.Seconds())
+	status := result.Status()
+	c.queriesExecuted.WithLabelValues(result.QueryName, result.QueryType, status).Inc()
+	if result.Canceled {
+		c.recordCanceled(&result)
+		return
+	}
+	c.queryDuration.WithLabelValues(result.QueryName, result.QueryType, status).Observe(result.Duration.Seconds())
 
 	if result.Success {
-		c.successfulQueries.Inc()
+		c.successfulQueries.WithLabelValues(result.QueryName, result.QueryType).Inc()
 	} else {
-		c.errorsTotal.Inc()
-		c.failedQueries.Inc()
+		c.errorsTotal.WithLabelValues(result.QueryName, result.QueryType, result.ErrorClass).Inc()
+		c.failedQueries.WithLabelValues(result.QueryName, result.QueryType).Inc()
+	}
+	if result.Outcome != "" {
+		c.transactions.WithLabelValues(result.QueryName, result.Outcome).Inc()
+	}
+	for _, class := range result.Retries {
+		c.retries.WithLabelValues(result.QueryName, result.QueryType, class).Inc()
+	}
+	for _, step := range result.Steps {
+		c.stepDuration.WithLabelValues(result.QueryName, step.Name, stepStatus(step.Success)).Observe(step.Duration.Seconds())
 	}
 
 	// Update internal stats
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.stats == nil {
-		c.stats = make(map[string]interface{})
-	}
-
-	// Update query-specific stats
-	queryStats, ok := c.stats[result.QueryName].(map[string]interface{})
+	stats, ok := c.stats[result.QueryName]
 	if !ok {
-		queryStats = make(map[string]interface{})
-		queryStats["total_queries"] = 0
-		queryStats["successful_queries"] = 0
-		queryStats["failed_queries"] = 0
-		queryStats["total_duration"] = time.Duration(0)
-		queryStats["avg_duration"] = time.Duration(0)
+		stats = &queryStats{latency: NewHistogram()}
+		c.stats[result.QueryName] = stats
 	}
 
-	queryStats["total_queries"] = queryStats["total_queries"].(int) + 1
+	stats.totalQueries++
 	if result.Success {
-		queryStats["successful_queries"] = queryStats["successful_queries"].(int) + 1
+		stats.successfulQueries++
 	} else {
-		queryStats["failed_queries"] = queryStats["failed_queries"].(int) + 1
+		stats.failedQueries++
+	}
+	stats.totalDuration += result.Duration
+	stats.latency.Record(result.Duration)
+	stats.recordErrorClasses(&result)
+	stats.recordTransaction(&result)
+
+	window, ok := c.window[result.QueryName]
+	if !ok {
+		window = &windowStats{latency: NewHistogram()}
+		c.window[result.QueryName] = window
+	}
+	window.requests++
+	if !result.Success {
+		window.errors++
+	}
+	window.latency.Record(result.Duration)
+
+	if !result.Success {
+		c.countErrorLocked(&result)
+	}
+
+	if c.rolling != nil {
+		c.rolling.record(time.Now(), &result)
+	}
+}
+
+// recordCanceled counts a query cut off by stopping the test. Its duration
+// and error say nothing about the database, so it stays out of the latency,
+// error and threshold statistics.
+func (c *Collector) recordCanceled(result *QueryResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, ok := c.stats[result.QueryName]
+	if !ok {
+		stats = &queryStats{latency: NewHistogram()}
+		c.stats[result.QueryName] = stats
+	}
+	stats.canceledQueries++
+}
+
+// EnableRollingWindow keeps the results of the given span for evaluating
+// thresholds over rolling windows and for live snapshots. A window already
+// covering the span is kept.
+func (c *Collector) EnableRollingWindow(span time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rolling != nil && c.rolling.span() >= span {
+		return
+	}
+	c.rolling = newRollingWindow(span)
+}
+
+// EvaluateRollingThreshold checks a threshold against the results of the
+// window ending now. Rolling windows must be enabled with a sufficient span.
+func (c *Collector) EvaluateRollingThreshold(threshold *Threshold, window time.Duration) ThresholdResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.rolling == nil {
+		return ThresholdResult{Expression: threshold.Expression, Actual: "no data", NoData: true}
 	}
+	return threshold.Evaluate(c.rolling.input(time.Now(), window, threshold.Query))
+}
 
-	totalDuration := queryStats["total_duration"].(time.Duration) + result.Duration
-	queryStats["total_duration"] = totalDuration
-	queryStats["avg_duration"] = totalDuration / time.Duration(queryStats["total_queries"].(int))
+// LiveSnapshot returns the results of the last completed second with the
+// current active users and connection pools. Rolling windows must be
+// enabled, otherwise the snapshot holds no query results.
+func (c *Collector) LiveSnapshot() IntervalSnapshot {
+	c.mu.RLock()
+	poolStatsFunc := c.poolStatsFunc
+	c.mu.RUnlock()
+
+	var pools []PoolStats
+	activeConnections := 0
+	if poolStatsFunc != nil {
+		pools = poolStatsFunc()
+		for _, pool := range pools {
+			activeConnections += pool.OpenConnections
+		}
+	}
 
-	c.stats[result.QueryName] = queryStats
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	snapshot := IntervalSnapshot{
+		Timestamp:         now,
+		Interval:          rollingSlotWidth,
+		ActiveUsers:       c.activeUsersCount,
+		ActiveConnections: activeConnections,
+		Queries:           make(map[string]WindowSummary, len(c.stats)),
+		Pools:             pools,
+	}
+	if !c.startTime.IsZero() {
+		snapshot.Elapsed = now.Sub(c.startTime)
+	}
+	if c.rolling == nil {
+		return snapshot
+	}
+
+	// The current second is still filling up, report the one before it
+	end := now.Add(-rollingSlotWidth)
+	for name := range c.stats {
+		requests, errors, latency := c.rolling.results(end, rollingSlotWidth, name)
+		snapshot.Queries[name] = newWindowSummary(requests, errors, latency, rollingSlotWidth)
+	}
+	requests, errors, latency := c.rolling.results(end, rollingSlotWidth, "")
+	snapshot.Total = newWindowSummary(requests, errors, latency, rollingSlotWidth)
+	return snapshot
+}
+
+// countErrorLocked counts the error message of a failed result, the caller
+// must hold c.mu
+func (c *Collector) countErrorLocked(result *QueryResult) {
+	key := errorKey{query: result.QueryName, message: result.Error}
+	if count, ok := c.errorCounts[key]; ok {
+		count.Count++
+		return
+	}
+	if len(c.errorCounts) >= maxTrackedErrors {
+		c.untrackedErrors++
+		return
+	}
+	c.errorCounts[key] = &ErrorCount{
+		Query:      result.QueryName,
+		ErrorClass: result.ErrorClass,
+		Message:    result.Error,
+		Count:      1,
+	}
+}
+
+// TopErrors returns the n most frequent error messages, and the number of
+// errors whose message was not tracked because the limit was reached
+func (c *Collector) TopErrors(n int) ([]ErrorCount, int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	counts := make([]ErrorCount, 0, len(c.errorCounts))
+	for _, count := range c.errorCounts {
+		counts = append(counts, *count)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Message < counts[j].Message
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts, c.untrackedErrors
+}
+
+// snapshotInterval closes the current interval window, records its snapshot
+// and appends it to the time series file
+func (c *Collector) snapshotInterval(final bool) {
+	c.mu.RLock()
+	poolStatsFunc := c.poolStatsFunc
+	c.mu.RUnlock()
+
+	var pools []PoolStats
+	activeConnections := 0
+	if poolStatsFunc != nil {
+		pools = poolStatsFunc()
+		for _, pool := range pools {
+			activeConnections += pool.OpenConnections
+		}
+		c.SetActiveConnections(activeConnections)
+		c.pools.set(pools)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if poolStatsFunc != nil {
+		c.poolStats = pools
+	}
+
+	if c.startTime.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	interval := now.Sub(c.windowStart)
+	if final && interval < c.interval/10 {
+		// A final window of a few milliseconds would report wildly skewed
+		// rates; its results remain part of the cumulative statistics
+		return
+	}
+	snapshot := IntervalSnapshot{
+		Timestamp:         now,
+		Elapsed:           now.Sub(c.startTime),
+		Interval:          interval,
+		ActiveUsers:       c.activeUsersCount,
+		ActiveConnections: activeConnections,
+		Pools:             pools,
+		Queries:           make(map[string]WindowSummary, len(c.window)),
+	}
+
+	total := NewHistogram()
+	var requests, errors int64
+	for name, window := range c.window {
+		snapshot.Queries[name] = newWindowSummary(window.requests, window.errors, window.latency, interval)
+		requests += window.requests
+		errors += window.errors
+		total.Merge(window.latency)
+
+		window.requests = 0
+		window.errors = 0
+		window.latency.Reset()
+	}
+	snapshot.Total = newWindowSummary(requests, errors, total, interval)
+
+	c.windowStart = now
+	c.snapshots = append(c.snapshots, snapshot)
+
+	if c.timeSeries != nil {
+		if err := c.timeSeries.Write(&snapshot); err != nil {
+			logrus.Errorf("Failed to write time series snapshot: %v", err)
+		}
+	}
+}
+
+// Snapshots returns the interval snapshots recorded so far
+func (c *Collector) Snapshots() []IntervalSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshots := make([]IntervalSnapshot, len(c.snapshots))
+	copy(snapshots, c.snapshots)
+	return snapshots
+}
+
+// stepStatus returns the status label of a step
+func stepStatus(success bool) string {
+	if success {
+		return StatusSuccess
+	}
+	return StatusError
+}
+
+// recordErrorClasses counts the error class of a failed result and the
+// classes of its retried attempts
+func (s *queryStats) recordErrorClasses(result *QueryResult) {
+	if !result.Success {
+		if s.errorClasses == nil {
+			s.errorClasses = make(map[string]int64)
+		}
+		s.errorClasses[result.ErrorClass]++
+	}
+	for _, class := range result.Retries {
+		if s.retries == nil {
+			s.retries = make(map[string]int64)
+		}
+		s.retries[class]++
+	}
+}
+
+// recordTransaction updates the outcome counts and per-step breakdown of a
+// transactional scenario result
+func (s *queryStats) recordTransaction(result *QueryResult) {
+	switch result.Outcome {
+	case OutcomeCommit:
+		s.commits++
+	case OutcomeRollback:
+		s.rollbacks++
+	}
+
+	for _, step := range result.Steps {
+		if s.steps == nil {
+			s.steps = make(map[string]*queryStats)
+		}
+		stepStats, ok := s.steps[step.Name]
+		if !ok {
+			stepStats = &queryStats{latency: NewHistogram()}
+			s.steps[step.Name] = stepStats
+		}
+		stepStats.totalQueries++
+		if step.Success {
+			stepStats.successfulQueries++
+		} else {
+			stepStats.failedQueries++
+		}
+		stepStats.totalDuration += step.Duration
+		stepStats.latency.Record(step.Duration)
+	}
+}
+
+// summary returns the reported view of the query stats
+func (s *queryStats) summary(withHistogram bool) QuerySummary {
+	summary := QuerySummary{
+		TotalQueries:      s.totalQueries,
+		SuccessfulQueries: s.successfulQueries,
+		FailedQueries:     s.failedQueries,
+		CanceledQueries:   s.canceledQueries,
+		TotalDuration:     s.totalDuration,
+		Latency:           s.latency.Summary(),
+	}
+	if s.totalQueries > 0 {
+		summary.AvgDuration = s.totalDuration / time.Duration(s.totalQueries)
+	}
+	if withHistogram {
+		summary.Histogram = s.latency.Snapshot()
+	}
+	summary.ErrorClasses = copyCounts(s.errorClasses)
+	summary.Retries = copyCounts(s.retries)
+	summary.Commits = s.commits
+	summary.Rollbacks = s.rollbacks
+	if len(s.steps) > 0 {
+		summary.Steps = make(map[string]QuerySummary, len(s.steps))
+		for name, stepStats := range s.steps {
+			summary.Steps[name] = stepStats.summary(withHistogram)
+		}
+	}
+	return summary
+}
+
+// copyCounts returns a copy of a count map, or nil if it is empty
+func copyCounts(counts map[string]int64) map[string]int64 {
+	if len(counts) == 0 {
+		return nil
+	}
+	copied := make(map[string]int64, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// ErrorClasses returns the errors and retries of all queries by error class,
+// most frequent first
+func (c *Collector) ErrorClasses() []ErrorClassCount {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var errors, retries map[string]int64
+	for _, stats := range c.stats {
+		errors = addCounts(errors, stats.errorClasses)
+		retries = addCounts(retries, stats.retries)
+	}
+	return errorClassCounts(errors, retries)
+}
+
+// ErrorClassCounts returns the errors and retries of the summary by error
+// class, most frequent first
+func (s QuerySummary) ErrorClassCounts() []ErrorClassCount {
+	return errorClassCounts(s.ErrorClasses, s.Retries)
+}
+
+// errorClassCounts combines error and retry counts by class, most frequent
+// first
+func errorClassCounts(errors, retries map[string]int64) []ErrorClassCount {
+	byClass := make(map[string]*ErrorClassCount)
+	count := func(class string) *ErrorClassCount {
+		if _, ok := byClass[class]; !ok {
+			byClass[class] = &ErrorClassCount{Class: class}
+		}
+		return byClass[class]
+	}
+	for class, n := range errors {
+		count(class).Errors += n
+	}
+	for class, n := range retries {
+		count(class).Retries += n
+	}
+
+	counts := make([]ErrorClassCount, 0, len(byClass))
+	for _, classCount := range byClass {
+		counts = append(counts, *classCount)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Errors != counts[j].Errors {
+			return counts[i].Errors > counts[j].Errors
+		}
+		if counts[i].Retries != counts[j].Retries {
+			return counts[i].Retries > counts[j].Retries
+		}
+		return counts[i].Class < counts[j].Class
+	})
+	return counts
 }
 
 // GetStats returns current statistics
@@ -210,14 +836,48 @@ func (c *Collector) GetStats() map[string]interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	return c.statsLocked(false)
+}
+
+// statsLocked builds the statistics map, the caller must hold c.mu
+func (c *Collector) statsLocked(withHistograms bool) map[string]interface{} {
 	stats := make(map[string]interface{})
-	for k, v := range c.stats {
-		stats[k] = v
+	for name, queryStats := range c.stats {
+		stats[name] = queryStats.summary(withHistograms)
 	}
 	stats["active_users"] = c.activeUsersCount
+	stats["dropped_iterations"] = c.droppedCount
+	stats["late_iterations"] = c.lateCount
 	return stats
 }
 
+// QuerySummaries returns the summary of every query by name
+func (c *Collector) QuerySummaries() map[string]QuerySummary {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	summaries := make(map[string]QuerySummary, len(c.stats))
+	for name, queryStats := range c.stats {
+		summaries[name] = queryStats.summary(false)
+	}
+	return summaries
+}
+
+// QueryHistograms returns a copy of the latency histogram of every query by
+// name, for merging with the results of other runs
+func (c *Collector) QueryHistograms() map[string]*Histogram {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	histograms := make(map[string]*Histogram, len(c.stats))
+	for name, queryStats := range c.stats {
+		histogram := NewHistogram()
+		histogram.Merge(queryStats.latency)
+		histograms[name] = histogram
+	}
+	return histograms
+}
+
 // PrintStats prints current statistics
 func (c *Collector) PrintStats() {
 	c.mu.RLock()
@@ -225,25 +885,77 @@ func (c *Collector) PrintStats() {
 
 	logrus.Info("=== Load Test Statistics ===")
 	logrus.Infof("Active Users: %d", c.activeUsersCount)
+	if c.droppedCount > 0 || c.lateCount > 0 {
+		logrus.Infof("Dropped Iterations: %d", c.droppedCount)
+		logrus.Infof("Late Iterations: %d", c.lateCount)
+	}
 
-	for queryName, queryStats := range c.stats {
-		if stats, ok := queryStats.(map[string]interface{}); ok {
-			logrus.Infof("Query: %s", queryName)
-			logrus.Infof("  Total Queries: %d", stats["total_queries"])
-			logrus.Infof("  Successful: %d", stats["successful_queries"])
-			logrus.Infof("  Failed: %d", stats["failed_queries"])
-			logrus.Infof("  Average Duration: %v", stats["avg_duration"])
+	names := make([]string, 0, len(c.stats))
+	for name := range c.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		summary := c.stats[name].summary(false)
+		latency := summary.Latency
+		logrus.Infof("Query: %s", name)
+		logrus.Infof("  Total Queries: %d", summary.TotalQueries)
+		logrus.Infof("  Successful: %d", summary.SuccessfulQueries)
+		logrus.Infof("  Failed: %d", summary.FailedQueries)
+		if summary.CanceledQueries > 0 {
+			logrus.Infof("  Canceled: %d", summary.CanceledQueries)
+		}
+		logrus.Infof("  Average Duration: %v", summary.AvgDuration)
+		logrus.Infof("  Latency: min=%v p50=%v p90=%v p95=%v p99=%v p99.9=%v max=%v stddev=%v",
+			latency.Min, latency.P50, latency.P90, latency.P95, latency.P99, latency.P999, latency.Max, latency.StdDev)
+		if len(summary.ErrorClasses) > 0 {
+			logrus.Infof("  Errors by class: %s", formatCounts(summary.ErrorClasses))
+		}
+		if len(summary.Retries) > 0 {
+			logrus.Infof("  Retries: %s", formatCounts(summary.Retries))
+		}
+		if summary.Commits > 0 || summary.Rollbacks > 0 {
+			logrus.Infof("  Commits: %d, Rollbacks: %d", summary.Commits, summary.Rollbacks)
+		}
+
+		steps := make([]string, 0, len(summary.Steps))
+		for step := range summary.Steps {
+			steps = append(steps, step)
+		}
+		sort.Strings(steps)
+		for _, step := range steps {
+			stepSummary := summary.Steps[step]
+			logrus.Infof("  Step %s: %d total, %d failed, p50=%v p95=%v p99=%v", step,
+				stepSummary.TotalQueries, stepSummary.FailedQueries,
+				stepSummary.Latency.P50, stepSummary.Latency.P95, stepSummary.Latency.P99)
 		}
 	}
 }
 
+// formatCounts formats a count map as "key=count" pairs sorted by key
+func formatCounts(counts map[string]int64) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%d", key, counts[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
 // collectMetrics collects and logs metrics
 func (c *Collector) collectMetrics() {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if c.outputFile != "" {
-		stats := c.GetStats()
+		// Histograms are included so results of several runs can be merged
+		stats := c.statsLocked(true)
 		data, err := json.MarshalIndent(stats, "", "  ")
 		if err != nil {
 			logrus.Errorf("Failed to marshal stats: %v", err)
@@ -258,6 +970,21 @@ func (c *Collector) collectMetrics() {
 
 // Close closes the metrics collector
 func (c *Collector) Close() {
-	close(c.stopChan)
-	logrus.Info("Metrics collector closed")
+	c.closeOnce.Do(func() {
+		close(c.stopChan)
+
+		// Record the final partial interval and write the final statistics
+		c.snapshotInterval(true)
+		c.collectMetrics()
+
+		c.mu.Lock()
+		if c.timeSeries != nil {
+			if err := c.timeSeries.Close(); err != nil {
+				logrus.Errorf("Failed to close time series file: %v", err)
+			}
+			c.timeSeries = nil
+		}
+		c.mu.Unlock()
+		logrus.Info("Metrics collector closed")
+	})
 }
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the text exposition of the collector's metrics
+func scrape(t *testing.T, c *Collector) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	c.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCollectorLabels(t *testing.T) {
+	c := NewCollector([]float64{0.001, 0.01})
+	c.RecordQuery(QueryResult{QueryName: "get_user", QueryType: "select", Success: true, Duration: 500 * time.Microsecond})
+	c.RecordQuery(QueryResult{QueryName: "get_user", QueryType: "select", Success: true, Duration: 5 * time.Millisecond})
+	c.RecordQuery(QueryResult{QueryName: "add_order", QueryType: "insert", Duration: time.Millisecond,
+		Error: "deadlock detected", ErrorClass: "deadlock", Retries: []string{"deadlock"}})
+	c.RecordQuery(QueryResult{QueryName: "add_order", QueryType: "insert", Canceled: true})
+
+	metrics := scrape(t, c)
+	for _, want := range []string{
+		`fiyuu_ktdb_queries_executed_total{query="get_user",status="success",type="select"} 2`,
+		`fiyuu_ktdb_queries_executed_total{query="add_order",status="error",type="insert"} 1`,
+		`fiyuu_ktdb_queries_executed_total{query="add_order",status="canceled",type="insert"} 1`,
+		`fiyuu_ktdb_query_duration_seconds_bucket{query="get_user",status="success",type="select",le="0.001"} 1`,
+		`fiyuu_ktdb_query_duration_seconds_bucket{query="get_user",status="success",type="select",le="0.01"} 2`,
+		`fiyuu_ktdb_errors_total{error_class="deadlock",query="add_order",type="insert"} 1`,
+		`fiyuu_ktdb_retries_total{error_class="deadlock",query="add_order",type="insert"} 1`,
+		`fiyuu_ktdb_successful_queries_total{query="get_user",type="select"} 2`,
+		`fiyuu_ktdb_failed_queries_total{query="add_order",type="insert"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+
+	// Canceled queries say nothing about the database's latency
+	if strings.Contains(metrics, `fiyuu_ktdb_query_duration_seconds_count{query="add_order",status="canceled"`) {
+		t.Error("canceled query observed in the duration histogram")
+	}
+}
+
+func TestCollectorsHaveOwnRegistries(t *testing.T) {
+	// Registering the same metrics twice on a shared registry panics
+	first := NewCollector(nil)
+	second := NewCollector(nil)
+	if first.Registry() == second.Registry() {
+		t.Fatal("collectors share a registry")
+	}
+
+	first.RecordQuery(QueryResult{QueryName: "first_only", QueryType: "select", Success: true, Duration: time.Millisecond})
+	second.RecordQuery(QueryResult{QueryName: "second_only", QueryType: "select", Success: true, Duration: time.Millisecond})
+
+	firstMetrics, secondMetrics := scrape(t, first), scrape(t, second)
+	if !strings.Contains(firstMetrics, `query="first_only"`) || strings.Contains(firstMetrics, `query="second_only"`) {
+		t.Error("first collector does not expose exactly its own queries")
+	}
+	if !strings.Contains(secondMetrics, `query="second_only"`) || strings.Contains(secondMetrics, `query="first_only"`) {
+		t.Error("second collector does not expose exactly its own queries")
+	}
+	if !strings.Contains(firstMetrics, "go_goroutines") {
+		t.Error("runtime metrics are not registered")
+	}
+}
@@ -0,0 +1,268 @@
+package metrics
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// histogramSignificantFigures is the value precision kept by every bucket
+	histogramSignificantFigures = 3
+	// histogramHighestValue is the largest trackable latency in microseconds
+	histogramHighestValue = int64(time.Hour / time.Microsecond)
+)
+
+// Histogram is an HDR-style latency histogram. Values are recorded in
+// microseconds into log-linear buckets that keep three significant figures,
+// so percentiles stay accurate from sub-millisecond to hour-long latencies
+// with a fixed memory footprint. Histograms are not safe for concurrent use.
+type Histogram struct {
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	counts                      []int64
+
+	totalCount int64
+	min        int64
+	max        int64
+	sum        float64
+	sumSquares float64
+}
+
+// HistogramSnapshot is a sparse, serializable copy of a histogram that can
+// be stored with run results and merged later
+type HistogramSnapshot struct {
+	Buckets    [][2]int64 `json:"buckets"` // [value in microseconds, count]
+	TotalCount int64      `json:"total_count"`
+	Min        int64      `json:"min"`
+	Max        int64      `json:"max"`
+	Sum        float64    `json:"sum"`
+	SumSquares float64    `json:"sum_squares"`
+}
+
+// LatencySummary holds the percentiles and moments of a histogram
+type LatencySummary struct {
+	Count  int64         `json:"count"`
+	Min    time.Duration `json:"min"`
+	Max    time.Duration `json:"max"`
+	Mean   time.Duration `json:"mean"`
+	StdDev time.Duration `json:"stddev"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P95    time.Duration `json:"p95"`
+	P99    time.Duration `json:"p99"`
+	P999   time.Duration `json:"p99_9"`
+}
+
+// NewHistogram creates an empty latency histogram
+func NewHistogram() *Histogram {
+	largestSingleUnit := 2 * int64(math.Pow10(histogramSignificantFigures))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	h := &Histogram{
+		subBucketHalfCountMagnitude: subBucketCountMagnitude - 1,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               subBucketCount - 1,
+	}
+
+	bucketCount := 1
+	for smallestUntrackable := subBucketCount; smallestUntrackable <= histogramHighestValue; smallestUntrackable <<= 1 {
+		bucketCount++
+	}
+	h.counts = make([]int64, int64(bucketCount+1)*h.subBucketHalfCount)
+
+	return h
+}
+
+// Record adds a latency observation
+func (h *Histogram) Record(d time.Duration) {
+	h.recordValue(int64(d/time.Microsecond), 1)
+
+	us := float64(d) / float64(time.Microsecond)
+	h.sum += us
+	h.sumSquares += us * us
+}
+
+// recordValue adds count observations of a value in microseconds to the buckets
+func (h *Histogram) recordValue(value, count int64) {
+	if value < 0 {
+		value = 0
+	}
+	if value > histogramHighestValue {
+		value = histogramHighestValue
+	}
+
+	h.counts[h.countsIndex(value)] += count
+	if h.totalCount == 0 || value < h.min {
+		h.min = value
+	}
+	if value > h.max {
+		h.max = value
+	}
+	h.totalCount += count
+}
+
+// Merge adds all observations of another histogram
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.totalCount == 0 {
+		return
+	}
+
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	if h.totalCount == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.totalCount += other.totalCount
+	h.sum += other.sum
+	h.sumSquares += other.sumSquares
+}
+
+// Reset removes all observations
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.totalCount = 0
+	h.min = 0
+	h.max = 0
+	h.sum = 0
+	h.sumSquares = 0
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() int64 {
+	return h.totalCount
+}
+
+// Percentile returns the latency at or below which the given percentage
+// (0-100) of observations fall
+func (h *Histogram) Percentile(percentile float64) time.Duration {
+	if h.totalCount == 0 {
+		return 0
+	}
+
+	percentile = math.Min(math.Max(percentile, 0), 100)
+	// The epsilon keeps rounding errors such as 99.9% of 1000 coming out as
+	// 999.0000000000001 from moving the target up a whole observation
+	target := int64(math.Ceil(percentile/100*float64(h.totalCount) - 1e-9))
+	if target < 1 {
+		target = 1
+	}
+
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= target {
+			value := h.highestEquivalentValue(h.valueFromIndex(i))
+			if value > h.max {
+				value = h.max
+			}
+			return time.Duration(value) * time.Microsecond
+		}
+	}
+
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// Summary returns the percentiles and moments of the histogram
+func (h *Histogram) Summary() LatencySummary {
+	summary := LatencySummary{
+		Count: h.totalCount,
+		P50:   h.Percentile(50),
+		P90:   h.Percentile(90),
+		P95:   h.Percentile(95),
+		P99:   h.Percentile(99),
+		P999:  h.Percentile(99.9),
+	}
+	if h.totalCount == 0 {
+		return summary
+	}
+
+	mean := h.sum / float64(h.totalCount)
+	variance := h.sumSquares/float64(h.totalCount) - mean*mean
+	if variance < 0 {
+		variance = 0
+	}
+
+	summary.Min = time.Duration(h.min) * time.Microsecond
+	summary.Max = time.Duration(h.max) * time.Microsecond
+	summary.Mean = time.Duration(mean * float64(time.Microsecond))
+	summary.StdDev = time.Duration(math.Sqrt(variance) * float64(time.Microsecond))
+	return summary
+}
+
+// Snapshot returns a sparse copy of the histogram
+func (h *Histogram) Snapshot() *HistogramSnapshot {
+	snapshot := &HistogramSnapshot{
+		TotalCount: h.totalCount,
+		Min:        h.min,
+		Max:        h.max,
+		Sum:        h.sum,
+		SumSquares: h.sumSquares,
+	}
+
+	for i, count := range h.counts {
+		if count > 0 {
+			snapshot.Buckets = append(snapshot.Buckets, [2]int64{h.valueFromIndex(i), count})
+		}
+	}
+
+	return snapshot
+}
+
+// HistogramFromSnapshot rebuilds a histogram from a snapshot
+func HistogramFromSnapshot(snapshot *HistogramSnapshot) *Histogram {
+	h := NewHistogram()
+	if snapshot == nil {
+		return h
+	}
+
+	for _, bucket := range snapshot.Buckets {
+		h.recordValue(bucket[0], bucket[1])
+	}
+	h.min = snapshot.Min
+	h.max = snapshot.Max
+	h.sum = snapshot.Sum
+	h.sumSquares = snapshot.SumSquares
+
+	return h
+}
+
+// countsIndex returns the bucket slot of a value
+func (h *Histogram) countsIndex(value int64) int {
+	bucketIndex := h.bucketIndex(value)
+	subBucketIndex := value >> uint(bucketIndex)
+	return int((int64(bucketIndex+1) << h.subBucketHalfCountMagnitude) + subBucketIndex - h.subBucketHalfCount)
+}
+
+// bucketIndex returns the power-of-two bucket of a value
+func (h *Histogram) bucketIndex(value int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(value|h.subBucketMask))
+	return pow2Ceiling - int(h.subBucketHalfCountMagnitude+1)
+}
+
+// valueFromIndex returns the lowest value counted in a bucket slot
+func (h *Histogram) valueFromIndex(index int) int64 {
+	bucketIndex := (index >> h.subBucketHalfCountMagnitude) - 1
+	subBucketIndex := int64(index)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= h.subBucketHalfCount
+		bucketIndex = 0
+	}
+	return subBucketIndex << uint(bucketIndex)
+}
+
+// highestEquivalentValue returns the largest value counted in the same slot
+func (h *Histogram) highestEquivalentValue(value int64) int64 {
+	bucketIndex := h.bucketIndex(value)
+	rangeSize := int64(1) << uint(bucketIndex)
+	lowest := (value >> uint(bucketIndex)) << uint(bucketIndex)
+	return lowest + rangeSize - 1
+}
@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+// durations returns count latencies from start, step apart
+func durations(start, step time.Duration, count int) []time.Duration {
+	values := make([]time.Duration, count)
+	for i := range values {
+		values[i] = start + time.Duration(i)*step
+	}
+	return values
+}
+
+// newHistogramOf returns a histogram of the latencies
+func newHistogramOf(values ...time.Duration) *Histogram {
+	h := NewHistogram()
+	for _, v := range values {
+		h.Record(v)
+	}
+	return h
+}
+
+// withinPrecision reports whether got is want to three significant figures
+func withinPrecision(got, want time.Duration) bool {
+	return math.Abs(float64(got-want)) <= float64(want)/1000
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	bimodal := append(durations(time.Millisecond, 0, 99), time.Second)
+
+	tests := []struct {
+		name       string
+		values     []time.Duration
+		percentile float64
+		want       time.Duration
+	}{
+		{name: "empty", percentile: 50, want: 0},
+		{name: "single", values: []time.Duration{5 * time.Millisecond}, percentile: 99, want: 5 * time.Millisecond},
+		{name: "uniform p0", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 0, want: time.Millisecond},
+		{name: "uniform p50", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 50, want: 500 * time.Millisecond},
+		{name: "uniform p90", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 90, want: 900 * time.Millisecond},
+		{name: "uniform p99", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 99, want: 990 * time.Millisecond},
+		{name: "uniform p99.9", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 99.9, want: 999 * time.Millisecond},
+		{name: "uniform p100", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 100, want: time.Second},
+		{name: "above 100 clamps", values: durations(time.Millisecond, time.Millisecond, 1000), percentile: 150, want: time.Second},
+		{name: "bimodal p99", values: bimodal, percentile: 99, want: time.Millisecond},
+		{name: "bimodal p99.9", values: bimodal, percentile: 99.9, want: time.Second},
+		{name: "sub-millisecond", values: []time.Duration{100 * time.Microsecond, 250 * time.Microsecond}, percentile: 100, want: 250 * time.Microsecond},
+		{name: "minutes", values: durations(time.Minute, time.Minute, 10), percentile: 50, want: 5 * time.Minute},
+		{name: "beyond an hour", values: []time.Duration{2 * time.Hour}, percentile: 50, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistogramOf(tt.values...)
+			if got := h.Percentile(tt.percentile); !withinPrecision(got, tt.want) {
+				t.Errorf("Percentile(%v) = %v, want %v", tt.percentile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []time.Duration
+		want   LatencySummary
+	}{
+		{name: "empty", want: LatencySummary{}},
+		{
+			name:   "two values",
+			values: []time.Duration{time.Millisecond, 3 * time.Millisecond},
+			want: LatencySummary{
+				Count: 2, Min: time.Millisecond, Max: 3 * time.Millisecond, Mean: 2 * time.Millisecond, StdDev: time.Millisecond,
+				P50: time.Millisecond, P90: 3 * time.Millisecond, P95: 3 * time.Millisecond, P99: 3 * time.Millisecond, P999: 3 * time.Millisecond,
+			},
+		},
+		{
+			name:   "constant",
+			values: durations(7*time.Millisecond, 0, 10),
+			want: LatencySummary{
+				Count: 10, Min: 7 * time.Millisecond, Max: 7 * time.Millisecond, Mean: 7 * time.Millisecond,
+				P50: 7 * time.Millisecond, P90: 7 * time.Millisecond, P95: 7 * time.Millisecond, P99: 7 * time.Millisecond, P999: 7 * time.Millisecond,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newHistogramOf(tt.values...).Summary(); got != tt.want {
+				t.Errorf("Summary() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	fast := durations(time.Millisecond, 10*time.Microsecond, 500)
+	slow := durations(100*time.Millisecond, time.Millisecond, 500)
+
+	merged := newHistogramOf(fast...)
+	merged.Merge(newHistogramOf(slow...))
+	merged.Merge(nil)
+	merged.Merge(NewHistogram())
+
+	want := newHistogramOf(append(fast, slow...)...)
+	if got := merged.Summary(); got != want.Summary() {
+		t.Errorf("merged Summary() = %+v, want %+v", got, want.Summary())
+	}
+
+	// Merging into an empty histogram takes the other's minimum
+	empty := NewHistogram()
+	empty.Merge(newHistogramOf(slow...))
+	if got := empty.Summary().Min; got != 100*time.Millisecond {
+		t.Errorf("Min after merging into an empty histogram = %v, want 100ms", got)
+	}
+}
+
+func TestHistogramSnapshotRoundTrip(t *testing.T) {
+	h := newHistogramOf(durations(500*time.Microsecond, 3*time.Millisecond, 1000)...)
+
+	data, err := json.Marshal(h.Snapshot())
+	if err != nil {
+		t.Fatalf("marshal snapshot: %v", err)
+	}
+	var snapshot HistogramSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("unmarshal snapshot: %v", err)
+	}
+
+	if got, want := HistogramFromSnapshot(&snapshot).Summary(), h.Summary(); got != want {
+		t.Errorf("Summary() after the round trip = %+v, want %+v", got, want)
+	}
+	if got := HistogramFromSnapshot(nil).Count(); got != 0 {
+		t.Errorf("Count() of a nil snapshot = %d, want 0", got)
+	}
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LoadQuerySummaries reads the query summaries of a run from its metrics
+// output file. Counters of the run that are not queries are skipped.
+func LoadQuerySummaries(filename string) (map[string]QuerySummary, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics file: %w", err)
+	}
+
+	var stats map[string]json.RawMessage
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics file %s: %w", filename, err)
+	}
+
+	summaries := make(map[string]QuerySummary, len(stats))
+	for name, raw := range stats {
+		var summary QuerySummary
+		if err := json.Unmarshal(raw, &summary); err != nil {
+			// active_users and the iteration counters are numbers
+			continue
+		}
+		if summary.Histogram == nil {
+			return nil, fmt.Errorf("metrics file %s: query %s has no latency histogram", filename, name)
+		}
+		summaries[name] = summary
+	}
+	return summaries, nil
+}
+
+// MergeQuerySummaries combines the results of one query from several runs.
+// Latency percentiles are recomputed from the merged histograms, so every
+// summary must carry its histogram.
+func MergeQuerySummaries(summaries ...QuerySummary) (QuerySummary, error) {
+	var merged QuerySummary
+	latency := NewHistogram()
+	steps := make(map[string][]QuerySummary)
+
+	for _, summary := range summaries {
+		if summary.Histogram == nil {
+			return QuerySummary{}, fmt.Errorf("summary has no latency histogram")
+		}
+		latency.Merge(HistogramFromSnapshot(summary.Histogram))
+
+		merged.TotalQueries += summary.TotalQueries
+		merged.SuccessfulQueries += summary.SuccessfulQueries
+		merged.FailedQueries += summary.FailedQueries
+		merged.CanceledQueries += summary.CanceledQueries
+		merged.TotalDuration += summary.TotalDuration
+		merged.Commits += summary.Commits
+		merged.Rollbacks += summary.Rollbacks
+		merged.ErrorClasses = addCounts(merged.ErrorClasses, summary.ErrorClasses)
+		merged.Retries = addCounts(merged.Retries, summary.Retries)
+
+		for name, step := range summary.Steps {
+			steps[name] = append(steps[name], step)
+		}
+	}
+
+	if merged.TotalQueries > 0 {
+		merged.AvgDuration = merged.TotalDuration / time.Duration(merged.TotalQueries)
+	}
+	merged.Latency = latency.Summary()
+	merged.Histogram = latency.Snapshot()
+
+	for name, stepSummaries := range steps {
+		step, err := MergeQuerySummaries(stepSummaries...)
+		if err != nil {
+			return QuerySummary{}, fmt.Errorf("step %s: %w", name, err)
+		}
+		if merged.Steps == nil {
+			merged.Steps = make(map[string]QuerySummary, len(steps))
+		}
+		merged.Steps[name] = step
+	}
+
+	return merged, nil
+}
+
+// addCounts adds the counts of from to to, allocating to if needed
+func addCounts(to, from map[string]int64) map[string]int64 {
+	for key, count := range from {
+		if to == nil {
+			to = make(map[string]int64, len(from))
+		}
+		to[key] += count
+	}
+	return to
+}
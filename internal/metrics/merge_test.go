@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeRun records the latencies of a query in a collector and writes its
+// metrics file, returning the file name
+func writeRun(t *testing.T, query string, failed int, latencies ...time.Duration) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "metrics.json")
+
+	c := NewCollector(nil)
+	c.SetOutputFile(filename)
+	for i, latency := range latencies {
+		result := QueryResult{QueryName: query, QueryType: "select", Success: i >= failed, Duration: latency, Timestamp: time.Now()}
+		if !result.Success {
+			result.Error = "deadlock"
+			result.ErrorClass = "deadlock"
+			result.Retries = []string{"deadlock"}
+		}
+		c.RecordQuery(result)
+	}
+	c.Close()
+	return filename
+}
+
+func TestLoadQuerySummaries(t *testing.T) {
+	filename := writeRun(t, "select_users", 1, durations(time.Millisecond, time.Millisecond, 10)...)
+
+	summaries, err := LoadQuerySummaries(filename)
+	if err != nil {
+		t.Fatalf("LoadQuerySummaries: %v", err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("LoadQuerySummaries returned %d queries, want only select_users: %v", len(summaries), summaries)
+	}
+	summary := summaries["select_users"]
+	if summary.TotalQueries != 10 || summary.FailedQueries != 1 || summary.Histogram == nil {
+		t.Errorf("select_users = %d total, %d failed, histogram %v; want 10, 1 and a histogram",
+			summary.TotalQueries, summary.FailedQueries, summary.Histogram != nil)
+	}
+
+	if _, err := LoadQuerySummaries(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadQuerySummaries of a missing file succeeded")
+	}
+}
+
+func TestMergeQuerySummaries(t *testing.T) {
+	fast := durations(time.Millisecond, time.Millisecond, 900)
+	slow := durations(901*time.Millisecond, time.Millisecond, 100)
+
+	var runs []QuerySummary
+	for _, filename := range []string{writeRun(t, "q", 2, fast...), writeRun(t, "q", 3, slow...)} {
+		summaries, err := LoadQuerySummaries(filename)
+		if err != nil {
+			t.Fatalf("LoadQuerySummaries: %v", err)
+		}
+		runs = append(runs, summaries["q"])
+	}
+
+	merged, err := MergeQuerySummaries(runs...)
+	if err != nil {
+		t.Fatalf("MergeQuerySummaries: %v", err)
+	}
+
+	if merged.TotalQueries != 1000 || merged.FailedQueries != 5 || merged.SuccessfulQueries != 995 {
+		t.Errorf("merged counts = %d total, %d failed, %d successful; want 1000, 5, 995",
+			merged.TotalQueries, merged.FailedQueries, merged.SuccessfulQueries)
+	}
+	if want := map[string]int64{"deadlock": 5}; !reflect.DeepEqual(merged.ErrorClasses, want) || !reflect.DeepEqual(merged.Retries, want) {
+		t.Errorf("merged error classes %v, retries %v; want %v", merged.ErrorClasses, merged.Retries, want)
+	}
+
+	// Percentiles span both runs, neither run alone has them
+	want := newHistogramOf(append(fast, slow...)...).Summary()
+	if !withinPrecision(merged.Latency.P50, want.P50) || !withinPrecision(merged.Latency.P95, want.P95) || !withinPrecision(merged.Latency.P99, want.P99) {
+		t.Errorf("merged p50/p95/p99 = %v/%v/%v, want %v/%v/%v",
+			merged.Latency.P50, merged.Latency.P95, merged.Latency.P99, want.P50, want.P95, want.P99)
+	}
+	if merged.Latency.Min != want.Min || merged.Latency.Max != want.Max {
+		t.Errorf("merged min/max = %v/%v, want %v/%v", merged.Latency.Min, merged.Latency.Max, want.Min, want.Max)
+	}
+
+	// The merged summary can be merged again
+	again, err := MergeQuerySummaries(merged, runs[0])
+	if err != nil {
+		t.Fatalf("MergeQuerySummaries of a merged summary: %v", err)
+	}
+	if again.TotalQueries != 1900 || again.Latency.Count != 1900 {
+		t.Errorf("merged again = %d total, %d latencies; want 1900", again.TotalQueries, again.Latency.Count)
+	}
+
+	if _, err := MergeQuerySummaries(QuerySummary{TotalQueries: 1}); err == nil {
+		t.Error("MergeQuerySummaries of a summary without histogram succeeded")
+	}
+}
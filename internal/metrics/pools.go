@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// PoolStats holds the aggregated sql.DBStats of one connection pool. Pools
+// made of several sql.DB, such as one per worker, report their sum.
+type PoolStats struct {
+	Name               string        `json:"name"`
+	Databases          int           `json:"databases"` // Number of sql.DB in the pool
+	MaxOpenConnections int           `json:"max_open_connections"`
+	OpenConnections    int           `json:"open_connections"`
+	InUse              int           `json:"in_use"`
+	Idle               int           `json:"idle"`
+	WaitCount          int64         `json:"wait_count"`
+	WaitDuration       time.Duration `json:"wait_duration"`
+	MaxIdleClosed      int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64         `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64         `json:"max_lifetime_closed"`
+}
+
+// poolLabels are the label names of connection pool metrics
+var poolLabels = []string{"pool"}
+
+// poolGauges exports connection pool statistics to Prometheus. Cumulative
+// sql.DBStats counters are exported as gauges because per-worker pools lose
+// their counts when a worker stops.
+type poolGauges struct {
+	maxOpen      *prometheus.GaugeVec
+	open         *prometheus.GaugeVec
+	inUse        *prometheus.GaugeVec
+	idle         *prometheus.GaugeVec
+	waitCount    *prometheus.GaugeVec
+	waitDuration *prometheus.GaugeVec
+}
+
+// newPoolGauges registers the connection pool metrics
+func newPoolGauges(factory promauto.Factory) *poolGauges {
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		return factory.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, poolLabels)
+	}
+	return &poolGauges{
+		maxOpen:      gauge("fiyuu_ktdb_pool_max_open_connections", "Maximum number of open connections of the pool"),
+		open:         gauge("fiyuu_ktdb_pool_open_connections", "Number of open connections of the pool"),
+		inUse:        gauge("fiyuu_ktdb_pool_in_use_connections", "Number of connections of the pool in use"),
+		idle:         gauge("fiyuu_ktdb_pool_idle_connections", "Number of idle connections of the pool"),
+		waitCount:    gauge("fiyuu_ktdb_pool_wait_count", "Number of times a query waited for a connection of the pool"),
+		waitDuration: gauge("fiyuu_ktdb_pool_wait_duration_seconds", "Total time queries waited for a connection of the pool"),
+	}
+}
+
+// set updates the gauges of every pool
+func (g *poolGauges) set(pools []PoolStats) {
+	for _, pool := range pools {
+		g.maxOpen.WithLabelValues(pool.Name).Set(float64(pool.MaxOpenConnections))
+		g.open.WithLabelValues(pool.Name).Set(float64(pool.OpenConnections))
+		g.inUse.WithLabelValues(pool.Name).Set(float64(pool.InUse))
+		g.idle.WithLabelValues(pool.Name).Set(float64(pool.Idle))
+		g.waitCount.WithLabelValues(pool.Name).Set(float64(pool.WaitCount))
+		g.waitDuration.WithLabelValues(pool.Name).Set(pool.WaitDuration.Seconds())
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import "time"
+
+// rollingSlotWidth is the time resolution of rolling windows
+const rollingSlotWidth = time.Second
+
+// rollingWindow keeps the results of the most recent seconds in a ring of
+// one-second slots. Latencies are stored as sparse histogram bucket counts so
+// a slot costs memory proportional to the distinct latencies it has seen.
+type rollingWindow struct {
+	slots []rollingSlot
+	index *Histogram // Provides the bucket layout, never records values
+}
+
+// rollingSlot holds the results of one second
+type rollingSlot struct {
+	second  int64 // Unix second the slot belongs to
+	queries map[string]*rollingStats
+}
+
+// rollingStats holds the results of one query within a slot
+type rollingStats struct {
+	requests   int64
+	errors     int64
+	sum        float64
+	sumSquares float64
+	buckets    map[int]int64 // Histogram bucket slot -> count
+}
+
+// newRollingWindow creates a rolling window covering at least span
+func newRollingWindow(span time.Duration) *rollingWindow {
+	size := int(span/rollingSlotWidth) + 2
+	return &rollingWindow{
+		slots: make([]rollingSlot, size),
+		index: NewHistogram(),
+	}
+}
+
+// record adds a query result at the given time
+func (w *rollingWindow) record(now time.Time, result *QueryResult) {
+	second := now.Unix()
+	slot := &w.slots[second%int64(len(w.slots))]
+	if slot.second != second || slot.queries == nil {
+		// The slot still holds a second that left the window
+		slot.second = second
+		slot.queries = make(map[string]*rollingStats)
+	}
+
+	stats, ok := slot.queries[result.QueryName]
+	if !ok {
+		stats = &rollingStats{buckets: make(map[int]int64)}
+		slot.queries[result.QueryName] = stats
+	}
+
+	stats.requests++
+	if !result.Success {
+		stats.errors++
+	}
+
+	us := result.Duration.Microseconds()
+	if us < 0 {
+		us = 0
+	}
+	if us > histogramHighestValue {
+		us = histogramHighestValue
+	}
+	stats.buckets[w.index.countsIndex(us)]++
+	value := float64(result.Duration) / float64(time.Microsecond)
+	stats.sum += value
+	stats.sumSquares += value * value
+}
+
+// input returns the results of a query, or of all queries if query is empty,
+// within the window ending now
+func (w *rollingWindow) input(now time.Time, window time.Duration, query string) *ThresholdInput {
+	requests, errors, latency := w.results(now, window, query)
+	return &ThresholdInput{
+		Requests: requests,
+		Errors:   errors,
+		Latency:  latency.Summary(),
+		Elapsed:  window,
+	}
+}
+
+// results returns the request and error counts and the latencies of a query,
+// or of all queries if query is empty, within the window ending now
+func (w *rollingWindow) results(now time.Time, window time.Duration, query string) (int64, int64, *Histogram) {
+	var requests, errors int64
+	latency := NewHistogram()
+
+	newest := now.Unix()
+	oldest := newest - int64(window/rollingSlotWidth)
+	for i := range w.slots {
+		slot := &w.slots[i]
+		if slot.queries == nil || slot.second <= oldest || slot.second > newest {
+			continue
+		}
+		for name, stats := range slot.queries {
+			if query != "" && name != query {
+				continue
+			}
+			requests += stats.requests
+			errors += stats.errors
+			for index, count := range stats.buckets {
+				latency.recordValue(latency.valueFromIndex(index), count)
+			}
+			latency.sum += stats.sum
+			latency.sumSquares += stats.sumSquares
+		}
+	}
+
+	return requests, errors, latency
+}
+
+// span returns the longest window the rolling window can evaluate
+func (w *rollingWindow) span() time.Duration {
+	return time.Duration(len(w.slots)-2) * rollingSlotWidth
+}
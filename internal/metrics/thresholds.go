@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Threshold metric names
+const (
+	ThresholdP50       = "p50"
+	ThresholdP90       = "p90"
+	ThresholdP95       = "p95"
+	ThresholdP99       = "p99"
+	ThresholdP999      = "p99.9"
+	ThresholdAvg       = "avg"
+	ThresholdMin       = "min"
+	ThresholdMax       = "max"
+	ThresholdErrorRate = "error_rate"
+	ThresholdQPS       = "qps"
+	ThresholdRequests  = "requests"
+	ThresholdErrors    = "errors"
+)
+
+// thresholdMetricAliases maps accepted spellings to metric names
+var thresholdMetricAliases = map[string]string{
+	"p50":        ThresholdP50,
+	"median":     ThresholdP50,
+	"p90":        ThresholdP90,
+	"p95":        ThresholdP95,
+	"p99":        ThresholdP99,
+	"p99.9":      ThresholdP999,
+	"p999":       ThresholdP999,
+	"avg":        ThresholdAvg,
+	"mean":       ThresholdAvg,
+	"min":        ThresholdMin,
+	"max":        ThresholdMax,
+	"error_rate": ThresholdErrorRate,
+	"qps":        ThresholdQPS,
+	"rps":        ThresholdQPS,
+	"throughput": ThresholdQPS,
+	"requests":   ThresholdRequests,
+	"count":      ThresholdRequests,
+	"errors":     ThresholdErrors,
+}
+
+// thresholdOperators are checked longest first so "<=" is not read as "<"
+var thresholdOperators = []string{"<=", ">=", "==", "<", ">"}
+
+// Threshold is a pass/fail condition on the results of all queries or of a
+// single query, for example "p95(select_users) < 50ms" or "error_rate < 1%"
+type Threshold struct {
+	Expression string
+	Metric     string
+	Query      string // Empty for all queries
+	Operator   string
+	Value      float64 // Nanoseconds for latencies, percent for error_rate
+}
+
+// ThresholdResult is the outcome of evaluating a threshold
+type ThresholdResult struct {
+	Expression string `json:"expression"`
+	Actual     string `json:"actual"`
+	Passed     bool   `json:"passed"`
+	NoData     bool   `json:"no_data,omitempty"` // No observations to evaluate a latency limit
+}
+
+// ThresholdInput holds the results a threshold is evaluated against
+type ThresholdInput struct {
+	Requests int64
+	Errors   int64
+	Latency  LatencySummary
+	Elapsed  time.Duration
+}
+
+// ParseThreshold parses an expression of the form
+// "metric[(query)] operator value"
+func ParseThreshold(expression string) (*Threshold, error) {
+	expr := strings.TrimSpace(expression)
+
+	var operator string
+	index := -1
+	for _, op := range thresholdOperators {
+		if i := strings.Index(expr, op); i >= 0 {
+			operator, index = op, i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("threshold %q: missing comparison operator", expression)
+	}
+
+	left := strings.TrimSpace(expr[:index])
+	right := strings.TrimSpace(expr[index+len(operator):])
+	if left == "" || right == "" {
+		return nil, fmt.Errorf("threshold %q: expected metric %s value", expression, operator)
+	}
+
+	t := &Threshold{Expression: expr, Operator: operator}
+
+	name := left
+	if open := strings.IndexByte(left, '('); open >= 0 {
+		if !strings.HasSuffix(left, ")") {
+			return nil, fmt.Errorf("threshold %q: unbalanced parentheses", expression)
+		}
+		name = strings.TrimSpace(left[:open])
+		t.Query = strings.TrimSpace(left[open+1 : len(left)-1])
+		if t.Query == "" {
+			return nil, fmt.Errorf("threshold %q: empty query name", expression)
+		}
+	}
+
+	metric, ok := thresholdMetricAliases[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("threshold %q: unknown metric %s", expression, name)
+	}
+	t.Metric = metric
+
+	value, err := parseThresholdValue(metric, right)
+	if err != nil {
+		return nil, fmt.Errorf("threshold %q: %w", expression, err)
+	}
+	t.Value = value
+
+	return t, nil
+}
+
+// parseThresholdValue parses the limit of a metric into its base unit
+func parseThresholdValue(metric, value string) (float64, error) {
+	switch {
+	case isLatencyMetric(metric):
+		if d, err := time.ParseDuration(value); err == nil {
+			return float64(d), nil
+		}
+		// A bare number is milliseconds
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		return ms * float64(time.Millisecond), nil
+
+	case metric == ThresholdErrorRate:
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage: %s", value)
+		}
+		return percent, nil
+
+	default:
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value, "/s"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %s", value)
+		}
+		return number, nil
+	}
+}
+
+// isLatencyMetric reports whether a metric is measured in time
+func isLatencyMetric(metric string) bool {
+	switch metric {
+	case ThresholdP50, ThresholdP90, ThresholdP95, ThresholdP99, ThresholdP999,
+		ThresholdAvg, ThresholdMin, ThresholdMax:
+		return true
+	default:
+		return false
+	}
+}
+
+// Evaluate checks the threshold against the given results
+func (t *Threshold) Evaluate(input *ThresholdInput) ThresholdResult {
+	result := ThresholdResult{Expression: t.Expression}
+
+	if isLatencyMetric(t.Metric) && input.Latency.Count == 0 {
+		// A latency limit cannot pass without a single observation
+		result.Actual = "no data"
+		result.NoData = true
+		return result
+	}
+
+	actual := t.actual(input)
+	result.Actual = t.format(actual)
+
+	switch t.Operator {
+	case "<":
+		result.Passed = actual < t.Value
+	case "<=":
+		result.Passed = actual <= t.Value
+	case ">":
+		result.Passed = actual > t.Value
+	case ">=":
+		result.Passed = actual >= t.Value
+	case "==":
+		result.Passed = actual == t.Value
+	}
+	return result
+}
+
+// actual returns the measured value of the threshold metric
+func (t *Threshold) actual(input *ThresholdInput) float64 {
+	latency := input.Latency
+
+	switch t.Metric {
+	case ThresholdP50:
+		return float64(latency.P50)
+	case ThresholdP90:
+		return float64(latency.P90)
+	case ThresholdP95:
+		return float64(latency.P95)
+	case ThresholdP99:
+		return float64(latency.P99)
+	case ThresholdP999:
+		return float64(latency.P999)
+	case ThresholdAvg:
+		return float64(latency.Mean)
+	case ThresholdMin:
+		return float64(latency.Min)
+	case ThresholdMax:
+		return float64(latency.Max)
+	case ThresholdErrorRate:
+		if input.Requests == 0 {
+			return 0
+		}
+		return float64(input.Errors) / float64(input.Requests) * 100
+	case ThresholdQPS:
+		if input.Elapsed <= 0 {
+			return 0
+		}
+		return float64(input.Requests) / input.Elapsed.Seconds()
+	case ThresholdRequests:
+		return float64(input.Requests)
+	case ThresholdErrors:
+		return float64(input.Errors)
+	default:
+		return 0
+	}
+}
+
+// format formats a measured value in the unit of the threshold metric
+func (t *Threshold) format(value float64) string {
+	switch {
+	case isLatencyMetric(t.Metric):
+		return time.Duration(value).Round(time.Microsecond).String()
+	case t.Metric == ThresholdErrorRate:
+		return fmt.Sprintf("%.2f%%", value)
+	case t.Metric == ThresholdQPS:
+		return fmt.Sprintf("%.2f/s", value)
+	default:
+		return fmt.Sprintf("%.0f", value)
+	}
+}
+
+// EvaluateThresholds checks thresholds against the cumulative results of the
+// run. Thresholds without a query are evaluated against all queries merged.
+func (c *Collector) EvaluateThresholds(thresholds []*Threshold, elapsed time.Duration) []ThresholdResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	total := &ThresholdInput{Elapsed: elapsed}
+	merged := NewHistogram()
+	for _, stats := range c.stats {
+		total.Requests += stats.totalQueries
+		total.Errors += stats.failedQueries
+		merged.Merge(stats.latency)
+	}
+	total.Latency = merged.Summary()
+
+	results := make([]ThresholdResult, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		input := total
+		if threshold.Query != "" {
+			input = &ThresholdInput{Elapsed: elapsed}
+			if stats, ok := c.stats[threshold.Query]; ok {
+				input.Requests = stats.totalQueries
+				input.Errors = stats.failedQueries
+				input.Latency = stats.latency.Summary()
+			}
+		}
+		results = append(results, threshold.Evaluate(input))
+	}
+	return results
+}
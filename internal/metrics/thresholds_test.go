@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       Threshold // Expression is not compared
+		wantErr    bool
+	}{
+		{expression: "p95 < 50ms", want: Threshold{Metric: ThresholdP95, Operator: "<", Value: float64(50 * time.Millisecond)}},
+		{expression: " p99.9<=1.5s ", want: Threshold{Metric: ThresholdP999, Operator: "<=", Value: float64(1500 * time.Millisecond)}},
+		{expression: "p999 < 2s", want: Threshold{Metric: ThresholdP999, Operator: "<", Value: float64(2 * time.Second)}},
+		{expression: "MEDIAN < 20", want: Threshold{Metric: ThresholdP50, Operator: "<", Value: float64(20 * time.Millisecond)}},
+		{expression: "avg < 0.5", want: Threshold{Metric: ThresholdAvg, Operator: "<", Value: float64(500 * time.Microsecond)}},
+		{expression: "p95(select_users) < 50ms", want: Threshold{Metric: ThresholdP95, Query: "select_users", Operator: "<", Value: float64(50 * time.Millisecond)}},
+		{expression: "max( slow query ) >= 1m", want: Threshold{Metric: ThresholdMax, Query: "slow query", Operator: ">=", Value: float64(time.Minute)}},
+		{expression: "error_rate < 1%", want: Threshold{Metric: ThresholdErrorRate, Operator: "<", Value: 1}},
+		{expression: "error_rate <= 0.5", want: Threshold{Metric: ThresholdErrorRate, Operator: "<=", Value: 0.5}},
+		{expression: "rps > 100/s", want: Threshold{Metric: ThresholdQPS, Operator: ">", Value: 100}},
+		{expression: "throughput >= 1e3", want: Threshold{Metric: ThresholdQPS, Operator: ">=", Value: 1000}},
+		{expression: "count(insert) > 10", want: Threshold{Metric: ThresholdRequests, Query: "insert", Operator: ">", Value: 10}},
+		{expression: "errors == 0", want: Threshold{Metric: ThresholdErrors, Operator: "==", Value: 0}},
+
+		{expression: "p95 50ms", wantErr: true},
+		{expression: "p95 <", wantErr: true},
+		{expression: "< 50ms", wantErr: true},
+		{expression: "p42 < 50ms", wantErr: true},
+		{expression: "p95 < fast", wantErr: true},
+		{expression: "error_rate < high%", wantErr: true},
+		{expression: "qps > many", wantErr: true},
+		{expression: "p95(select_users < 50ms", wantErr: true},
+		{expression: "p95() < 50ms", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			got, err := ParseThreshold(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseThreshold(%q) = %+v, want an error", tt.expression, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseThreshold(%q): %v", tt.expression, err)
+			}
+			tt.want.Expression = got.Expression
+			if *got != tt.want {
+				t.Errorf("ParseThreshold(%q) = %+v, want %+v", tt.expression, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholdEvaluate(t *testing.T) {
+	input := &ThresholdInput{
+		Requests: 200,
+		Errors:   3,
+		Elapsed:  10 * time.Second,
+		Latency:  LatencySummary{Count: 197, P95: 40 * time.Millisecond, Max: 2 * time.Second},
+	}
+
+	tests := []struct {
+		expression string
+		input      *ThresholdInput
+		wantPassed bool
+		wantActual string
+		wantNoData bool
+	}{
+		{expression: "p95 < 50ms", input: input, wantPassed: true, wantActual: "40ms"},
+		{expression: "p95 < 40ms", input: input, wantPassed: false, wantActual: "40ms"},
+		{expression: "p95 <= 40ms", input: input, wantPassed: true, wantActual: "40ms"},
+		{expression: "max > 1s", input: input, wantPassed: true, wantActual: "2s"},
+		{expression: "error_rate < 1%", input: input, wantPassed: false, wantActual: "1.50%"},
+		{expression: "error_rate < 2%", input: input, wantPassed: true, wantActual: "1.50%"},
+		{expression: "qps >= 20", input: input, wantPassed: true, wantActual: "20.00/s"},
+		{expression: "requests == 200", input: input, wantPassed: true, wantActual: "200"},
+		{expression: "errors == 0", input: input, wantPassed: false, wantActual: "3"},
+
+		// Without results latency limits fail, counts and rates are zero
+		{expression: "p95 < 50ms", input: &ThresholdInput{}, wantActual: "no data", wantNoData: true},
+		{expression: "error_rate < 1%", input: &ThresholdInput{}, wantPassed: true, wantActual: "0.00%"},
+		{expression: "qps > 0", input: &ThresholdInput{}, wantPassed: false, wantActual: "0.00/s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			threshold, err := ParseThreshold(tt.expression)
+			if err != nil {
+				t.Fatalf("ParseThreshold(%q): %v", tt.expression, err)
+			}
+			got := threshold.Evaluate(tt.input)
+			if got.Passed != tt.wantPassed || got.Actual != tt.wantActual || got.NoData != tt.wantNoData {
+				t.Errorf("Evaluate = %+v, want passed %v, actual %q, no data %v", got, tt.wantPassed, tt.wantActual, tt.wantNoData)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of the CSV rows. The query column of the total row is empty, so no
+// query name can be mistaken for it.
+const (
+	ScopeTotal = "total" // Aggregate of every query
+	ScopeQuery = "query" // One query or scenario
+)
+
+// windowStats holds the results of one query within the current interval
+type windowStats struct {
+	requests int64
+	errors   int64
+	latency  *Histogram
+}
+
+// WindowSummary is the reported view of one query's results in an interval
+type WindowSummary struct {
+	Requests   int64          `json:"requests"`
+	Errors     int64          `json:"errors"`
+	Throughput float64        `json:"throughput"` // Requests per second
+	ErrorRate  float64        `json:"error_rate"` // Percentage of failed requests
+	Latency    LatencySummary `json:"latency"`
+}
+
+// IntervalSnapshot holds the results of a single collection interval
+type IntervalSnapshot struct {
+	Timestamp         time.Time                `json:"timestamp"`
+	Elapsed           time.Duration            `json:"elapsed"`
+	Interval          time.Duration            `json:"interval"`
+	ActiveUsers       int                      `json:"active_users"`
+	ActiveConnections int                      `json:"active_connections"`
+	Total             WindowSummary            `json:"total"`
+	Queries           map[string]WindowSummary `json:"queries"`
+	Pools             []PoolStats              `json:"pools,omitempty"`
+}
+
+// newWindowSummary builds the reported view of a window
+func newWindowSummary(requests, errors int64, latency *Histogram, interval time.Duration) WindowSummary {
+	summary := WindowSummary{
+		Requests: requests,
+		Errors:   errors,
+		Latency:  latency.Summary(),
+	}
+	if seconds := interval.Seconds(); seconds > 0 {
+		summary.Throughput = float64(requests) / seconds
+	}
+	if requests > 0 {
+		summary.ErrorRate = float64(errors) / float64(requests) * 100
+	}
+	return summary
+}
+
+// timeSeriesWriter appends interval snapshots to a JSONL or CSV file
+type timeSeriesWriter struct {
+	file   *os.File
+	format string
+	csv    *csv.Writer
+}
+
+// timeSeriesColumns are the CSV columns, latencies are in milliseconds
+var timeSeriesColumns = []string{
+	"timestamp", "elapsed_s", "scope", "query", "requests", "errors", "throughput", "error_rate",
+	"p50_ms", "p90_ms", "p95_ms", "p99_ms", "p99_9_ms", "max_ms", "active_users", "active_connections",
+}
+
+// newTimeSeriesWriter creates the time series file, replacing any previous run
+func newTimeSeriesWriter(filename, format string) (*timeSeriesWriter, error) {
+	if format == "" {
+		if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+			format = "csv"
+		} else {
+			format = "jsonl"
+		}
+	}
+	if format != "csv" && format != "jsonl" {
+		return nil, fmt.Errorf("unsupported time series format: %s", format)
+	}
+
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create time series directory: %w", err)
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create time series file: %w", err)
+	}
+
+	w := &timeSeriesWriter{file: file, format: format}
+	if format == "csv" {
+		w.csv = csv.NewWriter(file)
+		if err := w.csv.Write(timeSeriesColumns); err != nil {
+			file.Close()
+			return nil, err
+		}
+		w.csv.Flush()
+	}
+
+	return w, nil
+}
+
+// Write appends one snapshot
+func (w *timeSeriesWriter) Write(snapshot *IntervalSnapshot) error {
+	if w.format == "jsonl" {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		_, err = w.file.Write(append(data, '\n'))
+		return err
+	}
+
+	names := make([]string, 0, len(snapshot.Queries))
+	for name := range snapshot.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := w.csv.Write(w.csvRecord(snapshot, ScopeTotal, "", snapshot.Total)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := w.csv.Write(w.csvRecord(snapshot, ScopeQuery, name, snapshot.Queries[name])); err != nil {
+			return err
+		}
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// csvRecord formats one query row of a snapshot
+func (w *timeSeriesWriter) csvRecord(snapshot *IntervalSnapshot, scope, query string, summary WindowSummary) []string {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	return []string{
+		snapshot.Timestamp.Format(time.RFC3339),
+		strconv.FormatFloat(snapshot.Elapsed.Seconds(), 'f', 1, 64),
+		scope,
+		query,
+		strconv.FormatInt(summary.Requests, 10),
+		strconv.FormatInt(summary.Errors, 10),
+		strconv.FormatFloat(summary.Throughput, 'f', 2, 64),
+		strconv.FormatFloat(summary.ErrorRate, 'f', 2, 64),
+		ms(summary.Latency.P50),
+		ms(summary.Latency.P90),
+		ms(summary.Latency.P95),
+		ms(summary.Latency.P99),
+		ms(summary.Latency.P999),
+		ms(summary.Latency.Max),
+		strconv.Itoa(snapshot.ActiveUsers),
+		strconv.Itoa(snapshot.ActiveConnections),
+	}
+}
+
+// Close closes the time series file
+func (w *timeSeriesWriter) Close() error {
+	if w.csv != nil {
+		w.csv.Flush()
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewWindowSummary(t *testing.T) {
+	tests := []struct {
+		name           string
+		requests       int64
+		errors         int64
+		interval       time.Duration
+		wantThroughput float64
+		wantErrorRate  float64
+	}{
+		{name: "idle", interval: time.Second},
+		{name: "no errors", requests: 50, interval: 5 * time.Second, wantThroughput: 10},
+		{name: "errors", requests: 200, errors: 50, interval: 2 * time.Second, wantThroughput: 100, wantErrorRate: 25},
+		{name: "no interval", requests: 10, errors: 10, wantErrorRate: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := newWindowSummary(tt.requests, tt.errors, NewHistogram(), tt.interval)
+			if summary.Throughput != tt.wantThroughput || summary.ErrorRate != tt.wantErrorRate {
+				t.Errorf("throughput %v, error rate %v; want %v, %v", summary.Throughput, summary.ErrorRate, tt.wantThroughput, tt.wantErrorRate)
+			}
+		})
+	}
+}
+
+// testSnapshot is an interval with a total and two queries, one of them
+// named like the total scope
+func testSnapshot() *IntervalSnapshot {
+	latency := newHistogramOf(2*time.Millisecond, 4*time.Millisecond)
+	return &IntervalSnapshot{
+		Timestamp:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Elapsed:           10 * time.Second,
+		Interval:          time.Second,
+		ActiveUsers:       4,
+		ActiveConnections: 3,
+		Total:             newWindowSummary(2, 1, latency, time.Second),
+		Queries: map[string]WindowSummary{
+			"total":   newWindowSummary(1, 0, newHistogramOf(2*time.Millisecond), time.Second),
+			"reports": newWindowSummary(1, 1, newHistogramOf(4*time.Millisecond), time.Second),
+		},
+	}
+}
+
+func TestTimeSeriesWriterCSV(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "series.csv")
+	w, err := newTimeSeriesWriter(filename, "")
+	if err != nil {
+		t.Fatalf("newTimeSeriesWriter: %v", err)
+	}
+	if err := w.Write(testSnapshot()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("read CSV: %v", err)
+	}
+
+	// The total comes first, then the queries by name
+	want := [][]string{
+		timeSeriesColumns,
+		{"2024-01-02T03:04:05Z", "10.0", ScopeTotal, "", "2", "1", "2.00", "50.00", "2.000", "4.000", "4.000", "4.000", "4.000", "4.000", "4", "3"},
+		{"2024-01-02T03:04:05Z", "10.0", ScopeQuery, "reports", "1", "1", "1.00", "100.00", "4.000", "4.000", "4.000", "4.000", "4.000", "4.000", "4", "3"},
+		{"2024-01-02T03:04:05Z", "10.0", ScopeQuery, "total", "1", "0", "1.00", "0.00", "2.000", "2.000", "2.000", "2.000", "2.000", "2.000", "4", "3"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CSV records:\n%q\nwant:\n%q", records, want)
+	}
+}
+
+func TestTimeSeriesWriterJSONL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "series.jsonl")
+	w, err := newTimeSeriesWriter(filename, "")
+	if err != nil {
+		t.Fatalf("newTimeSeriesWriter: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.Write(testSnapshot()); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+		var snapshot IntervalSnapshot
+		if err := json.Unmarshal(scanner.Bytes(), &snapshot); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if snapshot.Total.Requests != 2 || len(snapshot.Queries) != 2 {
+			t.Errorf("line %d: total %d requests, %d queries; want 2, 2", lines+1, snapshot.Total.Requests, len(snapshot.Queries))
+		}
+	}
+	if lines != 3 {
+		t.Errorf("%d lines, want 3", lines)
+	}
+}
+
+func TestNewTimeSeriesWriterFormat(t *testing.T) {
+	if _, err := newTimeSeriesWriter(filepath.Join(t.TempDir(), "series.txt"), "xml"); err == nil {
+		t.Error("newTimeSeriesWriter with format xml succeeded")
+	}
+}
@@ -0,0 +1,119 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// Chart geometry in SVG user units
+const (
+	chartWidth   = 640
+	chartHeight  = 260
+	chartLeft    = 56
+	chartRight   = 16
+	chartTop     = 32
+	chartBottom  = 48
+	chartGridLen = 5
+)
+
+// chartColors are assigned to series in order
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+// series is one line of a chart
+type series struct {
+	Name   string
+	Values []float64
+}
+
+// lineChart renders series sharing the x values (seconds since start) as an
+// inline SVG line chart
+func lineChart(title, unit string, xs []float64, lines []series) template.HTML {
+	plotWidth := float64(chartWidth - chartLeft - chartRight)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+
+	maxX := 0.0
+	for _, x := range xs {
+		maxX = math.Max(maxX, x)
+	}
+	if maxX == 0 {
+		maxX = 1
+	}
+	maxY := 0.0
+	for _, line := range lines {
+		for _, y := range line.Values {
+			maxY = math.Max(maxY, y)
+		}
+	}
+	maxY = niceCeil(maxY)
+
+	px := func(x float64) float64 { return chartLeft + x/maxX*plotWidth }
+	py := func(y float64) float64 { return chartTop + plotHeight - y/maxY*plotHeight }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="18" font-size="14" font-weight="600">%s</text>`, chartLeft, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<text x="%d" y="18" text-anchor="end" fill="#666">%s</text>`, chartWidth-chartRight, template.HTMLEscapeString(unit))
+
+	// Horizontal grid with y labels
+	for i := 0; i <= chartGridLen; i++ {
+		y := maxY * float64(i) / chartGridLen
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#e5e5e5"/>`,
+			chartLeft, py(y), chartWidth-chartRight, py(y))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" fill="#666">%s</text>`,
+			chartLeft-6, py(y)+4, formatTick(y))
+	}
+
+	// X labels at start, middle and end
+	for _, x := range []float64{0, maxX / 2, maxX} {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" fill="#666">%ss</text>`,
+			px(x), chartTop+int(plotHeight)+16, formatTick(x))
+	}
+
+	for i, line := range lines {
+		color := chartColors[i%len(chartColors)]
+		points := make([]string, 0, len(line.Values))
+		for j, y := range line.Values {
+			if j < len(xs) {
+				points = append(points, fmt.Sprintf("%.1f,%.1f", px(xs[j]), py(y)))
+			}
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, color, strings.Join(points, " "))
+
+		// Legend below the plot
+		lx := chartLeft + (i%4)*140
+		ly := chartHeight - 18 + (i/4)*14
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, lx, ly-9, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, lx+14, ly, template.HTMLEscapeString(line.Name))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// niceCeil rounds a chart maximum up to 1, 2 or 5 times a power of ten
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if v <= step*magnitude {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatTick formats an axis label without needless decimals
+func formatTick(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	if v >= 10 {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2g", v)
+}
@@ -0,0 +1,364 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// topErrorCount is the number of error messages listed in the report
+const topErrorCount = 20
+
+// totalSeriesName labels the aggregate of every query in the throughput chart
+const totalSeriesName = "(all queries)"
+
+// Data holds the results of a finished run rendered into the report
+type Data struct {
+	Title     string
+	StartTime time.Time
+	EndTime   time.Time
+	Executor  string
+	Aborted   string // Reason the circuit breaker aborted the run
+
+	// Metrics files of the runs a merged report combines
+	Sources []string
+
+	// Loaded settings with secrets masked
+	Settings map[string]interface{}
+
+	// Configured scaling steps, with achieved rates for arrival rate stages
+	Timeline []TimelineStep
+
+	// Pass/fail outcome of the configured thresholds
+	Thresholds []metrics.ThresholdResult
+
+	Snapshots       []metrics.IntervalSnapshot
+	Queries         map[string]metrics.QuerySummary
+	Total           metrics.QuerySummary
+	Pools           []metrics.PoolStats
+	ErrorClasses    []metrics.ErrorClassCount
+	Errors          []metrics.ErrorCount
+	UntrackedErrors int64
+}
+
+// TimelineStep is one row of the scaling timeline
+type TimelineStep struct {
+	Offset      time.Duration
+	Ramp        time.Duration
+	Target      string
+	Description string
+	Result      string
+}
+
+// FromCollector creates report data from the results of a collector
+func FromCollector(collector *metrics.Collector) *Data {
+	data := &Data{
+		Snapshots: collector.Snapshots(),
+		Queries:   collector.QuerySummaries(),
+	}
+	data.Pools = collector.PoolStats()
+	data.ErrorClasses = collector.ErrorClasses()
+	data.Errors, data.UntrackedErrors = collector.TopErrors(topErrorCount)
+
+	total := metrics.NewHistogram()
+	for _, histogram := range collector.QueryHistograms() {
+		total.Merge(histogram)
+	}
+	for _, summary := range data.Queries {
+		data.Total.TotalQueries += summary.TotalQueries
+		data.Total.SuccessfulQueries += summary.SuccessfulQueries
+		data.Total.FailedQueries += summary.FailedQueries
+		data.Total.TotalDuration += summary.TotalDuration
+	}
+	if data.Total.TotalQueries > 0 {
+		data.Total.AvgDuration = data.Total.TotalDuration / time.Duration(data.Total.TotalQueries)
+	}
+	data.Total.Latency = total.Summary()
+
+	return data
+}
+
+// FromMetricsFiles creates report data combining the runs of several
+// metrics output files. Latency percentiles come from the merged histograms
+// of the runs; interval snapshots and errors are not part of the files.
+func FromMetricsFiles(filenames []string) (*Data, error) {
+	runs := make(map[string][]metrics.QuerySummary)
+	for _, filename := range filenames {
+		summaries, err := metrics.LoadQuerySummaries(filename)
+		if err != nil {
+			return nil, err
+		}
+		for name, summary := range summaries {
+			runs[name] = append(runs[name], summary)
+		}
+	}
+
+	data := &Data{
+		Title:   fmt.Sprintf("Merged Load Test Report (%d runs)", len(filenames)),
+		Sources: filenames,
+		Queries: make(map[string]metrics.QuerySummary, len(runs)),
+	}
+	all := make([]metrics.QuerySummary, 0, len(runs))
+	for name, summaries := range runs {
+		summary, err := metrics.MergeQuerySummaries(summaries...)
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", name, err)
+		}
+		data.Queries[name] = summary
+		all = append(all, summary)
+	}
+	if len(all) > 0 {
+		total, err := metrics.MergeQuerySummaries(all...)
+		if err != nil {
+			return nil, err
+		}
+		data.Total = total
+		data.ErrorClasses = total.ErrorClassCounts()
+	}
+
+	return data, nil
+}
+
+// Write renders the report into a single HTML file without external assets
+func Write(filename string, data *Data) error {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create report directory: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, newView(data)); err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// view is the template model of the report
+type view struct {
+	*Data
+	Duration     time.Duration
+	Throughput   float64
+	ErrorRate    float64
+	SettingsJSON string
+	Charts       []template.HTML
+	QueryRows    []queryRow
+}
+
+// queryRow is one row of the percentile table
+type queryRow struct {
+	Name      string
+	Summary   metrics.QuerySummary
+	ErrorRate float64
+}
+
+// newView prepares the report data for rendering
+func newView(data *Data) *view {
+	v := &view{
+		Data:     data,
+		Duration: data.EndTime.Sub(data.StartTime).Round(time.Second),
+	}
+	if seconds := data.EndTime.Sub(data.StartTime).Seconds(); seconds > 0 {
+		v.Throughput = float64(data.Total.TotalQueries) / seconds
+	}
+	v.ErrorRate = errorRate(data.Total)
+
+	settings, err := json.MarshalIndent(data.Settings, "", "  ")
+	if err != nil {
+		settings = []byte(err.Error())
+	}
+	v.SettingsJSON = string(settings)
+
+	names := make([]string, 0, len(data.Queries))
+	for name := range data.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		summary := data.Queries[name]
+		v.QueryRows = append(v.QueryRows, queryRow{Name: name, Summary: summary, ErrorRate: errorRate(summary)})
+	}
+
+	v.Charts = buildCharts(data.Snapshots, names)
+	return v
+}
+
+// errorRate returns the percentage of failed queries of a summary
+func errorRate(summary metrics.QuerySummary) float64 {
+	if summary.TotalQueries == 0 {
+		return 0
+	}
+	return float64(summary.FailedQueries) / float64(summary.TotalQueries) * 100
+}
+
+// buildCharts renders the time series charts of the run
+func buildCharts(snapshots []metrics.IntervalSnapshot, queries []string) []template.HTML {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	xs := make([]float64, len(snapshots))
+	for i, snapshot := range snapshots {
+		xs[i] = snapshot.Elapsed.Seconds()
+	}
+	values := func(fn func(snapshot *metrics.IntervalSnapshot) float64) []float64 {
+		ys := make([]float64, len(snapshots))
+		for i := range snapshots {
+			ys[i] = fn(&snapshots[i])
+		}
+		return ys
+	}
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+
+	throughput := []series{{Name: totalSeriesName, Values: values(func(s *metrics.IntervalSnapshot) float64 {
+		return s.Total.Throughput
+	})}}
+	for _, name := range queries {
+		name := name
+		throughput = append(throughput, series{Name: name, Values: values(func(s *metrics.IntervalSnapshot) float64 {
+			return s.Queries[name].Throughput
+		})})
+	}
+
+	return []template.HTML{
+		lineChart("Throughput", "req/s", xs, throughput),
+		lineChart("Latency", "ms", xs, []series{
+			{Name: "p50", Values: values(func(s *metrics.IntervalSnapshot) float64 { return ms(s.Total.Latency.P50) })},
+			{Name: "p95", Values: values(func(s *metrics.IntervalSnapshot) float64 { return ms(s.Total.Latency.P95) })},
+			{Name: "p99", Values: values(func(s *metrics.IntervalSnapshot) float64 { return ms(s.Total.Latency.P99) })},
+		}),
+		lineChart("Error Rate", "%", xs, []series{
+			{Name: "errors", Values: values(func(s *metrics.IntervalSnapshot) float64 { return s.Total.ErrorRate })},
+		}),
+		lineChart("Users and Connections", "count", xs, []series{
+			{Name: "active users", Values: values(func(s *metrics.IntervalSnapshot) float64 { return float64(s.ActiveUsers) })},
+			{Name: "connections", Values: values(func(s *metrics.IntervalSnapshot) float64 { return float64(s.ActiveConnections) })},
+		}),
+	}
+}
+
+// formatMillis formats a duration in milliseconds for the tables
+func formatMillis(d time.Duration) string {
+	return fmt.Sprintf("%.2f", float64(d)/float64(time.Millisecond))
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": formatMillis,
+	"pct": func(v float64) string {
+		return fmt.Sprintf("%.2f%%", v)
+	},
+	"rate": func(v float64) string {
+		return fmt.Sprintf("%.2f", v)
+	},
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+}).Parse(reportHTML))
+
+const reportHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; padding: 24px 32px; color: #222; background: #f6f7f9; }
+h1 { margin: 0 0 4px; font-size: 24px; }
+h2 { margin: 32px 0 12px; font-size: 18px; border-bottom: 1px solid #ddd; padding-bottom: 6px; }
+.subtitle { color: #666; margin-bottom: 20px; }
+.cards { display: flex; flex-wrap: wrap; gap: 12px; }
+.card { background: #fff; border: 1px solid #e1e4e8; border-radius: 6px; padding: 12px 16px; min-width: 140px; }
+.card .label { color: #666; font-size: 12px; text-transform: uppercase; }
+.card .value { font-size: 22px; font-weight: 600; margin-top: 4px; }
+.charts { display: grid; grid-template-columns: repeat(auto-fit, minmax(560px, 1fr)); gap: 16px; }
+.chart { background: #fff; border: 1px solid #e1e4e8; border-radius: 6px; padding: 8px; }
+table { border-collapse: collapse; background: #fff; width: 100%; font-size: 13px; }
+th, td { border: 1px solid #e1e4e8; padding: 6px 10px; text-align: right; }
+th { background: #f0f2f5; }
+td.text, th.text { text-align: left; }
+tr.total td { font-weight: 600; background: #fafbfc; }
+td.error { color: #b00020; }
+pre { background: #fff; border: 1px solid #e1e4e8; border-radius: 6px; padding: 12px; overflow-x: auto; font-size: 12px; }
+.empty { color: #888; font-style: italic; }
+.aborted { background: #fdecea; border: 1px solid #f5c2c0; color: #b00020; border-radius: 6px; padding: 10px 14px; margin-bottom: 16px; font-weight: 600; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Sources}}<div class="subtitle">Merged from {{range $i, $source := .Sources}}{{if $i}}, {{end}}{{$source}}{{end}}</div>
+{{else}}<div class="subtitle">{{time .StartTime}} &ndash; {{time .EndTime}} ({{.Duration}}) &middot; executor: {{.Executor}}</div>{{end}}
+
+{{if .Aborted}}<div class="aborted">Aborted by circuit breaker: {{.Aborted}}</div>{{end}}
+
+<div class="cards">
+<div class="card"><div class="label">Queries</div><div class="value">{{.Total.TotalQueries}}</div></div>
+{{if not .Sources}}<div class="card"><div class="label">Throughput</div><div class="value">{{rate .Throughput}}/s</div></div>{{end}}
+<div class="card"><div class="label">Error Rate</div><div class="value">{{pct .ErrorRate}}</div></div>
+<div class="card"><div class="label">p50</div><div class="value">{{ms .Total.Latency.P50}} ms</div></div>
+<div class="card"><div class="label">p95</div><div class="value">{{ms .Total.Latency.P95}} ms</div></div>
+<div class="card"><div class="label">p99</div><div class="value">{{ms .Total.Latency.P99}} ms</div></div>
+</div>
+
+{{if .Thresholds}}<h2>Thresholds</h2>
+<table>
+<tr><th class="text">Result</th><th class="text">Threshold</th><th class="text">Actual</th></tr>
+{{range .Thresholds}}<tr><td class="text {{if not .Passed}}error{{end}}">{{if .Passed}}PASS{{else}}FAIL{{end}}</td><td class="text">{{.Expression}}</td><td class="text">{{.Actual}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if not .Sources}}<h2>Over Time</h2>
+{{if .Charts}}<div class="charts">{{range .Charts}}<div class="chart">{{.}}</div>{{end}}</div>
+{{else}}<p class="empty">No interval snapshots were recorded (metrics disabled or the run was shorter than one interval).</p>{{end}}
+{{end}}
+
+<h2>Queries</h2>
+<table>
+<tr><th class="text">Query</th><th>Total</th><th>Failed</th><th>Error Rate</th><th>Mean (ms)</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>p99.9</th><th>Max</th></tr>
+{{range .QueryRows}}<tr><td class="text">{{.Name}}</td><td>{{.Summary.TotalQueries}}</td><td>{{.Summary.FailedQueries}}</td><td>{{pct .ErrorRate}}</td><td>{{ms .Summary.Latency.Mean}}</td><td>{{ms .Summary.Latency.P50}}</td><td>{{ms .Summary.Latency.P90}}</td><td>{{ms .Summary.Latency.P95}}</td><td>{{ms .Summary.Latency.P99}}</td><td>{{ms .Summary.Latency.P999}}</td><td>{{ms .Summary.Latency.Max}}</td></tr>
+{{end}}<tr class="total"><td class="text">all</td><td>{{.Total.TotalQueries}}</td><td>{{.Total.FailedQueries}}</td><td>{{pct .ErrorRate}}</td><td>{{ms .Total.Latency.Mean}}</td><td>{{ms .Total.Latency.P50}}</td><td>{{ms .Total.Latency.P90}}</td><td>{{ms .Total.Latency.P95}}</td><td>{{ms .Total.Latency.P99}}</td><td>{{ms .Total.Latency.P999}}</td><td>{{ms .Total.Latency.Max}}</td></tr>
+</table>
+
+{{if .Pools}}<h2>Connection Pools</h2>
+<table>
+<tr><th class="text">Pool</th><th>Databases</th><th>Max Open</th><th>Open</th><th>In Use</th><th>Idle</th><th>Wait Count</th><th>Wait Time (ms)</th></tr>
+{{range .Pools}}<tr><td class="text">{{.Name}}</td><td>{{.Databases}}</td><td>{{.MaxOpenConnections}}</td><td>{{.OpenConnections}}</td><td>{{.InUse}}</td><td>{{.Idle}}</td><td>{{.WaitCount}}</td><td>{{ms .WaitDuration}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if not .Sources}}<h2>Scaling Timeline</h2>
+{{if .Timeline}}<table>
+<tr><th>Offset</th><th>Ramp</th><th class="text">Target</th><th class="text">Description</th><th class="text">Result</th></tr>
+{{range .Timeline}}<tr><td>{{.Offset}}</td><td>{{.Ramp}}</td><td class="text">{{.Target}}</td><td class="text">{{.Description}}</td><td class="text">{{.Result}}</td></tr>
+{{end}}</table>
+{{else}}<p class="empty">No scaling plan configured.</p>{{end}}
+{{end}}
+
+{{if .ErrorClasses}}<h2>Errors by Class</h2>
+<table>
+<tr><th class="text">Class</th><th>Errors</th><th>Retries</th></tr>
+{{range .ErrorClasses}}<tr><td class="text">{{.Class}}</td><td>{{.Errors}}</td><td>{{.Retries}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if not .Sources}}<h2>Top Errors</h2>
+{{if .Errors}}<table>
+<tr><th>Count</th><th class="text">Query</th><th class="text">Class</th><th class="text">Message</th></tr>
+{{range .Errors}}<tr><td>{{.Count}}</td><td class="text">{{.Query}}</td><td class="text">{{.ErrorClass}}</td><td class="text error">{{.Message}}</td></tr>
+{{end}}</table>
+{{if .UntrackedErrors}}<p class="empty">{{.UntrackedErrors}} further errors had messages beyond the tracking limit.</p>{{end}}
+{{else}}<p class="empty">No errors.</p>{{end}}
+
+<h2>Configuration</h2>
+<pre>{{.SettingsJSON}}</pre>
+{{end}}</body>
+</html>
+`
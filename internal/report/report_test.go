@@ -0,0 +1,84 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+func TestWrite(t *testing.T) {
+	collector := metrics.NewCollector(nil)
+	for i := 0; i < 10; i++ {
+		collector.RecordQuery(metrics.QueryResult{QueryName: "get_user", QueryType: "select", Success: true, Duration: time.Millisecond})
+	}
+	collector.RecordQuery(metrics.QueryResult{QueryName: "add_order", QueryType: "insert", Duration: time.Millisecond,
+		Error: `duplicate key "<orders_pkey>"`, ErrorClass: "constraint"})
+
+	start := time.Date(2026, 1, 2, 15, 4, 0, 0, time.UTC)
+	data := FromCollector(collector)
+	data.Title = "Checkout Load Test"
+	data.StartTime = start
+	data.EndTime = start.Add(10 * time.Second)
+	data.Executor = "closed_loop"
+	data.Settings = map[string]interface{}{"database": map[string]interface{}{"password": "******"}}
+	data.Timeline = []TimelineStep{{Offset: 5 * time.Second, Ramp: time.Second, Target: "20 users", Description: "peak"}}
+	data.Snapshots = []metrics.IntervalSnapshot{
+		{Elapsed: 5 * time.Second, ActiveUsers: 10, Total: metrics.WindowSummary{Requests: 5, Throughput: 1}},
+		{Elapsed: 10 * time.Second, ActiveUsers: 20, Total: metrics.WindowSummary{Requests: 6, Throughput: 1.2}},
+	}
+
+	filename := filepath.Join(t.TempDir(), "reports", "report.html")
+	if err := Write(filename, data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+
+	for _, want := range []string{
+		"<title>Checkout Load Test</title>",
+		"executor: closed_loop",
+		"<td class=\"text\">get_user</td><td>10</td><td>0</td>",
+		"<td class=\"text\">add_order</td><td>1</td><td>1</td><td>100.00%</td>",
+		"<td class=\"text\">all</td><td>11</td><td>1</td>",
+		"<td class=\"text\">20 users</td><td class=\"text\">peak</td>",
+		`&#34;password&#34;: &#34;******&#34;`,
+		// Error messages are escaped
+		"duplicate key &#34;&lt;orders_pkey&gt;&#34;",
+		"<svg",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report does not contain %s", want)
+		}
+	}
+
+	// The report must open offline; the SVG namespace is not fetched
+	offline := strings.ReplaceAll(html, `xmlns="http://www.w3.org/2000/svg"`, "")
+	for _, external := range []string{"<script src", "<link", "http://", "https://"} {
+		if strings.Contains(offline, external) {
+			t.Errorf("report references external assets: %s", external)
+		}
+	}
+}
+
+func TestWriteWithoutSnapshots(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "report.html")
+	if err := Write(filename, &Data{Title: "Empty"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"No interval snapshots were recorded", "No scaling plan configured.", "No errors."} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// QueryArg is one argument of a query. It is sent either as a plain JSON
+// value or as {"type": "decimal", "value": "12.50"} to bind the value as a
+// specific parameter type.
+type QueryArg struct {
+	Type  string      `json:"type,omitempty"`
+	Value interface{} `json:"value"` // Numbers are json.Number
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *QueryArg) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var typed struct {
+			Type  *string     `json:"type"`
+			Value interface{} `json:"value"`
+		}
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&typed); err != nil || typed.Type == nil {
+			return fmt.Errorf("object arguments must be {\"type\": ..., \"value\": ...}")
+		}
+		a.Type, a.Value = *typed.Type, typed.Value
+		return nil
+	}
+
+	a.Type = ""
+	return decoder.Decode(&a.Value)
+}
+
+// QueryArgs are the arguments of a query, either positional as a JSON array
+// or named as a JSON object
+type QueryArgs struct {
+	Positional []QueryArg
+	Named      map[string]QueryArg
+}
+
+// Len returns the number of arguments
+func (a QueryArgs) Len() int {
+	return len(a.Positional) + len(a.Named)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *QueryArgs) UnmarshalJSON(data []byte) error {
+	*a = QueryArgs{}
+	switch trimmed := bytes.TrimSpace(data); {
+	case bytes.Equal(trimmed, []byte("null")):
+		return nil
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return json.Unmarshal(trimmed, &a.Positional)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return json.Unmarshal(trimmed, &a.Named)
+	default:
+		return fmt.Errorf("args must be an array or an object")
+	}
+}
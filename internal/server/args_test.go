@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+)
+
+func TestQueryArgsUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    QueryArgs
+		wantErr bool
+	}{
+		{name: "null", json: `null`, want: QueryArgs{}},
+		{
+			name: "positional",
+			json: `[1, "a", null, {"type": "decimal", "value": "12.50"}]`,
+			want: QueryArgs{Positional: []QueryArg{
+				{Value: json.Number("1")}, {Value: "a"}, {Value: nil}, {Type: "decimal", Value: "12.50"},
+			}},
+		},
+		{
+			name: "named",
+			json: `{"id": 12345678901234567890, "at": {"type": "datetime", "value": "2024-01-31"}}`,
+			want: QueryArgs{Named: map[string]QueryArg{
+				"id": {Value: json.Number("12345678901234567890")},
+				"at": {Type: "datetime", Value: "2024-01-31"},
+			}},
+		},
+		{name: "scalar", json: `5`, wantErr: true},
+		{name: "object without type", json: `[{"value": 1}]`, wantErr: true},
+		{name: "object with unknown field", json: `[{"type": "int", "value": 1, "size": 4}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got QueryArgs
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Unmarshal(%s) = %+v, want an error", tt.json, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.json, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.json, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLPolicyResolve(t *testing.T) {
+	policy, err := newSQLPolicy(&config.EnvConfig{}, mustDialect(t, "postgres"))
+	if err != nil {
+		t.Fatalf("newSQLPolicy: %v", err)
+	}
+	named, err := newNamedQuery(config.NamedQuery{
+		ID:         "orders_since",
+		SQL:        "SELECT * FROM orders WHERE customer_id = :customer AND created_at > :since AND :customer > 0",
+		Parameters: map[string]string{"customer": "int", "since": "datetime"},
+	}, policy.dialect)
+	if err != nil {
+		t.Fatalf("newNamedQuery: %v", err)
+	}
+	policy.catalog["orders_since"] = named
+	since := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		request  string
+		wantSQL  string
+		wantArgs []interface{}
+		wantRule string
+	}{
+		{
+			name:     "named arguments",
+			request:  `{"query": "SELECT * FROM t WHERE id = :id AND name = :name OR parent = :id", "args": {"id": 7, "name": {"type": "nvarchar", "value": "x"}}}`,
+			wantSQL:  "SELECT * FROM t WHERE id = $1 AND name = $2 OR parent = $3",
+			wantArgs: []interface{}{int64(7), "x", int64(7)},
+		},
+		{
+			name:     "named argument without parameter",
+			request:  `{"query": "SELECT * FROM t WHERE id = :id", "args": {"id": 7, "other": 1}}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "named argument in a literal",
+			request:  `{"query": "SELECT ':id'", "args": {"id": 7}}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "positional arguments",
+			request:  `{"query": "SELECT * FROM t WHERE id = ? AND price = ?", "args": [7, {"type": "decimal", "value": "9.99"}]}`,
+			wantSQL:  "SELECT * FROM t WHERE id = $1 AND price = $2",
+			wantArgs: []interface{}{int64(7), "9.99"},
+		},
+		{
+			name:     "invalid typed argument",
+			request:  `{"query": "SELECT * FROM t WHERE id = ?", "args": [{"type": "int", "value": "seven"}]}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "catalog query with named arguments",
+			request:  `{"query_id": "orders_since", "args": {"customer": "42", "since": "2024-01-31"}}`,
+			wantSQL:  "SELECT * FROM orders WHERE customer_id = $1 AND created_at > $2 AND $3 > 0",
+			wantArgs: []interface{}{int64(42), since, int64(42)},
+		},
+		{
+			name:     "catalog query with positional arguments",
+			request:  `{"query_id": "orders_since", "args": [42, "2024-01-31"]}`,
+			wantSQL:  "SELECT * FROM orders WHERE customer_id = $1 AND created_at > $2 AND $3 > 0",
+			wantArgs: []interface{}{int64(42), since, int64(42)},
+		},
+		{
+			name:     "catalog query with too few arguments",
+			request:  `{"query_id": "orders_since", "args": [42]}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "catalog query with missing argument",
+			request:  `{"query_id": "orders_since", "args": {"customer": 42}}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "catalog query with conflicting type",
+			request:  `{"query_id": "orders_since", "args": {"customer": {"type": "nvarchar", "value": "42"}, "since": "2024-01-31"}}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "catalog query with unknown argument",
+			request:  `{"query_id": "orders_since", "args": {"customer": 42, "since": "2024-01-31", "limit": 10}}`,
+			wantRule: ruleArguments,
+		},
+		{
+			name:     "unknown catalog query",
+			request:  `{"query_id": "missing"}`,
+			wantRule: ruleUnknownQuery,
+		},
+		{
+			name:     "query and query_id",
+			request:  `{"query": "SELECT 1", "query_id": "orders_since"}`,
+			wantRule: ruleArguments,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req QueryRequest
+			if err := json.Unmarshal([]byte(tt.request), &req); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+
+			sql, args, violation := policy.resolve(&req)
+			if tt.wantRule != "" {
+				if violation == nil || violation.Rule != tt.wantRule {
+					t.Errorf("resolve = %v, want rule %s", violation, tt.wantRule)
+				}
+				return
+			}
+			if violation != nil {
+				t.Fatalf("resolve: %v", violation)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("resolve SQL = %q, want %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("resolve args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestQueryNamedArguments(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{},
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO t (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c')",
+	)
+
+	tests := []struct {
+		name     string
+		args     interface{}
+		wantRows int
+	}{
+		{name: "match", args: map[string]interface{}{"id": 2, "name": "b"}, wantRows: 1},
+		{name: "typed", args: map[string]interface{}{"id": map[string]interface{}{"type": "int", "value": "3"}, "name": "c"}, wantRows: 1},
+		{name: "no match", args: map[string]interface{}{"id": 2, "name": "c"}, wantRows: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response QueryResponse
+			request := map[string]interface{}{"query": "SELECT id FROM t WHERE id = :id AND name = :name", "args": tt.args}
+			if code := postJSON(t, s, "/api/v1/query", request, &response); code != http.StatusOK || !response.Success {
+				t.Fatalf("status %d, %+v", code, response)
+			}
+			if len(response.Data) != tt.wantRows {
+				t.Errorf("returned %d rows, want %d", len(response.Data), tt.wantRows)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Roles granted to callers. Every role includes the permissions of the
+// roles before it: read < query < admin.
+const (
+	RoleRead  = "read"  // Health, info, stats, the default query and load test stats
+	RoleQuery = "query" // Running SQL
+	RoleAdmin = "admin" // Connection cleanup and close, load test control
+)
+
+// roleLevels orders the roles
+var roleLevels = map[string]int{
+	RoleRead:  1,
+	RoleQuery: 2,
+	RoleAdmin: 3,
+}
+
+// Reasons unauthorized requests are counted under
+const (
+	authMissingCredentials = "missing_credentials"
+	authInvalidCredentials = "invalid_credentials"
+	authForbidden          = "forbidden"
+)
+
+// errNoCredentials is returned by an Authenticator when the request carries
+// no credential of its kind
+var errNoCredentials = errors.New("no credentials")
+
+// Principal is an authenticated caller
+type Principal struct {
+	Subject string
+	Role    string
+	Method  string // api_key, token, mtls
+}
+
+// Authenticator identifies the caller of a request by one kind of credential
+type Authenticator interface {
+	// Authenticate returns the caller, errNoCredentials if the request has no
+	// credential of this kind, or another error if the credential is invalid
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// principalKey is the request context key of the authenticated caller
+type principalKey struct{}
+
+// PrincipalFromContext returns the authenticated caller of a request, or nil
+// if authentication is disabled
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// newAuthenticators creates the authenticators configured in the environment
+func newAuthenticators(cfg *config.EnvConfig) ([]Authenticator, error) {
+	var authenticators []Authenticator
+
+	if cfg.AuthMTLSRoles != "" {
+		roles, err := parseRoleList(cfg.AuthMTLSRoles)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_MTLS_ROLES: %w", err)
+		}
+		authenticators = append(authenticators, &certAuthenticator{roles: roles})
+	}
+
+	if cfg.AuthAPIKeys != "" {
+		keys, err := parseAPIKeys(cfg.AuthAPIKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AUTH_API_KEYS: %w", err)
+		}
+		authenticators = append(authenticators, &apiKeyAuthenticator{keys: keys})
+	}
+
+	if cfg.AuthHMACSecret != "" {
+		authenticators = append(authenticators, &tokenAuthenticator{secret: []byte(cfg.AuthHMACSecret)})
+	}
+
+	return authenticators, nil
+}
+
+// authorize wraps a handler so only callers with at least the given role
+// reach it. Without authenticators every caller is let through.
+func (s *Server) authorize(role string, next http.HandlerFunc) http.HandlerFunc {
+	if len(s.authenticators) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := s.authenticate(r)
+		switch {
+		case errors.Is(err, errNoCredentials):
+			s.rejectRequest(w, r, http.StatusUnauthorized, authMissingCredentials, err)
+			return
+		case err != nil:
+			s.rejectRequest(w, r, http.StatusUnauthorized, authInvalidCredentials, err)
+			return
+		case roleLevels[principal.Role] < roleLevels[role]:
+			s.rejectRequest(w, r, http.StatusForbidden, authForbidden,
+				fmt.Errorf("%s %q has role %s, %s required", principal.Method, principal.Subject, principal.Role, role))
+			return
+		}
+
+		logrus.Debugf("%s %s authorized for %s %q (%s)", r.Method, r.URL.Path, principal.Method, principal.Subject, principal.Role)
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// authenticate returns the caller identified by the first credential the
+// request carries
+func (s *Server) authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range s.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return nil, errNoCredentials
+}
+
+// rejectRequest logs and counts an unauthorized request and sends the error
+func (s *Server) rejectRequest(w http.ResponseWriter, r *http.Request, statusCode int, reason string, err error) {
+	s.authFailures.record(reason)
+	logrus.Warnf("Unauthorized request %s %s from %s: %s: %v", r.Method, r.URL.Path, r.RemoteAddr, reason, err)
+
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="fiyuu-ktdb"`)
+	}
+	s.sendJSONResponse(w, statusCode, map[string]interface{}{
+		"success":   false,
+		"error":     http.StatusText(statusCode),
+		"reason":    reason,
+		"timestamp": time.Now(),
+	})
+}
+
+// reasonCounts counts rejected requests by reason
+type reasonCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// record counts one rejected request
+func (c *reasonCounts) record(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[reason]++
+}
+
+// snapshot returns the count of every reason, including the known reasons
+// never seen
+func (c *reasonCounts) snapshot(known ...string) map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(known)+len(c.counts))
+	for _, reason := range known {
+		counts[reason] = 0
+	}
+	for reason, count := range c.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// apiKey is a static API key from the environment
+type apiKey struct {
+	name string
+	role string
+	key  []byte
+}
+
+// apiKeyAuthenticator accepts static API keys sent in the X-API-Key header
+type apiKeyAuthenticator struct {
+	keys []apiKey
+}
+
+// Authenticate implements Authenticator
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, errNoCredentials
+	}
+
+	// Compare against every key so the timing does not reveal which matched
+	var match *apiKey
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), a.keys[i].key) == 1 {
+			match = &a.keys[i]
+		}
+	}
+	if match == nil {
+		return nil, errors.New("unknown API key")
+	}
+	return &Principal{Subject: match.name, Role: match.role, Method: "api_key"}, nil
+}
+
+// parseAPIKeys parses comma separated name:role:key entries
+func parseAPIKeys(value string) ([]apiKey, error) {
+	var keys []apiKey
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("entry %q is not name:role:key", strings.SplitN(entry, ":", 2)[0])
+		}
+		if _, ok := roleLevels[parts[1]]; !ok {
+			return nil, fmt.Errorf("key %s has unknown role %q", parts[0], parts[1])
+		}
+		keys = append(keys, apiKey{name: parts[0], role: parts[1], key: []byte(parts[2])})
+	}
+	return keys, nil
+}
+
+// TokenClaims are the claims of a signed bearer token
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"` // Unix seconds
+}
+
+// SignToken returns a bearer token for the claims: the base64url encoded JSON
+// claims and their HMAC-SHA256 signature, separated by a dot
+func SignToken(secret []byte, claims TokenClaims) (string, error) {
+	if _, ok := roleLevels[claims.Role]; !ok {
+		return "", fmt.Errorf("unknown role %q", claims.Role)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal token claims: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signToken(secret, encoded)), nil
+}
+
+// signToken returns the signature of an encoded token payload
+func signToken(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// tokenAuthenticator accepts HMAC-signed bearer tokens
+type tokenAuthenticator struct {
+	secret []byte
+}
+
+// Authenticate implements Authenticator
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return nil, errNoCredentials
+	}
+
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.New("malformed token")
+	}
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, signToken(a.secret, payload)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	decodedPayload, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(decodedPayload, &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if claims.ExpiresAt > 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("token of %q expired", claims.Subject)
+	}
+	if _, ok := roleLevels[claims.Role]; !ok {
+		return nil, fmt.Errorf("token of %q has unknown role %q", claims.Subject, claims.Role)
+	}
+	return &Principal{Subject: claims.Subject, Role: claims.Role, Method: "token"}, nil
+}
+
+// certAuthenticator accepts verified TLS client certificates, mapping their
+// subject common name to a role
+type certAuthenticator struct {
+	roles map[string]string
+}
+
+// Authenticate implements Authenticator
+func (a *certAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	// The TLS handshake verified the chain against the client CA
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, errNoCredentials
+	}
+
+	commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	role, ok := a.roles[commonName]
+	if !ok {
+		return nil, fmt.Errorf("client certificate %q has no role", commonName)
+	}
+	return &Principal{Subject: commonName, Role: role, Method: "mtls"}, nil
+}
+
+// parseRoleList parses comma separated name:role entries
+func parseRoleList(value string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, role, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("entry %q is not name:role", entry)
+		}
+		if _, ok := roleLevels[role]; !ok {
+			return nil, fmt.Errorf("%s has unknown role %q", name, role)
+		}
+		roles[name] = role
+	}
+	return roles, nil
+}
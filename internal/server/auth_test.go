@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// mustSignToken signs claims with testSecret
+func mustSignToken(t *testing.T, claims TokenClaims) string {
+	t.Helper()
+	token, err := SignToken(testSecret, claims)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	return token
+}
+
+func TestSignTokenUnknownRole(t *testing.T) {
+	if _, err := SignToken(testSecret, TokenClaims{Subject: "ci", Role: "root"}); err == nil {
+		t.Error("SignToken with an unknown role succeeded")
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	valid := mustSignToken(t, TokenClaims{Subject: "ci", Role: RoleQuery, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	// Claims the caller did not get signed
+	forgedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"ci","role":"admin"}`))
+
+	tests := []struct {
+		name          string
+		header        string
+		wantSubject   string
+		wantRole      string
+		noCredentials bool // errNoCredentials instead of an invalid credential
+	}{
+		{name: "valid", header: "Bearer " + valid, wantSubject: "ci", wantRole: RoleQuery},
+		{name: "no expiry", header: "Bearer " + mustSignToken(t, TokenClaims{Subject: "ops", Role: RoleAdmin}), wantSubject: "ops", wantRole: RoleAdmin},
+		{name: "expired", header: "Bearer " + mustSignToken(t, TokenClaims{Subject: "ci", Role: RoleRead, ExpiresAt: time.Now().Add(-time.Second).Unix()})},
+		{name: "expires now", header: "Bearer " + mustSignToken(t, TokenClaims{Subject: "ci", Role: RoleRead, ExpiresAt: time.Now().Unix()})},
+		{name: "other secret", header: "Bearer " + forgedPayload + "." + base64.RawURLEncoding.EncodeToString(signToken([]byte("other"), forgedPayload))},
+		{name: "payload swapped", header: "Bearer " + forgedPayload + "." + signature},
+		{name: "signature truncated", header: "Bearer " + payload + "." + signature[:len(signature)-2]},
+		{name: "no signature", header: "Bearer " + payload},
+		{name: "garbage", header: "Bearer not-a-token"},
+		{name: "unknown role", header: "Bearer " + signedPayload(`{"sub":"ci","role":"root"}`)},
+		{name: "malformed claims", header: "Bearer " + signedPayload(`{"sub":`)},
+		{name: "no header", noCredentials: true},
+		{name: "basic auth", header: "Basic dXNlcjpwYXNz", noCredentials: true},
+		{name: "empty bearer", header: "Bearer ", noCredentials: true},
+	}
+
+	authenticator := &tokenAuthenticator{secret: testSecret}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/info", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			principal, err := authenticator.Authenticate(r)
+			switch {
+			case tt.noCredentials:
+				if !errors.Is(err, errNoCredentials) {
+					t.Fatalf("Authenticate: error %v, want %v", err, errNoCredentials)
+				}
+			case tt.wantRole == "":
+				if err == nil || errors.Is(err, errNoCredentials) {
+					t.Fatalf("Authenticate = %+v, %v; want an invalid credential error", principal, err)
+				}
+			case err != nil:
+				t.Fatalf("Authenticate: %v", err)
+			case principal.Subject != tt.wantSubject || principal.Role != tt.wantRole || principal.Method != "token":
+				t.Errorf("Authenticate = %+v, want subject %q with role %s by token", principal, tt.wantSubject, tt.wantRole)
+			}
+		})
+	}
+}
+
+// signedPayload signs raw claims JSON with testSecret, bypassing the checks
+// of SignToken
+func signedPayload(claims string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signToken(testSecret, payload))
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string // Role the endpoint requires
+		token      string // Role of the caller's token, none if empty
+		wantStatus int
+	}{
+		{name: "no credentials", role: RoleRead, wantStatus: http.StatusUnauthorized},
+		{name: "same role", role: RoleQuery, token: RoleQuery, wantStatus: http.StatusOK},
+		{name: "higher role", role: RoleRead, token: RoleAdmin, wantStatus: http.StatusOK},
+		{name: "lower role", role: RoleAdmin, token: RoleQuery, wantStatus: http.StatusForbidden},
+	}
+
+	s := &Server{authenticators: []Authenticator{&tokenAuthenticator{secret: testSecret}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *Principal
+			handler := s.authorize(tt.role, func(w http.ResponseWriter, r *http.Request) {
+				principal = PrincipalFromContext(r.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/info", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+mustSignToken(t, TokenClaims{Subject: "ci", Role: tt.token}))
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && (principal == nil || principal.Role != tt.token) {
+				t.Errorf("handler got principal %+v, want role %s", principal, tt.token)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 response has no WWW-Authenticate header")
+			}
+		})
+	}
+}
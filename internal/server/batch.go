@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+// statementSkipped is the status of statements not run because an earlier
+// statement failed. The other statuses are query statuses.
+const statementSkipped = "skipped"
+
+// Transaction outcomes of a batch
+const (
+	txCommitted  = "committed"
+	txRolledBack = "rolled_back"
+)
+
+// BatchRequest is an ordered list of statements run on one connection
+type BatchRequest struct {
+	Statements      []BatchStatement `json:"statements"`
+	Transaction     bool             `json:"transaction"`       // Run the statements in one transaction
+	Isolation       string           `json:"isolation"`         // Isolation level of the transaction, the database default if empty
+	Rollback        bool             `json:"rollback"`          // Roll the transaction back even if every statement succeeds
+	ContinueOnError bool             `json:"continue_on_error"` // Keep running after a failed statement, not in a transaction
+	MaxRows         int              `json:"max_rows"`          // Rows returned per statement at most, capped by QUERY_MAX_ROWS
+	TimeoutMS       int              `json:"timeout_ms"`        // Timeout of the whole batch instead of QUERY_TIMEOUT per statement
+}
+
+// BatchStatement is one statement of a batch, raw SQL or a named query
+type BatchStatement struct {
+	Query       string    `json:"query"`
+	QueryID     string    `json:"query_id"`
+	Args        QueryArgs `json:"args"`
+	ReturnsRows *bool     `json:"returns_rows"` // Unset guesses from the statement's first keyword
+}
+
+// BatchResult is the result of one statement of a batch
+type BatchResult struct {
+	Status       string          `json:"status"` // ok, error, timeout, canceled or skipped
+	Columns      []ColumnInfo    `json:"columns,omitempty"`
+	Data         [][]interface{} `json:"data,omitempty"`          // Rows of values in column order
+	RowCount     *int            `json:"row_count,omitempty"`     // Rows returned by a query
+	RowsAffected *int64          `json:"rows_affected,omitempty"` // Rows changed by other statements, if the driver reports it
+	Truncated    bool            `json:"truncated,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Duration     time.Duration   `json:"duration"`
+}
+
+// BatchResponse represents the result of a batch
+type BatchResponse struct {
+	Success     bool          `json:"success"`
+	Status      string        `json:"status"`                // ok, or the status of the first failure
+	Transaction string        `json:"transaction,omitempty"` // committed or rolled_back
+	Results     []BatchResult `json:"results"`               // In statement order
+	Error       string        `json:"error,omitempty"`       // Failure outside a statement, such as a failed commit
+	Duration    time.Duration `json:"duration"`
+	Timestamp   time.Time     `json:"timestamp"`
+}
+
+// batchStatement is a statement resolved by the SQL policy
+type batchStatement struct {
+	query       string
+	args        []interface{}
+	returnsRows bool
+}
+
+// batchTarget runs the statements of a batch, a connection or a transaction
+type batchTarget interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// batchTx is the transaction of a batch, read-only if the SQL policy requires
+type batchTx interface {
+	batchTarget
+	Commit() error
+	Rollback() error
+}
+
+// handleBatch handles POST /api/v1/batch
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+
+	if err := s.validateBatch(&req); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid batch", err)
+		return
+	}
+	isolation, err := database.IsolationLevel(req.Isolation)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid batch", err)
+		return
+	}
+
+	// Every statement passes the policy before the first one runs. Raw SQL
+	// in read_only mode makes the whole batch read-only.
+	statements := make([]batchStatement, len(req.Statements))
+	readOnly := false
+	for i, statement := range req.Statements {
+		queryReq := &QueryRequest{Query: statement.Query, QueryID: statement.QueryID, Args: statement.Args}
+		readOnly = readOnly || s.policy.readOnlyTx(queryReq)
+		query, args, violation := s.policy.resolve(queryReq)
+		if violation != nil {
+			violation.Message = fmt.Sprintf("batch statement %d: %s", i+1, violation.Message)
+			s.rejectQuery(w, r, violation)
+			return
+		}
+		statements[i] = batchStatement{
+			query:       query,
+			args:        args,
+			returnsRows: s.returnsRows(query, statement.ReturnsRows),
+		}
+	}
+
+	ctx, cancel, err := s.queryContext(w, r, req.TimeoutMS)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid timeout", err)
+		return
+	}
+	defer cancel()
+	if req.TimeoutMS == 0 {
+		// Every statement may take QUERY_TIMEOUT
+		extendWriteDeadline(w, s.dbManager.QueryTimeout()*time.Duration(len(statements)))
+	}
+
+	opts := resultOptions{maxRows: s.rowLimit(req.MaxRows)}
+	response := s.runBatch(ctx, &req, statements, isolation, readOnly, opts)
+	s.sendJSONResponse(w, outcomeStatusCode(response.Status), response)
+}
+
+// validateBatch checks the statement count and that the options fit together
+func (s *Server) validateBatch(req *BatchRequest) error {
+	if len(req.Statements) == 0 {
+		return errors.New("statements are required")
+	}
+	if limit := s.config.BatchMaxStatements; limit > 0 && len(req.Statements) > limit {
+		return fmt.Errorf("batch has %d statements, limit is %d", len(req.Statements), limit)
+	}
+	for i, statement := range req.Statements {
+		if statement.Query == "" && statement.QueryID == "" {
+			return fmt.Errorf("statement %d: query is required", i+1)
+		}
+	}
+	if req.MaxRows < 0 {
+		return errors.New("max_rows must not be negative")
+	}
+	if !req.Transaction && (req.Isolation != "" || req.Rollback) {
+		return errors.New("isolation and rollback require a transaction")
+	}
+	if req.Transaction && req.ContinueOnError {
+		return errors.New("continue_on_error cannot be used in a transaction")
+	}
+	return nil
+}
+
+// returnsRows reports whether a statement is run as a query. Unless the
+// request says otherwise, statements starting with a read-only verb are
+// queries and the others are run for their affected row count.
+func (s *Server) returnsRows(query string, override *bool) bool {
+	if override != nil {
+		return *override
+	}
+	statements, err := splitStatements(query, s.dbManager.Dialect())
+	if err != nil || len(statements) == 0 {
+		return true
+	}
+	return readOnlyVerbs[statements[0][0]]
+}
+
+// runBatch runs the statements in order on one connection, in a transaction
+// if requested. Statements after a failed one are skipped unless the batch
+// continues on errors; a failed transaction is rolled back. A read-only
+// batch outside a transaction runs every statement in a read-only
+// transaction of its own.
+func (s *Server) runBatch(ctx context.Context, req *BatchRequest, statements []batchStatement, isolation sql.IsolationLevel, readOnly bool, opts resultOptions) (response BatchResponse) {
+	start := time.Now()
+	response = BatchResponse{
+		Results:   make([]BatchResult, len(statements)),
+		Timestamp: start,
+	}
+	defer func() {
+		response.Duration = time.Since(start)
+	}()
+	for i := range response.Results {
+		response.Results[i].Status = statementSkipped
+	}
+
+	// The batch is counted once, with the status of its first failure
+	fail := func(status string, err error) {
+		if response.Status == "" {
+			response.Status = status
+			s.reportQuery(status, err)
+		}
+	}
+	defer func() {
+		response.Success = response.Status == ""
+		if response.Success {
+			response.Status = queryOK
+			s.reportQuery(queryOK, nil)
+		}
+	}()
+
+	conn, err := s.dbManager.Conn(ctx)
+	if err != nil {
+		response.Error = fmt.Sprintf("failed to get a connection: %v", err)
+		fail(queryStatus(ctx, err), err)
+		return response
+	}
+	defer conn.Close()
+
+	begin := func(isolation sql.IsolationLevel) (batchTx, error) {
+		if readOnly {
+			return s.dbManager.BeginReadOnly(ctx, conn, isolation)
+		}
+		return conn.BeginTx(ctx, &sql.TxOptions{Isolation: isolation})
+	}
+
+	var target batchTarget = conn
+	var tx batchTx
+	if req.Transaction {
+		tx, err = begin(isolation)
+		if err != nil {
+			response.Error = fmt.Sprintf("failed to begin transaction: %v", err)
+			fail(queryStatus(ctx, err), err)
+			return response
+		}
+		target = tx
+	}
+
+	for i, statement := range statements {
+		if response.Status != "" && !req.ContinueOnError {
+			break
+		}
+
+		var result BatchResult
+		if readOnly && tx == nil {
+			result = s.runReadOnlyStatement(ctx, begin, statement, opts)
+		} else {
+			result = s.runStatement(ctx, target, statement, opts)
+		}
+		response.Results[i] = result
+		if result.Status != queryOK {
+			fail(result.Status, fmt.Errorf("batch statement %d: %s", i+1, result.Error))
+		}
+	}
+
+	if tx != nil {
+		response.Transaction = txRolledBack
+		if response.Status != "" || req.Rollback {
+			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+				logrus.Warnf("Failed to roll back batch transaction: %v", err)
+			}
+		} else if err := tx.Commit(); err != nil {
+			response.Error = fmt.Sprintf("failed to commit transaction: %v", err)
+			fail(queryStatus(ctx, err), err)
+		} else {
+			response.Transaction = txCommitted
+		}
+	}
+
+	return response
+}
+
+// runReadOnlyStatement runs one statement of a batch in a read-only
+// transaction that is rolled back afterwards
+func (s *Server) runReadOnlyStatement(ctx context.Context, begin func(sql.IsolationLevel) (batchTx, error), statement batchStatement, opts resultOptions) BatchResult {
+	tx, err := begin(sql.LevelDefault)
+	if err != nil {
+		return BatchResult{Status: queryStatus(ctx, err), Error: fmt.Sprintf("failed to begin read-only transaction: %v", err)}
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logrus.Warnf("Failed to roll back read-only batch statement: %v", err)
+		}
+	}()
+
+	return s.runStatement(ctx, tx, statement, opts)
+}
+
+// runStatement runs one statement of a batch. Without a batch timeout every
+// statement gets QUERY_TIMEOUT.
+func (s *Server) runStatement(ctx context.Context, target batchTarget, statement batchStatement, opts resultOptions) (result BatchResult) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, s.dbManager.QueryTimeout())
+	}
+	defer cancel()
+
+	fail := func(err error) BatchResult {
+		result.Status = queryStatus(ctx, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	if !statement.returnsRows {
+		res, err := target.ExecContext(ctx, statement.query, statement.args...)
+		if err != nil {
+			return fail(err)
+		}
+		if affected, err := res.RowsAffected(); err == nil {
+			result.RowsAffected = &affected
+		}
+		result.Status = queryOK
+		return result
+	}
+
+	// The rows must be closed before the next statement uses the connection
+	rows, err := target.QueryContext(ctx, statement.query, statement.args...)
+	if err != nil {
+		return fail(err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return fail(err)
+	}
+	result.Columns = columnInfos(types)
+
+	count, more, err := scanRows(rows, result.Columns, opts, func(row []interface{}) error {
+		result.Data = append(result.Data, row)
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	result.Status = queryOK
+	result.RowCount, result.Truncated = &count, more
+	return result
+}
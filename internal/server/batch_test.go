@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+)
+
+// batchOf returns a batch request of raw SQL statements with the options
+func batchOf(options map[string]interface{}, queries ...string) map[string]interface{} {
+	statements := make([]map[string]interface{}, len(queries))
+	for i, query := range queries {
+		statements[i] = map[string]interface{}{"query": query}
+	}
+	batch := map[string]interface{}{"statements": statements}
+	for name, value := range options {
+		batch[name] = value
+	}
+	return batch
+}
+
+func TestBatch(t *testing.T) {
+	const (
+		insert1 = "INSERT INTO t (id) VALUES (1)"
+		insert2 = "INSERT INTO t (id) VALUES (2)"
+		invalid = "INSERT INTO missing (id) VALUES (3)"
+		count   = "SELECT COUNT(*) AS n FROM t"
+	)
+
+	tests := []struct {
+		name         string
+		batch        map[string]interface{}
+		wantStatus   string
+		wantTx       string
+		wantResults  []string // Status of each statement
+		wantRows     int      // Rows in t afterwards
+		wantAffected int64    // Rows affected by the first statement
+	}{
+		{
+			name:        "transaction commits",
+			batch:       batchOf(map[string]interface{}{"transaction": true}, insert1, insert2, count),
+			wantStatus:  queryOK,
+			wantTx:      txCommitted,
+			wantResults: []string{queryOK, queryOK, queryOK},
+			wantRows:    2, wantAffected: 1,
+		},
+		{
+			name:        "rollback requested",
+			batch:       batchOf(map[string]interface{}{"transaction": true, "rollback": true}, insert1, insert2),
+			wantStatus:  queryOK,
+			wantTx:      txRolledBack,
+			wantResults: []string{queryOK, queryOK},
+			wantRows:    0, wantAffected: 1,
+		},
+		{
+			name:        "failure rolls back",
+			batch:       batchOf(map[string]interface{}{"transaction": true}, insert1, invalid, insert2),
+			wantStatus:  queryError,
+			wantTx:      txRolledBack,
+			wantResults: []string{queryOK, queryError, statementSkipped},
+			wantRows:    0, wantAffected: 1,
+		},
+		{
+			name:        "failure stops without transaction",
+			batch:       batchOf(nil, insert1, invalid, insert2),
+			wantStatus:  queryError,
+			wantResults: []string{queryOK, queryError, statementSkipped},
+			wantRows:    1, wantAffected: 1,
+		},
+		{
+			name:        "continue on error",
+			batch:       batchOf(map[string]interface{}{"continue_on_error": true}, invalid, insert1, insert2),
+			wantStatus:  queryError,
+			wantResults: []string{queryError, queryOK, queryOK},
+			wantRows:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, config.EnvConfig{}, "CREATE TABLE t (id INTEGER PRIMARY KEY)")
+
+			var response BatchResponse
+			if code := postJSON(t, s, "/api/v1/batch", tt.batch, &response); code != http.StatusOK {
+				t.Fatalf("status code %d, want %d: %+v", code, http.StatusOK, response)
+			}
+
+			statuses := make([]string, len(response.Results))
+			for i, result := range response.Results {
+				statuses[i] = result.Status
+			}
+			if response.Status != tt.wantStatus || response.Success != (tt.wantStatus == queryOK) ||
+				response.Transaction != tt.wantTx || !reflect.DeepEqual(statuses, tt.wantResults) {
+				t.Errorf("batch %s, success %v, transaction %q, statements %v; want %s, transaction %q, statements %v",
+					response.Status, response.Success, response.Transaction, statuses, tt.wantStatus, tt.wantTx, tt.wantResults)
+			}
+			if first := response.Results[0]; first.RowsAffected != nil && *first.RowsAffected != tt.wantAffected {
+				t.Errorf("first statement affected %d rows, want %d", *first.RowsAffected, tt.wantAffected)
+			}
+
+			var rows int
+			if err := s.dbManager.ExecuteQueryRow(context.Background(), count, nil, &rows); err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.wantRows {
+				t.Errorf("%d rows after the batch, want %d", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestBatchQueryResults(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{},
+		"CREATE TABLE t (id INTEGER PRIMARY KEY)",
+		"INSERT INTO t (id) VALUES (1), (2), (3)",
+	)
+
+	var response BatchResponse
+	batch := batchOf(map[string]interface{}{"transaction": true, "max_rows": 2},
+		"INSERT INTO t (id) VALUES (4)", "SELECT id FROM t ORDER BY id")
+	postJSON(t, s, "/api/v1/batch", batch, &response)
+
+	if len(response.Results) != 2 {
+		t.Fatalf("%d results, want 2", len(response.Results))
+	}
+	// Later statements see the writes of earlier ones in the transaction
+	rows := response.Results[1]
+	if rows.RowCount == nil || *rows.RowCount != 2 || !rows.Truncated || len(rows.Data) != 2 {
+		t.Errorf("select result %+v, want 2 rows truncated", rows)
+	}
+	if response.Results[0].RowsAffected == nil || *response.Results[0].RowsAffected != 1 {
+		t.Errorf("insert result %+v, want 1 row affected", response.Results[0])
+	}
+}
+
+func TestBatchInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.EnvConfig
+		batch    map[string]interface{}
+		wantCode int
+	}{
+		{name: "no statements", batch: batchOf(nil), wantCode: http.StatusBadRequest},
+		{name: "empty statement", batch: batchOf(nil, "SELECT 1", ""), wantCode: http.StatusBadRequest},
+		{name: "too many statements", cfg: config.EnvConfig{BatchMaxStatements: 1}, batch: batchOf(nil, "SELECT 1", "SELECT 2"), wantCode: http.StatusBadRequest},
+		{name: "rollback without transaction", batch: batchOf(map[string]interface{}{"rollback": true}, "SELECT 1"), wantCode: http.StatusBadRequest},
+		{name: "isolation without transaction", batch: batchOf(map[string]interface{}{"isolation": "serializable"}, "SELECT 1"), wantCode: http.StatusBadRequest},
+		{name: "continue on error in transaction", batch: batchOf(map[string]interface{}{"transaction": true, "continue_on_error": true}, "SELECT 1"), wantCode: http.StatusBadRequest},
+		{name: "unknown isolation", batch: batchOf(map[string]interface{}{"transaction": true, "isolation": "chaos"}, "SELECT 1"), wantCode: http.StatusBadRequest},
+		{name: "negative max rows", batch: batchOf(map[string]interface{}{"max_rows": -1}, "SELECT 1"), wantCode: http.StatusBadRequest},
+		{name: "policy rejects a statement", cfg: config.EnvConfig{SQLPolicyMode: config.SQLPolicyReadOnly}, batch: batchOf(nil, "SELECT 1", "DELETE FROM t"), wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.cfg, "CREATE TABLE t (id INTEGER PRIMARY KEY)", "INSERT INTO t (id) VALUES (1)")
+
+			var response map[string]interface{}
+			if code := postJSON(t, s, "/api/v1/batch", tt.batch, &response); code != tt.wantCode {
+				t.Fatalf("status code %d, want %d: %v", code, tt.wantCode, response)
+			}
+
+			// Nothing ran, the policy checks every statement first
+			var rows int
+			if err := s.dbManager.ExecuteQueryRow(context.Background(), "SELECT COUNT(*) FROM t", nil, &rows); err != nil {
+				t.Fatal(err)
+			}
+			if rows != 1 {
+				t.Errorf("%d rows after a rejected batch, want 1", rows)
+			}
+		})
+	}
+}
+
+func TestBatchReadOnly(t *testing.T) {
+	tests := []struct {
+		name        string
+		batch       map[string]interface{}
+		wantResults []string
+	}{
+		{name: "transaction", batch: batchOf(map[string]interface{}{"transaction": true}, "SELECT COUNT(*) FROM t", "SELECT id FROM t"), wantResults: []string{queryOK, queryOK}},
+		{name: "statements", batch: batchOf(nil, "SELECT COUNT(*) FROM t", "SELECT id FROM t"), wantResults: []string{queryOK, queryOK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, config.EnvConfig{SQLPolicyMode: config.SQLPolicyReadOnly}, "CREATE TABLE t (id INTEGER PRIMARY KEY)")
+
+			var response BatchResponse
+			postJSON(t, s, "/api/v1/batch", tt.batch, &response)
+			statuses := make([]string, len(response.Results))
+			for i, result := range response.Results {
+				statuses[i] = result.Status
+			}
+			if !response.Success || !reflect.DeepEqual(statuses, tt.wantResults) {
+				t.Errorf("batch %s, statements %v, error %q; want statements %v", response.Status, statuses, response.Error, tt.wantResults)
+			}
+
+			// The read-only transactions reset the connection afterwards
+			if _, err := s.dbManager.ExecuteExec(context.Background(), "INSERT INTO t (id) VALUES (1)"); err != nil {
+				t.Errorf("write after a read-only batch: %v", err)
+			}
+		})
+	}
+}
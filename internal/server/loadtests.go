@@ -0,0 +1,412 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/loadtest"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Load test states reported by the remote control API
+const (
+	loadTestRunning   = "running"
+	loadTestStopping  = "stopping"
+	loadTestCompleted = "completed"
+	loadTestStopped   = "stopped"
+	loadTestFailed    = "failed"
+)
+
+const (
+	// maxLoadTestConfigSize limits the size of a posted load test config
+	maxLoadTestConfigSize = 1 << 20
+	// loadTestStopWait is how long DELETE waits for a test to stop before
+	// answering that it is still stopping
+	loadTestStopWait = 10 * time.Second
+)
+
+// remoteLoadTest is a load test started through the API
+type remoteLoadTest struct {
+	id        string
+	config    *config.Config
+	tester    *loadtest.LoadTester
+	collector *metrics.Collector
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed when the test stopped and released its connections
+	startedAt time.Time
+
+	mu         sync.Mutex
+	status     string
+	err        string
+	finishedAt time.Time
+}
+
+// ScaleRequest represents a request to change the users of a load test
+type ScaleRequest struct {
+	Users        int    `json:"users"`
+	RampDuration string `json:"ramp_duration"`
+}
+
+// LoadTestResponse represents the state of a load test
+type LoadTestResponse struct {
+	ID          string                 `json:"id"`
+	Status      string                 `json:"status"`
+	Executor    string                 `json:"executor"`
+	Database    string                 `json:"database"`
+	Duration    time.Duration          `json:"duration"`
+	TargetUsers int                    `json:"target_users,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	StartedAt   time.Time              `json:"started_at"`
+	FinishedAt  *time.Time             `json:"finished_at,omitempty"`
+	Stats       map[string]interface{} `json:"stats,omitempty"`
+}
+
+// handleStartLoadTest handles POST /api/v1/loadtests
+func (s *Server) handleStartLoadTest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLoadTestConfigSize))
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Failed to read config", err)
+		return
+	}
+
+	cfg, err := config.Parse(body)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid load test config", err)
+		return
+	}
+	if err := confineFiles(cfg, s.config.LoadTestFilesDir); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid load test config", err)
+		return
+	}
+
+	s.loadTestsMu.Lock()
+	for _, test := range s.loadTests {
+		if test.state() == loadTestRunning || test.state() == loadTestStopping {
+			s.loadTestsMu.Unlock()
+			s.sendErrorResponse(w, http.StatusConflict, "A load test is already running",
+				fmt.Errorf("load test %s is %s", test.id, test.state()))
+			return
+		}
+	}
+
+	s.nextLoadTestID++
+	ctx, cancel := context.WithCancel(context.Background())
+	collector := metrics.NewCollector(cfg.Metrics.Prometheus.Buckets)
+	test := &remoteLoadTest{
+		id:        fmt.Sprintf("lt-%d", s.nextLoadTestID),
+		config:    cfg,
+		tester:    loadtest.NewLoadTester(cfg, collector),
+		collector: collector,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		startedAt: time.Now(),
+		status:    loadTestRunning,
+	}
+	s.loadTests[test.id] = test
+	s.loadTestsMu.Unlock()
+
+	logrus.Infof("Starting load test %s: %s executor against %s for %v",
+		test.id, cfg.Test.Executor, cfg.Database.Type, cfg.Test.Duration)
+	go test.run(ctx)
+
+	w.Header().Set("Location", "/api/v1/loadtests/"+test.id)
+	s.sendJSONResponse(w, http.StatusCreated, test.response(false))
+}
+
+// handleListLoadTests handles GET /api/v1/loadtests
+func (s *Server) handleListLoadTests(w http.ResponseWriter, r *http.Request) {
+	s.loadTestsMu.Lock()
+	tests := make([]*remoteLoadTest, 0, len(s.loadTests))
+	for _, test := range s.loadTests {
+		tests = append(tests, test)
+	}
+	s.loadTestsMu.Unlock()
+
+	sort.Slice(tests, func(i, j int) bool {
+		return tests[i].startedAt.Before(tests[j].startedAt)
+	})
+
+	responses := make([]LoadTestResponse, len(tests))
+	for i, test := range tests {
+		responses[i] = test.response(false)
+	}
+
+	s.sendJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"load_tests": responses,
+		"timestamp":  time.Now(),
+	})
+}
+
+// handleGetLoadTest handles GET /api/v1/loadtests/{id}
+func (s *Server) handleGetLoadTest(w http.ResponseWriter, r *http.Request) {
+	test := s.lookupLoadTest(w, r)
+	if test == nil {
+		return
+	}
+
+	s.sendJSONResponse(w, http.StatusOK, test.response(true))
+}
+
+// handleLoadTestMetrics handles GET /api/v1/loadtests/{id}/metrics
+func (s *Server) handleLoadTestMetrics(w http.ResponseWriter, r *http.Request) {
+	test := s.lookupLoadTest(w, r)
+	if test == nil {
+		return
+	}
+
+	test.collector.Handler().ServeHTTP(w, r)
+}
+
+// handleScaleLoadTest handles PATCH /api/v1/loadtests/{id}
+func (s *Server) handleScaleLoadTest(w http.ResponseWriter, r *http.Request) {
+	test := s.lookupLoadTest(w, r)
+	if test == nil {
+		return
+	}
+
+	var req ScaleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if req.Users <= 0 {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Users must be positive", nil)
+		return
+	}
+
+	var rampDuration time.Duration
+	if req.RampDuration != "" {
+		duration, err := time.ParseDuration(req.RampDuration)
+		if err != nil || duration < 0 {
+			s.sendErrorResponse(w, http.StatusBadRequest, "Invalid ramp duration", err)
+			return
+		}
+		rampDuration = duration
+	}
+
+	if status := test.state(); status != loadTestRunning {
+		s.sendErrorResponse(w, http.StatusConflict, "Load test is not running",
+			fmt.Errorf("load test %s is %s", test.id, status))
+		return
+	}
+	if err := test.tester.CanScaleUsers(req.Users); err != nil {
+		s.sendErrorResponse(w, http.StatusConflict, "Load test cannot be scaled", err)
+		return
+	}
+
+	// Ramping takes as long as requested, answer once scaling started
+	go func() {
+		description := fmt.Sprintf("remote request for %d users", req.Users)
+		if err := test.tester.ScaleUsers(req.Users, rampDuration, description); err != nil {
+			logrus.Errorf("Failed to scale load test %s: %v", test.id, err)
+		}
+	}()
+
+	response := test.response(false)
+	response.TargetUsers = req.Users
+	s.sendJSONResponse(w, http.StatusAccepted, response)
+}
+
+// handleStopLoadTest handles DELETE /api/v1/loadtests/{id}. A running test
+// is stopped and stays listed with its results; a test that has stopped is
+// removed together with its results.
+func (s *Server) handleStopLoadTest(w http.ResponseWriter, r *http.Request) {
+	test := s.lookupLoadTest(w, r)
+	if test == nil {
+		return
+	}
+
+	select {
+	case <-test.done:
+		s.loadTestsMu.Lock()
+		delete(s.loadTests, test.id)
+		s.loadTestsMu.Unlock()
+
+		s.sendJSONResponse(w, http.StatusOK, test.response(true))
+		return
+	default:
+	}
+
+	test.stop()
+
+	select {
+	case <-test.done:
+		s.sendJSONResponse(w, http.StatusOK, test.response(true))
+	case <-time.After(loadTestStopWait):
+		s.sendJSONResponse(w, http.StatusAccepted, test.response(false))
+	}
+}
+
+// confineFiles keeps the files a posted config reads and writes inside dir,
+// so API callers cannot reach other files of the host. Paths must be
+// relative and are resolved in dir. Without a dir, output files are not
+// written and configs that read files are rejected.
+func confineFiles(cfg *config.Config, dir string) error {
+	sqliteFile := cfg.Database.Type == "sqlite" && cfg.Database.Database != ":memory:"
+
+	if dir == "" {
+		cfg.Metrics.OutputFile = ""
+		cfg.Metrics.TimeSeriesFile = ""
+		cfg.Metrics.ReportFile = ""
+		if len(cfg.Test.DataSources) > 0 {
+			return fmt.Errorf("data sources require LOADTEST_FILES_DIR on the server")
+		}
+		if sqliteFile {
+			return fmt.Errorf("SQLite database files require LOADTEST_FILES_DIR on the server")
+		}
+		return nil
+	}
+
+	type file struct {
+		setting string
+		path    *string
+	}
+	files := []file{
+		{"metrics.output_file", &cfg.Metrics.OutputFile},
+		{"metrics.timeseries_file", &cfg.Metrics.TimeSeriesFile},
+		{"metrics.report_file", &cfg.Metrics.ReportFile},
+	}
+	for i := range cfg.Test.DataSources {
+		files = append(files, file{fmt.Sprintf("test.data_sources[%d].file", i), &cfg.Test.DataSources[i].File})
+	}
+	if sqliteFile {
+		files = append(files, file{"database.database", &cfg.Database.Database})
+	}
+
+	for _, f := range files {
+		if *f.path == "" {
+			continue
+		}
+		if !filepath.IsLocal(*f.path) {
+			return fmt.Errorf("%s must be a relative path inside LOADTEST_FILES_DIR, got %q", f.setting, *f.path)
+		}
+		*f.path = filepath.Join(dir, *f.path)
+	}
+	return nil
+}
+
+// lookupLoadTest returns the load test named in the URL, or sends a not
+// found response and returns nil
+func (s *Server) lookupLoadTest(w http.ResponseWriter, r *http.Request) *remoteLoadTest {
+	id := mux.Vars(r)["id"]
+
+	s.loadTestsMu.Lock()
+	test := s.loadTests[id]
+	s.loadTestsMu.Unlock()
+
+	if test == nil {
+		s.sendErrorResponse(w, http.StatusNotFound, "Load test not found", fmt.Errorf("no load test %q", id))
+	}
+	return test
+}
+
+// stopLoadTests stops all load tests and waits until they released their
+// connections or the context ends
+func (s *Server) stopLoadTests(ctx context.Context) {
+	s.loadTestsMu.Lock()
+	tests := make([]*remoteLoadTest, 0, len(s.loadTests))
+	for _, test := range s.loadTests {
+		tests = append(tests, test)
+	}
+	s.loadTestsMu.Unlock()
+
+	for _, test := range tests {
+		test.stop()
+	}
+	for _, test := range tests {
+		select {
+		case <-test.done:
+		case <-ctx.Done():
+			logrus.Warnf("Load test %s did not stop before shutdown", test.id)
+			return
+		}
+	}
+}
+
+// run runs the load test and releases its connections
+func (t *remoteLoadTest) run(ctx context.Context) {
+	defer close(t.done)
+	defer t.cancel()
+
+	runErr := t.tester.Run(ctx)
+	if err := t.tester.Close(); err != nil {
+		logrus.Errorf("Error cleaning up load test %s: %v", t.id, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.finishedAt = time.Now()
+	switch {
+	case t.status == loadTestStopping:
+		t.status = loadTestStopped
+	case runErr != nil:
+		t.status = loadTestFailed
+	default:
+		t.status = loadTestCompleted
+	}
+	if runErr != nil {
+		t.err = runErr.Error()
+	}
+
+	if runErr != nil && !errors.Is(runErr, loadtest.ErrThresholdsFailed) && !errors.Is(runErr, loadtest.ErrAborted) {
+		logrus.Errorf("Load test %s failed: %v", t.id, runErr)
+	} else {
+		logrus.Infof("Load test %s %s", t.id, t.status)
+	}
+}
+
+// stop cancels the load test if it is running
+func (t *remoteLoadTest) stop() {
+	t.mu.Lock()
+	if t.status == loadTestRunning {
+		t.status = loadTestStopping
+		logrus.Infof("Stopping load test %s", t.id)
+	}
+	t.mu.Unlock()
+
+	t.cancel()
+}
+
+// state returns the current status of the load test
+func (t *remoteLoadTest) state() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// response describes the load test, with its live statistics if requested
+func (t *remoteLoadTest) response(withStats bool) LoadTestResponse {
+	t.mu.Lock()
+	response := LoadTestResponse{
+		ID:        t.id,
+		Status:    t.status,
+		Executor:  t.config.Test.Executor,
+		Database:  t.config.Database.Type,
+		Duration:  t.config.Test.Duration,
+		Error:     t.err,
+		StartedAt: t.startedAt,
+	}
+	if !t.finishedAt.IsZero() {
+		finishedAt := t.finishedAt
+		response.FinishedAt = &finishedAt
+	}
+	t.mu.Unlock()
+
+	if withStats {
+		response.Stats = t.tester.GetStats()
+	}
+	return response
+}
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/metrics"
+)
+
+// testLoadTestConfig is a closed-loop load test of a SQLite database that
+// runs until it is stopped
+const testLoadTestConfig = `
+database:
+  type: sqlite
+  database: loadtest.db
+test:
+  duration: 1h
+  concurrent_users: 2
+  ramp_up_time: 0s
+  think_time: 10ms
+  queries:
+    - name: one
+      sql: SELECT 1
+      weight: 1
+metrics:
+  interval: 1h
+`
+
+// sendRequest sends a request with a raw body to the server and decodes the
+// response into response. It returns the status code.
+func sendRequest(t *testing.T, s *Server, method, path, body string, response interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+		t.Fatalf("%s %s: decode %q: %v", method, path, w.Body.String(), err)
+	}
+	return w.Code
+}
+
+func TestLoadTestLifecycle(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{LoadTestFilesDir: t.TempDir()})
+	t.Cleanup(func() { s.stopLoadTests(context.Background()) })
+
+	var started LoadTestResponse
+	if code := sendRequest(t, s, http.MethodPost, "/api/v1/loadtests", testLoadTestConfig, &started); code != http.StatusCreated {
+		t.Fatalf("POST /loadtests = %d, want %d", code, http.StatusCreated)
+	}
+	if started.ID == "" || started.Status != loadTestRunning || started.Database != "sqlite" {
+		t.Fatalf("POST /loadtests = %+v, want a running sqlite test", started)
+	}
+	path := "/api/v1/loadtests/" + started.ID
+
+	// One test runs at a time
+	var conflict map[string]interface{}
+	if code := sendRequest(t, s, http.MethodPost, "/api/v1/loadtests", testLoadTestConfig, &conflict); code != http.StatusConflict {
+		t.Errorf("second POST /loadtests = %d, want %d", code, http.StatusConflict)
+	}
+
+	var invalid map[string]interface{}
+	if code := sendRequest(t, s, http.MethodPatch, path, `{"users": 0}`, &invalid); code != http.StatusBadRequest {
+		t.Errorf("PATCH with 0 users = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Scaling is possible once the ramp-up finished
+	var scaled LoadTestResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code := sendRequest(t, s, http.MethodPatch, path, `{"users": 3}`, &scaled)
+		if code == http.StatusAccepted {
+			break
+		}
+		if code != http.StatusConflict || time.Now().After(deadline) {
+			t.Fatalf("PATCH %s = %d, want %d", path, code, http.StatusAccepted)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if scaled.TargetUsers != 3 {
+		t.Errorf("PATCH %s target users = %d, want 3", path, scaled.TargetUsers)
+	}
+
+	// The first DELETE stops the test and keeps it listed
+	var stopped LoadTestResponse
+	if code := sendRequest(t, s, http.MethodDelete, path, "", &stopped); code != http.StatusOK {
+		t.Fatalf("first DELETE %s = %d, want %d", path, code, http.StatusOK)
+	}
+	if stopped.Status != loadTestStopped || stopped.FinishedAt == nil {
+		t.Errorf("first DELETE %s = %+v, want a finished stopped test", path, stopped)
+	}
+	var listed LoadTestResponse
+	if code := sendRequest(t, s, http.MethodGet, path, "", &listed); code != http.StatusOK || listed.Status != loadTestStopped {
+		t.Errorf("GET %s after first DELETE = %d %q, want %d %q", path, code, listed.Status, http.StatusOK, loadTestStopped)
+	}
+	if code := sendRequest(t, s, http.MethodPatch, path, `{"users": 3}`, &invalid); code != http.StatusConflict {
+		t.Errorf("PATCH of a stopped test = %d, want %d", code, http.StatusConflict)
+	}
+
+	// The second DELETE removes it
+	var removed LoadTestResponse
+	if code := sendRequest(t, s, http.MethodDelete, path, "", &removed); code != http.StatusOK {
+		t.Fatalf("second DELETE %s = %d, want %d", path, code, http.StatusOK)
+	}
+	var missing map[string]interface{}
+	if code := sendRequest(t, s, http.MethodGet, path, "", &missing); code != http.StatusNotFound {
+		t.Errorf("GET %s after second DELETE = %d, want %d", path, code, http.StatusNotFound)
+	}
+	if code := sendRequest(t, s, http.MethodDelete, path, "", &missing); code != http.StatusNotFound {
+		t.Errorf("third DELETE %s = %d, want %d", path, code, http.StatusNotFound)
+	}
+
+	// A new test may start once the previous one stopped
+	if code := sendRequest(t, s, http.MethodPost, "/api/v1/loadtests", testLoadTestConfig, &started); code != http.StatusCreated {
+		t.Errorf("POST /loadtests after stop = %d, want %d", code, http.StatusCreated)
+	}
+}
+
+func TestStartLoadTestRejectsFiles(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{LoadTestFilesDir: t.TempDir()})
+
+	body := strings.Replace(testLoadTestConfig, "  interval: 1h\n", "  interval: 1h\n  report_file: /tmp/report.html\n", 1)
+	var response map[string]interface{}
+	if code := sendRequest(t, s, http.MethodPost, "/api/v1/loadtests", body, &response); code != http.StatusBadRequest {
+		t.Errorf("POST /loadtests with an absolute report file = %d, want %d", code, http.StatusBadRequest)
+	}
+	if len(s.loadTests) != 0 {
+		t.Errorf("rejected config started %d load tests", len(s.loadTests))
+	}
+}
+
+func TestConfineFiles(t *testing.T) {
+	const dir = "/srv/loadtests"
+
+	parse := func(t *testing.T, doc string) *config.Config {
+		t.Helper()
+		cfg, err := config.Parse([]byte(doc))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		return cfg
+	}
+	withDataSource := strings.Replace(testLoadTestConfig, "metrics:\n", "  data_sources:\n    - name: users\n      file: FILE\nmetrics:\n", 1)
+
+	t.Run("resolved in dir", func(t *testing.T) {
+		cfg := parse(t, strings.Replace(withDataSource, "FILE", "data/users.csv", 1))
+		cfg.Metrics.ReportFile = "reports/run.html"
+		if err := confineFiles(cfg, dir); err != nil {
+			t.Fatalf("confineFiles: %v", err)
+		}
+		for name, got := range map[string]string{
+			"output file": cfg.Metrics.OutputFile,
+			"report file": cfg.Metrics.ReportFile,
+			"data source": cfg.Test.DataSources[0].File,
+			"database":    cfg.Database.Database,
+		} {
+			if !strings.HasPrefix(got, dir+string(filepath.Separator)) {
+				t.Errorf("%s = %q, want a path in %s", name, got, dir)
+			}
+		}
+		if cfg.Metrics.TimeSeriesFile != "" {
+			t.Errorf("unset time series file became %q", cfg.Metrics.TimeSeriesFile)
+		}
+	})
+
+	for _, path := range []string{"/etc/passwd", "../secret.csv", "data/../../secret.csv"} {
+		t.Run("data source "+path, func(t *testing.T) {
+			cfg := parse(t, strings.Replace(withDataSource, "FILE", path, 1))
+			if err := confineFiles(cfg, dir); err == nil {
+				t.Errorf("confineFiles accepted data source file %q", path)
+			}
+		})
+	}
+
+	t.Run("sqlite outside dir", func(t *testing.T) {
+		cfg := parse(t, strings.Replace(testLoadTestConfig, "loadtest.db", "/var/lib/app.db", 1))
+		if err := confineFiles(cfg, dir); err == nil {
+			t.Error("confineFiles accepted an absolute SQLite database")
+		}
+	})
+
+	t.Run("no dir", func(t *testing.T) {
+		cfg := parse(t, strings.Replace(testLoadTestConfig, "loadtest.db", `":memory:"`, 1))
+		cfg.Metrics.TimeSeriesFile = "series.jsonl"
+		cfg.Metrics.ReportFile = "/tmp/report.html"
+		if err := confineFiles(cfg, ""); err != nil {
+			t.Fatalf("confineFiles: %v", err)
+		}
+		if cfg.Metrics.OutputFile != "" || cfg.Metrics.TimeSeriesFile != "" || cfg.Metrics.ReportFile != "" {
+			t.Errorf("output files %q, %q, %q kept without a dir",
+				cfg.Metrics.OutputFile, cfg.Metrics.TimeSeriesFile, cfg.Metrics.ReportFile)
+		}
+	})
+
+	t.Run("no dir with files to read", func(t *testing.T) {
+		if err := confineFiles(parse(t, strings.Replace(withDataSource, "FILE", "users.csv", 1)), ""); err == nil {
+			t.Error("confineFiles accepted a data source without a dir")
+		}
+		if err := confineFiles(parse(t, testLoadTestConfig), ""); err == nil {
+			t.Error("confineFiles accepted a SQLite database file without a dir")
+		}
+	})
+}
+
+// streamEvent is one Server-Sent Event of the live stats stream
+type streamEvent struct {
+	name string
+	data string
+}
+
+// readEvents sends the events of a Server-Sent Events stream until it ends
+func readEvents(body *bufio.Reader, events chan<- streamEvent) {
+	defer close(events)
+	var event streamEvent
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events <- event
+			event = streamEvent{}
+		}
+	}
+}
+
+func TestStreamLoadTest(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{LoadTestFilesDir: t.TempDir()})
+	t.Cleanup(func() { s.stopLoadTests(context.Background()) })
+	httpServer := httptest.NewServer(s.router)
+	defer httpServer.Close()
+
+	var started LoadTestResponse
+	if code := sendRequest(t, s, http.MethodPost, "/api/v1/loadtests", testLoadTestConfig, &started); code != http.StatusCreated {
+		t.Fatalf("POST /loadtests = %d, want %d", code, http.StatusCreated)
+	}
+	path := "/api/v1/loadtests/" + started.ID
+
+	response, err := http.Get(httpServer.URL + path + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s/stream = %d %s, want an event stream", path, response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	events := make(chan streamEvent)
+	go readEvents(bufio.NewReader(response.Body), events)
+	next := func() streamEvent {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed early")
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event within 5s")
+		}
+		return streamEvent{}
+	}
+
+	// The stream opens with the test's status
+	event := next()
+	var status LoadTestResponse
+	if err := json.Unmarshal([]byte(event.data), &status); event.name != streamEventStatus || err != nil || status.ID != started.ID {
+		t.Fatalf("first event = %s %s, want the status of test %s", event.name, event.data, started.ID)
+	}
+
+	// Then a snapshot of the last second every second
+	event = next()
+	var stats metrics.IntervalSnapshot
+	if err := json.Unmarshal([]byte(event.data), &stats); event.name != streamEventStats || err != nil {
+		t.Fatalf("second event = %s %s, want stats", event.name, event.data)
+	}
+	if stats.ActiveUsers != 2 || stats.Total.Requests == 0 || stats.Queries["one"].Requests == 0 {
+		t.Errorf("stats = %+v, want 2 users running query one", stats)
+	}
+
+	// Stopping the test ends the stream with its final status
+	var stopped LoadTestResponse
+	if code := sendRequest(t, s, http.MethodDelete, path, "", &stopped); code != http.StatusOK {
+		t.Fatalf("DELETE %s = %d, want %d", path, code, http.StatusOK)
+	}
+	for event = next(); event.name == streamEventStats; event = next() {
+	}
+	if err := json.Unmarshal([]byte(event.data), &status); event.name != streamEventEnd || err != nil || status.Status != loadTestStopped {
+		t.Fatalf("last event = %s %s, want the stopped status", event.name, event.data)
+	}
+	if _, ok := <-events; ok {
+		t.Error("stream continued after the end event")
+	}
+}
+
+func TestStreamUnknownLoadTest(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{LoadTestFilesDir: t.TempDir()})
+
+	var missing map[string]interface{}
+	if code := sendRequest(t, s, http.MethodGet, "/api/v1/loadtests/unknown/stream", "", &missing); code != http.StatusNotFound {
+		t.Errorf("GET stream of an unknown test = %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,433 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+)
+
+// Rules a query can be rejected by
+const (
+	ruleMaxSize       = "max_size"
+	ruleMaxStatements = "max_statements"
+	ruleDeny          = "deny"
+	ruleReadOnly      = "read_only"
+	ruleNamedOnly     = "named_only"
+	ruleUnknownQuery  = "unknown_query"
+	ruleArguments     = "arguments"
+	ruleSyntax        = "syntax"
+	ruleProcedure     = "procedure"
+)
+
+// policyRules are all rules, reported in metrics even before they match
+var policyRules = []string{
+	ruleMaxSize, ruleMaxStatements, ruleDeny, ruleReadOnly,
+	ruleNamedOnly, ruleUnknownQuery, ruleArguments, ruleSyntax, ruleProcedure,
+}
+
+// readOnlyVerbs are the statements accepted in read-only mode
+var readOnlyVerbs = map[string]bool{
+	"SELECT":   true,
+	"WITH":     true,
+	"SHOW":     true,
+	"DESCRIBE": true,
+	"DESC":     true,
+}
+
+// writeKeywords reject a query in read-only mode wherever they appear. SQL
+// Server runs statements of a batch without separators, so every word is
+// checked and not only the first of each statement.
+var writeKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "INTO": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+	"GRANT": true, "REVOKE": true, "DENY": true,
+	"EXEC": true, "EXECUTE": true, "CALL": true, "DO": true, "PREPARE": true,
+	"USE": true, "BACKUP": true, "RESTORE": true, "KILL": true, "SHUTDOWN": true,
+	"DBCC": true, "BULK": true, "COPY": true, "LOAD": true, "LOCK": true,
+	"PRAGMA": true, "ATTACH": true, "DETACH": true, "VACUUM": true, "REINDEX": true,
+	"OPENROWSET": true, "OPENQUERY": true, "OPENDATASOURCE": true,
+	"WAITFOR": true, "RECONFIGURE": true, "CHECKPOINT": true, "SET": true,
+	"DISABLE": true, "ENABLE": true, "WRITETEXT": true, "UPDATETEXT": true, "SETUSER": true,
+	"BEGIN": true, "COMMIT": true, "ROLLBACK": true, "SAVE": true,
+	"SEND": true, "OPEN": true, "CLOSE": true,
+
+	// Functions with side effects a SELECT can call. Not all of them are
+	// stopped by a read-only transaction.
+	"PG_TERMINATE_BACKEND": true, "PG_CANCEL_BACKEND": true, "PG_RELOAD_CONF": true,
+	"PG_ROTATE_LOGFILE": true, "SETVAL": true, "NEXTVAL": true, "SET_CONFIG": true,
+	"LO_IMPORT": true, "LO_EXPORT": true, "LO_UNLINK": true, "LO_CREATE": true, "LO_PUT": true,
+	"DBLINK_EXEC": true, "DBLINK_CONNECT": true, "PG_ADVISORY_LOCK": true, "GET_LOCK": true,
+}
+
+// PolicyViolation describes why the SQL policy rejected a query
+type PolicyViolation struct {
+	Rule      string `json:"rule"`
+	Message   string `json:"message"`
+	Statement int    `json:"statement,omitempty"` // 1-based statement the rule matched in
+	Keyword   string `json:"keyword,omitempty"`   // Keyword the rule matched
+}
+
+// Error implements error
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+}
+
+// statusCode returns the HTTP status of the rejection
+func (v *PolicyViolation) statusCode() int {
+	switch v.Rule {
+	case ruleMaxSize:
+		return http.StatusRequestEntityTooLarge
+	case ruleUnknownQuery:
+		return http.StatusNotFound
+	case ruleArguments, ruleSyntax:
+		return http.StatusBadRequest
+	default:
+		return http.StatusForbidden
+	}
+}
+
+// namedQuery is a catalog query rewritten for the dialect
+type namedQuery struct {
+	sql    string
+	params []string          // Parameter bound to each marker, in order
+	names  []string          // Distinct parameters in order of appearance, bound by positional args
+	types  map[string]string // Declared type by lower-cased parameter name
+}
+
+// sqlPolicy decides which SQL the query endpoint runs
+type sqlPolicy struct {
+	mode          string
+	dialect       *database.Dialect
+	maxBytes      int
+	maxStatements int
+	deny          [][]string // Denied keyword sequences
+	catalog       map[string]*namedQuery
+	procedures    map[string]bool // Allowed procedures by lower-cased name, nil allows all in open mode
+}
+
+// newSQLPolicy creates the SQL policy configured in the environment
+func newSQLPolicy(cfg *config.EnvConfig, dialect *database.Dialect) (*sqlPolicy, error) {
+	policy := &sqlPolicy{
+		mode:          cfg.SQLPolicyMode,
+		dialect:       dialect,
+		maxBytes:      cfg.SQLMaxQueryBytes,
+		maxStatements: cfg.SQLMaxStatements,
+		catalog:       make(map[string]*namedQuery),
+	}
+	if policy.mode == "" {
+		policy.mode = config.SQLPolicyOpen
+	}
+
+	for _, entry := range strings.Split(cfg.SQLDenyStatements, ",") {
+		if words := strings.Fields(strings.ToUpper(entry)); len(words) > 0 {
+			policy.deny = append(policy.deny, words)
+		}
+	}
+
+	for _, name := range strings.Split(cfg.SQLAllowedProcedures, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if policy.procedures == nil {
+				policy.procedures = make(map[string]bool)
+			}
+			policy.procedures[strings.ToLower(name)] = true
+		}
+	}
+
+	if cfg.SQLQueryCatalog != "" {
+		catalog, err := config.LoadQueryCatalog(cfg.SQLQueryCatalog)
+		if err != nil {
+			return nil, err
+		}
+		for _, query := range catalog.Queries {
+			named, err := newNamedQuery(query, dialect)
+			if err != nil {
+				return nil, err
+			}
+			policy.catalog[query.ID] = named
+		}
+	}
+
+	return policy, nil
+}
+
+// newNamedQuery rewrites a catalog query for the dialect and checks its
+// declared parameter types
+func newNamedQuery(query config.NamedQuery, dialect *database.Dialect) (*namedQuery, error) {
+	sql, params := dialect.RewriteNamed(query.SQL, func(string) bool { return true })
+	named := &namedQuery{sql: sql, params: params, types: make(map[string]string, len(query.Parameters))}
+
+	seen := make(map[string]bool, len(params))
+	for _, name := range params {
+		if !seen[name] {
+			seen[name] = true
+			named.names = append(named.names, name)
+		}
+		seen[strings.ToLower(name)] = true
+	}
+
+	for name, paramType := range query.Parameters {
+		if !database.ValidParamType(paramType) {
+			return nil, fmt.Errorf("query %q: parameter %s has unsupported type %q, supported: %s",
+				query.ID, name, paramType, strings.Join(database.ParamTypes, ", "))
+		}
+		if !seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("query %q declares parameter %s that its sql does not use", query.ID, name)
+		}
+		named.types[strings.ToLower(name)] = paramType
+	}
+
+	return named, nil
+}
+
+// resolve returns the SQL and arguments a query request runs. Named queries
+// are trusted; raw SQL must pass every configured rule.
+func (p *sqlPolicy) resolve(req *QueryRequest) (string, []interface{}, *PolicyViolation) {
+	if req.QueryID != "" {
+		return p.resolveNamed(req)
+	}
+
+	if p.mode == config.SQLPolicyNamed {
+		return "", nil, &PolicyViolation{Rule: ruleNamedOnly, Message: "raw SQL is disabled, send a query_id from the catalog"}
+	}
+	if violation := p.check(req.Query); violation != nil {
+		return "", nil, violation
+	}
+
+	switch {
+	case req.Args.Named != nil:
+		// :name references of the sent arguments become the dialect's markers
+		query, params := p.dialect.BindNamed(req.Query, func(name string) bool {
+			_, ok := req.Args.Named[name]
+			return ok
+		})
+		for name := range req.Args.Named {
+			if !containsString(params, name) {
+				return "", nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("query has no parameter :%s", name)}
+			}
+		}
+		args, violation := p.bindArgs(params, req.Args.Named, nil)
+		return query, args, violation
+
+	case req.Args.Positional != nil:
+		// Portable ? placeholders become the dialect's markers
+		args := make([]interface{}, len(req.Args.Positional))
+		for i, arg := range req.Args.Positional {
+			value, err := p.dialect.BindValue(arg.Type, arg.Value)
+			if err != nil {
+				return "", nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("argument %d: %v", i+1, err)}
+			}
+			args[i] = value
+		}
+		return p.dialect.Rebind(req.Query), args, nil
+	}
+
+	return req.Query, nil, nil
+}
+
+// resolveNamed binds the arguments of a catalog query. Positional arguments
+// bind to the parameters in the order they first appear in the query.
+func (p *sqlPolicy) resolveNamed(req *QueryRequest) (string, []interface{}, *PolicyViolation) {
+	if req.Query != "" {
+		return "", nil, &PolicyViolation{Rule: ruleArguments, Message: "send either query or query_id, not both"}
+	}
+	query, ok := p.catalog[req.QueryID]
+	if !ok {
+		return "", nil, &PolicyViolation{Rule: ruleUnknownQuery, Message: fmt.Sprintf("no query %q in the catalog", req.QueryID)}
+	}
+
+	values := req.Args.Named
+	if req.Args.Positional != nil {
+		if len(req.Args.Positional) != len(query.names) {
+			return "", nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("query %q takes %d arguments (%s), got %d",
+				req.QueryID, len(query.names), strings.Join(query.names, ", "), len(req.Args.Positional))}
+		}
+		values = make(map[string]QueryArg, len(query.names))
+		for i, name := range query.names {
+			values[name] = req.Args.Positional[i]
+		}
+	}
+
+	args, violation := p.bindArgs(query.params, values, query.types)
+	if violation != nil {
+		return "", nil, violation
+	}
+	for name := range values {
+		if !containsString(query.names, name) {
+			return "", nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("query %q has no parameter %q", req.QueryID, name)}
+		}
+	}
+
+	return query.sql, args, nil
+}
+
+// bindArgs returns the driver value of the argument bound to each marker.
+// A declared type takes precedence and the argument may only repeat it.
+func (p *sqlPolicy) bindArgs(params []string, values map[string]QueryArg, types map[string]string) ([]interface{}, *PolicyViolation) {
+	args := make([]interface{}, len(params))
+	for i, name := range params {
+		arg, ok := values[name]
+		if !ok {
+			return nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("missing argument %q", name)}
+		}
+
+		paramType := arg.Type
+		if declared := types[strings.ToLower(name)]; declared != "" {
+			if arg.Type != "" && arg.Type != declared {
+				return nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("argument %q is declared as %s, not %s", name, declared, arg.Type)}
+			}
+			paramType = declared
+		}
+
+		value, err := p.dialect.BindValue(paramType, arg.Value)
+		if err != nil {
+			return nil, &PolicyViolation{Rule: ruleArguments, Message: fmt.Sprintf("argument %q: %v", name, err)}
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+// readOnlyTx reports whether a query runs in a read-only transaction: raw
+// SQL in read_only mode, on databases that have them. Named queries come
+// from the trusted catalog.
+func (p *sqlPolicy) readOnlyTx(req *QueryRequest) bool {
+	return p.mode == config.SQLPolicyReadOnly && req.QueryID == "" && p.dialect.SupportsReadOnly()
+}
+
+// check applies the size, deny-list, statement count and read-only rules
+func (p *sqlPolicy) check(query string) *PolicyViolation {
+	if p.maxBytes > 0 && len(query) > p.maxBytes {
+		return &PolicyViolation{Rule: ruleMaxSize, Message: fmt.Sprintf("query is %d bytes, limit is %d", len(query), p.maxBytes)}
+	}
+	if p.mode == config.SQLPolicyOpen && len(p.deny) == 0 && p.maxStatements <= 0 {
+		return nil
+	}
+
+	statements, err := splitStatements(query, p.dialect)
+	if err != nil {
+		return &PolicyViolation{Rule: ruleSyntax, Message: err.Error()}
+	}
+
+	if p.maxStatements > 0 && len(statements) > p.maxStatements {
+		return &PolicyViolation{Rule: ruleMaxStatements, Message: fmt.Sprintf("query has %d statements, limit is %d", len(statements), p.maxStatements)}
+	}
+
+	for i, words := range statements {
+		for _, denied := range p.deny {
+			if containsSequence(words, denied) {
+				keyword := strings.Join(denied, " ")
+				return &PolicyViolation{Rule: ruleDeny, Message: keyword + " statements are denied", Statement: i + 1, Keyword: keyword}
+			}
+		}
+
+		if p.mode != config.SQLPolicyReadOnly {
+			continue
+		}
+		if !readOnlyVerbs[words[0]] {
+			return &PolicyViolation{Rule: ruleReadOnly, Message: words[0] + " statements are not read-only", Statement: i + 1, Keyword: words[0]}
+		}
+		for _, word := range words {
+			if writeKeywords[word] {
+				return &PolicyViolation{Rule: ruleReadOnly, Message: word + " is not allowed in read-only mode", Statement: i + 1, Keyword: word}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkProcedure decides whether a stored procedure may be called. The SQL
+// of a procedure is not visible to the policy, so outside open mode only
+// procedures on the allow list are called.
+func (p *sqlPolicy) checkProcedure(name string) *PolicyViolation {
+	if p.procedures == nil {
+		if p.mode == config.SQLPolicyOpen {
+			return nil
+		}
+		return &PolicyViolation{Rule: ruleProcedure, Message: fmt.Sprintf("procedure calls are disabled in %s mode, allow them with SQL_ALLOWED_PROCEDURES", p.mode)}
+	}
+	if !p.procedures[strings.ToLower(name)] {
+		return &PolicyViolation{Rule: ruleProcedure, Message: fmt.Sprintf("procedure %s is not allowed", name)}
+	}
+	return nil
+}
+
+// catalogIDs returns the IDs of the named queries, sorted
+func (p *sqlPolicy) catalogIDs() []string {
+	ids := make([]string, 0, len(p.catalog))
+	for id := range p.catalog {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsSequence reports whether words contains seq as consecutive words
+func containsSequence(words, seq []string) bool {
+	for i := 0; i+len(seq) <= len(words); i++ {
+		match := true
+		for j := range seq {
+			if words[i+j] != seq[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// splitStatements splits a query at semicolons into statements, each reduced
+// to its upper-cased words. Literals and comments are skipped the way the
+// dialect's server parses them, so SQL cannot hide from the policy inside
+// what only looks like a literal. Quoted identifiers are words, so a quoted
+// function name is still matched. Unterminated literals and comments are an
+// error.
+func splitStatements(query string, dialect *database.Dialect) ([][]string, error) {
+	var statements [][]string
+	var words []string
+
+	endStatement := func() {
+		if len(words) > 0 {
+			statements = append(statements, words)
+			words = nil
+		}
+	}
+
+	err := dialect.Scan(query, func(kind database.TokenKind, text string) {
+		switch kind {
+		case database.TokenWord:
+			words = append(words, strings.ToUpper(text))
+		case database.TokenIdentifier:
+			if len(text) > 2 {
+				words = append(words, strings.ToUpper(text[1:len(text)-1]))
+			}
+		case database.TokenCode:
+			if strings.Contains(text, ";") {
+				endStatement()
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	endStatement()
+
+	return statements, nil
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+)
+
+func mustDialect(t *testing.T, dbType string) *database.Dialect {
+	t.Helper()
+	dialect, err := database.DialectFor(dbType)
+	if err != nil {
+		t.Fatalf("DialectFor(%q): %v", dbType, err)
+	}
+	return dialect
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		query   string
+		want    [][]string
+		wantErr bool
+	}{
+		{name: "empty", dialect: "postgres", query: " ;; ", want: nil},
+		{name: "statements", dialect: "postgres", query: "select 1; Delete from t;", want: [][]string{{"SELECT"}, {"DELETE", "FROM", "T"}}},
+		{name: "semicolon in string", dialect: "postgres", query: "SELECT 'a;b'; SELECT 2", want: [][]string{{"SELECT"}, {"SELECT"}}},
+		{name: "doubled quote", dialect: "sqlite", query: "SELECT 'it''s; DELETE'", want: [][]string{{"SELECT"}}},
+
+		// Backslashes escape in MySQL strings and PostgreSQL E'' strings only
+		{name: "mysql backslash", dialect: "mysql", query: `SELECT 'a\'; DELETE FROM t'`, want: [][]string{{"SELECT"}}},
+		{name: "postgres backslash", dialect: "postgres", query: `SELECT 'a\'; DELETE FROM t`, want: [][]string{{"SELECT"}, {"DELETE", "FROM", "T"}}},
+		{name: "postgres escape string", dialect: "postgres", query: `SELECT E'a\'; DELETE'`, want: [][]string{{"SELECT", "E"}}},
+
+		// Comments
+		{name: "dash comment", dialect: "postgres", query: "SELECT a --x; DELETE", want: [][]string{{"SELECT", "A"}}},
+		{name: "mysql dash without space", dialect: "mysql", query: "SELECT a --x; DELETE", want: [][]string{{"SELECT", "A", "X"}, {"DELETE"}}},
+		{name: "mysql dash with space", dialect: "mysql", query: "SELECT a -- x; DELETE\nFROM t", want: [][]string{{"SELECT", "A", "FROM", "T"}}},
+		{name: "mysql hash comment", dialect: "mysql", query: "SELECT 1 # ; DELETE", want: [][]string{{"SELECT"}}},
+		{name: "hash outside mysql", dialect: "postgres", query: "SELECT 1 # DELETE", want: [][]string{{"SELECT", "#", "DELETE"}}},
+		{name: "block comment", dialect: "sqlite", query: "SELECT /* ; DELETE */ 1", want: [][]string{{"SELECT"}}},
+		{name: "nested comment", dialect: "postgres", query: "SELECT /* a /* b */ DELETE */ 1", want: [][]string{{"SELECT"}}},
+		{name: "unnested comment", dialect: "sqlite", query: "SELECT /* a /* b */ DELETE */ 1", want: [][]string{{"SELECT", "DELETE"}}},
+		{name: "mysql executable comment", dialect: "mysql", query: "SELECT /*! DELETE */ 1", want: [][]string{{"SELECT", "DELETE"}}},
+		{name: "mysql versioned comment", dialect: "mysql", query: "/*!50000 DROP TABLE t */", want: [][]string{{"DROP", "TABLE", "T"}}},
+		{name: "executable comment outside mysql", dialect: "postgres", query: "SELECT /*! DELETE */ 1", want: [][]string{{"SELECT"}}},
+
+		// Dollar quotes
+		{name: "dollar quote", dialect: "postgres", query: "SELECT $$; DELETE$$", want: [][]string{{"SELECT"}}},
+		{name: "tagged dollar quote", dialect: "postgres", query: "SELECT $fn$ $$; $fn$; DELETE", want: [][]string{{"SELECT"}, {"DELETE"}}},
+
+		// Quoted identifiers are words, MySQL "" is a string
+		{name: "quoted identifier", dialect: "postgres", query: `SELECT "pg_terminate_backend"(1)`, want: [][]string{{"SELECT", "PG_TERMINATE_BACKEND"}}},
+		{name: "mysql double quotes", dialect: "mysql", query: `SELECT "DELETE"`, want: [][]string{{"SELECT"}}},
+		{name: "backticks", dialect: "mysql", query: "SELECT `get_lock`('a', 1)", want: [][]string{{"SELECT", "GET_LOCK"}}},
+		{name: "brackets", dialect: "mssql", query: "SELECT [a b] FROM [t]", want: [][]string{{"SELECT", "A B", "FROM", "T"}}},
+		{name: "empty identifier", dialect: "postgres", query: `SELECT ""`, want: [][]string{{"SELECT"}}},
+
+		// Unterminated literals and comments
+		{name: "unterminated string", dialect: "postgres", query: "SELECT 'a", wantErr: true},
+		{name: "unterminated identifier", dialect: "mssql", query: "SELECT [a", wantErr: true},
+		{name: "unterminated comment", dialect: "postgres", query: "SELECT /* /* */", wantErr: true},
+		{name: "unterminated dollar quote", dialect: "postgres", query: "SELECT $x$ a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitStatements(tt.query, mustDialect(t, tt.dialect))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("splitStatements(%q) = %q, want an error", tt.query, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitStatements(%q): %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		dialect   string
+		cfg       config.EnvConfig
+		query     string
+		rule      string // Empty if the query passes
+		statement int
+		keyword   string
+	}{
+		{name: "open", dialect: "postgres", query: "DROP TABLE t"},
+		{name: "max size", dialect: "postgres", cfg: config.EnvConfig{SQLMaxQueryBytes: 8}, query: "SELECT 123", rule: ruleMaxSize},
+		{name: "max statements", dialect: "postgres", cfg: config.EnvConfig{SQLMaxStatements: 1}, query: "SELECT 1; SELECT 2", rule: ruleMaxStatements},
+		{name: "trailing semicolon", dialect: "postgres", cfg: config.EnvConfig{SQLMaxStatements: 1}, query: "SELECT 1;"},
+		{name: "deny", dialect: "postgres", cfg: config.EnvConfig{SQLDenyStatements: "drop, alter table"}, query: "SELECT 1; alter\n TABLE t ADD c int", rule: ruleDeny, statement: 2, keyword: "ALTER TABLE"},
+		{name: "deny in string", dialect: "postgres", cfg: config.EnvConfig{SQLDenyStatements: "DROP"}, query: "SELECT 'DROP TABLE t'"},
+		{name: "syntax", dialect: "postgres", cfg: config.EnvConfig{SQLDenyStatements: "DROP"}, query: "SELECT 'a", rule: ruleSyntax},
+
+		// Read-only mode
+		{name: "select", dialect: "postgres", cfg: readOnlyConfig, query: "WITH a AS (SELECT 1) SELECT * FROM a"},
+		{name: "write verb", dialect: "postgres", cfg: readOnlyConfig, query: "DELETE FROM t", rule: ruleReadOnly, statement: 1, keyword: "DELETE"},
+		{name: "second statement", dialect: "postgres", cfg: readOnlyConfig, query: "SELECT 1; DELETE FROM t", rule: ruleReadOnly, statement: 2, keyword: "DELETE"},
+		{name: "data-modifying cte", dialect: "postgres", cfg: readOnlyConfig, query: "WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", rule: ruleReadOnly, statement: 1, keyword: "DELETE"},
+		{name: "select into", dialect: "postgres", cfg: readOnlyConfig, query: "SELECT * INTO t2 FROM t", rule: ruleReadOnly, statement: 1, keyword: "INTO"},
+		{name: "quoted function", dialect: "postgres", cfg: readOnlyConfig, query: `SELECT "pg_terminate_backend"(1)`, rule: ruleReadOnly, statement: 1, keyword: "PG_TERMINATE_BACKEND"},
+		{name: "setval", dialect: "postgres", cfg: readOnlyConfig, query: "SELECT setval('s', 1)", rule: ruleReadOnly, statement: 1, keyword: "SETVAL"},
+		{name: "set_config", dialect: "postgres", cfg: readOnlyConfig, query: "SELECT set_config('role', 'admin', false)", rule: ruleReadOnly, statement: 1, keyword: "SET_CONFIG"},
+		{name: "keyword in string", dialect: "postgres", cfg: readOnlyConfig, query: "SELECT 'DELETE FROM t'"},
+		{name: "keyword in comment", dialect: "postgres", cfg: readOnlyConfig, query: "SELECT 1 -- ; DELETE FROM t"},
+		{name: "mysql executable comment", dialect: "mysql", cfg: readOnlyConfig, query: "SELECT /*! DELETE */ 1", rule: ruleReadOnly, statement: 1, keyword: "DELETE"},
+		{name: "mysql escaped quote", dialect: "mysql", cfg: readOnlyConfig, query: `SELECT 'a\'; DELETE FROM t'`},
+		{name: "mysql get_lock", dialect: "mysql", cfg: readOnlyConfig, query: "SELECT GET_LOCK('a', 10)", rule: ruleReadOnly, statement: 1, keyword: "GET_LOCK"},
+		{name: "sqlite pragma", dialect: "sqlite", cfg: readOnlyConfig, query: "SELECT 1; PRAGMA query_only = OFF", rule: ruleReadOnly, statement: 2, keyword: "PRAGMA"},
+		{name: "sqlserver waitfor", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 WAITFOR DELAY '0:0:5'", rule: ruleReadOnly, statement: 1, keyword: "WAITFOR"},
+		{name: "sqlserver exec", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT * FROM [t]; EXEC xp_cmdshell 'dir'", rule: ruleReadOnly, statement: 2, keyword: "EXEC"},
+		{name: "sqlserver disable trigger", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 DISABLE TRIGGER ALL ON DATABASE", rule: ruleReadOnly, statement: 1, keyword: "DISABLE"},
+		{name: "sqlserver enable trigger", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 ENABLE TRIGGER ALL ON DATABASE", rule: ruleReadOnly, statement: 1, keyword: "ENABLE"},
+		{name: "sqlserver setuser", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 SETUSER 'dbo'", rule: ruleReadOnly, statement: 1, keyword: "SETUSER"},
+		{name: "sqlserver updatetext", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 UPDATETEXT t.c @p 0 NULL 'x'", rule: ruleReadOnly, statement: 1, keyword: "UPDATETEXT"},
+		{name: "sqlserver writetext", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 WRITETEXT t.c @p 'x'", rule: ruleReadOnly, statement: 1, keyword: "WRITETEXT"},
+		{name: "sqlserver checkpoint", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 CHECKPOINT", rule: ruleReadOnly, statement: 1, keyword: "CHECKPOINT"},
+		{name: "sqlserver begin tran", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 BEGIN TRAN", rule: ruleReadOnly, statement: 1, keyword: "BEGIN"},
+		{name: "sqlserver commit", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 COMMIT", rule: ruleReadOnly, statement: 1, keyword: "COMMIT"},
+		{name: "sqlserver rollback", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 ROLLBACK", rule: ruleReadOnly, statement: 1, keyword: "ROLLBACK"},
+		{name: "sqlserver save tran", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 SAVE TRAN s", rule: ruleReadOnly, statement: 1, keyword: "SAVE"},
+		{name: "sqlserver send", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 SEND ON CONVERSATION @h (N'x')", rule: ruleReadOnly, statement: 1, keyword: "SEND"},
+		{name: "sqlserver open cursor", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 OPEN c", rule: ruleReadOnly, statement: 1, keyword: "OPEN"},
+		{name: "sqlserver close cursor", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 CLOSE c", rule: ruleReadOnly, statement: 1, keyword: "CLOSE"},
+		{name: "sqlserver set", dialect: "mssql", cfg: readOnlyConfig, query: "SELECT 1 SET CONTEXT_INFO 0x01", rule: ruleReadOnly, statement: 1, keyword: "SET"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newSQLPolicy(&tt.cfg, mustDialect(t, tt.dialect))
+			if err != nil {
+				t.Fatalf("newSQLPolicy: %v", err)
+			}
+
+			violation := policy.check(tt.query)
+			if tt.rule == "" {
+				if violation != nil {
+					t.Fatalf("check(%q) = %v, want no violation", tt.query, violation)
+				}
+				return
+			}
+			if violation == nil {
+				t.Fatalf("check(%q) passed, want rule %s", tt.query, tt.rule)
+			}
+			if violation.Rule != tt.rule || violation.Statement != tt.statement || violation.Keyword != tt.keyword {
+				t.Errorf("check(%q) = rule %s, statement %d, keyword %q; want rule %s, statement %d, keyword %q",
+					tt.query, violation.Rule, violation.Statement, violation.Keyword, tt.rule, tt.statement, tt.keyword)
+			}
+		})
+	}
+}
+
+func TestSQLPolicyReadOnlyTx(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		dialect string
+		req     QueryRequest
+		want    bool
+	}{
+		{name: "raw sql", mode: config.SQLPolicyReadOnly, dialect: "postgres", req: QueryRequest{Query: "SELECT 1"}, want: true},
+		{name: "named query", mode: config.SQLPolicyReadOnly, dialect: "postgres", req: QueryRequest{QueryID: "q"}, want: false},
+		{name: "open mode", mode: config.SQLPolicyOpen, dialect: "postgres", req: QueryRequest{Query: "SELECT 1"}, want: false},
+		{name: "sqlite", mode: config.SQLPolicyReadOnly, dialect: "sqlite", req: QueryRequest{Query: "SELECT 1"}, want: true},
+		{name: "sqlserver", mode: config.SQLPolicyReadOnly, dialect: "mssql", req: QueryRequest{Query: "SELECT 1"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newSQLPolicy(&config.EnvConfig{SQLPolicyMode: tt.mode}, mustDialect(t, tt.dialect))
+			if err != nil {
+				t.Fatalf("newSQLPolicy: %v", err)
+			}
+			if got := policy.readOnlyTx(&tt.req); got != tt.want {
+				t.Errorf("readOnlyTx(%+v) = %v, want %v", tt.req, got, tt.want)
+			}
+		})
+	}
+}
+
+var readOnlyConfig = config.EnvConfig{SQLPolicyMode: config.SQLPolicyReadOnly}
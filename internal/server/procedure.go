@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/database"
+)
+
+// ProcedureRequest represents a stored procedure call
+type ProcedureRequest struct {
+	Procedure string              `json:"procedure"`  // Name, optionally schema qualified
+	Params    map[string]QueryArg `json:"params"`     // Input parameters by name
+	Outputs   map[string]QueryArg `json:"outputs"`    // Output parameters by name, with a type or an initial value
+	MaxRows   int                 `json:"max_rows"`   // Rows returned per result set at most, capped by QUERY_MAX_ROWS
+	TimeoutMS int                 `json:"timeout_ms"` // Overrides QUERY_TIMEOUT, up to QUERY_MAX_TIMEOUT
+}
+
+// ResultSet is one result set of a procedure call
+type ResultSet struct {
+	Columns   []ColumnInfo    `json:"columns"`
+	Data      [][]interface{} `json:"data"` // Rows of values in column order
+	RowCount  int             `json:"row_count"`
+	Truncated bool            `json:"truncated,omitempty"` // Rows after max_rows were skipped
+}
+
+// ProcedureResponse represents the result of a procedure call
+type ProcedureResponse struct {
+	Success      bool                   `json:"success"`
+	Status       string                 `json:"status"` // ok, error, timeout or canceled
+	ResultSets   []ResultSet            `json:"result_sets"`
+	Outputs      map[string]interface{} `json:"outputs,omitempty"`
+	ReturnStatus *int64                 `json:"return_status,omitempty"` // SQL Server only
+	Error        string                 `json:"error,omitempty"`
+	Duration     time.Duration          `json:"duration"`
+	Timestamp    time.Time              `json:"timestamp"`
+}
+
+// handleProcedure handles POST /api/v1/procedure
+func (s *Server) handleProcedure(w http.ResponseWriter, r *http.Request) {
+	var req ProcedureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if req.Procedure == "" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Procedure is required", nil)
+		return
+	}
+	if req.MaxRows < 0 {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Max rows must not be negative", nil)
+		return
+	}
+
+	if violation := s.policy.checkProcedure(req.Procedure); violation != nil {
+		s.rejectQuery(w, r, violation)
+		return
+	}
+
+	call, err := s.dbManager.Dialect().NewProcedureCall(req.Procedure, procedureParams(&req))
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid procedure call", err)
+		return
+	}
+
+	ctx, cancel, err := s.queryContext(w, r, req.TimeoutMS)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid timeout", err)
+		return
+	}
+	defer cancel()
+
+	opts := resultOptions{maxRows: s.rowLimit(req.MaxRows)}
+	response := s.callProcedure(ctx, call, opts)
+	s.sendJSONResponse(w, outcomeStatusCode(response.Status), response)
+}
+
+// procedureParams returns the parameters of a procedure request in name
+// order, so calls are built the same way every time
+func procedureParams(req *ProcedureRequest) []database.ProcedureParam {
+	params := make([]database.ProcedureParam, 0, len(req.Params)+len(req.Outputs))
+	for name, arg := range req.Params {
+		params = append(params, database.ProcedureParam{Name: name, Type: arg.Type, Value: arg.Value})
+	}
+	for name, arg := range req.Outputs {
+		params = append(params, database.ProcedureParam{Name: name, Type: arg.Type, Value: arg.Value, Output: true})
+	}
+	sort.Slice(params, func(i, j int) bool {
+		return params[i].Name < params[j].Name
+	})
+	return params
+}
+
+// callProcedure runs a procedure call and reads every result set, then the
+// output parameters and the return status
+func (s *Server) callProcedure(ctx context.Context, call *database.ProcedureCall, opts resultOptions) (response ProcedureResponse) {
+	start := time.Now()
+	response = ProcedureResponse{
+		ResultSets: []ResultSet{},
+		Timestamp:  start,
+	}
+	defer func() {
+		response.Duration = time.Since(start)
+	}()
+
+	fail := func(err error) ProcedureResponse {
+		response.Status = s.finishQuery(ctx, err)
+		response.Error = err.Error()
+		return response
+	}
+
+	rows, err := s.dbManager.ExecuteQuery(ctx, call.SQL, call.Args...)
+	if err != nil {
+		return fail(err)
+	}
+
+	for {
+		types, err := rows.ColumnTypes()
+		if err != nil {
+			rows.Close()
+			return fail(err)
+		}
+
+		// Statements without a result, such as SET NOCOUNT OFF row counts,
+		// report no columns
+		if len(types) > 0 {
+			set := ResultSet{Columns: columnInfos(types), Data: [][]interface{}{}}
+			count, more, err := scanRows(rows.Rows, set.Columns, opts, func(row []interface{}) error {
+				set.Data = append(set.Data, row)
+				return nil
+			})
+			if err != nil {
+				rows.Close()
+				return fail(err)
+			}
+			set.RowCount, set.Truncated = count, more
+			response.ResultSets = append(response.ResultSets, set)
+		}
+
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	// Output parameters and the return status arrive after the last result
+	if err := rows.Close(); err != nil {
+		return fail(err)
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	response.Outputs = call.Outputs()
+	if call.OutputRow() && len(response.ResultSets) == 1 && len(response.ResultSets[0].Data) == 1 {
+		set := response.ResultSets[0]
+		response.Outputs = make(map[string]interface{}, len(set.Columns))
+		for i, column := range set.Columns {
+			response.Outputs[column.Name] = set.Data[0][i]
+		}
+		response.ResultSets = response.ResultSets[:0]
+	}
+	for name, value := range response.Outputs {
+		response.Outputs[name] = formatValue(value, "")
+	}
+	if status, ok := call.ReturnStatus(); ok {
+		response.ReturnStatus = &status
+	}
+
+	response.Success = true
+	response.Status = s.finishQuery(ctx, nil)
+	return response
+}
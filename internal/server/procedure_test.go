@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+	"fiyuu-ktdb-loadtest/internal/database"
+)
+
+// postgresCall returns a PostgreSQL procedure call with an output parameter
+// that runs query instead, so SQLite can stand in for the server
+func postgresCall(t *testing.T, query string) *database.ProcedureCall {
+	t.Helper()
+	call, err := mustDialect(t, "postgres").NewProcedureCall("place_order", []database.ProcedureParam{
+		{Name: "order_id", Output: true},
+	})
+	if err != nil {
+		t.Fatalf("NewProcedureCall: %v", err)
+	}
+	call.SQL, call.Args = query, nil
+	return call
+}
+
+func TestCallProcedureOutputRow(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{})
+
+	// The only row of the call holds the outputs
+	response := s.callProcedure(context.Background(), postgresCall(t, "SELECT 1001 AS order_id, 'open' AS status"), resultOptions{})
+	if !response.Success {
+		t.Fatalf("call failed: %s", response.Error)
+	}
+	want := map[string]interface{}{"order_id": int64(1001), "status": "open"}
+	if !reflect.DeepEqual(response.Outputs, want) {
+		t.Errorf("Outputs = %#v, want %#v", response.Outputs, want)
+	}
+	if len(response.ResultSets) != 0 {
+		t.Errorf("output row also returned as %d result sets", len(response.ResultSets))
+	}
+	if response.ReturnStatus != nil {
+		t.Errorf("ReturnStatus = %d, PostgreSQL has none", *response.ReturnStatus)
+	}
+
+	// Any other result is returned as it is
+	response = s.callProcedure(context.Background(), postgresCall(t, "SELECT 1001 AS order_id UNION ALL SELECT 1002"), resultOptions{})
+	if !response.Success {
+		t.Fatalf("call failed: %s", response.Error)
+	}
+	if response.Outputs != nil {
+		t.Errorf("Outputs = %v from a result of two rows", response.Outputs)
+	}
+	if len(response.ResultSets) != 1 || response.ResultSets[0].RowCount != 2 {
+		t.Errorf("result sets = %+v, want one of two rows", response.ResultSets)
+	}
+}
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/sirupsen/logrus"
+)
+
+// Result formats of the query endpoint
+const (
+	formatJSON   = "json"   // One JSON document holding every row
+	formatNDJSON = "ndjson" // Newline delimited JSON, rows are streamed as they are read
+)
+
+// ndjsonFlushRows is how many streamed rows are buffered before a flush
+const ndjsonFlushRows = 100
+
+// NDJSON line types
+const (
+	lineColumns = "columns" // Column metadata, the first line
+	lineRow     = "row"     // One row
+	lineEnd     = "end"     // Summary, the last line of a successful query
+	lineError   = "error"   // Error after streaming started, the last line
+)
+
+// ColumnInfo describes a result column as reported by the driver
+type ColumnInfo struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"`
+	Nullable     *bool  `json:"nullable,omitempty"`  // Unset if the driver does not know
+	Length       *int64 `json:"length,omitempty"`    // Of variable length types
+	Precision    *int64 `json:"precision,omitempty"` // Of decimal types
+	Scale        *int64 `json:"scale,omitempty"`     // Of decimal types
+}
+
+// ndjsonLine is one line of an NDJSON result
+type ndjsonLine struct {
+	Type       string        `json:"type"`
+	Columns    []ColumnInfo  `json:"columns,omitempty"`
+	Data       []interface{} `json:"data,omitempty"` // Values in column order
+	Success    *bool         `json:"success,omitempty"`
+	Status     string        `json:"status,omitempty"` // Query status of end and error lines
+	Error      string        `json:"error,omitempty"`
+	RowCount   *int          `json:"row_count,omitempty"`
+	Truncated  bool          `json:"truncated,omitempty"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+}
+
+// resultOptions control how many rows of a query are returned and how
+type resultOptions struct {
+	format      string
+	offset      int    // Rows skipped before the first returned row
+	maxRows     int    // 0 for no limit
+	fingerprint string // Identifies the query a cursor belongs to
+	ordered     bool   // The query has an ORDER BY, so its pages are stable
+	readOnly    bool   // Run the query in a read-only transaction
+}
+
+// pageCursor is the decoded form of a next_cursor
+type pageCursor struct {
+	Offset      int    `json:"o"`
+	Fingerprint string `json:"q"`
+}
+
+// resultOptions validates the paging options of a request for the resolved
+// query and arguments. Pages skip rows of the re-executed query, so offsets
+// and cursors need an ORDER BY that gives the rows a deterministic order.
+func (s *Server) resultOptions(r *http.Request, req *QueryRequest, query string, args []interface{}) (resultOptions, error) {
+	opts := resultOptions{
+		format:      strings.ToLower(req.Format),
+		offset:      req.Offset,
+		maxRows:     req.MaxRows,
+		fingerprint: queryFingerprint(query, args),
+		ordered:     s.hasOrderBy(query),
+	}
+
+	if opts.format == "" {
+		opts.format = formatJSON
+		if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+			opts.format = formatNDJSON
+		}
+	}
+	if opts.format != formatJSON && opts.format != formatNDJSON {
+		return opts, fmt.Errorf("format must be %s or %s", formatJSON, formatNDJSON)
+	}
+	if opts.offset < 0 || opts.maxRows < 0 {
+		return opts, errors.New("offset and max_rows must not be negative")
+	}
+	if (req.Offset > 0 || req.Cursor != "") && !opts.ordered {
+		return opts, errors.New("offset and cursor require a query with an ORDER BY that gives the rows a deterministic order")
+	}
+
+	if req.Cursor != "" {
+		if req.Offset != 0 {
+			return opts, errors.New("send either cursor or offset, not both")
+		}
+		cursor, err := decodeCursor(req.Cursor)
+		if err != nil || cursor.Fingerprint != opts.fingerprint {
+			return opts, errors.New("cursor is invalid or belongs to another query")
+		}
+		opts.offset = cursor.Offset
+	}
+
+	// A buffered response holds every row in memory
+	if opts.format == formatJSON {
+		opts.maxRows = s.rowLimit(opts.maxRows)
+	}
+
+	return opts, nil
+}
+
+// rowLimit caps the rows a buffered response holds at QUERY_MAX_ROWS
+func (s *Server) rowLimit(maxRows int) int {
+	if limit := s.config.QueryMaxRows; limit > 0 && (maxRows == 0 || maxRows > limit) {
+		return limit
+	}
+	return maxRows
+}
+
+// hasOrderBy reports whether the last statement of a query has an ORDER BY
+func (s *Server) hasOrderBy(query string) bool {
+	statements, err := splitStatements(query, s.dbManager.Dialect())
+	if err != nil || len(statements) == 0 {
+		return false
+	}
+	return containsSequence(statements[len(statements)-1], []string{"ORDER", "BY"})
+}
+
+// nextCursor returns the cursor of the page after count rows of this one,
+// or "" if the query is not ordered and its pages would not be stable
+func (o resultOptions) nextCursor(count int) string {
+	if !o.ordered {
+		return ""
+	}
+	payload, _ := json.Marshal(pageCursor{Offset: o.offset + count, Fingerprint: o.fingerprint})
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// decodeCursor decodes a next_cursor
+func decodeCursor(value string) (pageCursor, error) {
+	var cursor pageCursor
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.Offset < 0 {
+		return cursor, errors.New("negative offset")
+	}
+	return cursor, nil
+}
+
+// queryFingerprint identifies a query and its arguments
+func queryFingerprint(query string, args []interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%v", query, args)))
+	return base64.RawURLEncoding.EncodeToString(sum[:9])
+}
+
+// columnInfos describes the columns of a result
+func columnInfos(types []*sql.ColumnType) []ColumnInfo {
+	columns := make([]ColumnInfo, len(types))
+	for i, t := range types {
+		columns[i] = ColumnInfo{Name: t.Name(), DatabaseType: t.DatabaseTypeName()}
+		if nullable, ok := t.Nullable(); ok {
+			columns[i].Nullable = &nullable
+		}
+		if length, ok := t.Length(); ok {
+			columns[i].Length = &length
+		}
+		if precision, scale, ok := t.DecimalSize(); ok {
+			columns[i].Precision, columns[i].Scale = &precision, &scale
+		}
+	}
+	return columns
+}
+
+// scanRows skips the offset rows and passes up to maxRows rows to emit, each
+// as its values in column order so duplicate column names survive. It
+// returns the number of rows emitted and whether rows are left after them.
+func scanRows(rows *sql.Rows, columns []ColumnInfo, opts resultOptions, emit func([]interface{}) error) (int, bool, error) {
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range columns {
+		valuePtrs[i] = &values[i]
+	}
+
+	for skipped := 0; skipped < opts.offset; skipped++ {
+		if !rows.Next() {
+			return 0, false, rows.Err()
+		}
+	}
+
+	count := 0
+	for rows.Next() {
+		if opts.maxRows > 0 && count == opts.maxRows {
+			return count, true, nil
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return count, false, err
+		}
+
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			row[i] = formatValue(values[i], column.DatabaseType)
+		}
+		if err := emit(row); err != nil {
+			return count, false, err
+		}
+		count++
+	}
+
+	return count, false, rows.Err()
+}
+
+// formatValue converts a scanned value to its JSON representation
+func formatValue(value interface{}, databaseType string) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		if databaseType == "UNIQUEIDENTIFIER" {
+			var id mssql.UniqueIdentifier
+			if err := id.Scan(v); err == nil {
+				return id.String()
+			}
+		}
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return v
+	}
+}
+
+// streamQuery executes a SQL query and streams its rows as NDJSON: a columns
+// line, one row line per row and an end line. Errors are sent as an error
+// line since the status was sent with the first line.
+func (s *Server) streamQuery(ctx context.Context, w http.ResponseWriter, query string, opts resultOptions, args ...interface{}) {
+	start := time.Now()
+
+	// Large results take longer than the server's write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Debugf("Streaming query without clearing the write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+
+	fail := func(err error) {
+		failed := false
+		status := s.finishQuery(ctx, err)
+		encoder.Encode(ndjsonLine{Type: lineError, Success: &failed, Status: status, Error: err.Error(), Duration: time.Since(start)})
+	}
+
+	rows, err := s.queryRows(ctx, query, opts, args...)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		fail(err)
+		return
+	}
+	columns := columnInfos(types)
+	if err := encoder.Encode(ndjsonLine{Type: lineColumns, Columns: columns}); err != nil {
+		return
+	}
+
+	streamed := 0
+	count, more, err := scanRows(rows.Rows, columns, opts, func(row []interface{}) error {
+		if err := encoder.Encode(ndjsonLine{Type: lineRow, Data: row}); err != nil {
+			return fmt.Errorf("failed to send row: %w", err)
+		}
+		if streamed++; streamed%ndjsonFlushRows == 0 {
+			return controller.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	succeeded := true
+	status := s.finishQuery(ctx, nil)
+	end := ndjsonLine{Type: lineEnd, Success: &succeeded, Status: status, RowCount: &count, Duration: time.Since(start)}
+	if more {
+		end.Truncated = true
+		end.NextCursor = opts.nextCursor(count)
+	}
+	encoder.Encode(end)
+}
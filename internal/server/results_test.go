@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+)
+
+// newTestServer creates a server of a new SQLite database, running the
+// setup statements first. Settings not given in cfg keep their zero value.
+func newTestServer(t *testing.T, cfg config.EnvConfig, setup ...string) *Server {
+	t.Helper()
+	cfg.DBType = "sqlite"
+	cfg.DBName = filepath.Join(t.TempDir(), "test.db")
+	cfg.AuthDisabled = true
+
+	s, err := NewServer(&cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { s.dbManager.Close() })
+
+	for _, statement := range setup {
+		if _, err := s.dbManager.ExecuteExec(context.Background(), statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	return s
+}
+
+// postJSON sends a JSON request to the server and decodes the response into
+// response. It returns the status code.
+func postJSON(t *testing.T, s *Server, path string, request, response interface{}) int {
+	t.Helper()
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+		t.Fatalf("POST %s: decode %q: %v", path, w.Body.String(), err)
+	}
+	return w.Code
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+		count  int
+	}{
+		{name: "first page", count: 100},
+		{name: "later page", offset: 300, count: 100},
+		{name: "empty page", offset: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := resultOptions{offset: tt.offset, fingerprint: queryFingerprint("SELECT 1 ORDER BY 1", nil), ordered: true}
+			cursor, err := decodeCursor(opts.nextCursor(tt.count))
+			if err != nil {
+				t.Fatalf("decodeCursor: %v", err)
+			}
+			if cursor.Offset != tt.offset+tt.count || cursor.Fingerprint != opts.fingerprint {
+				t.Errorf("cursor = %+v, want offset %d of %s", cursor, tt.offset+tt.count, opts.fingerprint)
+			}
+		})
+	}
+}
+
+func TestNextCursorUnordered(t *testing.T) {
+	opts := resultOptions{fingerprint: queryFingerprint("SELECT 1", nil)}
+	if cursor := opts.nextCursor(10); cursor != "" {
+		t.Errorf("nextCursor of an unordered query = %q, want none", cursor)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(payload string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(payload))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "empty", cursor: ""},
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "not json", cursor: encode("offset=10")},
+		{name: "wrong type", cursor: encode(`{"o":"10","q":"x"}`)},
+		{name: "negative offset", cursor: encode(`{"o":-10,"q":"x"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := decodeCursor(tt.cursor); err == nil {
+				t.Errorf("decodeCursor(%q) = %+v, want an error", tt.cursor, cursor)
+			}
+		})
+	}
+}
+
+func TestQueryFingerprint(t *testing.T) {
+	base := queryFingerprint("SELECT * FROM t WHERE id > ? ORDER BY id", []interface{}{1})
+
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+		same  bool
+	}{
+		{name: "same", query: "SELECT * FROM t WHERE id > ? ORDER BY id", args: []interface{}{1}, same: true},
+		{name: "other argument", query: "SELECT * FROM t WHERE id > ? ORDER BY id", args: []interface{}{2}},
+		{name: "other query", query: "SELECT * FROM u WHERE id > ? ORDER BY id", args: []interface{}{1}},
+		{name: "no arguments", query: "SELECT * FROM t WHERE id > ? ORDER BY id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryFingerprint(tt.query, tt.args); (got == base) != tt.same {
+				t.Errorf("fingerprint %s, base %s, want same %v", got, base, tt.same)
+			}
+		})
+	}
+}
+
+func TestHasOrderBy(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{})
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "SELECT id FROM t ORDER BY id", want: true},
+		{query: "select id from t order\n  by id desc", want: true},
+		{query: "SELECT id FROM t"},
+		{query: "SELECT 'ORDER BY' FROM t"},
+		{query: "SELECT id FROM t -- ORDER BY id"},
+		{query: "SELECT id FROM t ORDER BY id; SELECT id FROM t"},
+		{query: "SELECT id FROM t; SELECT id FROM t ORDER BY id", want: true},
+		{query: "SELECT 'unterminated ORDER BY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := s.hasOrderBy(tt.query); got != tt.want {
+				t.Errorf("hasOrderBy(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPaging(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{},
+		"CREATE TABLE t (id INTEGER PRIMARY KEY)",
+		"INSERT INTO t (id) VALUES (1), (2), (3), (4), (5)",
+	)
+	const ordered = "SELECT id FROM t ORDER BY id"
+
+	// Follow the cursors through every page
+	var ids []float64
+	req := map[string]interface{}{"query": ordered, "max_rows": 2}
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging did not end")
+		}
+		var response QueryResponse
+		if code := postJSON(t, s, "/api/v1/query", req, &response); code != http.StatusOK || !response.Success {
+			t.Fatalf("page %d: status %d, %+v", pages+1, code, response)
+		}
+		for _, row := range response.Data {
+			ids = append(ids, row[0].(float64))
+		}
+		if !response.Truncated {
+			if response.NextCursor != "" {
+				t.Errorf("last page has next_cursor %q", response.NextCursor)
+			}
+			break
+		}
+		req["cursor"] = response.NextCursor
+	}
+	if want := []float64{1, 2, 3, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("paged ids %v, want %v", ids, want)
+	}
+
+	// An ordered cursor for the first page after two rows
+	var first QueryResponse
+	postJSON(t, s, "/api/v1/query", map[string]interface{}{"query": ordered, "max_rows": 2}, &first)
+
+	tests := []struct {
+		name string
+		req  map[string]interface{}
+	}{
+		{name: "offset without order by", req: map[string]interface{}{"query": "SELECT id FROM t", "offset": 2}},
+		{name: "cursor without order by", req: map[string]interface{}{"query": "SELECT id FROM t", "cursor": first.NextCursor}},
+		{name: "cursor of another query", req: map[string]interface{}{"query": "SELECT id FROM t ORDER BY id DESC", "cursor": first.NextCursor}},
+		{name: "cursor with arguments", req: map[string]interface{}{"query": "SELECT id FROM t WHERE id > ? ORDER BY id", "args": []int{0}, "cursor": first.NextCursor}},
+		{name: "cursor and offset", req: map[string]interface{}{"query": ordered, "offset": 2, "cursor": first.NextCursor}},
+		{name: "negative offset", req: map[string]interface{}{"query": ordered, "offset": -1}},
+		{name: "invalid cursor", req: map[string]interface{}{"query": ordered, "cursor": "garbage"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response map[string]interface{}
+			if code := postJSON(t, s, "/api/v1/query", tt.req, &response); code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d: %v", code, http.StatusBadRequest, response)
+			}
+		})
+	}
+}
+
+func TestQueryRowsKeepColumnOrder(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{QueryTimeout: time.Second, QueryStreamTimeout: time.Second},
+		"CREATE TABLE a (id INTEGER, zeta TEXT)",
+		"CREATE TABLE b (id INTEGER, alpha TEXT)",
+		"INSERT INTO a VALUES (1, 'z')",
+		"INSERT INTO b VALUES (2, 'a')",
+	)
+	const query = "SELECT a.zeta, a.id, b.alpha, b.id FROM a, b"
+	wantColumns := []string{"zeta", "id", "alpha", "id"}
+	wantRow := []interface{}{"z", float64(1), "a", float64(2)}
+
+	// Duplicate names and unsorted columns survive in JSON
+	var response QueryResponse
+	if code := postJSON(t, s, "/api/v1/query", map[string]interface{}{"query": query}, &response); code != http.StatusOK || !response.Success {
+		t.Fatalf("status %d, %+v", code, response)
+	}
+	var columns []string
+	for _, column := range response.Columns {
+		columns = append(columns, column.Name)
+	}
+	if !reflect.DeepEqual(columns, wantColumns) {
+		t.Errorf("columns = %v, want %v", columns, wantColumns)
+	}
+	if len(response.Data) != 1 || !reflect.DeepEqual(response.Data[0], wantRow) {
+		t.Errorf("data = %v, want [%v]", response.Data, wantRow)
+	}
+
+	// And in NDJSON
+	body, err := json.Marshal(map[string]interface{}{"query": query, "format": "ndjson"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/query", bytes.NewReader(body)))
+	lines := bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("NDJSON has %d lines, want columns, row and end: %s", len(lines), w.Body.String())
+	}
+	var row ndjsonLine
+	if err := json.Unmarshal(lines[1], &row); err != nil {
+		t.Fatal(err)
+	}
+	if row.Type != lineRow || !reflect.DeepEqual(row.Data, wantRow) {
+		t.Errorf("NDJSON row = %s, want data %v", lines[1], wantRow)
+	}
+}
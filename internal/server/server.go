@@ -2,16 +2,21 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"fiyuu-ktdb-loadtest/internal/config"
 	"fiyuu-ktdb-loadtest/internal/database"
 
 	"github.com/gorilla/mux"
-	_ "github.com/microsoft/go-mssqldb"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,21 +26,50 @@ type Server struct {
 	dbManager *database.Manager
 	router    *mux.Router
 	server    *http.Server
+
+	// Authentication, every endpoint is public if there is no authenticator
+	authenticators []Authenticator
+	authFailures   reasonCounts
+	allowedOrigins map[string]bool // nil allows every origin
+
+	// SQL the query endpoint accepts
+	policy           *sqlPolicy
+	policyRejections reasonCounts
+
+	// Queries run for requests by status
+	queryResults reasonCounts
+
+	// Load tests started through the API, by ID
+	loadTestsMu    sync.Mutex
+	loadTests      map[string]*remoteLoadTest
+	nextLoadTestID int
 }
 
 // QueryRequest represents a query request
 type QueryRequest struct {
-	Query string `json:"query"`
+	Query   string    `json:"query"`
+	QueryID string    `json:"query_id"` // Named query from the catalog, instead of query
+	Args    QueryArgs `json:"args"`     // Array for ? placeholders, object for :name parameters
+	Format  string    `json:"format"`   // json or ndjson, json unless the Accept header asks for NDJSON
+	MaxRows int       `json:"max_rows"` // Rows returned at most, capped by QUERY_MAX_ROWS for json
+	Offset  int       `json:"offset"`   // Rows skipped before the first returned row
+	Cursor  string    `json:"cursor"`   // next_cursor of the previous page, instead of offset
+
+	TimeoutMS int `json:"timeout_ms"` // Overrides QUERY_TIMEOUT, up to QUERY_MAX_TIMEOUT
 }
 
 // QueryResponse represents a query response
 type QueryResponse struct {
-	Success      bool                     `json:"success"`
-	Data         []map[string]interface{} `json:"data,omitempty"`
-	Error        string                   `json:"error,omitempty"`
-	Duration     time.Duration            `json:"duration"`
-	RowsAffected int64                    `json:"rows_affected,omitempty"`
-	Timestamp    time.Time                `json:"timestamp"`
+	Success      bool            `json:"success"`
+	Status       string          `json:"status"`            // ok, error, timeout or canceled
+	Columns      []ColumnInfo    `json:"columns,omitempty"` // In result order
+	Data         [][]interface{} `json:"data,omitempty"`    // Rows of values in column order
+	Error        string          `json:"error,omitempty"`
+	Duration     time.Duration   `json:"duration"`
+	RowsAffected int64           `json:"rows_affected,omitempty"`
+	Truncated    bool            `json:"truncated,omitempty"`   // More rows follow, fetch them with next_cursor
+	NextCursor   string          `json:"next_cursor,omitempty"` // Cursor of the next page
+	Timestamp    time.Time       `json:"timestamp"`
 }
 
 // HealthResponse represents a health check response
@@ -61,6 +95,7 @@ func NewServer(cfg *config.EnvConfig) (*Server, error) {
 		MaxIdleConns:    cfg.DBMaxIdleConns,
 		ConnMaxLifetime: cfg.DBConnMaxLifetime,
 		ConnMaxIdleTime: cfg.DBConnMaxIdleTime,
+		QueryTimeout:    cfg.QueryTimeout,
 	}
 
 	dbManager, err := database.NewManager(dbConfig)
@@ -68,11 +103,38 @@ func NewServer(cfg *config.EnvConfig) (*Server, error) {
 		return nil, fmt.Errorf("failed to create database manager: %w", err)
 	}
 
+	authenticators, err := newAuthenticators(cfg)
+	if err != nil {
+		dbManager.Close()
+		return nil, err
+	}
+	if len(authenticators) == 0 {
+		logrus.Warn("Authentication is disabled by AUTH_DISABLED, every endpoint is public")
+	} else {
+		logrus.Infof("Authentication enabled with %d method(s)", len(authenticators))
+	}
+
+	policy, err := newSQLPolicy(cfg, dbManager.Dialect())
+	if err != nil {
+		dbManager.Close()
+		return nil, err
+	}
+	if policy.mode == config.SQLPolicyReadOnly && !dbManager.Dialect().SupportsReadOnly() && !cfg.SQLReadOnlyLogin {
+		dbManager.Close()
+		return nil, fmt.Errorf("SQL Server has no read-only transactions, SQL_POLICY_MODE=%s requires a database user with read permissions only and SQL_READ_ONLY_LOGIN=true",
+			config.SQLPolicyReadOnly)
+	}
+	logrus.Infof("SQL policy: %s mode, %d named queries", policy.mode, len(policy.catalog))
+
 	router := mux.NewRouter()
 	server := &Server{
-		config:    cfg,
-		dbManager: dbManager,
-		router:    router,
+		config:         cfg,
+		dbManager:      dbManager,
+		router:         router,
+		loadTests:      make(map[string]*remoteLoadTest),
+		authenticators: authenticators,
+		allowedOrigins: parseOrigins(cfg.CORSAllowedOrigins),
+		policy:         policy,
 	}
 
 	server.setupRoutes()
@@ -86,27 +148,38 @@ func (s *Server) setupRoutes() {
 	api := s.router.PathPrefix("/api/v1").Subrouter()
 
 	// Query endpoint
-	api.HandleFunc("/query", s.handleQuery).Methods("POST")
-	api.HandleFunc("/query", s.handleDefaultQuery).Methods("GET")
+	api.HandleFunc("/query", s.authorize(RoleQuery, s.handleQuery)).Methods("POST")
+	api.HandleFunc("/query", s.authorize(RoleRead, s.handleDefaultQuery)).Methods("GET")
+	api.HandleFunc("/procedure", s.authorize(RoleQuery, s.handleProcedure)).Methods("POST")
+	api.HandleFunc("/batch", s.authorize(RoleQuery, s.handleBatch)).Methods("POST")
 
 	// Health check
-	api.HandleFunc("/health", s.handleHealth).Methods("GET")
+	api.HandleFunc("/health", s.authorize(RoleRead, s.handleHealth)).Methods("GET")
 
 	// Database info
-	api.HandleFunc("/db/info", s.handleDBInfo).Methods("GET")
-	api.HandleFunc("/db/stats", s.handleDBStats).Methods("GET")
+	api.HandleFunc("/db/info", s.authorize(RoleRead, s.handleDBInfo)).Methods("GET")
+	api.HandleFunc("/db/stats", s.authorize(RoleRead, s.handleDBStats)).Methods("GET")
 
 	// Connection management
-	api.HandleFunc("/db/cleanup", s.handleDBCleanup).Methods("POST")
-	api.HandleFunc("/db/close", s.handleDBClose).Methods("POST")
+	api.HandleFunc("/db/cleanup", s.authorize(RoleAdmin, s.handleDBCleanup)).Methods("POST")
+	api.HandleFunc("/db/close", s.authorize(RoleAdmin, s.handleDBClose)).Methods("POST")
+
+	// Load test remote control
+	api.HandleFunc("/loadtests", s.authorize(RoleAdmin, s.handleStartLoadTest)).Methods("POST")
+	api.HandleFunc("/loadtests", s.authorize(RoleRead, s.handleListLoadTests)).Methods("GET")
+	api.HandleFunc("/loadtests/{id}", s.authorize(RoleRead, s.handleGetLoadTest)).Methods("GET")
+	api.HandleFunc("/loadtests/{id}", s.authorize(RoleAdmin, s.handleScaleLoadTest)).Methods("PATCH")
+	api.HandleFunc("/loadtests/{id}", s.authorize(RoleAdmin, s.handleStopLoadTest)).Methods("DELETE")
+	api.HandleFunc("/loadtests/{id}/metrics", s.authorize(RoleRead, s.handleLoadTestMetrics)).Methods("GET")
+	api.HandleFunc("/loadtests/{id}/stream", s.authorize(RoleRead, s.handleStreamLoadTest)).Methods("GET")
 
 	// Prometheus metrics endpoint
 	if s.config.PrometheusEnabled {
-		s.router.HandleFunc(s.config.PrometheusPath, s.handlePrometheusMetrics).Methods("GET")
+		s.router.HandleFunc(s.config.PrometheusPath, s.authorize(RoleRead, s.handlePrometheusMetrics)).Methods("GET")
 	}
 
 	// Root endpoint
-	s.router.HandleFunc("/", s.handleRoot).Methods("GET")
+	s.router.HandleFunc("/", s.authorize(RoleRead, s.handleRoot)).Methods("GET")
 
 	// Add middleware
 	s.router.Use(s.loggingMiddleware)
@@ -119,18 +192,54 @@ func (s *Server) Start() error {
 		Addr:         s.config.GetServerAddress(),
 		Handler:      s.router,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: responseWriteTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 
+	if s.config.TLSEnabled() {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		s.server.TLSConfig = tlsConfig
+
+		logrus.Infof("Starting server on %s with TLS", s.config.GetServerAddress())
+		return s.server.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+	}
+
 	logrus.Infof("Starting server on %s", s.config.GetServerAddress())
 	return s.server.ListenAndServe()
 }
 
+// tlsConfig returns the TLS settings of the server. Client certificates are
+// requested and verified against the client CA if one is set; clients
+// without one can still authenticate by other methods.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.config.TLSClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	caPEM, err := os.ReadFile(s.config.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in client CA %s", s.config.TLSClientCAFile)
+	}
+
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
 // Stop stops the HTTP server
 func (s *Server) Stop(ctx context.Context) error {
 	logrus.Info("Stopping server...")
 
+	s.stopLoadTests(ctx)
+
 	if s.dbManager != nil {
 		s.dbManager.Close()
 	}
@@ -146,21 +255,84 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Query == "" {
+	if req.Query == "" && req.QueryID == "" {
 		s.sendErrorResponse(w, http.StatusBadRequest, "Query is required", nil)
 		return
 	}
 
-	s.executeQuery(w, req.Query)
+	query, args, violation := s.policy.resolve(&req)
+	if violation != nil {
+		s.rejectQuery(w, r, violation)
+		return
+	}
+
+	opts, err := s.resultOptions(r, &req, query, args)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid result options", err)
+		return
+	}
+	opts.readOnly = s.policy.readOnlyTx(&req)
+
+	ctx, cancel, err := s.resultContext(w, r, req.TimeoutMS, opts)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid timeout", err)
+		return
+	}
+	defer cancel()
+
+	s.executeQuery(ctx, w, query, opts, args...)
+}
+
+// rejectQuery logs and counts a query the SQL policy rejected and sends the
+// violation
+func (s *Server) rejectQuery(w http.ResponseWriter, r *http.Request, violation *PolicyViolation) {
+	s.policyRejections.record(violation.Rule)
+
+	caller := r.RemoteAddr
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		caller = fmt.Sprintf("%s %q", principal.Method, principal.Subject)
+	}
+	logrus.Warnf("Query from %s rejected by SQL policy: %v", caller, violation)
+
+	s.sendJSONResponse(w, violation.statusCode(), map[string]interface{}{
+		"success":   false,
+		"error":     "Query rejected by SQL policy",
+		"violation": violation,
+		"timestamp": time.Now(),
+	})
 }
 
 // handleDefaultQuery handles GET /api/v1/query
 func (s *Server) handleDefaultQuery(w http.ResponseWriter, r *http.Request) {
-	s.executeQuery(w, s.config.DefaultQuery)
+	opts, err := s.resultOptions(r, &QueryRequest{}, s.config.DefaultQuery, nil)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, "Invalid result options", err)
+		return
+	}
+
+	ctx, cancel, _ := s.resultContext(w, r, 0, opts)
+	defer cancel()
+
+	s.executeQuery(ctx, w, s.config.DefaultQuery, opts)
+}
+
+// queryRows executes a query, in a read-only transaction if the SQL policy
+// requires one
+func (s *Server) queryRows(ctx context.Context, query string, opts resultOptions, args ...interface{}) (*database.Rows, error) {
+	if opts.readOnly {
+		return s.dbManager.ExecuteReadOnlyQuery(ctx, query, args...)
+	}
+	return s.dbManager.ExecuteQuery(ctx, query, args...)
 }
 
-// executeQuery executes a SQL query
-func (s *Server) executeQuery(w http.ResponseWriter, query string) {
+// executeQuery executes a SQL query with its arguments and sends the rows
+// selected by the result options
+func (s *Server) executeQuery(ctx context.Context, w http.ResponseWriter, query string, opts resultOptions, args ...interface{}) {
+	if opts.format == formatNDJSON {
+		s.streamQuery(ctx, w, query, opts, args...)
+		return
+	}
+
 	start := time.Now()
 	response := QueryResponse{
 		Timestamp: start,
@@ -168,74 +340,50 @@ func (s *Server) executeQuery(w http.ResponseWriter, query string) {
 
 	defer func() {
 		response.Duration = time.Since(start)
-		s.sendJSONResponse(w, http.StatusOK, response)
+		s.sendJSONResponse(w, outcomeStatusCode(response.Status), response)
 	}()
 
-	// Execute the query
-	rows, err := s.dbManager.ExecuteQuery(query)
-	if err != nil {
+	fail := func(err error) {
 		response.Success = false
+		response.Status = s.finishQuery(ctx, err)
 		response.Error = err.Error()
-		logrus.Errorf("Query execution failed: %v", err)
+	}
+
+	// Execute the query
+	rows, err := s.queryRows(ctx, query, opts, args...)
+	if err != nil {
+		fail(err)
 		return
 	}
 	defer rows.Close()
 
-	// Get column names
-	columns, err := rows.Columns()
+	// Get column metadata
+	types, err := rows.ColumnTypes()
 	if err != nil {
-		response.Success = false
-		response.Error = err.Error()
+		fail(err)
 		return
 	}
+	response.Columns = columnInfos(types)
 
 	// Scan rows
-	var results []map[string]interface{}
-	for rows.Next() {
-		// Create a slice of interface{} to hold the values
-		values := make([]interface{}, len(columns))
-		valuePtrs := make([]interface{}, len(columns))
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-
-		// Scan the row
-		if err := rows.Scan(valuePtrs...); err != nil {
-			response.Success = false
-			response.Error = err.Error()
-			return
-		}
-
-		// Create a map for this row
-		row := make(map[string]interface{})
-		for i, col := range columns {
-			val := values[i]
-			if val != nil {
-				// Handle different types
-				switch v := val.(type) {
-				case []byte:
-					row[col] = string(v)
-				case time.Time:
-					row[col] = v.Format(time.RFC3339)
-				default:
-					row[col] = v
-				}
-			} else {
-				row[col] = nil
-			}
-		}
+	var results [][]interface{}
+	count, more, err := scanRows(rows.Rows, response.Columns, opts, func(row []interface{}) error {
 		results = append(results, row)
-	}
-
-	if err := rows.Err(); err != nil {
-		response.Success = false
-		response.Error = err.Error()
+		return nil
+	})
+	if err != nil {
+		fail(err)
 		return
 	}
 
 	response.Success = true
+	response.Status = s.finishQuery(ctx, nil)
 	response.Data = results
-	response.RowsAffected = int64(len(results))
+	response.RowsAffected = int64(count)
+	if more {
+		response.Truncated = true
+		response.NextCursor = opts.nextCursor(count)
+	}
 }
 
 // handleHealth handles GET /api/v1/health
@@ -245,7 +393,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check database connection
-	if err := s.dbManager.HealthCheck(); err != nil {
+	if err := s.dbManager.HealthCheck(r.Context()); err != nil {
 		response.Status = "unhealthy"
 		response.Database = "disconnected"
 		s.sendJSONResponse(w, http.StatusServiceUnavailable, response)
@@ -353,10 +501,17 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		"endpoints": map[string]string{
 			"health":     "/api/v1/health",
 			"query":      "/api/v1/query",
+			"procedure":  "/api/v1/procedure",
+			"batch":      "/api/v1/batch",
 			"db_info":    "/api/v1/db/info",
 			"db_stats":   "/api/v1/db/stats",
 			"db_cleanup": "/api/v1/db/cleanup",
 			"db_close":   "/api/v1/db/close",
+			"load_tests": "/api/v1/loadtests",
+		},
+		"sql_policy": map[string]interface{}{
+			"mode":          s.policy.mode,
+			"named_queries": s.policy.catalogIDs(),
 		},
 		"timestamp": time.Now(),
 	}
@@ -402,12 +557,26 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// corsMiddleware adds CORS headers
+// corsMiddleware adds CORS headers for allowed origins. Without allowed
+// origins browsers get no cross-origin access.
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		origin := r.Header.Get("Origin")
+		allowed := true
+		switch {
+		case s.allowedOrigins == nil:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case s.allowedOrigins[origin]:
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		default:
+			allowed = false
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		}
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -418,6 +587,22 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// parseOrigins parses the comma separated allowed CORS origins, returning
+// nil if every origin is allowed
+func parseOrigins(value string) map[string]bool {
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			return nil
+		}
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -429,6 +614,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap returns the wrapped writer so http.ResponseController can flush
+// streams and change their deadlines
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // handlePrometheusMetrics handles Prometheus metrics endpoint
 func (s *Server) handlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
 	// Get database stats
@@ -484,6 +675,28 @@ fiyuu_ktdb_server_info{database_type="%s",database_host="%s",database_name="%s"}
 		s.config.DBName,
 	)
 
+	var counters strings.Builder
+	writeReasonCounter(&counters, "fiyuu_ktdb_auth_failures_total", "Total number of unauthorized requests", "reason",
+		s.authFailures.snapshot(authMissingCredentials, authInvalidCredentials, authForbidden))
+	writeReasonCounter(&counters, "fiyuu_ktdb_sql_policy_rejections_total", "Total number of queries rejected by the SQL policy", "rule",
+		s.policyRejections.snapshot(policyRules...))
+	writeReasonCounter(&counters, "fiyuu_ktdb_queries_total", "Total number of queries, procedure calls and batches run for requests", "status",
+		s.queryResults.snapshot(queryStatuses...))
+
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
-	w.Write([]byte(metrics))
+	w.Write([]byte(metrics + counters.String()))
+}
+
+// writeReasonCounter writes a counter with one series per label value in the
+// Prometheus text format
+func writeReasonCounter(b *strings.Builder, name, help, label string, counts map[string]int64) {
+	fmt.Fprintf(b, "\n# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(b, "%s{%s=\"%s\"} %d\n", name, label, value, counts[value])
+	}
 }
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// streamInterval is the delay between live stats events
+const streamInterval = time.Second
+
+// Server-Sent Events sent by the live stats stream
+const (
+	streamEventStatus = "status" // LoadTestResponse when the stream opens
+	streamEventStats  = "stats"  // metrics.IntervalSnapshot of the last second
+	streamEventEnd    = "end"    // Final LoadTestResponse, the stream closes after it
+)
+
+// handleStreamLoadTest handles GET /api/v1/loadtests/{id}/stream. It sends
+// a Server-Sent Events stream with a snapshot of the last second every second
+// until the test stops or the client disconnects.
+func (s *Server) handleStreamLoadTest(w http.ResponseWriter, r *http.Request) {
+	test := s.lookupLoadTest(w, r)
+	if test == nil {
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, "Streaming is not supported", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep reverse proxies from buffering events
+	w.WriteHeader(http.StatusOK)
+
+	if err := sendEvent(w, controller, streamEventStatus, test.response(false)); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(streamInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-test.done:
+			sendEvent(w, controller, streamEventEnd, test.response(true))
+			return
+		case <-ticker.C:
+			if err := sendEvent(w, controller, streamEventStats, test.collector.LiveSnapshot()); err != nil {
+				logrus.Debugf("Live stats stream of load test %s closed: %v", test.id, err)
+				return
+			}
+		}
+	}
+}
+
+// sendEvent writes one Server-Sent Event with a JSON payload and flushes it
+func sendEvent(w http.ResponseWriter, controller *http.ResponseController, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return controller.Flush()
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Query statuses reported in responses and metrics
+const (
+	queryOK       = "ok"
+	queryError    = "error"
+	queryTimeout  = "timeout"  // QUERY_TIMEOUT or the request's timeout_ms expired
+	queryCanceled = "canceled" // The client went away before the query finished
+)
+
+// queryStatuses are all statuses, reported in metrics even before they occur
+var queryStatuses = []string{queryOK, queryError, queryTimeout, queryCanceled}
+
+// statusClientClosedRequest is the status of requests whose client went away.
+// The client never receives it, it shows up in the request log.
+const statusClientClosedRequest = 499
+
+// responseWriteTimeout is the server's write timeout, and how long a
+// response may take to write after its queries ended
+const responseWriteTimeout = 15 * time.Second
+
+// queryContext returns the context the queries of a request run with. It is
+// canceled when the client disconnects, and timeoutMS overrides QUERY_TIMEOUT
+// up to QUERY_MAX_TIMEOUT. Without timeoutMS every query gets QUERY_TIMEOUT.
+// The write deadline of the response is extended past the query timeout.
+func (s *Server) queryContext(w http.ResponseWriter, r *http.Request, timeoutMS int) (context.Context, context.CancelFunc, error) {
+	if timeoutMS < 0 {
+		return nil, nil, errors.New("timeout_ms must not be negative")
+	}
+	if timeoutMS == 0 {
+		extendWriteDeadline(w, s.dbManager.QueryTimeout())
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+
+	timeout := time.Duration(timeoutMS) * time.Millisecond
+	if limit := s.config.QueryMaxTimeout; limit > 0 && timeout > limit {
+		return nil, nil, fmt.Errorf("timeout_ms must not exceed %d", limit.Milliseconds())
+	}
+	extendWriteDeadline(w, timeout)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// extendWriteDeadline lets the response be written up to
+// responseWriteTimeout after queries running for the timeout
+func extendWriteDeadline(w http.ResponseWriter, timeout time.Duration) {
+	deadline := time.Now().Add(timeout + responseWriteTimeout)
+	if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+		logrus.Debugf("Failed to extend the write deadline: %v", err)
+	}
+}
+
+// resultContext returns the context of a query whose rows are returned with
+// the result options. Streamed results may take longer to read, so without
+// timeoutMS they get QUERY_STREAM_TIMEOUT instead of QUERY_TIMEOUT.
+func (s *Server) resultContext(w http.ResponseWriter, r *http.Request, timeoutMS int, opts resultOptions) (context.Context, context.CancelFunc, error) {
+	if timeoutMS == 0 && opts.format == formatNDJSON {
+		ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryStreamTimeout)
+		return ctx, cancel, nil
+	}
+	return s.queryContext(w, r, timeoutMS)
+}
+
+// queryStatus returns the status of a query that ended with err. The request
+// context tells a timeout from a client that went away; drivers report both
+// in their own ways.
+func queryStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return queryOK
+	case errors.Is(ctx.Err(), context.Canceled):
+		return queryCanceled
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), database.ClassifyError(err) == database.ErrorClassTimeout:
+		return queryTimeout
+	default:
+		return queryError
+	}
+}
+
+// finishQuery counts and logs how a query ended and returns its status
+func (s *Server) finishQuery(ctx context.Context, err error) string {
+	status := queryStatus(ctx, err)
+	s.reportQuery(status, err)
+	return status
+}
+
+// reportQuery counts and logs a query status
+func (s *Server) reportQuery(status string, err error) {
+	s.queryResults.record(status)
+
+	switch status {
+	case queryCanceled:
+		logrus.Infof("Query canceled, the client went away: %v", err)
+	case queryTimeout:
+		logrus.Warnf("Query timed out: %v", err)
+	case queryError:
+		logrus.Errorf("Query execution failed: %v", err)
+	}
+}
+
+// outcomeStatusCode returns the HTTP status of a response reporting a query
+// status. Failed queries are reported in the body with 200 OK.
+func outcomeStatusCode(status string) int {
+	switch status {
+	case queryTimeout:
+		return http.StatusGatewayTimeout
+	case queryCanceled:
+		return statusClientClosedRequest
+	default:
+		return http.StatusOK
+	}
+}
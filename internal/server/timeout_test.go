@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"fiyuu-ktdb-loadtest/internal/config"
+)
+
+// endlessQuery runs until SQLite is interrupted
+const endlessQuery = "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT COUNT(*) FROM c"
+
+func TestQueryTimeout(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{QueryTimeout: time.Minute, QueryMaxTimeout: time.Minute})
+
+	tests := []struct {
+		path    string
+		request map[string]interface{}
+	}{
+		{path: "/api/v1/query", request: map[string]interface{}{"query": endlessQuery, "timeout_ms": 50}},
+		{path: "/api/v1/batch", request: map[string]interface{}{
+			"statements": []map[string]interface{}{{"query": endlessQuery}},
+			"timeout_ms": 50,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var response struct {
+				Success bool   `json:"success"`
+				Status  string `json:"status"`
+			}
+			code := postJSON(t, s, tt.path, tt.request, &response)
+			if code != http.StatusGatewayTimeout || response.Success || response.Status != queryTimeout {
+				t.Errorf("POST %s = %d, success %v, status %q; want %d, false, %q",
+					tt.path, code, response.Success, response.Status, http.StatusGatewayTimeout, queryTimeout)
+			}
+		})
+	}
+
+	var response map[string]interface{}
+	if code := postJSON(t, s, "/api/v1/query", map[string]interface{}{"query": "SELECT 1", "timeout_ms": 120000}, &response); code != http.StatusBadRequest {
+		t.Errorf("timeout_ms above QUERY_MAX_TIMEOUT = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := postJSON(t, s, "/api/v1/query", map[string]interface{}{"query": "SELECT 1", "timeout_ms": -1}, &response); code != http.StatusBadRequest {
+		t.Errorf("negative timeout_ms = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestQueryCanceled(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{QueryTimeout: time.Minute})
+
+	body, err := json.Marshal(map[string]interface{}{"query": endlessQuery})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client goes away while the query runs
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/query", bytes.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	var response QueryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	if w.Code != statusClientClosedRequest || response.Status != queryCanceled {
+		t.Errorf("canceled query = %d, status %q; want %d, %q", w.Code, response.Status, statusClientClosedRequest, queryCanceled)
+	}
+	if got := s.queryResults.snapshot()[queryCanceled]; got != 1 {
+		t.Errorf("canceled queries counted %d times, want 1", got)
+	}
+}
+
+func TestQueryOutlivesWriteTimeout(t *testing.T) {
+	s := newTestServer(t, config.EnvConfig{QueryTimeout: time.Minute, QueryMaxTimeout: time.Minute})
+
+	// The query ends after the server's write timeout, its response must
+	// still arrive
+	server := httptest.NewUnstartedServer(s.router)
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	body, err := json.Marshal(map[string]interface{}{"query": endlessQuery, "timeout_ms": 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+"/api/v1/query", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /api/v1/query: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response QueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("response lost after the write timeout: %v", err)
+	}
+	if resp.StatusCode != http.StatusGatewayTimeout || response.Status != queryTimeout {
+		t.Errorf("POST /api/v1/query = %d, status %q; want %d, %q", resp.StatusCode, response.Status, http.StatusGatewayTimeout, queryTimeout)
+	}
+}
@@ -12,9 +12,9 @@ import (
 	"fiyuu-ktdb-loadtest/internal/config"
 	"fiyuu-ktdb-loadtest/internal/loadtest"
 	"fiyuu-ktdb-loadtest/internal/metrics"
+	"fiyuu-ktdb-loadtest/internal/report"
 	"fiyuu-ktdb-loadtest/internal/server"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -23,6 +23,14 @@ var (
 	configFile string
 	verbose    bool
 	serverMode bool
+
+	// Token command
+	tokenSubject string
+	tokenRole    string
+	tokenTTL     time.Duration
+
+	// Report command
+	reportOutput string
 )
 
 func main() {
@@ -37,12 +45,36 @@ func main() {
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose logging")
 	rootCmd.Flags().BoolVarP(&serverMode, "server", "s", true, "Run in server mode (default: true)")
 
+	tokenCmd := &cobra.Command{
+		Use:   "token",
+		Short: "Sign a bearer token for the server API",
+		Long:  "Sign a bearer token for the server API with the AUTH_HMAC_SECRET environment variable",
+		RunE:  runToken,
+	}
+	tokenCmd.Flags().StringVar(&tokenSubject, "subject", "", "Caller the token identifies")
+	tokenCmd.Flags().StringVar(&tokenRole, "role", server.RoleRead, "Role granted by the token: read, query or admin")
+	tokenCmd.Flags().DurationVar(&tokenTTL, "ttl", 24*time.Hour, "Validity of the token, 0 for no expiry")
+	rootCmd.AddCommand(tokenCmd)
+
+	reportCmd := &cobra.Command{
+		Use:   "report metrics.json...",
+		Short: "Merge the results of several load test runs into one HTML report",
+		Long:  "Merge the metrics output files of several load test runs into one HTML report, with latency percentiles computed from the merged histograms",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runReport,
+	}
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "merged_report.html", "HTML report file to write")
+	rootCmd.AddCommand(reportCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		logrus.Fatal(err)
 	}
 }
 
 func run(cmd *cobra.Command, args []string) error {
+	// Flags are valid once we get here, later errors are not usage errors
+	cmd.SilenceUsage = true
+
 	// Setup logging
 	setupLogging()
 
@@ -53,6 +85,41 @@ func run(cmd *cobra.Command, args []string) error {
 	return runLoadTest()
 }
 
+func runToken(cmd *cobra.Command, args []string) error {
+	secret := os.Getenv("AUTH_HMAC_SECRET")
+	if secret == "" {
+		return fmt.Errorf("AUTH_HMAC_SECRET environment variable is required")
+	}
+	if tokenSubject == "" {
+		return fmt.Errorf("--subject is required")
+	}
+
+	claims := server.TokenClaims{Subject: tokenSubject, Role: tokenRole}
+	if tokenTTL > 0 {
+		claims.ExpiresAt = time.Now().Add(tokenTTL).Unix()
+	}
+
+	token, err := server.SignToken([]byte(secret), claims)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+func runReport(cmd *cobra.Command, args []string) error {
+	data, err := report.FromMetricsFiles(args)
+	if err != nil {
+		return err
+	}
+	if err := report.Write(reportOutput, data); err != nil {
+		return err
+	}
+
+	fmt.Printf("Report of %d runs written to %s\n", len(args), reportOutput)
+	return nil
+}
+
 func setupLogging() {
 	// Set log level from environment or flag
 	logLevel := os.Getenv("LOG_LEVEL")
@@ -162,16 +229,17 @@ func runLoadTest() error {
 	}()
 
 	// Initialize metrics collector
-	metricsCollector := metrics.NewCollector()
+	metricsCollector := metrics.NewCollector(cfg.Metrics.Prometheus.Buckets)
 	defer metricsCollector.Close()
 
 	// Start Prometheus metrics server if enabled
 	if cfg.Metrics.Prometheus.Enabled {
 		go func() {
-			http.Handle(cfg.Metrics.Prometheus.Path, promhttp.Handler())
+			mux := http.NewServeMux()
+			mux.Handle(cfg.Metrics.Prometheus.Path, metricsCollector.Handler())
 			addr := fmt.Sprintf(":%d", cfg.Metrics.Prometheus.Port)
 			logrus.Infof("Starting Prometheus metrics server on %s%s", addr, cfg.Metrics.Prometheus.Path)
-			if err := http.ListenAndServe(addr, nil); err != nil {
+			if err := http.ListenAndServe(addr, mux); err != nil {
 				logrus.Errorf("Prometheus metrics server error: %v", err)
 			}
 		}()
@@ -194,8 +262,9 @@ func runLoadTest() error {
 	logrus.Infof("Ramp-up time: %v", cfg.Test.RampUpTime)
 
 	// Run load test
-	if err := loadTester.Run(ctx); err != nil {
-		logrus.Errorf("Load test failed: %v", err)
+	runErr := loadTester.Run(ctx)
+	if runErr != nil {
+		logrus.Errorf("Load test failed: %v", runErr)
 	}
 
 	// Always clean up connections, even if test failed
@@ -207,5 +276,7 @@ func runLoadTest() error {
 	}
 
 	logrus.Info("Load test completed")
-	return nil
+
+	// A failed run or breached threshold exits non-zero so CI can gate on it
+	return runErr
 }